@@ -0,0 +1,8 @@
+//go:build bbolt
+
+package main
+
+// The bbolt storage backend is only available when built with the bbolt build tag.
+import (
+	_ "github.com/kristenfelch/pkgindexer/data/bbolt"
+)
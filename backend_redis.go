@@ -0,0 +1,6 @@
+package main
+
+// The Redis storage backend needs no client library, so is always available.
+import (
+	_ "github.com/kristenfelch/pkgindexer/data/redis"
+)
@@ -0,0 +1,8 @@
+//go:build sqlite
+
+package main
+
+// The SQLite storage backend is only available when built with the sqlite build tag.
+import (
+	_ "github.com/kristenfelch/pkgindexer/data/sqlite"
+)
@@ -0,0 +1,305 @@
+// Command pkgadmin performs administrative operations against a running indexing service,
+// such as finding orphaned packages and garbage collecting them.
+//
+// Usage:
+//
+//	pkgadmin [-addr localhost:8080] [-token TOKEN] [-namespace NAME] <command> [options]
+//
+// Commands:
+//
+//	orphans [-before TIME] [-unqueried]           lists dependencies that nothing depends on any longer
+//	gc [-before TIME] [-unqueried] [-apply]       lists, or with -apply removes, garbage packages
+//	namespace <create|drop|list|stats> [NAME]     manages namespaces, each an independent index
+//	fsck                                          checks the index for cycles and inconsistent references
+//	diff [-json] FROM TO                          lists packages added, removed and changed between two indexes
+//
+// Commands other than namespace and diff apply to the namespace given with -namespace, or the default namespace.
+// Indexes compared by diff are each a namespace, as it is now, or as it was at a time, as in staging@TIME.
+// TIME is either a unix timestamp or an RFC3339 time.  Privileged commands such as gc require the
+// token the service was started with, using -adminToken.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+)
+
+// adminClient sends messages to the indexing service over its line-oriented protocol.
+type adminClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// send sends a single message, returning the status and payload of the response.
+func (c *adminClient) send(msg string) (status string, payload string, err error) {
+	if _, err = fmt.Fprintln(c.conn, msg); err != nil {
+		return "", "", err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", "", err
+	}
+	line = strings.TrimRight(line, "\n")
+	if i := strings.Index(line, "|"); i != -1 {
+		return line[:i], line[i+1:], nil
+	}
+	return line, "", nil
+}
+
+// request sends a message, failing unless the service responds OK.
+func (c *adminClient) request(msg string) (payload string, err error) {
+	status, payload, err := c.send(msg)
+	if err != nil {
+		return "", err
+	}
+	if status != "OK" {
+		return "", fmt.Errorf("%s returned %s %s", strings.SplitN(msg, "|", 2)[0], status, payload)
+	}
+	return payload, nil
+}
+
+// parseTime reads a unix timestamp or RFC3339 time, returning it as a unix timestamp.
+// An empty time is returned as 0, meaning any time.
+func parseTime(value string) (seconds int64, err error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	if seconds, err = strconv.ParseInt(value, 10, 64); err == nil {
+		return seconds, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("time should be a unix timestamp or RFC3339 : %s", value)
+	}
+	return parsed.Unix(), nil
+}
+
+// printList prints each comma delimited package of a payload on its own line.
+func printList(payload string) {
+	if len(payload) == 0 {
+		return
+	}
+	for _, name := range strings.Split(payload, ",") {
+		fmt.Println(name)
+	}
+}
+
+// orphanCommand runs the orphans and gc commands, which share their filter options.
+func orphanCommand(client *adminClient, verb string, args []string) error {
+	flags := flag.NewFlagSet(strings.ToLower(verb), flag.ExitOnError)
+	before := flags.String("before", "", "only packages indexed before this unix timestamp or RFC3339 time")
+	unqueried := flags.Bool("unqueried", false, "only packages that have never been queried")
+	apply := false
+	if verb == "GC" {
+		flags.BoolVar(&apply, "apply", false, "remove packages rather than only listing them")
+	}
+	flags.Parse(args)
+
+	seconds, err := parseTime(*before)
+	if err != nil {
+		return err
+	}
+	options := make([]string, 0)
+	if *unqueried {
+		options = append(options, "unqueried")
+	}
+	if apply {
+		options = append(options, "apply")
+	}
+	payload, err := client.request(fmt.Sprintf("%s|%d|%s", verb, seconds, strings.Join(options, ",")))
+	if err != nil {
+		return err
+	}
+	printList(payload)
+	return nil
+}
+
+// namespaceCommand runs the namespace command, printing the namespaces listed, or the statistics
+// of a namespace one per line.
+func namespaceCommand(client *adminClient, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("namespace needs one of create, drop, list or stats")
+	}
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	}
+	payload, err := client.request("NAMESPACE|" + args[0] + "|" + name)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		printList(payload)
+	case "stats":
+		labels := []string{"packages", "requests", "failures", "errors", "conflicts"}
+		for i, value := range strings.Split(payload, "|") {
+			if i < len(labels) {
+				fmt.Printf("%s\t%s\n", labels[i], value)
+			}
+		}
+	}
+	return nil
+}
+
+// fsckCommand runs the fsck command, printing each problem found on its own line, and failing if there are any.
+func fsckCommand(client *adminClient) error {
+	status, payload, err := client.send("FSCK||")
+	if err != nil {
+		return err
+	}
+	if status == "OK" {
+		fmt.Println("index is consistent")
+		return nil
+	}
+	if status != "FAIL" {
+		return fmt.Errorf("FSCK returned %s %s", status, payload)
+	}
+	problems := strings.Split(payload, "|")
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	return fmt.Errorf("index has %d problems", len(problems))
+}
+
+// indexName reads the name of an index to diff, a namespace optionally followed by a time as in
+// staging@2017-01-01T00:00:00Z, which is sent as a unix timestamp.
+func indexName(name string) (named string, err error) {
+	i := strings.Index(name, "@")
+	if i == -1 {
+		return name, nil
+	}
+	seconds, err := parseTime(name[i+1:])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s@%d", name[:i], seconds), nil
+}
+
+// parseDiff reads the packages that differ between two indexes from the payload of DIFF.
+func parseDiff(payload string) *data.IndexDiff {
+	diff := &data.IndexDiff{Added: make([]string, 0), Removed: make([]string, 0), Changed: make([]data.DependencyChange, 0)}
+	if len(payload) == 0 {
+		return diff
+	}
+	for _, entry := range strings.Split(payload, "|") {
+		switch entry[0] {
+		case '+':
+			diff.Added = append(diff.Added, entry[1:])
+		case '-':
+			diff.Removed = append(diff.Removed, entry[1:])
+		case '~':
+			parts := strings.Split(entry[1:], ",")
+			change := data.DependencyChange{Name: parts[0], Added: make([]string, 0), Removed: make([]string, 0)}
+			for _, dep := range parts[1:] {
+				if strings.HasPrefix(dep, "+") {
+					change.Added = append(change.Added, dep[1:])
+				} else {
+					change.Removed = append(change.Removed, dep[1:])
+				}
+			}
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+	return diff
+}
+
+// diffCommand runs the diff command, printing the packages that differ between two indexes as text, one
+// per line, or as JSON.
+func diffCommand(client *adminClient, args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the differences as JSON rather than text")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return fmt.Errorf("diff needs the two indexes to compare")
+	}
+	from, err := indexName(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	to, err := indexName(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	payload, err := client.request("DIFF|" + from + "|" + to)
+	if err != nil {
+		return err
+	}
+	diff := parseDiff(payload)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	}
+	fmt.Print(diff.Text())
+	return nil
+}
+
+func run(addr string, token string, namespace string, command string, args []string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := &adminClient{conn, bufio.NewReader(conn)}
+
+	// administrative commands need version 2 of our protocol, and extended responses explain
+	// why any command fails.
+	if _, err := client.request("HELLO|2|extended"); err != nil {
+		return err
+	}
+
+	if len(token) > 0 {
+		if _, err := client.request("AUTH|" + token + "|"); err != nil {
+			return err
+		}
+	}
+
+	if len(namespace) > 0 {
+		if _, err := client.request("USE|" + namespace + "|"); err != nil {
+			return err
+		}
+	}
+
+	switch command {
+	case "namespace":
+		return namespaceCommand(client, args)
+	case "fsck":
+		return fsckCommand(client)
+	case "diff":
+		return diffCommand(client, args)
+	case "orphans":
+		return orphanCommand(client, "ORPHANS", args)
+	case "gc":
+		return orphanCommand(client, "GC", args)
+	}
+	return fmt.Errorf("unknown command : %s", command)
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address of the indexing service")
+	token := flag.String("token", "", "admin token for privileged commands")
+	namespace := flag.String("namespace", "", "namespace commands apply to, rather than the default namespace")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pkgadmin [-addr host:port] [-token TOKEN] [-namespace NAME] <orphans|gc|namespace|fsck|diff> [options]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*addr, *token, *namespace, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// DefaultBackend is the storage backend used unless another is selected, which keeps our Index in memory.
+const DefaultBackend = "memory"
+
+// Backend creates the IndexStore of a namespace.  Options configure the backend, such as the location
+// of its database, and are the same for every namespace, so backends keep each namespace apart themselves.
+type Backend func(namespace string, options string, logger logging.Logger) (store IndexStore, error error)
+
+// Droppable is an IndexStore keeping its packages outside our process, such as in a database shared by every
+// namespace, so it must discard them itself when its namespace is dropped.
+type Droppable interface {
+	// Discards every package of our namespace, and releases whatever the store holds, such as its connection
+	// to a database.  The store cannot be used once dropped.
+	Drop() (error error)
+}
+
+// ConcurrentStore is an IndexStore safe for use by many clients at once, so that requests need not wait for
+// one another, sharing the lock of their namespace rather than holding it exclusively.  Other clients may change
+// the store between the checks a request makes and its change, so a ConcurrentStore refuses to add or update a
+// Package unless each dependency other than optional dependencies is indexed, and to remove a Package that other
+// packages depend on, as RedisIndexStore does.
+type ConcurrentStore interface {
+	// Indicates if the store is safe for concurrent use, as it may wrap a store that is not.
+	Concurrent() bool
+
+	// Changes the info of an indexed Package in place, so that changes other clients make to it are not lost.
+	UpdateInfo(name string, change func(info *PackageInfo)) (error error)
+}
+
+// backends maps the name of each storage backend to the function creating its stores.
+var backends = map[string]Backend{
+	DefaultBackend: func(namespace string, options string, logger logging.Logger) (IndexStore, error) {
+		return NewIndexStore(logger), nil
+	},
+	ShardedBackend: newShardedBackend,
+	CompactBackend: func(namespace string, options string, logger logging.Logger) (IndexStore, error) {
+		return NewCompactIndexStore(logger), nil
+	},
+}
+
+// RegisterBackend makes a storage backend available by name, replacing any backend of that name.
+func RegisterBackend(name string, backend Backend) {
+	backends[name] = backend
+}
+
+// BackendNames returns the name of every storage backend, sorted.
+func BackendNames() []string {
+	names := make(map[string]bool, len(backends))
+	for name := range backends {
+		names[name] = true
+	}
+	return sortedKeys(names)
+}
+
+// NewBackendStore creates the IndexStore of a namespace using the named storage backend.
+func NewBackendStore(name string, namespace string, options string, logger logging.Logger) (store IndexStore, error error) {
+	backend, ok := backends[name]
+	if !ok {
+		return nil, err.NewCodedIndexError(err.CodeInvalidArgument, "Unknown storage backend : " + name)
+	}
+	return backend(namespace, options, logger)
+}
@@ -0,0 +1,29 @@
+package data
+
+import (
+	"testing"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// Tests that stores are created by the backend selected, and that unknown backends are rejected.
+func TestNewBackendStore(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	if store, err := NewBackendStore(DefaultBackend, "default", "", logger); (err != nil || store == nil) {
+		t.Error("Store should be created by default backend")
+	}
+	if _, err := NewBackendStore("missing", "default", "", logger); (err == nil) {
+		t.Error("Unknown backend should be rejected")
+	}
+
+	RegisterBackend("testing", func(namespace string, options string, logger logging.Logger) (IndexStore, error) {
+		return NewTestStore(true, nil, true, nil, true, nil, true, nil), nil
+	})
+	defer delete(backends, "testing")
+	if names := BackendNames(); (len(names) != 4 || names[0] != "compact" || names[1] != "memory" || names[2] != "sharded" || names[3] != "testing") {
+		t.Errorf("Backends should be listed, got %v", names)
+	}
+	if store, _ := NewBackendStore("testing", "default", "", logger); (store == nil) {
+		t.Error("Store should be created by registered backend")
+	}
+}
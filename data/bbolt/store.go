@@ -0,0 +1,496 @@
+//go:build bbolt
+
+// Package bbolt is a storage backend keeping our Index in an embedded B+tree key value file, using bbolt,
+// so that our Index survives a crash without a log of our own, and is not read into memory at startup.
+// It is only built with the bbolt build tag, as in go build -tags bbolt, so that installations which
+// keep their Index in memory need not fetch bbolt.
+package bbolt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Backend is the name our storage backend is selected by.
+const Backend = "bbolt"
+
+// Each namespace keeps its packages in a bucket of its own, under keys starting with one of these prefixes.
+// A key naming two packages separates them with separator, which no package name contains, so that the
+// keys of one package are never mistaken for those of another whose name starts the same way.
+// The value of a package key is its info, and the value of a dependency key is the kind of that dependency.
+// Parent keys record each package that depends on another, other than optionally, and remain while that
+// package is forcibly removed, so its parents are restored when it is indexed again.
+const (
+	packagePrefix    = "p/"
+	dependencyPrefix = "d/"
+	parentPrefix     = "r/"
+	versionPrefix    = "v/"
+	separator        = "\x00"
+)
+
+// databases holds every database we have opened, by path, as a database file can only be opened once,
+// and is shared by every namespace kept in it, along with the number of stores using each database, which
+// is closed once every store using it has been dropped.
+var databases = struct {
+	open  map[string]*bolt.DB
+	users map[string]int
+	lock  sync.Mutex
+}{open: make(map[string]*bolt.DB), users: make(map[string]int)}
+
+// BoltIndexStore is an IndexStore keeping the packages of a single namespace in a bbolt database.
+// Each change is made in its own write transaction, unless a transaction has begun, in which case every
+// change is made within it until it is committed or rolled back.  Only one write transaction can be in
+// progress in a database, so a transaction in one namespace delays changes to others in the same database.
+type BoltIndexStore struct {
+	db        *bolt.DB
+	tx        *bolt.Tx
+	namespace []byte
+	path      string
+	logger    logging.Logger
+}
+
+// key builds a key from a prefix and the names it is made of.
+func key(prefix string, names ...string) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(prefix)
+	for i, name := range names {
+		if i > 0 {
+			buffer.WriteString(separator)
+		}
+		buffer.WriteString(name)
+	}
+	return buffer.Bytes()
+}
+
+// scan calls found with the rest of each key starting with prefix, and its value, in order of their keys.
+// Keys and values are only valid until the transaction ends, so are copied by found if they are kept.
+func scan(bucket *bolt.Bucket, prefix []byte, found func(rest []byte, value []byte)) {
+	cursor := bucket.Cursor()
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		found(k[len(prefix):], v)
+	}
+}
+
+// names returns the rest of each key starting with prefix, in order.
+func names(bucket *bolt.Bucket, prefix []byte) (found []string) {
+	found = make([]string, 0)
+	scan(bucket, prefix, func(rest []byte, value []byte) {
+		found = append(found, string(rest))
+	})
+	return found
+}
+
+// read reads our bucket within the transaction in progress, or otherwise within a read transaction.
+func (s *BoltIndexStore) read(reader func(bucket *bolt.Bucket) error) error {
+	if s.tx != nil {
+		return wrap(reader(s.tx.Bucket(s.namespace)))
+	}
+	return wrap(s.db.View(func(tx *bolt.Tx) error {
+		return reader(tx.Bucket(s.namespace))
+	}))
+}
+
+// write makes a change within the transaction in progress, or otherwise within a write transaction of
+// its own, so that a change is never left partially made.
+func (s *BoltIndexStore) write(change func(bucket *bolt.Bucket) error) error {
+	if s.tx != nil {
+		return wrap(change(s.tx.Bucket(s.namespace)))
+	}
+	return wrap(s.db.Update(func(tx *bolt.Tx) error {
+		return change(tx.Bucket(s.namespace))
+	}))
+}
+
+// wrap describes an error from our database as an IndexError.
+func wrap(failure error) error {
+	if failure == nil {
+		return nil
+	}
+	if _, ok := failure.(*err.IndexError); ok {
+		return failure
+	}
+	return err.NewIndexError("bbolt store failed : " + failure.Error())
+}
+
+// getInfo reads the info of a Package, which is nil if it is not indexed.
+func getInfo(bucket *bolt.Bucket, name string) (info *data.PackageInfo, error error) {
+	value := bucket.Get(key(packagePrefix, name))
+	if value == nil {
+		return nil, nil
+	}
+	info = &data.PackageInfo{}
+	if decodeErr := json.Unmarshal(value, info); decodeErr != nil {
+		return nil, decodeErr
+	}
+	return info, nil
+}
+
+// putInfo writes the info of a Package, indexing it if it was not.
+func putInfo(bucket *bolt.Bucket, name string, info data.PackageInfo) (error error) {
+	value, encodeErr := json.Marshal(info)
+	if encodeErr != nil {
+		return encodeErr
+	}
+	return bucket.Put(key(packagePrefix, name), value)
+}
+
+func (s *BoltIndexStore) AddPackage(name string, deps []string) (added bool, error error) {
+	return s.AddTypedPackage(name, deps, nil)
+}
+
+func (s *BoltIndexStore) AddTypedPackage(name string, deps []string, kinds map[string]data.DependencyKind) (bool, error) {
+	writeErr := s.write(func(bucket *bolt.Bucket) error {
+		now := time.Now()
+		base, _ := data.SplitVersion(name)
+		if putErr := putInfo(bucket, name, data.PackageInfo{Revision: 1, Indexed: now, Updated: now}); putErr != nil {
+			return putErr
+		}
+		if putErr := bucket.Put(key(versionPrefix, base, name), []byte{}); putErr != nil {
+			return putErr
+		}
+		return replaceDependencies(bucket, name, deps, kinds)
+	})
+	if writeErr != nil {
+		return false, writeErr
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s added to Index", name))
+	return true, nil
+}
+
+// removeDependencies removes the keys recording each dependency of a Package, and it as their parent.
+func removeDependencies(bucket *bolt.Bucket, name string) (error error) {
+	// keys cannot be deleted while a cursor walks them, so are found first.
+	deps := names(bucket, key(dependencyPrefix, name, ""))
+	for _, dep := range deps {
+		if deleteErr := bucket.Delete(key(dependencyPrefix, name, dep)); deleteErr != nil {
+			return deleteErr
+		}
+		if deleteErr := bucket.Delete(key(parentPrefix, dep, name)); deleteErr != nil {
+			return deleteErr
+		}
+	}
+	return nil
+}
+
+// replaceDependencies replaces the dependencies of a Package, and it as their parent.
+func replaceDependencies(bucket *bolt.Bucket, name string, deps []string, kinds map[string]data.DependencyKind) (error error) {
+	if error = removeDependencies(bucket, name); error != nil {
+		return error
+	}
+	for _, dep := range deps {
+		kind, ok := kinds[dep]
+		if !ok {
+			kind = data.KindRuntime
+		}
+		if error = bucket.Put(key(dependencyPrefix, name, dep), []byte(kind)); error != nil {
+			return error
+		}
+		// optional dependencies never make a package a parent.
+		if kind == data.KindOptional {
+			continue
+		}
+		if error = bucket.Put(key(parentPrefix, dep, name), []byte{}); error != nil {
+			return error
+		}
+	}
+	return nil
+}
+
+func (s *BoltIndexStore) UpdatePackage(name string, deps []string, kinds map[string]data.DependencyKind) (bool, error) {
+	writeErr := s.write(func(bucket *bolt.Bucket) error {
+		info, getErr := getInfo(bucket, name)
+		if getErr != nil {
+			return getErr
+		}
+		if info == nil {
+			return err.NewIndexError("Unable to update Unindexed package")
+		}
+		info.Revision++
+		info.Updated = time.Now()
+		if putErr := putInfo(bucket, name, *info); putErr != nil {
+			return putErr
+		}
+		// the parents of this package are keyed by it, so are kept.
+		return replaceDependencies(bucket, name, deps, kinds)
+	})
+	if writeErr != nil {
+		return false, writeErr
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s updated in Index", name))
+	return true, nil
+}
+
+func (s *BoltIndexStore) RemovePackage(name string) (bool, error) {
+	writeErr := s.write(func(bucket *bolt.Bucket) error {
+		base, _ := data.SplitVersion(name)
+		if deleteErr := bucket.Delete(key(packagePrefix, name)); deleteErr != nil {
+			return deleteErr
+		}
+		if deleteErr := bucket.Delete(key(versionPrefix, base, name)); deleteErr != nil {
+			return deleteErr
+		}
+		return removeDependencies(bucket, name)
+	})
+	if writeErr != nil {
+		return false, writeErr
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s removed from Index", name))
+	return true, nil
+}
+
+func (s *BoltIndexStore) ForceRemovePackage(name string) (dependents []string, error error) {
+	exists, error := s.HasPackage(name)
+	if error != nil || !exists {
+		return []string{}, error
+	}
+	// dependents keep their dependency on this package, and it its parent keys, so are left dangling.
+	dependents, error = s.GetParents(name)
+	if error != nil {
+		return []string{}, error
+	}
+	if _, error = s.RemovePackage(name); error != nil {
+		return []string{}, error
+	}
+	return dependents, nil
+}
+
+func (s *BoltIndexStore) HasPackage(name string) (bool, error) {
+	exists := false
+	readErr := s.read(func(bucket *bolt.Bucket) error {
+		exists = bucket.Get(key(packagePrefix, name)) != nil
+		return nil
+	})
+	return exists, readErr
+}
+
+// mustExist fails unless a Package is indexed, describing what could not be done.
+func mustExist(bucket *bolt.Bucket, name string, failure string) (error error) {
+	if bucket.Get(key(packagePrefix, name)) == nil {
+		return err.NewIndexError(failure)
+	}
+	return nil
+}
+
+func (s *BoltIndexStore) HasParents(name string) (hasParents bool, error error) {
+	parents, error := s.list(name, "Unable to determined if Unindexed package has parents", key(parentPrefix, name, ""))
+	return len(parents) > 0, error
+}
+
+// list returns the rest of each key starting with prefix, in order, failing unless a Package is indexed.
+func (s *BoltIndexStore) list(name string, failure string, prefix []byte) ([]string, error) {
+	var found []string
+	readErr := s.read(func(bucket *bolt.Bucket) error {
+		if existErr := mustExist(bucket, name, failure); existErr != nil {
+			return existErr
+		}
+		found = names(bucket, prefix)
+		return nil
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	return found, nil
+}
+
+func (s *BoltIndexStore) GetDependencies(name string) (deps []string, error error) {
+	return s.list(name, "Unable to determine dependencies of Unindexed package", key(dependencyPrefix, name, ""))
+}
+
+func (s *BoltIndexStore) GetDependencyKinds(name string) (map[string]data.DependencyKind, error) {
+	var kinds map[string]data.DependencyKind
+	readErr := s.read(func(bucket *bolt.Bucket) error {
+		if existErr := mustExist(bucket, name, "Unable to determine dependencies of Unindexed package"); existErr != nil {
+			return existErr
+		}
+		kinds = make(map[string]data.DependencyKind)
+		scan(bucket, key(dependencyPrefix, name, ""), func(dep []byte, kind []byte) {
+			kinds[string(dep)] = data.DependencyKind(kind)
+		})
+		return nil
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	return kinds, nil
+}
+
+func (s *BoltIndexStore) GetParents(name string) (parents []string, error error) {
+	return s.list(name, "Unable to determine parents of Unindexed package", key(parentPrefix, name, ""))
+}
+
+func (s *BoltIndexStore) ListPackages() ([]string, error) {
+	var packages []string
+	readErr := s.read(func(bucket *bolt.Bucket) error {
+		packages = names(bucket, []byte(packagePrefix))
+		return nil
+	})
+	return packages, readErr
+}
+
+func (s *BoltIndexStore) GetVersions(name string) ([]string, error) {
+	var versions []string
+	readErr := s.read(func(bucket *bolt.Bucket) error {
+		versions = names(bucket, key(versionPrefix, name, ""))
+		return nil
+	})
+	return versions, readErr
+}
+
+func (s *BoltIndexStore) GetInfo(name string) (*data.PackageInfo, error) {
+	var info *data.PackageInfo
+	readErr := s.read(func(bucket *bolt.Bucket) error {
+		stored, getErr := getInfo(bucket, name)
+		if getErr == nil && stored == nil {
+			getErr = err.NewIndexError("Unable to determine info of Unindexed package")
+		}
+		info = stored
+		return getErr
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	return info, nil
+}
+
+// update changes the info of a single indexed Package, describing what could not be done if it is not indexed.
+func (s *BoltIndexStore) update(name string, failure string, change func(info *data.PackageInfo)) error {
+	return s.write(func(bucket *bolt.Bucket) error {
+		info, getErr := getInfo(bucket, name)
+		if getErr != nil {
+			return getErr
+		}
+		if info == nil {
+			return err.NewIndexError(failure)
+		}
+		change(info)
+		return putInfo(bucket, name, *info)
+	})
+}
+
+func (s *BoltIndexStore) SetInfo(name string, info data.PackageInfo) (error error) {
+	return s.update(name, "Unable to set info of Unindexed package", func(stored *data.PackageInfo) {
+		*stored = info
+	})
+}
+
+func (s *BoltIndexStore) MarkQueried(name string) (error error) {
+	return s.update(name, "Unable to mark Unindexed package as queried", func(info *data.PackageInfo) {
+		info.Queried = time.Now()
+	})
+}
+
+func (s *BoltIndexStore) Begin() (error error) {
+	if s.tx != nil {
+		return err.NewIndexError("Unable to begin a transaction within another transaction")
+	}
+	tx, beginErr := s.db.Begin(true)
+	if beginErr != nil {
+		return wrap(beginErr)
+	}
+	s.tx = tx
+	return nil
+}
+
+func (s *BoltIndexStore) Commit() (error error) {
+	if s.tx == nil {
+		return err.NewIndexError("Unable to commit outside of a transaction")
+	}
+	tx := s.tx
+	s.tx = nil
+	return wrap(tx.Commit())
+}
+
+func (s *BoltIndexStore) Rollback() (error error) {
+	if s.tx == nil {
+		return err.NewIndexError("Unable to roll back outside of a transaction")
+	}
+	tx := s.tx
+	s.tx = nil
+	s.logger.Trace("Transaction rolled back")
+	return wrap(tx.Rollback())
+}
+
+// Drop deletes the bucket of our namespace, along with every package in it, rolling back any transaction in
+// progress, and closes our database if no other namespace uses it.
+func (s *BoltIndexStore) Drop() error {
+	if s.tx != nil {
+		s.Rollback()
+	}
+	dropErr := wrap(s.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(s.namespace)
+	}))
+	if releaseErr := release(s.path); dropErr == nil {
+		dropErr = releaseErr
+	}
+	return dropErr
+}
+
+// Check verifies that Parents and Dependencies mirror one another, and that no packages depend on one
+// another in a cycle.
+func (s *BoltIndexStore) Check() (problems []data.Problem, error error) {
+	return data.CheckGraph(s)
+}
+
+// open opens the database at path, or returns it if already open, counting the store that uses it.
+func open(path string) (db *bolt.DB, error error) {
+	databases.lock.Lock()
+	defer databases.lock.Unlock()
+	if opened, ok := databases.open[path]; ok {
+		databases.users[path]++
+		return opened, nil
+	}
+	// another process holding the database fails us rather than waiting forever.
+	db, openErr := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if openErr != nil {
+		return nil, wrap(openErr)
+	}
+	databases.open[path] = db
+	databases.users[path] = 1
+	return db, nil
+}
+
+// release records that a store no longer uses the database at path, closing it once no store does.
+func release(path string) (error error) {
+	databases.lock.Lock()
+	defer databases.lock.Unlock()
+	databases.users[path]--
+	if databases.users[path] > 0 {
+		return nil
+	}
+	db := databases.open[path]
+	delete(databases.open, path)
+	delete(databases.users, path)
+	return wrap(db.Close())
+}
+
+// New creates an IndexStore for a namespace in the bbolt database at path, creating the database if needed.
+func New(namespace string, path string, logger logging.Logger) (data.IndexStore, error) {
+	if len(path) == 0 {
+		return nil, err.NewCodedIndexError(err.CodeInvalidArgument, "bbolt store needs the path of its database in storeOptions")
+	}
+	db, openErr := open(path)
+	if openErr != nil {
+		return nil, openErr
+	}
+	bucket := []byte(namespace)
+	createErr := db.Update(func(tx *bolt.Tx) error {
+		_, bucketErr := tx.CreateBucketIfNotExists(bucket)
+		return bucketErr
+	})
+	if createErr != nil {
+		release(path)
+		return nil, wrap(createErr)
+	}
+	return &BoltIndexStore{db, nil, bucket, path, logger}, nil
+}
+
+func init() {
+	data.RegisterBackend(Backend, New)
+}
@@ -0,0 +1,116 @@
+//go:build bbolt
+
+package bbolt
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/data/storetest"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+func newTestStore(t *testing.T, namespace string, path string) data.IndexStore {
+	logLevel := "FATAL"
+	store, err := New(namespace, path, logging.NewIndexLogger(&logLevel))
+	if (err != nil) {
+		t.Fatalf("Error encountered opening store : %s", err.Error())
+	}
+	return store
+}
+
+// closeTestDatabase closes a database, so that it is opened again from disk.
+func closeTestDatabase(t *testing.T, path string) {
+	databases.lock.Lock()
+	defer databases.lock.Unlock()
+	if err := databases.open[path].Close(); (err != nil) {
+		t.Fatalf("Error encountered closing database : %s", err.Error())
+	}
+	delete(databases.open, path)
+	delete(databases.users, path)
+}
+
+// Tests that our bbolt store conforms to IndexStore.
+func TestConformance(t *testing.T) {
+	// each test has a namespace of its own, as they share a database.
+	path := filepath.Join(t.TempDir(), "index.db")
+	tests := 0
+	storetest.Run(t, func() data.IndexStore {
+		tests++
+		return newTestStore(t, fmt.Sprintf("test%d", tests), path)
+	})
+	closeTestDatabase(t, path)
+}
+
+// Tests that packages are kept on disk, and that namespaces sharing a database are kept apart.
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	store := newTestStore(t, "default", path)
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	other := newTestStore(t, "other", path)
+	other.AddPackage("zlib", nil)
+	closeTestDatabase(t, path)
+
+	reopened := newTestStore(t, "default", path)
+	if names, _ := reopened.ListPackages(); (len(names) != 2 || names[0] != "base" || names[1] != "lib") {
+		t.Errorf("Packages should be kept on disk, got %v", names)
+	}
+	if parents, _ := reopened.GetParents("base"); (len(parents) != 1 || parents[0] != "lib") {
+		t.Errorf("Parents should be kept on disk, got %v", parents)
+	}
+	if exists, _ := reopened.HasPackage("zlib"); (exists) {
+		t.Error("Packages of other namespaces should not be seen")
+	}
+	closeTestDatabase(t, path)
+}
+
+// Tests that dropping a namespace deletes its bucket, so a namespace created again with its name is empty, and
+// that the database is only closed once every namespace using it has been dropped.
+func TestDrop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	store := newTestStore(t, "default", path)
+	store.AddPackage("base", nil)
+	other := newTestStore(t, "other", path)
+	other.AddPackage("zlib", nil)
+	other.AddPackage("lib", []string{"zlib"})
+
+	if err := other.(data.Droppable).Drop(); (err != nil) {
+		t.Fatalf("Error encountered dropping store : %s", err.Error())
+	}
+	recreated := newTestStore(t, "other", path)
+	if names, _ := recreated.ListPackages(); (len(names) != 0) {
+		t.Errorf("Packages of dropped namespace should be deleted, got %v", names)
+	}
+	if exists, _ := store.HasPackage("base"); (!exists) {
+		t.Error("Packages of other namespaces should be kept")
+	}
+	recreated.(data.Droppable).Drop()
+	store.(data.Droppable).Drop()
+	if _, open := databases.open[path]; (open) {
+		t.Error("Database should be closed once every namespace using it is dropped")
+	}
+}
+
+// Tests that a change which fails is not partially made.
+func TestFailedChangeUndone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	store := newTestStore(t, "default", path).(*BoltIndexStore)
+	store.AddPackage("base", nil)
+	if _, err := store.UpdatePackage("missing", []string{"base"}, nil); (err == nil) {
+		t.Error("Update of package that is not indexed should fail")
+	}
+	if hasParents, _ := store.HasParents("base"); (hasParents) {
+		t.Error("Failed update should not leave dependencies behind")
+	}
+	closeTestDatabase(t, path)
+}
+
+// Tests that a database is required.
+func TestNewWithoutPath(t *testing.T) {
+	logLevel := "FATAL"
+	if _, err := New("default", "", logging.NewIndexLogger(&logLevel)); (err == nil) {
+		t.Error("Store should not be created without the path of its database")
+	}
+}
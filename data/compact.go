@@ -0,0 +1,541 @@
+package data
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// CompactBackend is the name of the storage backend keeping our Index in memory in a compact form.
+const CompactBackend = "compact"
+
+// nameID identifies a package name interned by a CompactIndexStore.
+type nameID uint32
+
+// idSet is a set of interned package names, kept as a sorted slice, which is far smaller than a map.
+type idSet []nameID
+
+// search finds where an id is, or would be, in our set.
+func (s idSet) search(id nameID) int {
+	return sort.Search(len(s), func(i int) bool {
+		return s[i] >= id
+	})
+}
+
+// has determines if an id is in our set.
+func (s idSet) has(id nameID) bool {
+	i := s.search(id)
+	return i < len(s) && s[i] == id
+}
+
+// add adds an id to our set, unless already in it.
+func (s *idSet) add(id nameID) {
+	i := s.search(id)
+	if i < len(*s) && (*s)[i] == id {
+		return
+	}
+	*s = append(*s, 0)
+	copy((*s)[i+1:], (*s)[i:])
+	(*s)[i] = id
+}
+
+// remove removes an id from our set, if in it.
+func (s *idSet) remove(id nameID) {
+	i := s.search(id)
+	if i < len(*s) && (*s)[i] == id {
+		*s = append((*s)[:i], (*s)[i+1:]...)
+	}
+}
+
+// node is everything a CompactIndexStore knows of a package name, whether or not it is indexed.
+// Kinds only records the kind of dependencies other than runtime dependencies, and is nil if there are none.
+// Dangling records the dependents left dangling when the package was forcibly removed, until it is indexed again.
+type node struct {
+	indexed  bool
+	deps     idSet
+	kinds    map[nameID]DependencyKind
+	parents  idSet
+	dangling idSet
+	info     PackageInfo
+}
+
+// kind returns the kind of one of our dependencies.
+func (n *node) kind(dep nameID) DependencyKind {
+	if kind, ok := n.kinds[dep]; ok {
+		return kind
+	}
+	return KindRuntime
+}
+
+// requires determines if we depend on a package such that it cannot be removed while we are indexed.
+func (n *node) requires(dep nameID) bool {
+	return n.deps.has(dep) && n.kind(dep) != KindOptional
+}
+
+// copy creates a deep copy of a node, so that later changes to either do not affect the other.
+func (n *node) copy() *node {
+	copied := *n
+	copied.deps = append(idSet(nil), n.deps...)
+	copied.parents = append(idSet(nil), n.parents...)
+	copied.dangling = append(idSet(nil), n.dangling...)
+	if n.kinds != nil {
+		copied.kinds = make(map[nameID]DependencyKind, len(n.kinds))
+		for dep, kind := range n.kinds {
+			copied.kinds[dep] = kind
+		}
+	}
+	return &copied
+}
+
+// CompactIndexStore is an IndexStore keeping our Index in memory like MapsIndexStore, but in a compact form
+// for indexes with millions of edges.  Each package name is interned once, and dependencies and parents are
+// kept as sorted slices of interned names rather than maps of names, so every edge costs only a few bytes.
+// Names stay interned once their package is removed, so that it can be indexed again cheaply.
+type CompactIndexStore struct {
+	names []string
+	ids   map[string]nameID
+	nodes []node
+	// versions records the indexed versions of each package name, by interned name.
+	versions map[nameID]idSet
+	// journal records the state of each node before it was first changed during a transaction, and is nil
+	// outside of one.
+	journal map[nameID]*node
+	logger  logging.Logger
+}
+
+// intern returns the id of a package name, interning it if needed.  Interning may move our nodes, so
+// no node is held while names are interned.
+func (c *CompactIndexStore) intern(name string) nameID {
+	if id, ok := c.ids[name]; ok {
+		return id
+	}
+	id := nameID(len(c.names))
+	c.names = append(c.names, name)
+	c.nodes = append(c.nodes, node{})
+	c.ids[name] = id
+	return id
+}
+
+// indexed finds the node of an indexed package, which is nil if it is not indexed.
+func (c *CompactIndexStore) indexed(name string) (id nameID, lib *node) {
+	id, ok := c.ids[name]
+	if !ok || !c.nodes[id].indexed {
+		return id, nil
+	}
+	return id, &c.nodes[id]
+}
+
+// sortedNames returns the names of a set of ids, sorted.
+func (c *CompactIndexStore) sortedNames(set idSet) []string {
+	names := make([]string, len(set))
+	for i, id := range set {
+		names[i] = c.names[id]
+	}
+	sort.Strings(names)
+	return names
+}
+
+// record saves the state of a node before it is first changed during a transaction.
+func (c *CompactIndexStore) record(id nameID) {
+	if c.journal == nil {
+		return
+	}
+	if _, ok := c.journal[id]; !ok {
+		c.journal[id] = c.nodes[id].copy()
+	}
+}
+
+// addVersion records that a package is indexed under its name.
+func (c *CompactIndexStore) addVersion(id nameID) {
+	name, _ := SplitVersion(c.names[id])
+	base := c.intern(name)
+	versions := c.versions[base]
+	versions.add(id)
+	c.versions[base] = versions
+}
+
+// removeVersion records that a package is no longer indexed under its name.
+func (c *CompactIndexStore) removeVersion(id nameID) {
+	name, _ := SplitVersion(c.names[id])
+	base, ok := c.ids[name]
+	if !ok {
+		return
+	}
+	versions := c.versions[base]
+	versions.remove(id)
+	if len(versions) == 0 {
+		delete(c.versions, base)
+	} else {
+		c.versions[base] = versions
+	}
+}
+
+func (c *CompactIndexStore) AddPackage(name string, deps []string) (added bool, error error) {
+	return c.AddTypedPackage(name, deps, nil)
+}
+
+func (c *CompactIndexStore) AddTypedPackage(name string, deps []string, kinds map[string]DependencyKind) (added bool, error error) {
+	depIDs := make([]nameID, len(deps))
+	for i, dep := range deps {
+		depIDs[i] = c.intern(dep)
+	}
+	id := c.intern(name)
+	// replacing a package already indexed first removes it as a parent of its dependencies.
+	if _, old := c.indexed(name); old != nil {
+		c.remove(id)
+	}
+	c.record(id)
+	lib := &c.nodes[id]
+	lib.deps, lib.kinds = nil, nil
+	for i, depID := range depIDs {
+		lib.deps.add(depID)
+		if kind, ok := kinds[deps[i]]; ok && kind != KindRuntime {
+			if lib.kinds == nil {
+				lib.kinds = make(map[nameID]DependencyKind)
+			}
+			lib.kinds[depID] = kind
+		}
+		if lib.kind(depID) == KindOptional {
+			continue
+		}
+		if depNode := &c.nodes[depID]; depNode.indexed {
+			c.record(depID)
+			c.logger.Trace(fmt.Sprintf("Package %s added to dependencies of %s", name, deps[i]))
+			depNode.parents.add(id)
+		}
+	}
+	// this package is only indexed once its dependencies are, so is never its own parent.
+	lib.indexed = true
+	// dependents left dangling when this package was forcibly removed depend on it once again.
+	lib.parents, lib.dangling = lib.dangling, nil
+	now := time.Now()
+	lib.info = PackageInfo{Revision: 1, Indexed: now, Updated: now}
+	c.addVersion(id)
+	c.logger.Trace(fmt.Sprintf("Package %s added to Index", name))
+	return true, nil
+}
+
+func (c *CompactIndexStore) UpdatePackage(name string, deps []string, kinds map[string]DependencyKind) (updated bool, error error) {
+	depIDs := make([]nameID, len(deps))
+	for i, dep := range deps {
+		depIDs[i] = c.intern(dep)
+	}
+	id, lib := c.indexed(name)
+	if lib == nil {
+		return false, err.NewIndexError("Unable to update Unindexed package")
+	}
+	c.record(id)
+	replaced := &node{indexed: true, parents: lib.parents, info: lib.info}
+	for i, depID := range depIDs {
+		replaced.deps.add(depID)
+		if kind, ok := kinds[deps[i]]; ok && kind != KindRuntime {
+			if replaced.kinds == nil {
+				replaced.kinds = make(map[nameID]DependencyKind)
+			}
+			replaced.kinds[depID] = kind
+		}
+	}
+
+	// drop this package from the parents of dependencies it no longer requires.
+	for _, depID := range lib.deps {
+		if !lib.requires(depID) || replaced.requires(depID) {
+			continue
+		}
+		c.record(depID)
+		if depNode := &c.nodes[depID]; depNode.indexed {
+			depNode.parents.remove(id)
+		} else {
+			depNode.dangling.remove(id)
+		}
+	}
+	// and add it to the parents of dependencies it newly requires.
+	for _, depID := range replaced.deps {
+		if !replaced.requires(depID) || lib.requires(depID) {
+			continue
+		}
+		if depNode := &c.nodes[depID]; depNode.indexed {
+			c.record(depID)
+			depNode.parents.add(id)
+		}
+	}
+
+	// our own parents may have changed, if we depend on ourselves.
+	replaced.parents = c.nodes[id].parents
+	replaced.info.Revision++
+	replaced.info.Updated = time.Now()
+	c.nodes[id] = *replaced
+	c.logger.Trace(fmt.Sprintf("Package %s updated in Index", name))
+	return true, nil
+}
+
+// remove removes an indexed package, keeping only the dependents left dangling by it.
+func (c *CompactIndexStore) remove(id nameID) {
+	c.record(id)
+	lib := c.nodes[id]
+	c.nodes[id] = node{dangling: lib.dangling}
+	c.removeVersion(id)
+	for _, depID := range lib.deps {
+		c.record(depID)
+		if depNode := &c.nodes[depID]; depNode.indexed {
+			depNode.parents.remove(id)
+		} else {
+			// this package no longer dangles from a forcibly removed dependency.
+			depNode.dangling.remove(id)
+		}
+	}
+}
+
+func (c *CompactIndexStore) RemovePackage(name string) (removed bool, error error) {
+	if id, lib := c.indexed(name); lib != nil {
+		c.remove(id)
+	}
+	c.logger.Trace(fmt.Sprintf("Package %s removed from Index", name))
+	return true, nil
+}
+
+func (c *CompactIndexStore) ForceRemovePackage(name string) (dependents []string, error error) {
+	id, lib := c.indexed(name)
+	if lib == nil {
+		return []string{}, nil
+	}
+	c.record(id)
+	dependents = c.sortedNames(lib.parents)
+	for _, parent := range lib.parents {
+		c.logger.Trace(fmt.Sprintf("Package %s left with dangling dependency %s", c.names[parent], name))
+		lib.dangling.add(parent)
+	}
+	c.remove(id)
+	return dependents, nil
+}
+
+func (c *CompactIndexStore) HasPackage(name string) (exists bool, error error) {
+	_, lib := c.indexed(name)
+	return lib != nil, nil
+}
+
+func (c *CompactIndexStore) HasParents(name string) (hasParents bool, error error) {
+	if _, lib := c.indexed(name); lib != nil {
+		return len(lib.parents) > 0, nil
+	}
+	return false, err.NewIndexError("Unable to determined if Unindexed package has parents")
+}
+
+func (c *CompactIndexStore) GetDependencies(name string) (deps []string, error error) {
+	if _, lib := c.indexed(name); lib != nil {
+		return c.sortedNames(lib.deps), nil
+	}
+	return nil, err.NewIndexError("Unable to determine dependencies of Unindexed package")
+}
+
+func (c *CompactIndexStore) GetDependencyKinds(name string) (kinds map[string]DependencyKind, error error) {
+	if _, lib := c.indexed(name); lib != nil {
+		kinds = make(map[string]DependencyKind, len(lib.deps))
+		for _, depID := range lib.deps {
+			kinds[c.names[depID]] = lib.kind(depID)
+		}
+		return kinds, nil
+	}
+	return nil, err.NewIndexError("Unable to determine dependencies of Unindexed package")
+}
+
+func (c *CompactIndexStore) GetParents(name string) (parents []string, error error) {
+	if _, lib := c.indexed(name); lib != nil {
+		return c.sortedNames(lib.parents), nil
+	}
+	return nil, err.NewIndexError("Unable to determine parents of Unindexed package")
+}
+
+func (c *CompactIndexStore) ListPackages() (names []string, error error) {
+	names = make([]string, 0)
+	for id := range c.nodes {
+		if c.nodes[id].indexed {
+			names = append(names, c.names[id])
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *CompactIndexStore) GetVersions(name string) (versions []string, error error) {
+	id, ok := c.ids[name]
+	if !ok {
+		return []string{}, nil
+	}
+	return c.sortedNames(c.versions[id]), nil
+}
+
+func (c *CompactIndexStore) GetInfo(name string) (info *PackageInfo, error error) {
+	if _, lib := c.indexed(name); lib != nil {
+		info := lib.info
+		return &info, nil
+	}
+	return nil, err.NewIndexError("Unable to determine info of Unindexed package")
+}
+
+func (c *CompactIndexStore) SetInfo(name string, info PackageInfo) (error error) {
+	if id, lib := c.indexed(name); lib != nil {
+		c.record(id)
+		lib.info = info
+		return nil
+	}
+	return err.NewIndexError("Unable to set info of Unindexed package")
+}
+
+func (c *CompactIndexStore) MarkQueried(name string) (error error) {
+	if id, lib := c.indexed(name); lib != nil {
+		c.record(id)
+		lib.info.Queried = time.Now()
+		return nil
+	}
+	return err.NewIndexError("Unable to mark Unindexed package as queried")
+}
+
+func (c *CompactIndexStore) Begin() (error error) {
+	if c.journal != nil {
+		return err.NewIndexError("Unable to begin a transaction within another transaction")
+	}
+	c.journal = make(map[nameID]*node)
+	return nil
+}
+
+func (c *CompactIndexStore) Commit() (error error) {
+	if c.journal == nil {
+		return err.NewIndexError("Unable to commit outside of a transaction")
+	}
+	c.journal = nil
+	return nil
+}
+
+func (c *CompactIndexStore) Rollback() (error error) {
+	if c.journal == nil {
+		return err.NewIndexError("Unable to roll back outside of a transaction")
+	}
+	for id, saved := range c.journal {
+		if c.nodes[id].indexed {
+			c.removeVersion(id)
+		}
+		c.nodes[id] = *saved
+		if saved.indexed {
+			c.addVersion(id)
+		}
+	}
+	c.journal = nil
+	c.logger.Trace("Transaction rolled back")
+	return nil
+}
+
+// nameSet returns the names of a set of ids as a set.
+func (c *CompactIndexStore) nameSet(set idSet) map[string]bool {
+	names := make(map[string]bool, len(set))
+	for _, id := range set {
+		names[c.names[id]] = true
+	}
+	return names
+}
+
+// expanded returns a MapsIndexStore holding a copy of our Index.  It takes far more memory than we do,
+// so is only used to check and save our Index.
+func (c *CompactIndexStore) expanded() *MapsIndexStore {
+	expanded := NewIndexStore(c.logger).(*MapsIndexStore)
+	for id := range c.nodes {
+		lib := &c.nodes[id]
+		name := c.names[id]
+		if len(lib.dangling) > 0 {
+			expanded.dangling[name] = c.nameSet(lib.dangling)
+		}
+		if !lib.indexed {
+			continue
+		}
+		kinds := make(map[string]DependencyKind, len(lib.kinds))
+		for depID, kind := range lib.kinds {
+			kinds[c.names[depID]] = kind
+		}
+		expanded.store[name] = &Package{c.nameSet(lib.deps), kinds, c.nameSet(lib.parents), lib.info}
+		expanded.addVersion(name)
+	}
+	return expanded
+}
+
+// Check verifies our Index exactly as MapsIndexStore does.
+func (c *CompactIndexStore) Check() (problems []Problem, error error) {
+	return c.expanded().Check()
+}
+
+// Save writes our Index in the same form as MapsIndexStore, so either can load it.
+func (c *CompactIndexStore) Save(w io.Writer) (error error) {
+	if c.journal != nil {
+		return err.NewIndexError("Unable to save within a transaction")
+	}
+	return c.expanded().Save(w)
+}
+
+// Load replaces our Index with one saved by either CompactIndexStore or MapsIndexStore.
+func (c *CompactIndexStore) Load(r io.Reader) (error error) {
+	if c.journal != nil {
+		return err.NewIndexError("Unable to load within a transaction")
+	}
+	loaded := NewIndexStore(c.logger).(*MapsIndexStore)
+	if error = loaded.Load(r); error != nil {
+		return error
+	}
+	*c = *NewCompactIndexStore(c.logger).(*CompactIndexStore)
+	// every name is interned before any node is held.
+	for name, lib := range loaded.store {
+		c.intern(name)
+		for dep := range lib.Dependencies {
+			c.intern(dep)
+		}
+		for parent := range lib.Parents {
+			c.intern(parent)
+		}
+	}
+	for dep, dependents := range loaded.dangling {
+		c.intern(dep)
+		for dependent := range dependents {
+			c.intern(dependent)
+		}
+	}
+	for name, lib := range loaded.store {
+		id := c.ids[name]
+		loadedNode := &c.nodes[id]
+		loadedNode.indexed = true
+		loadedNode.info = lib.Info
+		for dep := range lib.Dependencies {
+			loadedNode.deps.add(c.ids[dep])
+		}
+		for dep, kind := range lib.Kinds {
+			if loadedNode.kinds == nil {
+				loadedNode.kinds = make(map[nameID]DependencyKind)
+			}
+			loadedNode.kinds[c.ids[dep]] = kind
+		}
+		for parent := range lib.Parents {
+			loadedNode.parents.add(c.ids[parent])
+		}
+	}
+	for dep, dependents := range loaded.dangling {
+		depNode := &c.nodes[c.ids[dep]]
+		for dependent := range dependents {
+			depNode.dangling.add(c.ids[dependent])
+		}
+	}
+	for name := range loaded.store {
+		c.addVersion(c.ids[name])
+	}
+	return nil
+}
+
+// NewCompactIndexStore creates an empty CompactIndexStore.
+func NewCompactIndexStore(logger logging.Logger) IndexStore {
+	return &CompactIndexStore{
+		make([]string, 0),
+		make(map[string]nameID),
+		make([]node, 0),
+		make(map[nameID]idSet),
+		nil,
+		logger,
+	}
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+func newTestCompactStore() *CompactIndexStore {
+	logLevel := "FATAL"
+	return NewCompactIndexStore(logging.NewIndexLogger(&logLevel)).(*CompactIndexStore)
+}
+
+// Tests that each name is interned once, however many packages refer to it, and is reused once indexed again.
+func TestCompactInterning(t *testing.T) {
+	store := newTestCompactStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	store.AddPackage("app", []string{"base", "lib"})
+	if (len(store.names) != 3) {
+		t.Errorf("Each name should be interned once, got %v", store.names)
+	}
+	store.RemovePackage("app")
+	store.AddPackage("app", []string{"lib"})
+	if (len(store.names) != 3) {
+		t.Errorf("Names should be reused once indexed again, got %v", store.names)
+	}
+	if parents, _ := store.GetParents("lib"); (len(parents) != 1 || parents[0] != "app") {
+		t.Errorf("Parents should be kept, got %v", parents)
+	}
+}
+
+// Tests that dependencies and parents are returned sorted by name, whatever order they were interned in.
+func TestCompactSorted(t *testing.T) {
+	store := newTestCompactStore()
+	store.AddPackage("zlib", nil)
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"zlib", "base"})
+	store.AddPackage("app", []string{"zlib"})
+	if deps, _ := store.GetDependencies("lib"); (len(deps) != 2 || deps[0] != "base" || deps[1] != "zlib") {
+		t.Errorf("Dependencies should be sorted, got %v", deps)
+	}
+	if parents, _ := store.GetParents("zlib"); (len(parents) != 2 || parents[0] != "app" || parents[1] != "lib") {
+		t.Errorf("Parents should be sorted, got %v", parents)
+	}
+}
+
+// Tests that a compact store saves in the same form as our in memory store, so either loads it.
+func TestCompactSaveLoad(t *testing.T) {
+	store := newTestCompactStore()
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+	store.AddTypedPackage("package", []string{"dep1", "dep2", "extra"}, map[string]DependencyKind{"extra": KindOptional})
+	store.ForceRemovePackage("dep2")
+
+	var saved bytes.Buffer
+	if err := store.Save(&saved); (err != nil) {
+		t.Fatalf("Error encountered saving store : %s", err.Error())
+	}
+	maps := newTestMapsStore()
+	if err := maps.Load(bytes.NewReader(saved.Bytes())); (err != nil) {
+		t.Fatalf("Error encountered loading store : %s", err.Error())
+	}
+	if parents, _ := maps.GetParents("dep1"); (len(parents) != 1 || parents[0] != "package") {
+		t.Errorf("Parents should be loaded, got %v", parents)
+	}
+
+	loaded := newTestCompactStore()
+	if err := loaded.Load(&saved); (err != nil) {
+		t.Fatalf("Error encountered loading store : %s", err.Error())
+	}
+	if kinds, _ := loaded.GetDependencyKinds("package"); (kinds["extra"] != KindOptional || kinds["dep1"] != KindRuntime) {
+		t.Errorf("Dependency kinds should be loaded, got %v", kinds)
+	}
+	loaded.AddPackage("dep2", nil)
+	if parents, _ := loaded.GetParents("dep2"); (len(parents) != 1 || parents[0] != "package") {
+		t.Errorf("Dangling dependents should be loaded, got %v", parents)
+	}
+	if problems, _ := loaded.Check(); (len(problems) != 0) {
+		t.Errorf("Loaded store should be consistent, got %v", problems)
+	}
+}
+
+// buildGraph indexes packages that each depend on up to deps of the packages indexed before them.
+// Names are built separately for every reference, as they are when read from requests.
+func buildGraph(store IndexStore, packages int, deps int) {
+	for i := 0; i < packages; i++ {
+		names := make([]string, 0, deps)
+		for j := 1; j <= deps && j <= i; j++ {
+			names = append(names, fmt.Sprintf("package%d", i - j))
+		}
+		store.AddPackage(fmt.Sprintf("package%d", i), names)
+	}
+}
+
+// benchmarkMemory builds a graph in new stores, reporting how much memory each package held by a store takes.
+func benchmarkMemory(b *testing.B, newStore func() IndexStore) {
+	const packages = 10000
+	var total int64
+	var before, after runtime.MemStats
+	for n := 0; n < b.N; n++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		store := newStore()
+		buildGraph(store, packages, 8)
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		total += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+		runtime.KeepAlive(store)
+	}
+	b.ReportMetric(float64(total) / float64(b.N) / packages, "bytes/package")
+}
+
+// Benchmarks the memory taken by our in memory store.
+func BenchmarkMemoryMapsIndexStore(b *testing.B) {
+	benchmarkMemory(b, func() IndexStore {
+		return newTestMapsStore()
+	})
+}
+
+// Benchmarks the memory taken by our compact store.
+func BenchmarkMemoryCompactIndexStore(b *testing.B) {
+	benchmarkMemory(b, func() IndexStore {
+		return newTestCompactStore()
+	})
+}
@@ -0,0 +1,38 @@
+package data_test
+
+import (
+	"testing"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/data/storetest"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// Tests that our in memory store conforms to IndexStore.
+func TestMapsIndexStoreConformance(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	storetest.Run(t, func() data.IndexStore {
+		store, _ := data.NewBackendStore(data.DefaultBackend, "default", "", logger)
+		return store
+	})
+}
+
+// Tests that our sharded store conforms to IndexStore, with few enough shards that most packages share one.
+func TestShardedIndexStoreConformance(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	storetest.Run(t, func() data.IndexStore {
+		store, _ := data.NewBackendStore(data.ShardedBackend, "default", "3", logger)
+		return store
+	})
+}
+
+// Tests that our compact store conforms to IndexStore.
+func TestCompactIndexStoreConformance(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	storetest.Run(t, func() data.IndexStore {
+		store, _ := data.NewBackendStore(data.CompactBackend, "default", "", logger)
+		return store
+	})
+}
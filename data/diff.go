@@ -0,0 +1,114 @@
+package data
+
+import (
+	"strings"
+)
+
+// IndexDiff describes how one Index differs from another - the packages only indexed in the later Index,
+// those only indexed in the earlier Index, and those indexed in both whose dependencies differ.
+// Each list is sorted by name.
+type IndexDiff struct {
+	Added   []string
+	Removed []string
+	Changed []DependencyChange
+}
+
+// DependencyChange describes how the dependencies of a Package differ between two indexes.  Dependencies
+// are given along with their kind, as in build:gcc, so a dependency whose kind differs is both removed and added.
+type DependencyChange struct {
+	Name    string
+	Added   []string
+	Removed []string
+}
+
+// Diff compares two indexes, such as two snapshots, or a snapshot and the store it was taken from, using
+// only the methods of IndexStore.  Neither may change while they are compared.
+func Diff(before IndexStore, after IndexStore) (diff *IndexDiff, error error) {
+	diff = &IndexDiff{make([]string, 0), make([]string, 0), make([]DependencyChange, 0)}
+	earlier, listErr := dependencySets(before)
+	if listErr != nil {
+		return diff, listErr
+	}
+	later, listErr := dependencySets(after)
+	if listErr != nil {
+		return diff, listErr
+	}
+	names := make(map[string]bool, len(later))
+	for name := range earlier {
+		names[name] = true
+	}
+	for name := range later {
+		names[name] = true
+	}
+	for _, name := range sortedKeys(names) {
+		deps, wasIndexed := earlier[name]
+		replaced, isIndexed := later[name]
+		if !wasIndexed {
+			diff.Added = append(diff.Added, name)
+		} else if !isIndexed {
+			diff.Removed = append(diff.Removed, name)
+		} else if change := (DependencyChange{name, difference(replaced, deps), difference(deps, replaced)}); len(change.Added) + len(change.Removed) > 0 {
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+	return diff, nil
+}
+
+// dependencySets returns the dependencies of every Package in an Index, along with their kind.
+func dependencySets(store IndexStore) (sets map[string]map[string]bool, error error) {
+	names, listErr := store.ListPackages()
+	if listErr != nil {
+		return nil, listErr
+	}
+	sets = make(map[string]map[string]bool, len(names))
+	for _, name := range names {
+		kinds, kindsErr := store.GetDependencyKinds(name)
+		if kindsErr != nil {
+			return nil, kindsErr
+		}
+		deps := make(map[string]bool, len(kinds))
+		for dep, kind := range kinds {
+			deps[JoinDependencyKind(kind, dep)] = true
+		}
+		sets[name] = deps
+	}
+	return sets, nil
+}
+
+// difference returns the members of one set that are not members of another, sorted.
+func difference(set map[string]bool, other map[string]bool) []string {
+	only := make(map[string]bool)
+	for key := range set {
+		if !other[key] {
+			only[key] = true
+		}
+	}
+	return sortedKeys(only)
+}
+
+// Empty determines if the indexes compared were the same.
+func (d *IndexDiff) Empty() bool {
+	return len(d.Added) + len(d.Removed) + len(d.Changed) == 0
+}
+
+// Text formats our diff one line per Package, preceded by + if added, - if removed and ~ if its dependencies
+// changed, which follow on their own indented lines preceded by + or -.
+func (d *IndexDiff) Text() string {
+	var text strings.Builder
+	for _, name := range d.Added {
+		text.WriteString("+ " + name + "\n")
+	}
+	for _, name := range d.Removed {
+		text.WriteString("- " + name + "\n")
+	}
+	for _, change := range d.Changed {
+		text.WriteString("~ " + change.Name + "\n")
+		for _, dep := range change.Added {
+			text.WriteString("    + " + dep + "\n")
+		}
+		for _, dep := range change.Removed {
+			text.WriteString("    - " + dep + "\n")
+		}
+	}
+	return text.String()
+}
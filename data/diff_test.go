@@ -0,0 +1,48 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Tests that packages added, removed and whose dependencies changed since a snapshot are reported.
+func TestDiffSnapshot(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("old", nil)
+	store.AddPackage("lib", []string{"base", "old"})
+	store.AddPackage("tool", []string{"base"})
+	before, _ := store.Snapshot()
+
+	store.UpdatePackage("lib", []string{"base"}, nil)
+	store.RemovePackage("old")
+	store.UpdatePackage("tool", []string{"base"}, map[string]DependencyKind{"base": KindBuild})
+	store.AddPackage("app", []string{"lib"})
+
+	diff, diffErr := Diff(before, store)
+	if (diffErr != nil) {
+		t.Fatalf("Error encountered comparing indexes : %s", diffErr.Error())
+	}
+	encoded, _ := json.Marshal(diff)
+	expected := `{"Added":["app"],"Removed":["old"],"Changed":[` +
+		`{"Name":"lib","Added":[],"Removed":["old"]},` +
+		`{"Name":"tool","Added":["build:base"],"Removed":["base"]}]}`
+	if (string(encoded) != expected) {
+		t.Errorf("Differences should be reported, got %s", encoded)
+	}
+	text := "+ app\n- old\n~ lib\n    - old\n~ tool\n    + build:base\n    - base\n"
+	if (diff.Text() != text) {
+		t.Errorf("Differences should be formatted as text, got %s", diff.Text())
+	}
+}
+
+// Tests that an index does not differ from itself.
+func TestDiffSame(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	snapshot, _ := store.Snapshot()
+	if diff, _ := Diff(snapshot, store); (!diff.Empty() || len(diff.Text()) != 0) {
+		t.Errorf("Index should not differ from its snapshot, got %v", diff)
+	}
+}
@@ -0,0 +1,244 @@
+package data
+
+import (
+	"sort"
+	"strings"
+)
+
+// Kinds of Problem found when checking the consistency of our Index.
+const (
+	// packages depend on one another in a cycle.
+	ProblemCycle = "CYCLE"
+	// a package depends on another that does not list it as a parent, or lists a parent that does not depend on it.
+	ProblemAsymmetric = "ASYMMETRIC"
+	// a package refers to another that is not indexed, and was not forcibly removed.
+	ProblemDangling = "DANGLING"
+)
+
+// Problem is an inconsistency found in our Index, along with the packages involved.
+// A cycle lists every package in it, sorted.  Asymmetric and dangling problems list the package
+// referring to another, followed by the package referred to.
+type Problem struct {
+	Kind     string
+	Packages []string
+}
+
+// String formats a Problem as its kind followed by its packages, as in CYCLE:a,b.
+func (p Problem) String() string {
+	return p.Kind + ":" + strings.Join(p.Packages, ",")
+}
+
+// Checkable is anything whose consistency can be checked.
+type Checkable interface {
+	// Returns every Problem found, in a consistent order, or none if consistent.
+	Check() (problems []Problem, error error)
+}
+
+// Check verifies that Parents and Dependencies mirror one another, that every package referred to is
+// indexed or was forcibly removed, and that no packages depend on one another in a cycle.
+// Optional dependencies are expected to have no parent edge, and need not be indexed.
+func (m *MapsIndexStore) Check() (problems []Problem, error error) {
+	problems = make([]Problem, 0)
+	names, _ := m.ListPackages()
+	for _, name := range names {
+		lib := m.store[name]
+		for _, dep := range sortedKeys(lib.Dependencies) {
+			depPackage, indexed := m.store[dep]
+			optional := lib.kind(dep) == KindOptional
+			switch {
+			case !indexed && !optional && !m.dangling[dep][name]:
+				problems = append(problems, Problem{ProblemDangling, []string{name, dep}})
+			case indexed && depPackage.Parents[name] == optional:
+				problems = append(problems, Problem{ProblemAsymmetric, []string{name, dep}})
+			}
+		}
+		for _, parent := range sortedKeys(lib.Parents) {
+			parentPackage, indexed := m.store[parent]
+			if !indexed {
+				problems = append(problems, Problem{ProblemDangling, []string{name, parent}})
+			} else if !parentPackage.Dependencies[name] {
+				problems = append(problems, Problem{ProblemAsymmetric, []string{parent, name}})
+			}
+		}
+	}
+	for _, dep := range sortedDangling(m.dangling) {
+		for _, dependent := range sortedKeys(m.dangling[dep]) {
+			if lib, indexed := m.store[dependent]; !indexed || !lib.Dependencies[dep] {
+				problems = append(problems, Problem{ProblemDangling, []string{dependent, dep}})
+			}
+		}
+	}
+	for _, cycle := range m.cycles(names) {
+		problems = append(problems, Problem{ProblemCycle, cycle})
+	}
+	return problems, nil
+}
+
+// sortedDangling returns the forcibly removed packages that others still depend on, sorted.
+func sortedDangling(dangling map[string]map[string]bool) []string {
+	keys := make(map[string]bool, len(dangling))
+	for key := range dangling {
+		keys[key] = true
+	}
+	return sortedKeys(keys)
+}
+
+// componentSet returns the first member of each component as a set.
+func componentSet(components map[string][]string) map[string]bool {
+	keys := make(map[string]bool, len(components))
+	for key := range components {
+		keys[key] = true
+	}
+	return keys
+}
+
+// cycles finds every group of packages that depend on one another in a cycle.
+func (m *MapsIndexStore) cycles(names []string) (cycles [][]string) {
+	dependencies := make(map[string][]string, len(names))
+	for _, name := range names {
+		dependencies[name] = sortedKeys(m.store[name].Dependencies)
+	}
+	return findCycles(names, dependencies)
+}
+
+// CheckGraph verifies that the Parents and Dependencies of any IndexStore mirror one another, and that
+// no packages depend on one another in a cycle, using only the methods of IndexStore.  Unlike Check, it
+// cannot tell a dependency that was forcibly removed from one that is dangling, so reports neither.
+func CheckGraph(store IndexStore) (problems []Problem, error error) {
+	problems = make([]Problem, 0)
+	names, listErr := store.ListPackages()
+	if listErr != nil {
+		return problems, listErr
+	}
+	dependencies := make(map[string][]string, len(names))
+	for _, name := range names {
+		kinds, kindsErr := store.GetDependencyKinds(name)
+		if kindsErr != nil {
+			return problems, kindsErr
+		}
+		deps := make(map[string]bool, len(kinds))
+		for dep := range kinds {
+			deps[dep] = true
+		}
+		dependencies[name] = sortedKeys(deps)
+		for _, dep := range dependencies[name] {
+			indexed, hasErr := store.HasPackage(dep)
+			if hasErr != nil {
+				return problems, hasErr
+			}
+			if !indexed {
+				continue
+			}
+			parents, parentsErr := store.GetParents(dep)
+			if parentsErr != nil {
+				return problems, parentsErr
+			}
+			if contains(parents, name) == (kinds[dep] == KindOptional) {
+				problems = append(problems, Problem{ProblemAsymmetric, []string{name, dep}})
+			}
+		}
+		parents, parentsErr := store.GetParents(name)
+		if parentsErr != nil {
+			return problems, parentsErr
+		}
+		for _, parent := range parents {
+			indexed, hasErr := store.HasPackage(parent)
+			if hasErr != nil {
+				return problems, hasErr
+			}
+			if !indexed {
+				problems = append(problems, Problem{ProblemDangling, []string{name, parent}})
+				continue
+			}
+			parentDeps, depsErr := store.GetDependencies(parent)
+			if depsErr != nil {
+				return problems, depsErr
+			}
+			if !contains(parentDeps, name) {
+				problems = append(problems, Problem{ProblemAsymmetric, []string{parent, name}})
+			}
+		}
+	}
+	for _, cycle := range findCycles(names, dependencies) {
+		problems = append(problems, Problem{ProblemCycle, cycle})
+	}
+	return problems, nil
+}
+
+// findCycles finds every group of packages that depend on one another in a cycle, including a package
+// that depends on itself, using Tarjan's strongly connected components algorithm.  Components are
+// found by walking dependencies with an explicit stack, so that long chains cannot overflow.
+// Dependencies on packages that are not named are ignored.
+func findCycles(names []string, dependencies map[string][]string) (cycles [][]string) {
+	// components are keyed by their first member, so that they can be returned in order.
+	components := make(map[string][]string)
+	index := make(map[string]int, len(names))
+	lowest := make(map[string]int, len(names))
+	onStack := make(map[string]bool)
+	stack := make([]string, 0)
+
+	// frame is a package being visited, along with its dependencies still to be walked.
+	type frame struct {
+		name string
+		deps []string
+	}
+	for _, root := range names {
+		if _, visited := index[root]; visited {
+			continue
+		}
+		walk := []*frame{{root, nil}}
+		for len(walk) > 0 {
+			current := walk[len(walk)-1]
+			if _, visited := index[current.name]; !visited {
+				index[current.name] = len(index)
+				lowest[current.name] = index[current.name]
+				stack = append(stack, current.name)
+				onStack[current.name] = true
+				current.deps = dependencies[current.name]
+			}
+			if len(current.deps) > 0 {
+				dep := current.deps[0]
+				current.deps = current.deps[1:]
+				if _, named := dependencies[dep]; !named {
+					continue
+				}
+				if _, visited := index[dep]; !visited {
+					walk = append(walk, &frame{dep, nil})
+				} else if onStack[dep] && index[dep] < lowest[current.name] {
+					lowest[current.name] = index[dep]
+				}
+				continue
+			}
+
+			walk = walk[:len(walk)-1]
+			if len(walk) > 0 {
+				parent := walk[len(walk)-1].name
+				if lowest[current.name] < lowest[parent] {
+					lowest[parent] = lowest[current.name]
+				}
+			}
+			if lowest[current.name] != index[current.name] {
+				continue
+			}
+			component := make([]string, 0)
+			for {
+				member := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[member] = false
+				component = append(component, member)
+				if member == current.name {
+					break
+				}
+			}
+			if len(component) > 1 || contains(dependencies[current.name], current.name) {
+				sort.Strings(component)
+				components[component[0]] = component
+			}
+		}
+	}
+	cycles = make([][]string, 0, len(components))
+	for _, first := range sortedKeys(componentSet(components)) {
+		cycles = append(cycles, components[first])
+	}
+	return cycles
+}
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"testing"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+func newCheckedStore() *MapsIndexStore {
+	logLevel := "FATAL"
+	return NewIndexStore(logging.NewIndexLogger(&logLevel)).(*MapsIndexStore)
+}
+
+func problemStrings(problems []Problem) []string {
+	formatted := make([]string, len(problems))
+	for i, problem := range problems {
+		formatted[i] = problem.String()
+	}
+	return formatted
+}
+
+// Tests that a consistent store has no problems, including forcibly removed and optional dependencies.
+func TestCheckConsistent(t *testing.T) {
+	store := newCheckedStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	store.AddTypedPackage("app", []string{"lib", "docs"}, map[string]DependencyKind{"docs": KindOptional})
+	store.ForceRemovePackage("base")
+	if problems, err := store.Check(); (err != nil || len(problems) != 0) {
+		t.Errorf("Consistent store should have no problems, got %v", problemStrings(problems))
+	}
+}
+
+// Tests that edges only recorded in one direction are found.
+func TestCheckAsymmetric(t *testing.T) {
+	store := newCheckedStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	store.AddPackage("other", nil)
+	delete(store.store["base"].Parents, "lib")
+	store.store["other"].Parents["lib"] = true
+	problems, _ := store.Check()
+	formatted := problemStrings(problems)
+	if (len(formatted) != 2 || formatted[0] != "ASYMMETRIC:lib,base" || formatted[1] != "ASYMMETRIC:lib,other") {
+		t.Errorf("Asymmetric edges should be found, got %v", formatted)
+	}
+}
+
+// Tests that references to packages that are not indexed, and were not forcibly removed, are found.
+func TestCheckDangling(t *testing.T) {
+	store := newCheckedStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	delete(store.store, "base")
+	store.store["lib"].Parents["gone"] = true
+	problems, _ := store.Check()
+	formatted := problemStrings(problems)
+	if (len(formatted) != 2 || formatted[0] != "DANGLING:lib,base" || formatted[1] != "DANGLING:lib,gone") {
+		t.Errorf("Dangling references should be found, got %v", formatted)
+	}
+}
+
+// Tests that packages depending on one another in a cycle are found, including a package depending on itself.
+func TestCheckCycles(t *testing.T) {
+	store := newCheckedStore()
+	store.AddPackage("a", nil)
+	store.AddPackage("b", []string{"a"})
+	store.AddPackage("c", []string{"b"})
+	store.AddPackage("d", nil)
+	store.store["a"].Dependencies["c"] = true
+	store.store["c"].Parents["a"] = true
+	store.store["d"].Dependencies["d"] = true
+	store.store["d"].Parents["d"] = true
+	problems, _ := store.Check()
+	formatted := problemStrings(problems)
+	if (len(formatted) != 2 || formatted[0] != "CYCLE:a,b,c" || formatted[1] != "CYCLE:d") {
+		t.Errorf("Cycles should be found, got %v", formatted)
+	}
+}
+
+// Tests that the graph of any store is checked through IndexStore alone.
+func TestCheckGraph(t *testing.T) {
+	store := newCheckedStore()
+	store.AddPackage("a", nil)
+	store.AddPackage("b", []string{"a"})
+	store.AddPackage("c", []string{"b"})
+	store.ForceRemovePackage("a")
+	if problems, err := CheckGraph(store); (err != nil || len(problems) != 0) {
+		t.Errorf("Consistent store should have no problems, got %v", problemStrings(problems))
+	}
+	store.AddPackage("a", nil)
+	store.store["a"].Dependencies["c"] = true
+	store.store["c"].Parents["a"] = true
+	delete(store.store["b"].Parents, "c")
+	problems, _ := CheckGraph(store)
+	formatted := problemStrings(problems)
+	if (len(formatted) != 2 || formatted[0] != "ASYMMETRIC:c,b" || formatted[1] != "CYCLE:a,b,c") {
+		t.Errorf("Problems should be found, got %v", formatted)
+	}
+}
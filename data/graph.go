@@ -0,0 +1,179 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"github.com/kristenfelch/pkgindexer/err"
+)
+
+// MaxPaths bounds the chains Paths returns, including when it is given no limit.
+const MaxPaths = 1000
+
+// maxSearched bounds the partial chains Paths searches through, as a graph can hold exponentially many chains
+// between two packages, such as a chain of diamonds.
+const maxSearched = 100000
+
+// ShortestPath finds one shortest chain of dependencies leading from Package 'from' to Package 'to',
+// using a breadth first search over each Package's Dependencies.  The returned chain begins with
+// 'from' and ends with 'to'.  An empty chain is returned if 'to' is not a (transitive) dependency of 'from'.
+// Only dependencies of the kinds allowed by our filter are followed.  Each Package is visited once, recording
+// the Package it was first reached from, so that the chain leading to 'to' can be followed back once it is reached.
+func ShortestPath(store IndexStore, from string, to string, kinds KindFilter) (path []string, error error) {
+	exists, existsErr := store.HasPackage(from)
+	if existsErr != nil || !exists {
+		return []string{}, existsErr
+	}
+	previous := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if name == to {
+			for ; len(name) > 0; name = previous[name] {
+				path = append([]string{name}, path...)
+			}
+			return path, nil
+		}
+		deps, depsErr := dependenciesOf(store, name, kinds)
+		if depsErr != nil {
+			return []string{}, depsErr
+		}
+		for _, dep := range deps {
+			if _, ok := previous[dep]; !ok {
+				previous[dep] = name
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return []string{}, nil
+}
+
+// Paths finds up to limit chains of dependencies leading from Package 'from' to Package 'to',
+// shortest chains first.  Chains never visit the same Package twice, so cycles in the graph
+// do not produce endless results.  A limit of zero or less, or beyond MaxPaths, returns up to MaxPaths chains.
+// Only dependencies of the kinds allowed by our filter are followed.  Fails once more partial chains
+// have been searched than we allow, rather than holding up every other request while it searches.
+func Paths(store IndexStore, from string, to string, limit int, kinds KindFilter) (paths [][]string, error error) {
+	paths = make([][]string, 0)
+	if limit <= 0 || limit > MaxPaths {
+		limit = MaxPaths
+	}
+	exists, existsErr := store.HasPackage(from)
+	if existsErr != nil || !exists {
+		return paths, existsErr
+	}
+
+	// Restrict our search to packages that can actually reach the target, so that we never
+	// expand chains that are dead ends.
+	reaches, reachesErr := reachingPackages(store, from, to, kinds)
+	if reachesErr != nil {
+		return paths, reachesErr
+	}
+	if !reaches[from] {
+		return paths, nil
+	}
+
+	queue := [][]string{{from}}
+	for searched := 0; len(queue) > 0; searched++ {
+		if searched >= maxSearched {
+			return paths, err.NewCodedIndexError(err.CodeInvalidArgument, fmt.Sprintf("Too many chains of dependencies from %s to %s to search", from, to))
+		}
+		current := queue[0]
+		queue = queue[1:]
+		last := current[len(current)-1]
+		if last == to {
+			paths = append(paths, current)
+			if len(paths) >= limit {
+				break
+			}
+			continue
+		}
+		deps, depsErr := dependenciesOf(store, last, kinds)
+		if depsErr != nil {
+			return paths, depsErr
+		}
+		for _, dep := range deps {
+			if reaches[dep] && !contains(current, dep) {
+				next := make([]string, len(current), len(current)+1)
+				copy(next, current)
+				queue = append(queue, append(next, dep))
+			}
+		}
+	}
+	return paths, nil
+}
+
+// reachingPackages determines which packages reachable from 'from' can themselves reach 'to'.
+// We first walk forward from 'from' recording reversed edges, and then walk those reversed
+// edges back from 'to', which remains correct when the graph contains cycles.
+func reachingPackages(store IndexStore, from string, to string, kinds KindFilter) (reaches map[string]bool, error error) {
+	reversed := make(map[string][]string)
+	visited := map[string]bool{from: true}
+	queue := []string{from}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		deps, depsErr := dependenciesOf(store, name, kinds)
+		if depsErr != nil {
+			return nil, depsErr
+		}
+		for _, dep := range deps {
+			reversed[dep] = append(reversed[dep], name)
+			if !visited[dep] {
+				visited[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	reaches = make(map[string]bool)
+	if !visited[to] {
+		return reaches, nil
+	}
+	reaches[to] = true
+	queue = []string{to}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, parent := range reversed[name] {
+			if !reaches[parent] {
+				reaches[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return reaches, nil
+}
+
+// dependenciesOf returns the Dependencies of a Package of the kinds allowed by our filter, sorted by name,
+// or none if the Package is not indexed.
+func dependenciesOf(store IndexStore, name string, kinds KindFilter) (deps []string, error error) {
+	exists, existsErr := store.HasPackage(name)
+	if existsErr != nil || !exists {
+		return []string{}, existsErr
+	}
+	if len(kinds) == 0 {
+		return store.GetDependencies(name)
+	}
+	depKinds, kindsErr := store.GetDependencyKinds(name)
+	if kindsErr != nil {
+		return []string{}, kindsErr
+	}
+	deps = make([]string, 0, len(depKinds))
+	for dep, kind := range depKinds {
+		if kinds.Allows(kind) {
+			deps = append(deps, dep)
+		}
+	}
+	sort.Strings(deps)
+	return deps, nil
+}
+
+func contains(list []string, name string) bool {
+	for _, v := range list {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+	"strings"
+	"time"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// newDiamondStore creates a store where top depends on left and right, which both depend on bottom.
+func newDiamondStore() IndexStore {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("bottom", nil)
+	store.AddPackage("left", []string{"bottom"})
+	store.AddPackage("right", []string{"bottom"})
+	store.AddPackage("top", []string{"left", "right"})
+	return store
+}
+
+// Tests that a direct dependency is explained by a chain of length two.
+func TestShortestPathDirect(t *testing.T) {
+	store := newDiamondStore()
+	path, err := ShortestPath(store, "top", "left", nil)
+	if (err != nil || strings.Join(path, ",") != "top,left") {
+		t.Errorf("Incorrect path for direct dependency : %v", path)
+	}
+}
+
+// Tests that in a diamond graph, exactly one shortest chain is returned.
+func TestShortestPathDiamond(t *testing.T) {
+	store := newDiamondStore()
+	path, err := ShortestPath(store, "top", "bottom", nil)
+	if (err != nil || strings.Join(path, ",") != "top,left,bottom") {
+		t.Errorf("Incorrect shortest path through diamond : %v", path)
+	}
+}
+
+// Tests that in a diamond graph, both chains are returned when all are requested.
+func TestPathsDiamond(t *testing.T) {
+	store := newDiamondStore()
+	paths, err := Paths(store, "top", "bottom", 0, nil)
+	if (err != nil || len(paths) != 2) {
+		t.Fatalf("Both chains through diamond should be returned : %v", paths)
+	}
+	if (strings.Join(paths[0], ",") != "top,left,bottom" || strings.Join(paths[1], ",") != "top,right,bottom") {
+		t.Errorf("Incorrect chains through diamond : %v", paths)
+	}
+}
+
+// Tests that the number of chains returned respects our limit.
+func TestPathsLimit(t *testing.T) {
+	store := newDiamondStore()
+	paths, err := Paths(store, "top", "bottom", 1, nil)
+	if (err != nil || len(paths) != 1) {
+		t.Errorf("Only one chain should be returned when limited : %v", paths)
+	}
+}
+
+// Tests that no chain is returned when the target is not a dependency.
+func TestShortestPathUnreachable(t *testing.T) {
+	store := newDiamondStore()
+	path, err := ShortestPath(store, "bottom", "top", nil)
+	if (err != nil || len(path) != 0) {
+		t.Errorf("No path should be found to a package that is not a dependency : %v", path)
+	}
+	path, err = ShortestPath(store, "left", "right", nil)
+	if (err != nil || len(path) != 0) {
+		t.Errorf("No path should be found between siblings : %v", path)
+	}
+}
+
+// Tests that no chain is returned when either package has not been indexed.
+func TestShortestPathNotIndexed(t *testing.T) {
+	store := newDiamondStore()
+	path, err := ShortestPath(store, "missing", "bottom", nil)
+	if (err != nil || len(path) != 0) {
+		t.Errorf("No path should be found from an unindexed package : %v", path)
+	}
+	path, err = ShortestPath(store, "top", "missing", nil)
+	if (err != nil || len(path) != 0) {
+		t.Errorf("No path should be found to an unindexed package : %v", path)
+	}
+}
+
+// Tests that cycles in the graph do not cause endless or repeated chains.
+func TestPathsWithCycle(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("target", nil)
+	store.AddPackage("b", nil)
+	store.AddPackage("a", []string{"b"})
+	// re-index b so that a and b depend on each other.
+	store.RemovePackage("b")
+	store.AddPackage("b", []string{"a", "target"})
+	paths, err := Paths(store, "a", "target", 0, nil)
+	if (err != nil || len(paths) != 1 || strings.Join(paths[0], ",") != "a,b,target") {
+		t.Errorf("Incorrect chains through cycle : %v", paths)
+	}
+}
+
+// Tests that chains only follow dependencies of the kinds allowed.
+func TestPathsFilteredByKind(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("target", nil)
+	store.AddPackage("compiler", []string{"target"})
+	store.AddPackage("lib", []string{"target"})
+	store.AddTypedPackage("app", []string{"compiler", "lib"}, map[string]DependencyKind{"compiler": KindBuild})
+
+	paths, err := Paths(store, "app", "target", 0, nil)
+	if (err != nil || len(paths) != 2) {
+		t.Errorf("Every kind should be followed without a filter : %v", paths)
+	}
+	runtime, _ := ParseKindFilter("runtime")
+	paths, err = Paths(store, "app", "target", 0, runtime)
+	if (err != nil || len(paths) != 1 || strings.Join(paths[0], ",") != "app,lib,target") {
+		t.Errorf("Only runtime dependencies should be followed : %v", paths)
+	}
+	optional, _ := ParseKindFilter("optional")
+	if path, _ := ShortestPath(store, "app", "target", optional); (len(path) != 0) {
+		t.Errorf("No chain of optional dependencies should be found : %v", path)
+	}
+}
+
+// newDiamondChainStore creates a store where top depends on a chain of diamonds, each of whose bottom is the top
+// of the next, ending at bottom, so that there are two chains through each diamond.
+func newDiamondChainStore(diamonds int) IndexStore {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("bottom", nil)
+	below := "bottom"
+	for i := diamonds; i > 0; i-- {
+		left, right, top := fmt.Sprintf("left%d", i), fmt.Sprintf("right%d", i), fmt.Sprintf("top%d", i)
+		store.AddPackage(left, []string{below})
+		store.AddPackage(right, []string{below})
+		store.AddPackage(top, []string{left, right})
+		below = top
+	}
+	store.AddPackage("top", []string{below})
+	return store
+}
+
+// Tests that a shortest chain through a chain of diamonds is found without searching every chain.
+func TestShortestPathDiamondChain(t *testing.T) {
+	store := newDiamondChainStore(40)
+	start := time.Now()
+	path, err := ShortestPath(store, "top", "bottom", nil)
+	if (err != nil || len(path) != 2 + 2 * 40 || path[1] != "top1" || path[len(path) - 2] != "left40") {
+		t.Errorf("Incorrect shortest path through chain of diamonds : %v", path)
+	}
+	if (time.Since(start) > time.Second) {
+		t.Errorf("Shortest path should be found quickly, took %v", time.Since(start))
+	}
+}
+
+// Tests that searching too many chains fails rather than holding up other requests, and that chains are capped
+// when no limit is given.
+func TestPathsBounded(t *testing.T) {
+	if _, err := Paths(newDiamondChainStore(40), "top", "bottom", 0, nil); (err == nil) {
+		t.Error("Searching exponentially many chains should fail")
+	}
+	paths, err := Paths(newDiamondChainStore(12), "top", "bottom", 0, nil)
+	if (err != nil || len(paths) != MaxPaths) {
+		t.Errorf("Chains should be capped without a limit, got %d %v", len(paths), err)
+	}
+}
@@ -0,0 +1,355 @@
+package data
+
+import (
+	"time"
+)
+
+// HistoricalStore is an IndexStore that keeps a history of the changes made to each Package, bounded by a
+// Retention, so that it can report the state of a Package at an earlier Moment.
+type HistoricalStore interface {
+	IndexStore
+
+	// Keeps changes in our history only for as long as retention allows.
+	Retain(retention Retention)
+
+	// Returns the dependencies, kinds and info a Package had at moment, or nil if it was not indexed then.
+	// Indicates if our history reaches back to moment, as the state of a Package is otherwise unknown.
+	PackageAt(name string, moment Moment) (lib *Package, known bool, error error)
+
+	// Returns an immutable IndexStore holding our Index as it was at a time, as Snapshot does for now.
+	// Indicates if our history reaches back to that time.
+	IndexAt(at time.Time) (snapshot IndexStore, known bool, error error)
+}
+
+// Retention bounds the history kept of our Index, by the age of each change and the number of changes kept.
+// Either bound is unlimited if 0.
+type Retention struct {
+	Age     time.Duration
+	Changes int
+}
+
+// DefaultRetention keeps a week of changes, up to 100000 of them.
+var DefaultRetention = Retention{7 * 24 * time.Hour, 100000}
+
+// Moment identifies a point in the history of a Package, either a Time, or the Revision it had in
+// its PackageInfo, which is used unless 0.
+type Moment struct {
+	Time     time.Time
+	Revision int
+}
+
+// Change records the state of a Package once it was indexed, updated or removed, which is nil once removed.
+type Change struct {
+	Name    string
+	Time    time.Time
+	Package *Package
+}
+
+// history records every Change made to our Index since some time, oldest first, pruning those no longer
+// retained.  Its maps are shared with snapshots, so are copied before they are changed while shared.
+type history struct {
+	retention Retention
+	// since is the earliest time our history knows the state of every Package at.
+	since time.Time
+	// pruned records whether changes have ever been pruned, so the earlier revisions of a Package may be unknown.
+	pruned  bool
+	changes []*Change
+	// packages holds the changes to each Package, oldest first.
+	packages map[string][]*Change
+	// base holds the latest pruned change to each Package with changes that are still retained, as the state
+	// they were changed from, unless it was removed.
+	base map[string]*Change
+	// sealed is our latest change when a snapshot last shared our history or a transaction last began, which may
+	// no longer be amended, as the snapshot holds it, or rolling back would not undo its amendment.
+	sealed *Change
+}
+
+// newHistory creates an empty history, which knows every Package from now on.
+func newHistory(retention Retention) *history {
+	return &history{
+		retention,
+		time.Now(),
+		false,
+		make([]*Change, 0),
+		make(map[string][]*Change),
+		make(map[string]*Change),
+		nil,
+	}
+}
+
+// share returns a copy of our history sharing our changes, for a snapshot.
+func (h *history) share() *history {
+	if h == nil {
+		return nil
+	}
+	h.seal()
+	shared := *h
+	return &shared
+}
+
+// unshare copies our maps, which a snapshot shares.  Lists of changes are only ever appended to or
+// shortened, never changed within, so are still shared.
+func (h *history) unshare() {
+	if h == nil {
+		return
+	}
+	packages := make(map[string][]*Change, len(h.packages))
+	for name, changes := range h.packages {
+		packages[name] = changes
+	}
+	base := make(map[string]*Change, len(h.base))
+	for name, change := range h.base {
+		base[name] = change
+	}
+	h.packages, h.base = packages, base
+}
+
+// seal keeps our latest change from being amended.
+func (h *history) seal() {
+	if len(h.changes) > 0 {
+		h.sealed = h.changes[len(h.changes) - 1]
+	}
+}
+
+// recorded returns the state of a Package as our history records it, without its parents, or nil if removed.
+func recorded(lib *Package) *Package {
+	if lib == nil {
+		return nil
+	}
+	kinds := make(map[string]DependencyKind, len(lib.Kinds))
+	for dep, kind := range lib.Kinds {
+		kinds[dep] = kind
+	}
+	return &Package{copySet(lib.Dependencies), kinds, nil, lib.Info}
+}
+
+// add records the state of a Package once changed, which is nil if it was removed.
+func (h *history) add(name string, lib *Package) {
+	change := &Change{name, time.Now(), recorded(lib)}
+	h.changes = append(h.changes, change)
+	h.packages[name] = append(h.packages[name], change)
+}
+
+// amend replaces the state recorded by our latest change with the state of a Package once its info is set,
+// keeping the time of the change, if the change was to the same revision of the same Package and is not sealed.
+// Indicates if it was amended.
+func (h *history) amend(name string, lib *Package) bool {
+	if len(h.changes) == 0 || lib == nil {
+		return false
+	}
+	latest := h.changes[len(h.changes) - 1]
+	if latest == h.sealed || latest.Name != name || latest.Package == nil || latest.Package.Info.Revision != lib.Info.Revision {
+		return false
+	}
+	latest.Package = recorded(lib)
+	return true
+}
+
+// truncate discards every change after the first count, undoing them.
+func (h *history) truncate(count int) {
+	for i := len(h.changes) - 1; i >= count; i-- {
+		name := h.changes[i].Name
+		changes := h.packages[name][:len(h.packages[name]) - 1]
+		if len(changes) == 0 {
+			delete(h.packages, name)
+			delete(h.base, name)
+		} else {
+			h.packages[name] = changes
+		}
+	}
+	h.changes = h.changes[:count]
+}
+
+// expired determines if our oldest change is no longer retained.
+func (h *history) expired(now time.Time) bool {
+	if h == nil || len(h.changes) == 0 {
+		return false
+	}
+	if h.retention.Changes > 0 && len(h.changes) > h.retention.Changes {
+		return true
+	}
+	return h.retention.Age > 0 && now.Sub(h.changes[0].Time) > h.retention.Age
+}
+
+// prune discards every change no longer retained, oldest first.
+func (h *history) prune(now time.Time) {
+	for h.expired(now) {
+		oldest := h.changes[0]
+		h.changes = h.changes[1:]
+		h.since = oldest.Time
+		h.pruned = true
+		changes := h.packages[oldest.Name][1:]
+		if len(changes) == 0 {
+			// the Package is as it now is at every time we still know.
+			delete(h.packages, oldest.Name)
+			delete(h.base, oldest.Name)
+			continue
+		}
+		h.packages[oldest.Name] = changes
+		if oldest.Package != nil {
+			h.base[oldest.Name] = oldest
+		} else {
+			delete(h.base, oldest.Name)
+		}
+	}
+}
+
+// at returns the state of a Package at moment, given its current state, indicating if it is known.
+func (h *history) at(name string, moment Moment, current *Package) (lib *Package, known bool) {
+	changes := h.packages[name]
+	if moment.Revision > 0 {
+		for i := len(changes) - 1; i >= 0; i-- {
+			if changes[i].Package != nil && changes[i].Package.Info.Revision == moment.Revision {
+				return changes[i].Package, true
+			}
+		}
+		if base, ok := h.base[name]; ok && base.Package.Info.Revision == moment.Revision {
+			return base.Package, true
+		}
+		if len(changes) == 0 && current != nil && current.Info.Revision == moment.Revision {
+			return current, true
+		}
+		return nil, !h.pruned
+	}
+	if moment.Time.Before(h.since) {
+		return nil, false
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if !changes[i].Time.After(moment.Time) {
+			return changes[i].Package, true
+		}
+	}
+	if len(changes) > 0 {
+		if base, ok := h.base[name]; ok {
+			return base.Package, true
+		}
+		return nil, true
+	}
+	return current, true
+}
+
+// savedHistory is the saved form of our history.
+type savedHistory struct {
+	Since   time.Time
+	Pruned  bool
+	Changes []*Change
+	Base    map[string]*Change
+}
+
+// saved returns the saved form of our history.
+func (h *history) saved() *savedHistory {
+	if h == nil {
+		return nil
+	}
+	return &savedHistory{h.since, h.pruned, h.changes, h.base}
+}
+
+// loadHistory restores a saved history, or starts a new one if nothing was saved.
+func loadHistory(saved *savedHistory, retention Retention) *history {
+	loaded := newHistory(retention)
+	if saved == nil {
+		return loaded
+	}
+	loaded.since, loaded.pruned = saved.Since, saved.Pruned
+	for _, change := range saved.Changes {
+		loaded.changes = append(loaded.changes, change)
+		loaded.packages[change.Name] = append(loaded.packages[change.Name], change)
+	}
+	for name, change := range saved.Base {
+		loaded.base[name] = change
+	}
+	return loaded
+}
+
+// Retain keeps changes in our history only for as long as retention allows, pruning them once changed.
+func (m *MapsIndexStore) Retain(retention Retention) {
+	if m.history != nil {
+		m.history.retention = retention
+	}
+}
+
+// PackageAt answers from our history.  A snapshot has the history of its store when it was taken.
+func (m *MapsIndexStore) PackageAt(name string, moment Moment) (lib *Package, known bool, error error) {
+	if m.history == nil {
+		return nil, false, nil
+	}
+	lib, known = m.history.at(name, moment, m.store[name])
+	if lib != nil {
+		// our history does not record parents, so neither is the current Package reported with them.
+		lib = lib.copy()
+		lib.Parents = make(map[string]bool)
+	}
+	return lib, known, nil
+}
+
+// remember records the state of a Package in our history once changed, pruning changes no longer retained
+// unless within a transaction, which may still be rolled back.
+func (m *MapsIndexStore) remember(name string) {
+	if m.history == nil {
+		return
+	}
+	m.history.add(name, m.store[name])
+	if m.journal == nil {
+		m.prune()
+	}
+}
+
+// rememberInfo records the info of a Package in our history once set.  Info is set to complete the change
+// just made to a Package, such as with the client that made it, so amends that change where it can, and is
+// otherwise remembered as a change of its own.  Info our history does not report, such as whether the Package
+// is required by another, is never remembered on its own, so setting only that records no change.
+func (m *MapsIndexStore) rememberInfo(name string, before PackageInfo) {
+	lib := m.store[name]
+	if m.history == nil || !reported(before, lib.Info) || m.history.amend(name, lib) {
+		return
+	}
+	m.remember(name)
+}
+
+// reported determines if two infos of a Package differ in what our history reports of it - its revision, when it
+// was indexed and updated, and the client that changed it.
+func reported(before PackageInfo, after PackageInfo) bool {
+	return before.Revision != after.Revision || !before.Indexed.Equal(after.Indexed) ||
+		!before.Updated.Equal(after.Updated) || before.Client != after.Client
+}
+
+// prune discards changes no longer retained from our history.
+func (m *MapsIndexStore) prune() {
+	now := time.Now()
+	if m.history.expired(now) {
+		m.write()
+		m.history.prune(now)
+	}
+}
+
+// IndexAt rebuilds our Index from our history, including the parents of each Package, which our history
+// does not record.  Packages left depending on a forcibly removed package are not known to be dangling.
+func (m *MapsIndexStore) IndexAt(at time.Time) (snapshot IndexStore, known bool, error error) {
+	if m.history == nil || at.Before(m.history.since) {
+		return nil, false, nil
+	}
+	names := make(map[string]bool, len(m.store))
+	for name := range m.store {
+		names[name] = true
+	}
+	for name := range m.history.packages {
+		names[name] = true
+	}
+	rebuilt := &MapsIndexStore{store: make(map[string]*Package), dangling: make(map[string]map[string]bool), versions: make(map[string]map[string]bool), logger: m.logger}
+	for name := range names {
+		if lib, _ := m.history.at(name, Moment{at, 0}, m.store[name]); lib != nil {
+			lib = lib.copy()
+			lib.Parents = make(map[string]bool)
+			rebuilt.store[name] = lib
+			rebuilt.addVersion(name)
+		}
+	}
+	for name, lib := range rebuilt.store {
+		for dep := range lib.Dependencies {
+			if depPackage, ok := rebuilt.store[dep]; ok && lib.requires(dep) {
+				depPackage.Parents[name] = true
+			}
+		}
+	}
+	return &snapshotStore{rebuilt}, true, nil
+}
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// changeTimes moves the time of every change in our history back by the given ages, oldest first, so that
+// tests need not wait between changes.
+func changeTimes(store *MapsIndexStore, ages ...time.Duration) {
+	now := time.Now()
+	store.history.since = now.Add(-ages[0] - time.Minute)
+	for i, change := range store.history.changes {
+		change.Time = now.Add(-ages[i])
+	}
+}
+
+// Tests that a package is reported as it was at each time, before it was indexed and once removed.
+func TestHistoryAtTime(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", nil)
+	store.UpdatePackage("lib", []string{"base"}, nil)
+	store.RemovePackage("lib")
+	changeTimes(store, 4 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour)
+	now := time.Now()
+
+	if lib, known, _ := store.PackageAt("lib", Moment{now.Add(-210 * time.Minute), 0}); (!known || lib != nil) {
+		t.Errorf("Package should not be indexed before it was, got %v", lib)
+	}
+	if lib, known, _ := store.PackageAt("lib", Moment{now.Add(-150 * time.Minute), 0}); (!known || lib == nil || len(lib.Dependencies) != 0) {
+		t.Errorf("Package should be indexed without dependencies, got %v", lib)
+	}
+	if lib, _, _ := store.PackageAt("lib", Moment{now.Add(-90 * time.Minute), 0}); (lib == nil || !lib.Dependencies["base"] || lib.Info.Revision != 2) {
+		t.Errorf("Package should be updated, got %v", lib)
+	}
+	if lib, known, _ := store.PackageAt("lib", Moment{now, 0}); (!known || lib != nil) {
+		t.Errorf("Package should be removed, got %v", lib)
+	}
+	if base, _, _ := store.PackageAt("base", Moment{now, 0}); (base == nil) {
+		t.Error("Package unchanged since indexed should be reported")
+	}
+	if _, known, _ := store.PackageAt("lib", Moment{now.Add(-5 * time.Hour), 0}); (known) {
+		t.Error("Packages should not be known before our history began")
+	}
+}
+
+// Tests that a package is reported as it was at each of its revisions.
+func TestHistoryAtRevision(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", nil)
+	store.UpdatePackage("lib", []string{"base"}, nil)
+	if lib, known, _ := store.PackageAt("lib", Moment{time.Time{}, 1}); (!known || lib == nil || len(lib.Dependencies) != 0) {
+		t.Errorf("First revision should have no dependencies, got %v", lib)
+	}
+	if lib, _, _ := store.PackageAt("lib", Moment{time.Time{}, 2}); (lib == nil || !lib.Dependencies["base"]) {
+		t.Errorf("Second revision should depend on base, got %v", lib)
+	}
+	if lib, known, _ := store.PackageAt("lib", Moment{time.Time{}, 3}); (!known || lib != nil) {
+		t.Errorf("Revisions not yet made should not be indexed, got %v", lib)
+	}
+	if lib, _, _ := store.PackageAt("base", Moment{time.Time{}, 1}); (lib == nil || len(lib.Parents) != 0) {
+		t.Errorf("Packages should be reported without parents, got %v", lib)
+	}
+}
+
+// Tests that changes are pruned once no longer retained, with the state they leave still known.
+func TestHistoryRetention(t *testing.T) {
+	store := newTestMapsStore()
+	store.Retain(Retention{time.Hour, 2})
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", nil)
+	store.UpdatePackage("lib", []string{"base"}, nil)
+	store.AddPackage("app", []string{"lib"})
+	if (len(store.history.changes) != 2) {
+		t.Errorf("Changes beyond our limit should be pruned, got %d", len(store.history.changes))
+	}
+	if lib, known, _ := store.PackageAt("lib", Moment{store.history.since, 0}); (!known || lib == nil || len(lib.Dependencies) != 0) {
+		t.Errorf("Package should be known as it was once pruned, got %v", lib)
+	}
+	if _, known, _ := store.PackageAt("lib", Moment{store.history.since.Add(-time.Second), 0}); (known) {
+		t.Error("Packages should not be known before pruned changes")
+	}
+	store.UpdatePackage("lib", []string{"base"}, nil)
+	if lib, _, _ := store.PackageAt("lib", Moment{time.Time{}, 2}); (lib == nil || !lib.Dependencies["base"]) {
+		t.Errorf("Revision changed from should be known, got %v", lib)
+	}
+	if _, known, _ := store.PackageAt("lib", Moment{time.Time{}, 1}); (known) {
+		t.Error("Pruned revisions should not be known")
+	}
+
+	changeTimes(store, 3 * time.Hour, 2 * time.Hour)
+	store.AddPackage("tool", nil)
+	if (len(store.history.changes) != 1) {
+		t.Errorf("Changes older than retained should be pruned, got %d", len(store.history.changes))
+	}
+	if lib, _, _ := store.PackageAt("lib", Moment{time.Now(), 0}); (lib == nil || lib.Info.Revision != 3) {
+		t.Errorf("Packages no longer in our history should be reported as they are, got %v", lib)
+	}
+}
+
+// Tests that info set just after a change amends it, unless a snapshot or transaction has since sealed it.
+func TestHistorySetInfo(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("lib", nil)
+	store.SetInfo("lib", PackageInfo{Revision: 1, Client: "alice"})
+	if (len(store.history.changes) != 1 || store.history.changes[0].Package.Info.Client != "alice") {
+		t.Errorf("Info set after a change should amend it, got %d changes", len(store.history.changes))
+	}
+	snapshot, _ := store.Snapshot()
+	store.SetInfo("lib", PackageInfo{Revision: 1, Client: "bob"})
+	if (len(store.history.changes) != 2) {
+		t.Errorf("Info set after a snapshot should be a change of its own, got %d changes", len(store.history.changes))
+	}
+	if lib, _, _ := snapshot.(HistoricalStore).PackageAt("lib", Moment{time.Time{}, 1}); (lib == nil || lib.Info.Client != "alice") {
+		t.Errorf("Snapshot should keep the info it was taken with, got %v", lib)
+	}
+	store.Begin()
+	store.SetInfo("lib", PackageInfo{Revision: 1, Client: "carol"})
+	store.Rollback()
+	if lib, _, _ := store.PackageAt("lib", Moment{time.Time{}, 1}); (lib == nil || lib.Info.Client != "bob") {
+		t.Errorf("Info set within a transaction should be forgotten once rolled back, got %v", lib)
+	}
+}
+
+// Tests that info our history does not report, such as whether a Package is required, records no change.
+func TestHistoryRequiredInfo(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("dep", nil)
+	store.SetInfo("dep", PackageInfo{Revision: 1, Client: "alice"})
+	store.AddPackage("lib", []string{"dep"})
+	store.SetInfo("dep", PackageInfo{Revision: 1, Client: "alice", Required: true})
+	if (len(store.history.changes) != 2) {
+		t.Errorf("Requiring a Package should not be a change of its own, got %d changes", len(store.history.changes))
+	}
+}
+
+// Tests that changes made within a transaction are forgotten once rolled back.
+func TestHistoryRollback(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	store.Begin()
+	store.AddPackage("lib", []string{"base"})
+	store.RemovePackage("base")
+	store.Rollback()
+	if (len(store.history.changes) != 1) {
+		t.Errorf("Changes rolled back should be forgotten, got %d", len(store.history.changes))
+	}
+	if lib, _, _ := store.PackageAt("lib", Moment{time.Now(), 0}); (lib != nil) {
+		t.Errorf("Packages rolled back should not be indexed, got %v", lib)
+	}
+	store.Begin()
+	store.AddPackage("lib", []string{"base"})
+	store.Commit()
+	if lib, _, _ := store.PackageAt("lib", Moment{time.Time{}, 1}); (lib == nil) {
+		t.Error("Changes committed should be kept")
+	}
+}
+
+// Tests that our history is saved and loaded along with our Index, and shared by snapshots.
+func TestHistorySaveLoad(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", nil)
+	snapshot, _ := store.Snapshot()
+	store.UpdatePackage("lib", []string{"base"}, nil)
+
+	var saved bytes.Buffer
+	if err := snapshot.(PersistentStore).Save(&saved); (err != nil) {
+		t.Fatalf("Error encountered saving snapshot : %s", err.Error())
+	}
+	loaded := newTestMapsStore()
+	if err := loaded.Load(&saved); (err != nil) {
+		t.Fatalf("Error encountered loading snapshot : %s", err.Error())
+	}
+	if (len(loaded.history.changes) != 2 || !loaded.history.since.Equal(store.history.since)) {
+		t.Errorf("History should be loaded as it was when snapshotted, got %d changes", len(loaded.history.changes))
+	}
+	if lib, _, _ := loaded.PackageAt("lib", Moment{time.Time{}, 1}); (lib == nil || len(lib.Dependencies) != 0) {
+		t.Errorf("Revisions should be loaded, got %v", lib)
+	}
+	if lib, _, _ := store.PackageAt("lib", Moment{time.Time{}, 2}); (lib == nil || !lib.Dependencies["base"]) {
+		t.Errorf("Store should keep changes after its snapshot, got %v", lib)
+	}
+}
+
+// Tests that our Index is rebuilt as it was at a time, along with the parents of each package.
+func TestHistoryIndexAt(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	store.RemovePackage("lib")
+	store.AddPackage("app", []string{"base"})
+	changeTimes(store, 4 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour)
+
+	index, known, _ := store.IndexAt(time.Now().Add(-150 * time.Minute))
+	if names, _ := index.ListPackages(); (!known || len(names) != 2 || names[0] != "base" || names[1] != "lib") {
+		t.Errorf("Packages indexed at the time should be listed, got %v", names)
+	}
+	if parents, _ := index.GetParents("base"); (len(parents) != 1 || parents[0] != "lib") {
+		t.Errorf("Parents should be rebuilt, got %v", parents)
+	}
+	if problems, _ := index.(Checkable).Check(); (len(problems) != 0) {
+		t.Errorf("Index rebuilt should be consistent, got %v", problems)
+	}
+	if _, addErr := index.AddPackage("tool", nil); (addErr == nil) {
+		t.Error("Index rebuilt should not be changed")
+	}
+	if _, known, _ = store.IndexAt(time.Now().Add(-5 * time.Hour)); (known) {
+		t.Error("Index should not be known before our history began")
+	}
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"github.com/kristenfelch/pkgindexer/err"
+)
+
+// DependencyKind distinguishes the ways that one Package can depend on another.
+type DependencyKind string
+
+const (
+	// dependency is required to run the Package, which is the default.
+	KindRuntime DependencyKind = "runtime"
+	// dependency is only required to build the Package.
+	KindBuild DependencyKind = "build"
+	// dependency is used if present, so need not be indexed, and does not prevent its removal.
+	KindOptional DependencyKind = "optional"
+)
+
+// KindSeparator separates the kind of a dependency from the dependency, as in build:gcc.
+const KindSeparator = ":"
+
+// ParseDependencyKind reads the kind of a dependency.
+func ParseDependencyKind(kind string) (parsed DependencyKind, error error) {
+	switch DependencyKind(kind) {
+	case KindRuntime, KindBuild, KindOptional:
+		return DependencyKind(kind), nil
+	}
+	return "", err.NewCodedIndexError(err.CodeInvalidDependencies, fmt.Sprintf("Unknown dependency kind : %s", kind))
+}
+
+// SplitDependencyKind splits a dependency, as in build:gcc, into its kind and the dependency itself.
+// Dependencies without a kind are runtime dependencies.
+func SplitDependencyKind(dependency string) (kind DependencyKind, rest string, error error) {
+	if i := strings.Index(dependency, KindSeparator); i != -1 {
+		kind, error = ParseDependencyKind(dependency[:i])
+		return kind, dependency[i+len(KindSeparator):], error
+	}
+	return KindRuntime, dependency, nil
+}
+
+// JoinDependencyKind formats a dependency along with its kind, which is omitted for runtime dependencies.
+func JoinDependencyKind(kind DependencyKind, dependency string) string {
+	if kind == KindRuntime || len(kind) == 0 {
+		return dependency
+	}
+	return string(kind) + KindSeparator + dependency
+}
+
+// KindFilter restricts graph queries to dependencies of the given kinds.  An empty filter allows every kind.
+type KindFilter map[DependencyKind]bool
+
+// Allows determines if dependencies of a kind pass our filter.
+func (f KindFilter) Allows(kind DependencyKind) bool {
+	return len(f) == 0 || f[kind]
+}
+
+// ParseKindFilter reads a comma delimited list of dependency kinds, where an empty list allows every kind.
+func ParseKindFilter(kinds string) (filter KindFilter, error error) {
+	filter = make(KindFilter)
+	if len(kinds) == 0 {
+		return filter, nil
+	}
+	for _, kind := range strings.Split(kinds, ",") {
+		parsed, parseErr := ParseDependencyKind(kind)
+		if parseErr != nil {
+			return filter, parseErr
+		}
+		filter[parsed] = true
+	}
+	return filter, nil
+}
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"testing"
+)
+
+// Tests splitting dependencies into their kind and the dependency itself.
+func TestSplitDependencyKind(t *testing.T) {
+	cases := []struct {
+		dependency string
+		kind       DependencyKind
+		rest       string
+		valid      bool
+	}{
+		{"gcc", KindRuntime, "gcc", true},
+		{"runtime:lib", KindRuntime, "lib", true},
+		{"build:gcc@9.1", KindBuild, "gcc@9.1", true},
+		{"optional:docs>=1,<2", KindOptional, "docs>=1,<2", true},
+		{"test:mock", "", "", false},
+	}
+	for _, c := range cases {
+		kind, rest, err := SplitDependencyKind(c.dependency)
+		if (c.valid && (err != nil || kind != c.kind || rest != c.rest)) {
+			t.Errorf("Incorrect split of %s : %s %s", c.dependency, kind, rest)
+		}
+		if (!c.valid && err == nil) {
+			t.Errorf("Unknown kind should be rejected : %s", c.dependency)
+		}
+	}
+	if (JoinDependencyKind(KindRuntime, "lib") != "lib" || JoinDependencyKind(KindBuild, "gcc") != "build:gcc") {
+		t.Error("Runtime dependencies should be formatted without their kind")
+	}
+}
+
+// Tests filtering dependencies by kind.
+func TestKindFilter(t *testing.T) {
+	filter, err := ParseKindFilter("")
+	if (err != nil || !filter.Allows(KindOptional)) {
+		t.Error("Empty filter should allow every kind")
+	}
+	filter, err = ParseKindFilter("runtime,build")
+	if (err != nil || !filter.Allows(KindBuild) || filter.Allows(KindOptional)) {
+		t.Error("Filter should only allow the given kinds")
+	}
+	if _, err = ParseKindFilter("runtime,test"); (err == nil) {
+		t.Error("Filter with unknown kind should be rejected")
+	}
+}
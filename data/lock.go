@@ -5,13 +5,17 @@ import (
 )
 
 // IndexLock is a custom Lock object for ensuring that all operations on our data are safe.
+// It is held exclusively by operations changing our data, and may be shared by those only reading a
+// ConcurrentStore.
 type IndexLock interface {
 	Lock()
 	Unlock()
+	RLock()
+	RUnlock()
 }
 
 type SimpleLock struct {
-	lock *sync.Mutex
+	lock *sync.RWMutex
 }
 
 func (s *SimpleLock) Lock() {
@@ -22,6 +26,14 @@ func (s *SimpleLock) Unlock() {
 	s.lock.Unlock()
 }
 
+func (s *SimpleLock) RLock() {
+	s.lock.RLock()
+}
+
+func (s *SimpleLock) RUnlock() {
+	s.lock.RUnlock()
+}
+
 func NewLock() IndexLock {
-	return &SimpleLock{&sync.Mutex{}}
+	return &SimpleLock{&sync.RWMutex{}}
 }
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"github.com/kristenfelch/pkgindexer/err"
+)
+
+// Persistent is anything whose contents can be saved, and loaded again when our service restarts.
+type Persistent interface {
+	// Writes our contents to w.
+	Save(w io.Writer) (error error)
+
+	// Replaces our contents with those previously saved to r.
+	Load(r io.Reader) (error error)
+}
+
+// PersistentStore is an IndexStore that saves every Package in our Index, along with its info.
+type PersistentStore interface {
+	IndexStore
+	Persistent
+}
+
+// snapshot is the saved form of a MapsIndexStore.
+type snapshot struct {
+	Packages map[string]*Package
+	Dangling map[string]map[string]bool
+	History  *savedHistory `json:",omitempty"`
+}
+
+func (m *MapsIndexStore) Save(w io.Writer) (error error) {
+	if m.journal != nil {
+		return err.NewIndexError("Unable to save within a transaction")
+	}
+	return json.NewEncoder(w).Encode(&snapshot{m.saved(), m.dangling, m.history.saved()})
+}
+
+// saved returns our packages as they are saved, including when those queried since our latest snapshot were.
+func (m *MapsIndexStore) saved() (packages map[string]*Package) {
+	if len(m.queried) == 0 {
+		return m.store
+	}
+	packages = make(map[string]*Package, len(m.store))
+	for name, lib := range m.store {
+		packages[name] = lib
+	}
+	for name, queried := range m.queried {
+		lib := *packages[name]
+		lib.Info.Queried = queried
+		packages[name] = &lib
+	}
+	return packages
+}
+
+func (m *MapsIndexStore) Load(r io.Reader) (error error) {
+	if m.journal != nil {
+		return err.NewIndexError("Unable to load within a transaction")
+	}
+	var saved snapshot
+	if decodeErr := json.NewDecoder(r).Decode(&saved); decodeErr != nil {
+		return err.NewIndexError("Unable to load saved index : " + decodeErr.Error())
+	}
+	if saved.Packages == nil {
+		saved.Packages = make(map[string]*Package)
+	}
+	if saved.Dangling == nil {
+		saved.Dangling = make(map[string]map[string]bool)
+	}
+	for _, lib := range saved.Packages {
+		if lib.Dependencies == nil {
+			lib.Dependencies = make(map[string]bool)
+		}
+		if lib.Kinds == nil {
+			lib.Kinds = make(map[string]DependencyKind)
+		}
+		if lib.Parents == nil {
+			lib.Parents = make(map[string]bool)
+		}
+	}
+	// any snapshot keeps the maps we replace, so no longer shares ours.
+	m.snapshot = nil
+	m.owned = nil
+	m.queried = nil
+	m.store = saved.Packages
+	m.dangling = saved.Dangling
+	if m.history != nil {
+		m.history = loadHistory(saved.History, m.history.retention)
+	}
+	m.versions = make(map[string]map[string]bool)
+	for id := range m.store {
+		m.addVersion(id)
+	}
+	return nil
+}
+
+// SaveFile saves to a file.  The file is replaced only once it has been
+// written completely, so that a failed save never loses the previously saved index.
+func SaveFile(store Persistent, path string) (error error) {
+	file, createErr := os.Create(path + ".tmp")
+	if createErr != nil {
+		return err.NewIndexError("Unable to save index : " + createErr.Error())
+	}
+	if saveErr := store.Save(file); saveErr != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return saveErr
+	}
+	if closeErr := file.Close(); closeErr != nil {
+		os.Remove(file.Name())
+		return err.NewIndexError("Unable to save index : " + closeErr.Error())
+	}
+	if renameErr := os.Rename(file.Name(), path); renameErr != nil {
+		return err.NewIndexError("Unable to save index : " + renameErr.Error())
+	}
+	return nil
+}
+
+// LoadFile loads from a file, indicating if there was one to load.
+func LoadFile(store Persistent, path string) (loaded bool, error error) {
+	file, openErr := os.Open(path)
+	if os.IsNotExist(openErr) {
+		return false, nil
+	}
+	if openErr != nil {
+		return false, err.NewIndexError("Unable to load index : " + openErr.Error())
+	}
+	defer file.Close()
+	if loadErr := store.Load(file); loadErr != nil {
+		return false, loadErr
+	}
+	return true, nil
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// Tests that a saved store is loaded with the same packages, parents and info.
+func TestSaveLoad(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := NewIndexStore(logger).(*MapsIndexStore)
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+	store.AddPackage("package", []string{"dep1", "dep2"})
+	store.ForceRemovePackage("dep2")
+	info, _ := store.GetInfo("package")
+	info.Revision = 3
+	info.Client = "bot"
+	store.SetInfo("package", *info)
+
+	var saved bytes.Buffer
+	if err := store.Save(&saved); (err != nil) {
+		t.Errorf("Error encountered saving store : %s", err.Error())
+	}
+	loaded := NewIndexStore(logger).(*MapsIndexStore)
+	if err := loaded.Load(&saved); (err != nil) {
+		t.Errorf("Error encountered loading store : %s", err.Error())
+	}
+
+	if parents, _ := loaded.GetParents("dep1"); (len(parents) != 1 || parents[0] != "package") {
+		t.Errorf("Parents should be loaded, got %v", parents)
+	}
+	loadedInfo, _ := loaded.GetInfo("package")
+	if (loadedInfo.Revision != 3 || loadedInfo.Client != "bot" || !loadedInfo.Indexed.Equal(info.Indexed)) {
+		t.Errorf("Info should be loaded, got %v", loadedInfo)
+	}
+	loaded.AddPackage("dep2", nil)
+	if parents, _ := loaded.GetParents("dep2"); (len(parents) != 1 || parents[0] != "package") {
+		t.Error("Dangling dependencies should be loaded")
+	}
+}
+
+// Tests saving to and loading from a file, and that a missing file loads nothing.
+func TestSaveLoadFile(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	dir, _ := ioutil.TempDir("", "pkgindexer")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "index.json")
+
+	store := NewIndexStore(logger).(*MapsIndexStore)
+	if loaded, err := LoadFile(store, path); (err != nil || loaded) {
+		t.Error("Missing file should load nothing without error")
+	}
+	store.AddPackage("package", nil)
+	if err := SaveFile(store, path); (err != nil) {
+		t.Errorf("Error encountered saving file : %s", err.Error())
+	}
+	loaded := NewIndexStore(logger).(*MapsIndexStore)
+	if ok, err := LoadFile(loaded, path); (err != nil || !ok) {
+		t.Error("Saved file should be loaded")
+	}
+	if exists, _ := loaded.HasPackage("package"); (!exists) {
+		t.Error("Saved package should be indexed once loaded")
+	}
+}
@@ -0,0 +1,164 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// dialTimeout is how long we wait to connect to Redis.
+const dialTimeout = 5 * time.Second
+
+// status is a simple string reply, such as OK, as opposed to a bulk string reply.
+type status string
+
+// replyError is an error reply from Redis.
+type replyError string
+
+func (r replyError) Error() string {
+	return string(r)
+}
+
+// client speaks the Redis protocol over a single connection, connecting again after the connection fails.
+// Replies are status, string for bulk strings, int64 for integers, []interface{} for arrays, and nil for
+// a missing value.  Error replies are returned as a replyError.
+type client struct {
+	address string
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	// released is set once we are no longer needed, after which we do not connect again.
+	released bool
+}
+
+// connect connects to Redis unless already connected.
+func (c *client) connect() (error error) {
+	if c.conn != nil {
+		return nil
+	}
+	if c.released {
+		return fmt.Errorf("connection to %s released", c.address)
+	}
+	conn, error := net.DialTimeout("tcp", c.address, dialTimeout)
+	if error != nil {
+		return error
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.writer = bufio.NewWriter(conn)
+	return nil
+}
+
+// close closes our connection, so that the next command connects again.
+func (c *client) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// release closes our connection for good.
+func (c *client) release() {
+	c.close()
+	c.released = true
+}
+
+// do sends a command and reads its reply.  After a failure other than an error reply, we cannot know
+// where the next reply starts, so the connection is closed.
+func (c *client) do(args ...string) (reply interface{}, error error) {
+	if error = c.connect(); error != nil {
+		return nil, error
+	}
+	writeCommand(c.writer, args)
+	error = c.writer.Flush()
+	if error == nil {
+		reply, error = readReply(c.reader)
+	}
+	if _, isReply := error.(replyError); error != nil && !isReply {
+		c.close()
+	}
+	return reply, error
+}
+
+// writeCommand writes a command as an array of bulk strings.  A failure to write is found when flushed.
+func writeCommand(w *bufio.Writer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readLine reads a line without its line ending.
+func readLine(r *bufio.Reader) (line string, error error) {
+	line, error = r.ReadString('\n')
+	if error != nil {
+		return "", error
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply reads a single reply, including every element of an array.
+func readReply(r *bufio.Reader) (reply interface{}, error error) {
+	line, error := readLine(r)
+	if error != nil {
+		return nil, error
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return status(line[1:]), nil
+	case '-':
+		return nil, replyError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, parseErr := strconv.Atoi(line[1:])
+		if parseErr != nil || length < 0 {
+			return nil, parseErr
+		}
+		bulk := make([]byte, length + 2)
+		if _, readErr := io.ReadFull(r, bulk); readErr != nil {
+			return nil, readErr
+		}
+		return string(bulk[:length]), nil
+	case '*':
+		length, parseErr := strconv.Atoi(line[1:])
+		if parseErr != nil || length < 0 {
+			return nil, parseErr
+		}
+		elements := make([]interface{}, length)
+		for i := range elements {
+			element, readErr := readReply(r)
+			// an error reply within an array, as from EXEC, is one of its elements.
+			if replyErr, ok := readErr.(replyError); ok {
+				element, readErr = replyErr, nil
+			}
+			if readErr != nil {
+				return nil, readErr
+			}
+			elements[i] = element
+		}
+		return elements, nil
+	}
+	return nil, fmt.Errorf("unknown reply %q", line)
+}
+
+// values converts an array reply of bulk strings, treating a missing value as empty.
+func values(reply interface{}) []string {
+	elements, _ := reply.([]interface{})
+	found := make([]string, 0, len(elements))
+	for _, element := range elements {
+		if value, ok := element.(string); ok {
+			found = append(found, value)
+		}
+	}
+	return found
+}
@@ -0,0 +1,317 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeServer is an in process stand in for Redis, speaking just enough of its protocol for our store,
+// so that our tests need no Redis server.  Keys are watched by the number of times each was changed.
+type fakeServer struct {
+	listener net.Listener
+	values   map[string]string
+	sets     map[string]map[string]bool
+	hashes   map[string]map[string]string
+	changes  map[string]int
+	// beforeExec, if set, is called before each EXEC, so tests can act as another client.
+	beforeExec func(server *fakeServer)
+	lock       sync.Mutex
+}
+
+// fakeSession is the state of a single connection to our fakeServer.
+type fakeSession struct {
+	watched map[string]int
+	queued  [][]string
+	multi   bool
+}
+
+// newFakeServer starts a fakeServer on a free local port, which is stopped when our test ends.
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if (listenErr != nil) {
+		t.Fatalf("Error encountered starting fake Redis : %s", listenErr.Error())
+	}
+	server := &fakeServer{
+		listener: listener,
+		values:   make(map[string]string),
+		sets:     make(map[string]map[string]bool),
+		hashes:   make(map[string]map[string]string),
+		changes:  make(map[string]int),
+	}
+	go server.serve()
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return server
+}
+
+// address is where our fakeServer listens.
+func (f *fakeServer) address() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeServer) serve() {
+	for {
+		conn, acceptErr := f.listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+// handle reads commands from a connection until it is closed, replying to each.
+func (f *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	session := &fakeSession{watched: make(map[string]int)}
+	for {
+		request, readErr := readReply(reader)
+		if readErr != nil {
+			return
+		}
+		command := values(request)
+		if len(command) == 0 {
+			return
+		}
+		writeReply(writer, f.execute(session, command))
+		if writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+// writeReply writes a reply of any type our fakeServer replies with.
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch value := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case status:
+		fmt.Fprintf(w, "+%s\r\n", value)
+	case replyError:
+		fmt.Fprintf(w, "-%s\r\n", value)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", value)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(value), value)
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(value))
+		for _, element := range value {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(element), element)
+		}
+	case []interface{}:
+		if value == nil {
+			w.WriteString("*-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "*%d\r\n", len(value))
+		for _, element := range value {
+			writeReply(w, element)
+		}
+	}
+}
+
+// execute runs a command for a session, queueing it within MULTI.
+func (f *fakeServer) execute(session *fakeSession, command []string) (reply interface{}) {
+	name := strings.ToUpper(command[0])
+	switch {
+	case name == "MULTI":
+		session.multi = true
+		return status("OK")
+	case name == "DISCARD":
+		session.multi, session.queued = false, nil
+		session.watched = make(map[string]int)
+		return status("OK")
+	case name == "EXEC":
+		return f.exec(session)
+	case session.multi:
+		session.queued = append(session.queued, command)
+		return status("QUEUED")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.run(session, name, command[1:])
+}
+
+// exec runs every queued command, unless a watched key changed since it was watched.
+func (f *fakeServer) exec(session *fakeSession) (reply interface{}) {
+	if f.beforeExec != nil {
+		f.beforeExec(f)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	queued, watched := session.queued, session.watched
+	session.multi, session.queued = false, nil
+	session.watched = make(map[string]int)
+	for key, changes := range watched {
+		if f.changes[key] != changes {
+			return []interface{}(nil)
+		}
+	}
+	replies := make([]interface{}, 0, len(queued))
+	for _, command := range queued {
+		replies = append(replies, f.run(session, strings.ToUpper(command[0]), command[1:]))
+	}
+	return replies
+}
+
+// change records that a key changed, so that clients watching it fail to EXEC.
+func (f *fakeServer) change(key string) {
+	f.changes[key]++
+}
+
+// run runs a single command while our fakeServer is locked.
+func (f *fakeServer) run(session *fakeSession, name string, args []string) (reply interface{}) {
+	switch name {
+	case "PING":
+		return status("PONG")
+	case "WATCH":
+		for _, key := range args {
+			session.watched[key] = f.changes[key]
+		}
+		return status("OK")
+	case "UNWATCH":
+		session.watched = make(map[string]int)
+		return status("OK")
+	case "GET":
+		value, ok := f.values[args[0]]
+		if !ok {
+			return nil
+		}
+		return value
+	case "SET":
+		f.values[args[0]] = args[1]
+		f.change(args[0])
+		return status("OK")
+	case "EXISTS":
+		count := 0
+		for _, key := range args {
+			if _, ok := f.values[key]; ok {
+				count++
+			} else if _, ok := f.sets[key]; ok {
+				count++
+			} else if _, ok := f.hashes[key]; ok {
+				count++
+			}
+		}
+		return count
+	case "DEL":
+		count := 0
+		for _, key := range args {
+			_, isValue := f.values[key]
+			_, isSet := f.sets[key]
+			_, isHash := f.hashes[key]
+			if isValue || isSet || isHash {
+				count++
+				f.change(key)
+			}
+			delete(f.values, key)
+			delete(f.sets, key)
+			delete(f.hashes, key)
+		}
+		return count
+	case "SADD":
+		set, ok := f.sets[args[0]]
+		if !ok {
+			set = make(map[string]bool)
+			f.sets[args[0]] = set
+		}
+		count := 0
+		for _, member := range args[1:] {
+			if !set[member] {
+				set[member] = true
+				count++
+			}
+		}
+		f.change(args[0])
+		return count
+	case "SREM":
+		set := f.sets[args[0]]
+		count := 0
+		for _, member := range args[1:] {
+			if set[member] {
+				delete(set, member)
+				count++
+			}
+		}
+		// like Redis, an empty set does not exist.
+		if len(set) == 0 {
+			delete(f.sets, args[0])
+		}
+		f.change(args[0])
+		return count
+	case "SMEMBERS":
+		members := make([]string, 0, len(f.sets[args[0]]))
+		for member := range f.sets[args[0]] {
+			members = append(members, member)
+		}
+		return members
+	case "SCARD":
+		return len(f.sets[args[0]])
+	case "SCAN":
+		// every matching key is returned at once, ending the scan, ignoring COUNT.
+		matched := make([]string, 0)
+		for _, key := range f.sortedKeys() {
+			if len(args) > 2 && strings.ToUpper(args[1]) == "MATCH" {
+				if ok, _ := path.Match(args[2], key); !ok {
+					continue
+				}
+			}
+			matched = append(matched, key)
+		}
+		return []interface{}{"0", matched}
+	case "HSET":
+		hash, ok := f.hashes[args[0]]
+		if !ok {
+			hash = make(map[string]string)
+			f.hashes[args[0]] = hash
+		}
+		for i := 1; i + 1 < len(args); i += 2 {
+			hash[args[i]] = args[i + 1]
+		}
+		f.change(args[0])
+		return (len(args) - 1) / 2
+	case "HGETALL":
+		fields := make([]string, 0, 2 * len(f.hashes[args[0]]))
+		for field := range f.hashes[args[0]] {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		reply := make([]string, 0, 2 * len(fields))
+		for _, field := range fields {
+			reply = append(reply, field, f.hashes[args[0]][field])
+		}
+		return reply
+	}
+	return replyError("ERR unknown command '" + name + "'")
+}
+
+// keys returns every key our fakeServer holds, sorted.
+func (f *fakeServer) keys() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.sortedKeys()
+}
+
+// sortedKeys returns every key our fakeServer holds, sorted, while it is locked.
+func (f *fakeServer) sortedKeys() []string {
+	keys := make([]string, 0)
+	for key := range f.values {
+		keys = append(keys, key)
+	}
+	for key := range f.sets {
+		keys = append(keys, key)
+	}
+	for key := range f.hashes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,569 @@
+// Package redis is a storage backend keeping our Index in Redis, or any server speaking its protocol, so that
+// several replicas of our service can share one Index.  It speaks the protocol itself, needing no client library.
+//
+// Each change to a Package is made atomically with MULTI and EXEC, having first watched the keys of the
+// Package and of its dependencies, so that a change is retried if another replica changes the same Package,
+// or removes a dependency, first.  The checks our operations make before a change, which another replica may
+// invalidate before it is made, are made again while watched, and the change refused if they no longer hold.
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// Backend is the name our storage backend is selected by.
+const Backend = "redis"
+
+// scanCount is how many keys we ask for at a time while scanning the keys of a namespace.
+const scanCount = "1000"
+
+// globEscaper escapes the characters that are special in the patterns SCAN matches keys against.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// maxAttempts is how many times a change is attempted while other replicas change the same Package.
+const maxAttempts = 10
+
+// The keys of each namespace start with the name of the namespace, followed by one of these.
+// The info of each indexed Package is kept as JSON, so that a Package is indexed while its info exists.
+// Dependencies and parents are kept as sets, and the kinds of dependencies other than runtime dependencies
+// as a hash.  The parents of a Package remain while it is forcibly removed, so are restored when it is
+// indexed again.
+const (
+	packagesKey     = "packages"
+	infoKey         = "info:"
+	dependenciesKey = "deps:"
+	kindsKey        = "kinds:"
+	parentsKey      = "parents:"
+	versionsKey     = "versions:"
+)
+
+// saved is the state of a Package before it was first changed during a transaction.
+type saved struct {
+	info  *data.PackageInfo
+	deps  []string
+	kinds map[string]data.DependencyKind
+}
+
+// RedisIndexStore is an IndexStore keeping the packages of a single namespace in Redis.
+// Redis cannot read within MULTI, so a transaction cannot be kept from other replicas.  Instead, changes are
+// made as they happen, with the state of each Package before it was first changed kept in a journal, which
+// is restored if the transaction is rolled back.  The journal is nil outside of a transaction.
+type RedisIndexStore struct {
+	client  *client
+	prefix  string
+	journal map[string]*saved
+	logger  logging.Logger
+}
+
+// key returns the key of our namespace built from its parts.
+func (s *RedisIndexStore) key(parts ...string) string {
+	key := s.prefix
+	for _, part := range parts {
+		key += part
+	}
+	return key
+}
+
+// wrap describes an error from Redis as an IndexError.
+func wrap(failure error) error {
+	if failure == nil {
+		return nil
+	}
+	if _, ok := failure.(*err.IndexError); ok {
+		return failure
+	}
+	return err.NewIndexError("Redis store failed : " + failure.Error())
+}
+
+// sorted returns the members of a set, sorted.
+func (s *RedisIndexStore) sorted(key string) (members []string, error error) {
+	reply, error := s.client.do("SMEMBERS", key)
+	if error != nil {
+		return nil, wrap(error)
+	}
+	members = values(reply)
+	sort.Strings(members)
+	return members, nil
+}
+
+// read reads the state of a Package, which is nil if it is not indexed.
+func (s *RedisIndexStore) read(name string) (state *saved, error error) {
+	reply, error := s.client.do("GET", s.key(infoKey, name))
+	if error != nil || reply == nil {
+		return nil, wrap(error)
+	}
+	encoded, _ := reply.(string)
+	state = &saved{&data.PackageInfo{}, nil, make(map[string]data.DependencyKind)}
+	if decodeErr := json.Unmarshal([]byte(encoded), state.info); decodeErr != nil {
+		return nil, wrap(decodeErr)
+	}
+	if state.deps, error = s.sorted(s.key(dependenciesKey, name)); error != nil {
+		return nil, error
+	}
+	reply, error = s.client.do("HGETALL", s.key(kindsKey, name))
+	if error != nil {
+		return nil, wrap(error)
+	}
+	fields := values(reply)
+	for i := 0; i + 1 < len(fields); i += 2 {
+		state.kinds[fields[i]] = data.DependencyKind(fields[i + 1])
+	}
+	return state, nil
+}
+
+// mustRead reads the state of a Package, describing what could not be done if it is not indexed.
+func (s *RedisIndexStore) mustRead(name string, failure string) (state *saved, error error) {
+	state, error = s.read(name)
+	if error == nil && state == nil {
+		error = err.NewIndexError(failure)
+	}
+	return state, error
+}
+
+// remember keeps the state of a Package in our journal before it is first changed during a transaction.
+func (s *RedisIndexStore) remember(name string) (error error) {
+	if s.journal == nil {
+		return nil
+	}
+	if _, remembered := s.journal[name]; remembered {
+		return nil
+	}
+	state, error := s.read(name)
+	if error == nil {
+		s.journal[name] = state
+	}
+	return error
+}
+
+// change changes a Package atomically.  The keys of the Package, including its parents, are watched along with
+// the info of each of deps before prepare reads what it needs and returns the commands making the change, so
+// that if another replica changes the Package or indexes or removes one of deps before the commands are
+// executed, none are, and the change is attempted again.  Prepare refuses the change by returning no commands
+// and false, once what it reads no longer allows it.
+func (s *RedisIndexStore) change(name string, deps []string, prepare func() ([][]string, bool, error)) (changed bool, error error) {
+	if error = s.remember(name); error != nil {
+		return false, error
+	}
+	watched := []string{"WATCH", s.key(infoKey, name), s.key(dependenciesKey, name), s.key(parentsKey, name)}
+	for _, dep := range deps {
+		watched = append(watched, s.key(infoKey, dep))
+	}
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if _, watchErr := s.client.do(watched...); watchErr != nil {
+			return false, wrap(watchErr)
+		}
+		commands, allowed, prepareErr := prepare()
+		if prepareErr != nil || !allowed {
+			s.client.do("UNWATCH")
+			return false, wrap(prepareErr)
+		}
+		if _, multiErr := s.client.do("MULTI"); multiErr != nil {
+			return false, wrap(multiErr)
+		}
+		for _, command := range commands {
+			if _, queueErr := s.client.do(command...); queueErr != nil {
+				s.client.do("DISCARD")
+				return false, wrap(queueErr)
+			}
+		}
+		reply, execErr := s.client.do("EXEC")
+		if execErr != nil {
+			return false, wrap(execErr)
+		}
+		if reply != nil {
+			return true, nil
+		}
+		s.logger.Debug(fmt.Sprintf("Package %s changed by another client, retrying", name))
+	}
+	return false, err.NewIndexError("Unable to change package changed by other clients")
+}
+
+// required returns the dependencies of a Package other than optional dependencies, which must be indexed.
+func required(deps []string, kinds map[string]data.DependencyKind) []string {
+	found := make([]string, 0, len(deps))
+	for _, dep := range deps {
+		if kinds[dep] != data.KindOptional {
+			found = append(found, dep)
+		}
+	}
+	return found
+}
+
+// missing determines if any of deps is not indexed, so that a Package cannot depend on it.
+func (s *RedisIndexStore) missing(deps []string) (missing bool, error error) {
+	for _, dep := range deps {
+		exists, error := s.HasPackage(dep)
+		if error != nil || !exists {
+			return !exists, error
+		}
+	}
+	return false, nil
+}
+
+// setInfo returns the command setting the info of a Package.
+func (s *RedisIndexStore) setInfo(name string, info data.PackageInfo) (command []string, error error) {
+	encoded, error := json.Marshal(info)
+	if error != nil {
+		return nil, error
+	}
+	return []string{"SET", s.key(infoKey, name), string(encoded)}, nil
+}
+
+// replaceDependencies returns the commands replacing the old dependencies of a Package with deps,
+// along with the Package as a parent of each.
+func (s *RedisIndexStore) replaceDependencies(name string, old []string, deps []string, kinds map[string]data.DependencyKind) (commands [][]string) {
+	commands = s.removeDependencies(name, old)
+	for _, dep := range deps {
+		kind, ok := kinds[dep]
+		if !ok {
+			kind = data.KindRuntime
+		}
+		commands = append(commands, []string{"SADD", s.key(dependenciesKey, name), dep})
+		if kind != data.KindRuntime {
+			commands = append(commands, []string{"HSET", s.key(kindsKey, name), dep, string(kind)})
+		}
+		// optional dependencies never make a package a parent.
+		if kind != data.KindOptional {
+			commands = append(commands, []string{"SADD", s.key(parentsKey, dep), name})
+		}
+	}
+	return commands
+}
+
+// removeDependencies returns the commands removing the dependencies of a Package, and it as their parent.
+func (s *RedisIndexStore) removeDependencies(name string, deps []string) (commands [][]string) {
+	commands = [][]string{
+		{"DEL", s.key(dependenciesKey, name)},
+		{"DEL", s.key(kindsKey, name)},
+	}
+	for _, dep := range deps {
+		commands = append(commands, []string{"SREM", s.key(parentsKey, dep), name})
+	}
+	return commands
+}
+
+func (s *RedisIndexStore) AddPackage(name string, deps []string) (added bool, error error) {
+	return s.AddTypedPackage(name, deps, nil)
+}
+
+func (s *RedisIndexStore) AddTypedPackage(name string, deps []string, kinds map[string]data.DependencyKind) (bool, error) {
+	return s.add(name, deps, kinds, true)
+}
+
+// add adds a Package, refusing to unless each of its dependencies other than optional dependencies is indexed
+// if checked, as another replica may have removed one since our operations checked.
+func (s *RedisIndexStore) add(name string, deps []string, kinds map[string]data.DependencyKind, checked bool) (bool, error) {
+	watched := []string{}
+	if checked {
+		watched = required(deps, kinds)
+	}
+	added, changeErr := s.change(name, watched, func() ([][]string, bool, error) {
+		if missing, missingErr := s.missing(watched); missingErr != nil || missing {
+			return nil, false, missingErr
+		}
+		state, readErr := s.read(name)
+		if readErr != nil {
+			return nil, false, readErr
+		}
+		old := []string{}
+		if state != nil {
+			old = state.deps
+		}
+		now := time.Now()
+		setInfo, encodeErr := s.setInfo(name, data.PackageInfo{Revision: 1, Indexed: now, Updated: now})
+		if encodeErr != nil {
+			return nil, false, encodeErr
+		}
+		base, _ := data.SplitVersion(name)
+		commands := [][]string{
+			setInfo,
+			{"SADD", s.key(packagesKey), name},
+			{"SADD", s.key(versionsKey, base), name},
+		}
+		return append(commands, s.replaceDependencies(name, old, deps, kinds)...), true, nil
+	})
+	if changeErr != nil || !added {
+		return false, changeErr
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s added to Index", name))
+	return true, nil
+}
+
+// UpdatePackage refuses to update a Package unless each of its dependencies other than optional dependencies
+// is indexed, as another replica may have removed one since our operations checked.
+func (s *RedisIndexStore) UpdatePackage(name string, deps []string, kinds map[string]data.DependencyKind) (bool, error) {
+	watched := required(deps, kinds)
+	updated, changeErr := s.change(name, watched, func() ([][]string, bool, error) {
+		state, readErr := s.mustRead(name, "Unable to update Unindexed package")
+		if readErr != nil {
+			return nil, false, readErr
+		}
+		if missing, missingErr := s.missing(watched); missingErr != nil || missing {
+			return nil, false, missingErr
+		}
+		state.info.Revision++
+		state.info.Updated = time.Now()
+		setInfo, encodeErr := s.setInfo(name, *state.info)
+		if encodeErr != nil {
+			return nil, false, encodeErr
+		}
+		// the parents of this package are kept under its own key, so are kept.
+		return append([][]string{setInfo}, s.replaceDependencies(name, state.deps, deps, kinds)...), true, nil
+	})
+	if changeErr != nil || !updated {
+		return false, changeErr
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s updated in Index", name))
+	return true, nil
+}
+
+// RemovePackage refuses to remove a Package that other packages depend on, as another replica may have indexed
+// one since our operations checked.
+func (s *RedisIndexStore) RemovePackage(name string) (bool, error) {
+	return s.remove(name, true)
+}
+
+// remove removes a Package, refusing to if checked while other packages depend on it.
+func (s *RedisIndexStore) remove(name string, checked bool) (bool, error) {
+	removed, changeErr := s.change(name, nil, func() ([][]string, bool, error) {
+		state, readErr := s.read(name)
+		if readErr != nil || state == nil {
+			return nil, readErr == nil, readErr
+		}
+		if checked {
+			reply, parentsErr := s.client.do("SCARD", s.key(parentsKey, name))
+			if count, _ := reply.(int64); parentsErr != nil || count > 0 {
+				return nil, false, parentsErr
+			}
+		}
+		base, _ := data.SplitVersion(name)
+		commands := [][]string{
+			{"DEL", s.key(infoKey, name)},
+			{"SREM", s.key(packagesKey), name},
+			{"SREM", s.key(versionsKey, base), name},
+		}
+		return append(commands, s.removeDependencies(name, state.deps)...), true, nil
+	})
+	if changeErr != nil || !removed {
+		return false, changeErr
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s removed from Index", name))
+	return true, nil
+}
+
+func (s *RedisIndexStore) ForceRemovePackage(name string) (dependents []string, error error) {
+	exists, error := s.HasPackage(name)
+	if error != nil || !exists {
+		return []string{}, error
+	}
+	// dependents keep their dependency on this package, and it its parents, so are left dangling.
+	dependents, error = s.GetParents(name)
+	if error != nil {
+		return []string{}, error
+	}
+	if _, error = s.remove(name, false); error != nil {
+		return []string{}, error
+	}
+	return dependents, nil
+}
+
+func (s *RedisIndexStore) HasPackage(name string) (exists bool, error error) {
+	reply, error := s.client.do("EXISTS", s.key(infoKey, name))
+	if error != nil {
+		return false, wrap(error)
+	}
+	count, _ := reply.(int64)
+	return count > 0, nil
+}
+
+// mustExist fails unless a Package is indexed, describing what could not be done.
+func (s *RedisIndexStore) mustExist(name string, failure string) (error error) {
+	exists, error := s.HasPackage(name)
+	if error == nil && !exists {
+		error = err.NewIndexError(failure)
+	}
+	return error
+}
+
+func (s *RedisIndexStore) HasParents(name string) (hasParents bool, error error) {
+	if error = s.mustExist(name, "Unable to determined if Unindexed package has parents"); error != nil {
+		return false, error
+	}
+	reply, error := s.client.do("SCARD", s.key(parentsKey, name))
+	if error != nil {
+		return false, wrap(error)
+	}
+	count, _ := reply.(int64)
+	return count > 0, nil
+}
+
+func (s *RedisIndexStore) GetDependencies(name string) (deps []string, error error) {
+	if error = s.mustExist(name, "Unable to determine dependencies of Unindexed package"); error != nil {
+		return nil, error
+	}
+	return s.sorted(s.key(dependenciesKey, name))
+}
+
+func (s *RedisIndexStore) GetDependencyKinds(name string) (kinds map[string]data.DependencyKind, error error) {
+	state, error := s.mustRead(name, "Unable to determine dependencies of Unindexed package")
+	if error != nil {
+		return nil, error
+	}
+	kinds = make(map[string]data.DependencyKind, len(state.deps))
+	for _, dep := range state.deps {
+		kind, ok := state.kinds[dep]
+		if !ok {
+			kind = data.KindRuntime
+		}
+		kinds[dep] = kind
+	}
+	return kinds, nil
+}
+
+func (s *RedisIndexStore) GetParents(name string) (parents []string, error error) {
+	if error = s.mustExist(name, "Unable to determine parents of Unindexed package"); error != nil {
+		return nil, error
+	}
+	return s.sorted(s.key(parentsKey, name))
+}
+
+func (s *RedisIndexStore) ListPackages() (names []string, error error) {
+	return s.sorted(s.key(packagesKey))
+}
+
+func (s *RedisIndexStore) GetVersions(name string) (versions []string, error error) {
+	return s.sorted(s.key(versionsKey, name))
+}
+
+func (s *RedisIndexStore) GetInfo(name string) (info *data.PackageInfo, error error) {
+	state, error := s.mustRead(name, "Unable to determine info of Unindexed package")
+	if error != nil {
+		return nil, error
+	}
+	return state.info, nil
+}
+
+// update changes the info of a single indexed Package, describing what could not be done if it is not indexed.
+func (s *RedisIndexStore) update(name string, failure string, change func(info *data.PackageInfo)) error {
+	_, changeErr := s.change(name, nil, func() ([][]string, bool, error) {
+		state, readErr := s.mustRead(name, failure)
+		if readErr != nil {
+			return nil, false, readErr
+		}
+		change(state.info)
+		setInfo, encodeErr := s.setInfo(name, *state.info)
+		return [][]string{setInfo}, encodeErr == nil, encodeErr
+	})
+	return changeErr
+}
+
+func (s *RedisIndexStore) SetInfo(name string, info data.PackageInfo) (error error) {
+	return s.update(name, "Unable to set info of Unindexed package", func(stored *data.PackageInfo) {
+		*stored = info
+	})
+}
+
+func (s *RedisIndexStore) MarkQueried(name string) (error error) {
+	return s.update(name, "Unable to mark Unindexed package as queried", func(info *data.PackageInfo) {
+		info.Queried = time.Now()
+	})
+}
+
+func (s *RedisIndexStore) Begin() (error error) {
+	if s.journal != nil {
+		return err.NewIndexError("Unable to begin a transaction within another transaction")
+	}
+	s.journal = make(map[string]*saved)
+	return nil
+}
+
+func (s *RedisIndexStore) Commit() (error error) {
+	if s.journal == nil {
+		return err.NewIndexError("Unable to commit outside of a transaction")
+	}
+	s.journal = nil
+	return nil
+}
+
+// Rollback restores every Package changed during our transaction, removing those that were not indexed.
+// Packages are restored in no particular order, so regardless of their dependencies and parents.
+func (s *RedisIndexStore) Rollback() (error error) {
+	if s.journal == nil {
+		return err.NewIndexError("Unable to roll back outside of a transaction")
+	}
+	journal := s.journal
+	s.journal = nil
+	for name, state := range journal {
+		if state == nil {
+			_, error = s.remove(name, false)
+		} else if _, error = s.add(name, state.deps, state.kinds, false); error == nil {
+			error = s.SetInfo(name, *state.info)
+		}
+		if error != nil {
+			return error
+		}
+	}
+	s.logger.Trace("Transaction rolled back")
+	return nil
+}
+
+// Drop deletes every key of our namespace, discarding any transaction in progress, as there is nothing left to
+// restore, and releases our connection.  Keys are found with SCAN, so other clients are not blocked while a
+// large namespace is dropped.
+func (s *RedisIndexStore) Drop() error {
+	s.journal = nil
+	defer s.client.release()
+	cursor := "0"
+	for {
+		reply, scanErr := s.client.do("SCAN", cursor, "MATCH", globEscaper.Replace(s.prefix) + "*", "COUNT", scanCount)
+		if scanErr != nil {
+			return wrap(scanErr)
+		}
+		page, _ := reply.([]interface{})
+		if len(page) != 2 {
+			return err.NewIndexError("Redis store failed : malformed reply to SCAN")
+		}
+		cursor, _ = page[0].(string)
+		if keys := values(page[1]); len(keys) > 0 {
+			if _, delErr := s.client.do(append([]string{"DEL"}, keys...)...); delErr != nil {
+				return wrap(delErr)
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	s.logger.Trace("Namespace dropped from Redis")
+	return nil
+}
+
+// Check verifies that Parents and Dependencies mirror one another, and that no packages depend on one
+// another in a cycle.
+func (s *RedisIndexStore) Check() (problems []data.Problem, error error) {
+	return data.CheckGraph(s)
+}
+
+// New creates an IndexStore for a namespace in the Redis server at address, as in localhost:6379.
+// Keys start with pkgindexer, followed by the name of the namespace.
+func New(namespace string, address string, logger logging.Logger) (store data.IndexStore, error error) {
+	if len(address) == 0 {
+		return nil, err.NewCodedIndexError(err.CodeInvalidArgument, "Redis store needs the address of its server in storeOptions")
+	}
+	connection := &client{address: address}
+	if _, error = connection.do("PING"); error != nil {
+		connection.close()
+		return nil, wrap(error)
+	}
+	return &RedisIndexStore{connection, "pkgindexer:" + namespace + ":", nil, logger}, nil
+}
+
+func init() {
+	data.RegisterBackend(Backend, New)
+}
@@ -0,0 +1,197 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/data/storetest"
+	"github.com/kristenfelch/pkgindexer/logging"
+	"github.com/kristenfelch/pkgindexer/operation"
+)
+
+func newTestStore(t *testing.T, namespace string, address string) *RedisIndexStore {
+	logLevel := "FATAL"
+	store, err := New(namespace, address, logging.NewIndexLogger(&logLevel))
+	if (err != nil) {
+		t.Fatalf("Error encountered connecting store : %s", err.Error())
+	}
+	return store.(*RedisIndexStore)
+}
+
+// Tests that our Redis store conforms to IndexStore.
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func() data.IndexStore {
+		return newTestStore(t, "default", newFakeServer(t).address())
+	})
+}
+
+// Tests that replicas sharing a server share their Index, and that namespaces are kept apart.
+func TestShared(t *testing.T) {
+	server := newFakeServer(t)
+	store := newTestStore(t, "default", server.address())
+	replica := newTestStore(t, "default", server.address())
+	other := newTestStore(t, "other", server.address())
+	store.AddPackage("base", nil)
+	replica.AddPackage("lib", []string{"base"})
+	other.AddPackage("zlib", nil)
+
+	if parents, _ := store.GetParents("base"); (len(parents) != 1 || parents[0] != "lib") {
+		t.Errorf("Packages indexed by a replica should be seen, got %v", parents)
+	}
+	if names, _ := replica.ListPackages(); (len(names) != 2 || names[0] != "base" || names[1] != "lib") {
+		t.Errorf("Packages of other namespaces should not be seen, got %v", names)
+	}
+	for _, key := range server.keys() {
+		if (!strings.HasPrefix(key, "pkgindexer:default:") && !strings.HasPrefix(key, "pkgindexer:other:")) {
+			t.Errorf("Keys should start with their namespace, got %s", key)
+		}
+	}
+}
+
+// Tests that a change is attempted again when another client changes the same package first.
+func TestConflictRetried(t *testing.T) {
+	server := newFakeServer(t)
+	store := newTestStore(t, "default", server.address())
+	replica := newTestStore(t, "default", server.address())
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+	store.AddPackage("lib", []string{"dep1"})
+
+	// another replica changes lib just before our first attempt is executed.
+	attempts := 0
+	server.beforeExec = func(server *fakeServer) {
+		attempts++
+		if (attempts == 1) {
+			server.beforeExec = nil
+			replica.UpdatePackage("lib", []string{"dep2"}, nil)
+		}
+	}
+	if updated, err := store.UpdatePackage("lib", []string{"dep1", "dep2"}, nil); (err != nil || !updated) {
+		t.Fatalf("Package should be updated once retried, got %v %v", updated, err)
+	}
+	info, _ := store.GetInfo("lib")
+	if (info.Revision != 3) {
+		t.Errorf("Both updates should be kept, got revision %d", info.Revision)
+	}
+	if parents, _ := store.GetParents("dep1"); (len(parents) != 1 || parents[0] != "lib") {
+		t.Errorf("Parents should reflect the retried update, got %v", parents)
+	}
+	if problems, _ := store.Check(); (len(problems) != 0) {
+		t.Errorf("Store should be consistent, got %v", problems)
+	}
+}
+
+// Tests that a change fails, rather than retrying forever, while other clients keep changing the package.
+func TestConflictGivesUp(t *testing.T) {
+	server := newFakeServer(t)
+	store := newTestStore(t, "default", server.address())
+	store.AddPackage("lib", nil)
+	server.beforeExec = func(server *fakeServer) {
+		server.lock.Lock()
+		server.change("pkgindexer:default:info:lib")
+		server.lock.Unlock()
+	}
+	if _, err := store.UpdatePackage("lib", nil, nil); (err == nil) {
+		t.Error("Update should fail while other clients keep changing the package")
+	}
+}
+
+// Tests that a replica indexing a package while another removes its dependency leaves a consistent Index,
+// whichever of them changes it first.
+func TestConcurrentReplicas(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	server := newFakeServer(t)
+	store := newTestStore(t, "default", server.address())
+	replica := newTestStore(t, "default", server.address())
+	store.AddPackage("dep", nil)
+
+	// the replica removes dep once we have checked it is indexed, just before we index lib.
+	server.beforeExec = func(server *fakeServer) {
+		server.beforeExec = nil
+		if removed, err := operation.NewRemover(replica, logger).Remove("dep"); (err != nil || !removed) {
+			t.Errorf("Replica should remove dependency, got %v %v", removed, err)
+		}
+	}
+	if indexed, err := operation.NewIndexer(store, logger).Index("lib", []string{"dep"}, ""); (err != nil || indexed) {
+		t.Errorf("Package should not be indexed once its dependency is removed, got %v %v", indexed, err)
+	}
+	if names, _ := store.ListPackages(); (len(names) != 0) {
+		t.Errorf("Index should be empty, got %v", names)
+	}
+
+	// the replica indexes lib once we have checked nothing depends on dep, just before we remove it.
+	store.AddPackage("dep", nil)
+	server.beforeExec = func(server *fakeServer) {
+		server.beforeExec = nil
+		if indexed, err := operation.NewIndexer(replica, logger).Index("lib", []string{"dep"}, ""); (err != nil || !indexed) {
+			t.Errorf("Replica should index package, got %v %v", indexed, err)
+		}
+	}
+	if removed, err := operation.NewRemover(store, logger).Remove("dep"); (err != nil || removed) {
+		t.Errorf("Package should not be removed once another depends on it, got %v %v", removed, err)
+	}
+	if parents, _ := store.GetParents("dep"); (len(parents) != 1 || parents[0] != "lib") {
+		t.Errorf("Dependency should be kept along with its parent, got %v", parents)
+	}
+	if problems, _ := store.Check(); (len(problems) != 0) {
+		t.Errorf("Store should be consistent, got %v", problems)
+	}
+}
+
+// Tests that dropping a namespace deletes its keys, keeping those of other namespaces, and releases our connection.
+func TestDrop(t *testing.T) {
+	server := newFakeServer(t)
+	store := newTestStore(t, "default", server.address())
+	other := newTestStore(t, "def*", server.address())
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	other.AddPackage("zlib", nil)
+
+	if err := other.Drop(); (err != nil) {
+		t.Fatalf("Error encountered dropping store : %s", err.Error())
+	}
+	for _, key := range server.keys() {
+		if (!strings.HasPrefix(key, "pkgindexer:default:")) {
+			t.Errorf("Keys of dropped namespace should be deleted, got %s", key)
+		}
+	}
+	if names, _ := store.ListPackages(); (len(names) != 2) {
+		t.Errorf("Packages of other namespaces should be kept, got %v", names)
+	}
+	if _, err := other.HasPackage("zlib"); (err == nil) {
+		t.Error("Dropped store should not connect again")
+	}
+	store.Drop()
+	if keys := server.keys(); (len(keys) != 0) {
+		t.Errorf("Every key should be deleted, got %v", keys)
+	}
+}
+
+// Tests that our store reconnects once its connection fails.
+func TestReconnect(t *testing.T) {
+	server := newFakeServer(t)
+	store := newTestStore(t, "default", server.address())
+	store.AddPackage("base", nil)
+	store.client.conn.Close()
+	if _, err := store.HasPackage("base"); (err == nil) {
+		t.Error("Command should fail once connection is closed")
+	}
+	if exists, err := store.HasPackage("base"); (err != nil || !exists) {
+		t.Errorf("Store should reconnect, got %v %v", exists, err)
+	}
+}
+
+// Tests that a server is required, and must be reachable.
+func TestNewWithoutServer(t *testing.T) {
+	logLevel := "FATAL"
+	if _, err := New("default", "", logging.NewIndexLogger(&logLevel)); (err == nil) {
+		t.Error("Store should not be created without the address of its server")
+	}
+	server := newFakeServer(t)
+	address := server.address()
+	server.listener.Close()
+	if _, err := New("default", address, logging.NewIndexLogger(&logLevel)); (err == nil) {
+		t.Error("Store should not be created when its server cannot be reached")
+	}
+}
@@ -0,0 +1,619 @@
+package data
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// ShardedBackend is the name of the storage backend keeping our Index in memory across shards.
+const ShardedBackend = "sharded"
+
+// DefaultShards is how many shards a ShardedIndexStore has unless configured otherwise.
+const DefaultShards = 16
+
+// shard holds the packages whose names hash to it, along with the dangling dependents of forcibly removed
+// packages and the versions of package names that hash to it.
+type shard struct {
+	store    map[string]*Package
+	dangling map[string]map[string]bool
+	versions map[string]map[string]bool
+	lock     *sync.RWMutex
+}
+
+// ShardedIndexStore is an IndexStore keeping our Index in memory like MapsIndexStore, but partitioned across
+// shards by the hash of each package name, each with its own lock, so that it is safe for concurrent use and
+// clients changing unrelated packages rarely wait for one another.
+// A change locks the shards of every package it affects, always in the same order, so never deadlocks.  As other
+// clients may change the store between the checks a request makes and its change, each change checks again that
+// it is allowed while its shards are locked.
+// Transactions journal changes for the whole store, so expect no other changes until committed or rolled back.
+type ShardedIndexStore struct {
+	shards []*shard
+	// journal records how to undo changes made during a transaction, and is nil outside of one.
+	journal     *journal
+	journalLock *sync.Mutex
+	logger      logging.Logger
+}
+
+// shardIndex finds the shard of a package name, using the FNV-1a hash.
+func (s *ShardedIndexStore) shardIndex(name string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= 16777619
+	}
+	return int(hash % uint32(len(s.shards)))
+}
+
+// shardOf finds the shard of a package name.
+func (s *ShardedIndexStore) shardOf(name string) *shard {
+	return s.shards[s.shardIndex(name)]
+}
+
+// get finds a Package, with its shard locked.
+func (s *ShardedIndexStore) get(name string) *Package {
+	return s.shardOf(name).store[name]
+}
+
+// lock locks the shards of every package name given, in order, returning a function unlocking them.
+func (s *ShardedIndexStore) lock(names []string) (unlock func()) {
+	indexes := make([]int, 0, len(names))
+	locked := make(map[int]bool, len(names))
+	for _, name := range names {
+		index := s.shardIndex(name)
+		if !locked[index] {
+			locked[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		s.shards[index].lock.Lock()
+	}
+	return func() {
+		for _, index := range indexes {
+			s.shards[index].lock.Unlock()
+		}
+	}
+}
+
+// lockAll locks every shard, for reading only unless write is set, returning a function unlocking them.
+func (s *ShardedIndexStore) lockAll(write bool) (unlock func()) {
+	for _, shard := range s.shards {
+		if write {
+			shard.lock.Lock()
+		} else {
+			shard.lock.RLock()
+		}
+	}
+	return func() {
+		for _, shard := range s.shards {
+			if write {
+				shard.lock.Unlock()
+			} else {
+				shard.lock.RUnlock()
+			}
+		}
+	}
+}
+
+// change calls changer with the shards of a Package, its name without a version, its current dependencies and
+// any others given locked, along with the Package, which is nil if not indexed.  The dependencies of a Package
+// can only be found once it is locked, so if it changes before all are locked, every lock is released and taken
+// again.
+func (s *ShardedIndexStore) change(name string, others []string, changer func(lib *Package)) {
+	for {
+		first := s.shardOf(name)
+		first.lock.RLock()
+		lib := first.store[name]
+		first.lock.RUnlock()
+
+		base, _ := SplitVersion(name)
+		names := append([]string{name, base}, others...)
+		if lib != nil {
+			for dep := range lib.Dependencies {
+				names = append(names, dep)
+			}
+		}
+		unlock := s.lock(names)
+		if first.store[name] == lib {
+			changer(lib)
+			unlock()
+			return
+		}
+		unlock()
+	}
+}
+
+// read calls reader with the shard of a Package locked for reading, along with the Package.
+func (s *ShardedIndexStore) read(name string, reader func(lib *Package)) {
+	shard := s.shardOf(name)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	reader(shard.store[name])
+}
+
+// record saves the state of a Package before it is first changed during a transaction, with its shard locked.
+func (s *ShardedIndexStore) record(name string) {
+	s.journalLock.Lock()
+	defer s.journalLock.Unlock()
+	if s.journal == nil {
+		return
+	}
+	if _, ok := s.journal.packages[name]; ok {
+		return
+	}
+	if lib := s.get(name); lib != nil {
+		s.journal.packages[name] = lib.copy()
+	} else {
+		s.journal.packages[name] = nil
+	}
+}
+
+// addVersion records that a Package is indexed under its name, with the shard of its name locked.
+func (s *ShardedIndexStore) addVersion(id string) {
+	name, _ := SplitVersion(id)
+	versions := s.shardOf(name).versions
+	if _, ok := versions[name]; !ok {
+		versions[name] = make(map[string]bool)
+	}
+	versions[name][id] = true
+}
+
+// removeVersion records that a Package is no longer indexed under its name, with the shard of its name locked.
+func (s *ShardedIndexStore) removeVersion(id string) {
+	name, _ := SplitVersion(id)
+	versions := s.shardOf(name).versions
+	if ids, ok := versions[name]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(versions, name)
+		}
+	}
+}
+
+// undangle records that a Package no longer dangles from a forcibly removed dependency, with the shard of
+// the dependency locked.
+func (s *ShardedIndexStore) undangle(name string, dep string) {
+	dangling := s.shardOf(dep).dangling
+	if dependents, ok := dangling[dep]; ok {
+		delete(dependents, name)
+		if len(dependents) == 0 {
+			delete(dangling, dep)
+		}
+	}
+}
+
+func (s *ShardedIndexStore) AddPackage(name string, deps []string) (added bool, error error) {
+	return s.AddTypedPackage(name, deps, nil)
+}
+
+// missing determines if any dependency other than optional dependencies is not indexed, with the shard of each
+// dependency locked.
+func (s *ShardedIndexStore) missing(deps []string, kinds map[string]DependencyKind) bool {
+	for _, dep := range deps {
+		if kinds[dep] != KindOptional && s.get(dep) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ShardedIndexStore) AddTypedPackage(name string, deps []string, kinds map[string]DependencyKind) (added bool, error error) {
+	s.change(name, deps, func(old *Package) {
+		if s.missing(deps, kinds) {
+			return
+		}
+		added = true
+		// replacing a Package already indexed, as another client may have just indexed it, first removes it as a
+		// parent of its dependencies, though packages depending on it still do.
+		parents := make(map[string]bool)
+		if old != nil {
+			parents = copySet(old.Parents)
+			delete(parents, name)
+			s.remove(name, old)
+		}
+		dependencies := make(map[string]bool, len(deps))
+		dependencyKinds := make(map[string]DependencyKind)
+		for _, dep := range deps {
+			dependencies[dep] = true
+			if kind, ok := kinds[dep]; ok && kind != KindRuntime {
+				dependencyKinds[dep] = kind
+			}
+			if dependencyKinds[dep] == KindOptional {
+				continue
+			}
+			if depPackage := s.get(dep); depPackage != nil {
+				s.record(dep)
+				depPackage.Parents[name] = true
+			}
+		}
+		s.record(name)
+		s.addVersion(name)
+		now := time.Now()
+		lib := &Package{dependencies, dependencyKinds, parents, PackageInfo{Revision: 1, Indexed: now, Updated: now}}
+		// dependents left dangling when this package was forcibly removed depend on it once again.
+		dangling := s.shardOf(name).dangling
+		for dependent := range dangling[name] {
+			lib.Parents[dependent] = true
+		}
+		delete(dangling, name)
+		s.shardOf(name).store[name] = lib
+	})
+	if !added {
+		return false, nil
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s added to Index", name))
+	return true, nil
+}
+
+func (s *ShardedIndexStore) UpdatePackage(name string, deps []string, kinds map[string]DependencyKind) (updated bool, error error) {
+	s.change(name, deps, func(lib *Package) {
+		if lib == nil {
+			error = err.NewIndexError("Unable to update Unindexed package")
+			return
+		}
+		if s.missing(deps, kinds) {
+			return
+		}
+		updated = true
+		s.record(name)
+		replaced := &Package{make(map[string]bool, len(deps)), make(map[string]DependencyKind), lib.Parents, lib.Info}
+		for _, dep := range deps {
+			replaced.Dependencies[dep] = true
+			if kind, ok := kinds[dep]; ok && kind != KindRuntime {
+				replaced.Kinds[dep] = kind
+			}
+		}
+		for dep := range lib.Dependencies {
+			if !lib.requires(dep) || replaced.requires(dep) {
+				continue
+			}
+			if depPackage := s.get(dep); depPackage != nil {
+				s.record(dep)
+				delete(depPackage.Parents, name)
+			} else {
+				s.undangle(name, dep)
+			}
+		}
+		for dep := range replaced.Dependencies {
+			if !replaced.requires(dep) || lib.requires(dep) {
+				continue
+			}
+			if depPackage := s.get(dep); depPackage != nil {
+				s.record(dep)
+				depPackage.Parents[name] = true
+			}
+		}
+		replaced.Info.Revision++
+		replaced.Info.Updated = time.Now()
+		s.shardOf(name).store[name] = replaced
+	})
+	if error != nil || !updated {
+		return false, error
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s updated in Index", name))
+	return true, nil
+}
+
+// remove removes an indexed Package, with the shards of it and its dependencies locked.
+func (s *ShardedIndexStore) remove(name string, lib *Package) {
+	s.record(name)
+	delete(s.shardOf(name).store, name)
+	s.removeVersion(name)
+	for dep := range lib.Dependencies {
+		if depPackage := s.get(dep); depPackage != nil {
+			s.record(dep)
+			delete(depPackage.Parents, name)
+		} else {
+			s.undangle(name, dep)
+		}
+	}
+}
+
+// RemovePackage refuses to remove a Package that other packages depend on, as another client may have indexed
+// one since our operations checked.
+func (s *ShardedIndexStore) RemovePackage(name string) (removed bool, error error) {
+	s.change(name, nil, func(lib *Package) {
+		if lib == nil {
+			removed = true
+		} else if !lib.HasParents() {
+			s.remove(name, lib)
+			removed = true
+		}
+	})
+	if !removed {
+		return false, nil
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s removed from Index", name))
+	return true, nil
+}
+
+func (s *ShardedIndexStore) ForceRemovePackage(name string) (dependents []string, error error) {
+	dependents = []string{}
+	s.change(name, nil, func(lib *Package) {
+		if lib == nil {
+			return
+		}
+		dependents = sortedKeys(lib.Parents)
+		dangling := s.shardOf(name).dangling
+		if len(dependents) > 0 && dangling[name] == nil {
+			dangling[name] = make(map[string]bool, len(dependents))
+		}
+		for _, dependent := range dependents {
+			s.logger.Trace(fmt.Sprintf("Package %s left with dangling dependency %s", dependent, name))
+			dangling[name][dependent] = true
+		}
+		s.remove(name, lib)
+	})
+	return dependents, nil
+}
+
+func (s *ShardedIndexStore) HasPackage(name string) (exists bool, error error) {
+	s.read(name, func(lib *Package) {
+		exists = lib != nil
+	})
+	return exists, nil
+}
+
+func (s *ShardedIndexStore) HasParents(name string) (hasParents bool, error error) {
+	s.read(name, func(lib *Package) {
+		if lib == nil {
+			error = err.NewIndexError("Unable to determined if Unindexed package has parents")
+			return
+		}
+		hasParents = lib.HasParents()
+	})
+	return hasParents, error
+}
+
+func (s *ShardedIndexStore) GetDependencies(name string) (deps []string, error error) {
+	s.read(name, func(lib *Package) {
+		if lib == nil {
+			error = err.NewIndexError("Unable to determine dependencies of Unindexed package")
+			return
+		}
+		deps = sortedKeys(lib.Dependencies)
+	})
+	return deps, error
+}
+
+func (s *ShardedIndexStore) GetDependencyKinds(name string) (kinds map[string]DependencyKind, error error) {
+	s.read(name, func(lib *Package) {
+		if lib == nil {
+			error = err.NewIndexError("Unable to determine dependencies of Unindexed package")
+			return
+		}
+		kinds = make(map[string]DependencyKind, len(lib.Dependencies))
+		for dep := range lib.Dependencies {
+			kinds[dep] = lib.kind(dep)
+		}
+	})
+	return kinds, error
+}
+
+func (s *ShardedIndexStore) GetParents(name string) (parents []string, error error) {
+	s.read(name, func(lib *Package) {
+		if lib == nil {
+			error = err.NewIndexError("Unable to determine parents of Unindexed package")
+			return
+		}
+		parents = sortedKeys(lib.Parents)
+	})
+	return parents, error
+}
+
+func (s *ShardedIndexStore) ListPackages() (names []string, error error) {
+	unlock := s.lockAll(false)
+	defer unlock()
+	names = make([]string, 0)
+	for _, shard := range s.shards {
+		for name := range shard.store {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *ShardedIndexStore) GetVersions(name string) (versions []string, error error) {
+	shard := s.shardOf(name)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	return sortedKeys(shard.versions[name]), nil
+}
+
+func (s *ShardedIndexStore) GetInfo(name string) (info *PackageInfo, error error) {
+	s.read(name, func(lib *Package) {
+		if lib == nil {
+			error = err.NewIndexError("Unable to determine info of Unindexed package")
+			return
+		}
+		copied := lib.Info
+		info = &copied
+	})
+	return info, error
+}
+
+// update changes the info of a single indexed Package, describing what could not be done if it is not indexed.
+func (s *ShardedIndexStore) update(name string, failure string, change func(info *PackageInfo)) (error error) {
+	shard := s.shardOf(name)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	lib := shard.store[name]
+	if lib == nil {
+		return err.NewIndexError(failure)
+	}
+	s.record(name)
+	change(&lib.Info)
+	return nil
+}
+
+func (s *ShardedIndexStore) SetInfo(name string, info PackageInfo) (error error) {
+	return s.update(name, "Unable to set info of Unindexed package", func(stored *PackageInfo) {
+		*stored = info
+	})
+}
+
+func (s *ShardedIndexStore) MarkQueried(name string) (error error) {
+	return s.update(name, "Unable to mark Unindexed package as queried", func(info *PackageInfo) {
+		info.Queried = time.Now()
+	})
+}
+
+func (s *ShardedIndexStore) UpdateInfo(name string, change func(info *PackageInfo)) (error error) {
+	return s.update(name, "Unable to update info of Unindexed package", change)
+}
+
+func (s *ShardedIndexStore) Begin() (error error) {
+	unlock := s.lockAll(true)
+	defer unlock()
+	if s.journal != nil {
+		return err.NewIndexError("Unable to begin a transaction within another transaction")
+	}
+	dangling := make(map[string]map[string]bool)
+	for _, shard := range s.shards {
+		for key, dependents := range shard.dangling {
+			dangling[key] = copySet(dependents)
+		}
+	}
+	s.journal = &journal{make(map[string]*Package), dangling, 0}
+	return nil
+}
+
+func (s *ShardedIndexStore) Commit() (error error) {
+	unlock := s.lockAll(true)
+	defer unlock()
+	if s.journal == nil {
+		return err.NewIndexError("Unable to commit outside of a transaction")
+	}
+	s.journal = nil
+	return nil
+}
+
+func (s *ShardedIndexStore) Rollback() (error error) {
+	unlock := s.lockAll(true)
+	defer unlock()
+	if s.journal == nil {
+		return err.NewIndexError("Unable to roll back outside of a transaction")
+	}
+	for name, lib := range s.journal.packages {
+		if lib == nil {
+			delete(s.shardOf(name).store, name)
+			s.removeVersion(name)
+		} else {
+			s.shardOf(name).store[name] = lib
+			s.addVersion(name)
+		}
+	}
+	for _, shard := range s.shards {
+		shard.dangling = make(map[string]map[string]bool)
+	}
+	for key, dependents := range s.journal.dangling {
+		s.shardOf(key).dangling[key] = dependents
+	}
+	s.journal = nil
+	s.logger.Trace("Transaction rolled back")
+	return nil
+}
+
+// merged returns a MapsIndexStore sharing the packages of every shard, with every shard locked.
+func (s *ShardedIndexStore) merged() *MapsIndexStore {
+	merged := NewIndexStore(s.logger).(*MapsIndexStore)
+	for _, shard := range s.shards {
+		for name, lib := range shard.store {
+			merged.store[name] = lib
+		}
+		for key, dependents := range shard.dangling {
+			merged.dangling[key] = dependents
+		}
+		for key, ids := range shard.versions {
+			merged.versions[key] = ids
+		}
+	}
+	return merged
+}
+
+// Concurrent is always true, as each shard has its own lock.
+func (s *ShardedIndexStore) Concurrent() bool {
+	return true
+}
+
+// Check verifies our Index exactly as MapsIndexStore does.
+func (s *ShardedIndexStore) Check() (problems []Problem, error error) {
+	unlock := s.lockAll(false)
+	defer unlock()
+	return s.merged().Check()
+}
+
+// Save writes our Index in the same form as MapsIndexStore, so either can load it.
+func (s *ShardedIndexStore) Save(w io.Writer) (error error) {
+	unlock := s.lockAll(false)
+	defer unlock()
+	if s.journal != nil {
+		return err.NewIndexError("Unable to save within a transaction")
+	}
+	return s.merged().Save(w)
+}
+
+// Load replaces our Index with one saved by either ShardedIndexStore or MapsIndexStore.
+func (s *ShardedIndexStore) Load(r io.Reader) (error error) {
+	loaded := NewIndexStore(s.logger).(*MapsIndexStore)
+	if error = loaded.Load(r); error != nil {
+		return error
+	}
+	unlock := s.lockAll(true)
+	defer unlock()
+	if s.journal != nil {
+		return err.NewIndexError("Unable to load within a transaction")
+	}
+	for _, shard := range s.shards {
+		shard.store = make(map[string]*Package)
+		shard.dangling = make(map[string]map[string]bool)
+		shard.versions = make(map[string]map[string]bool)
+	}
+	for name, lib := range loaded.store {
+		s.shardOf(name).store[name] = lib
+		s.addVersion(name)
+	}
+	for key, dependents := range loaded.dangling {
+		s.shardOf(key).dangling[key] = dependents
+	}
+	return nil
+}
+
+// NewShardedIndexStore creates an empty ShardedIndexStore with the given number of shards, at least one.
+func NewShardedIndexStore(shards int, logger logging.Logger) IndexStore {
+	if shards < 1 {
+		shards = 1
+	}
+	store := &ShardedIndexStore{make([]*shard, shards), nil, &sync.Mutex{}, logger}
+	for i := range store.shards {
+		store.shards[i] = &shard{
+			make(map[string]*Package),
+			make(map[string]map[string]bool),
+			make(map[string]map[string]bool),
+			&sync.RWMutex{},
+		}
+	}
+	return store
+}
+
+// newShardedBackend creates a ShardedIndexStore, with the number of shards given by options, if any.
+func newShardedBackend(namespace string, options string, logger logging.Logger) (store IndexStore, error error) {
+	shards := DefaultShards
+	if len(options) > 0 {
+		parsed, parseErr := strconv.Atoi(options)
+		if parseErr != nil || parsed < 1 {
+			return nil, err.NewCodedIndexError(err.CodeInvalidArgument, "Sharded store needs a positive number of shards in storeOptions")
+		}
+		shards = parsed
+	}
+	return NewShardedIndexStore(shards, logger), nil
+}
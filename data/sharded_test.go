@@ -0,0 +1,218 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+func newTestShardedStore(shards int) *ShardedIndexStore {
+	logLevel := "FATAL"
+	return NewShardedIndexStore(shards, logging.NewIndexLogger(&logLevel)).(*ShardedIndexStore)
+}
+
+func newTestMapsStore() *MapsIndexStore {
+	logLevel := "FATAL"
+	return NewIndexStore(logging.NewIndexLogger(&logLevel)).(*MapsIndexStore)
+}
+
+// Tests that packages are spread across shards, with parents kept up to date across them.
+func TestShardedAcrossShards(t *testing.T) {
+	store := newTestShardedStore(8)
+	store.AddPackage("base", nil)
+	for i := 0; i < 50; i++ {
+		store.AddPackage(fmt.Sprintf("lib%d", i), []string{"base"})
+	}
+	used := 0
+	for _, shard := range store.shards {
+		if (len(shard.store) > 0) {
+			used++
+		}
+	}
+	if (used < 2) {
+		t.Errorf("Packages should be spread across shards, got %d used", used)
+	}
+	if parents, _ := store.GetParents("base"); (len(parents) != 50) {
+		t.Errorf("Parents in other shards should be kept, got %v", parents)
+	}
+	for i := 0; i < 50; i++ {
+		store.RemovePackage(fmt.Sprintf("lib%d", i))
+	}
+	if hasParents, _ := store.HasParents("base"); (hasParents) {
+		t.Error("Parents in other shards should be removed")
+	}
+}
+
+// Tests that clients changing packages concurrently leave our store consistent.
+func TestShardedConcurrent(t *testing.T) {
+	store := newTestShardedStore(4)
+	for i := 0; i < 10; i++ {
+		store.AddPackage(fmt.Sprintf("base%d", i), nil)
+	}
+	var wait sync.WaitGroup
+	for client := 0; client < 20; client++ {
+		wait.Add(1)
+		go func(client int) {
+			defer wait.Done()
+			for i := 0; i < 100; i++ {
+				name := fmt.Sprintf("lib%d", i % 10)
+				deps := []string{fmt.Sprintf("base%d", (client + i) % 10), fmt.Sprintf("base%d", i % 10)}
+				switch (client + i) % 4 {
+				case 0:
+					store.AddPackage(name, deps)
+				case 1:
+					store.UpdatePackage(name, deps[:1], nil)
+				case 2:
+					store.RemovePackage(name)
+				case 3:
+					store.GetParents(deps[0])
+				}
+			}
+		}(client)
+	}
+	wait.Wait()
+	if problems, _ := store.Check(); (len(problems) != 0) {
+		t.Errorf("Store should be consistent, got %v", problems)
+	}
+}
+
+// Tests that changes are checked again as they are made, as another client may have changed the store since
+// the request making them checked it.
+func TestShardedChecksChanges(t *testing.T) {
+	store := newTestShardedStore(4)
+	if added, _ := store.AddPackage("lib", []string{"base"}); (added) {
+		t.Error("Package should not be added while a dependency is missing")
+	}
+	if exists, _ := store.HasPackage("lib"); (exists) {
+		t.Error("Package refused should not be indexed")
+	}
+	added, _ := store.AddTypedPackage("lib", []string{"docs"}, map[string]DependencyKind{"docs": KindOptional})
+	if (!added) {
+		t.Error("Package should be added while only an optional dependency is missing")
+	}
+	store.AddPackage("base", nil)
+	store.AddPackage("app", []string{"base"})
+	if updated, _ := store.UpdatePackage("app", []string{"base", "missing"}, nil); (updated) {
+		t.Error("Package should not be updated while a dependency is missing")
+	}
+	if deps, _ := store.GetDependencies("app"); (len(deps) != 1) {
+		t.Errorf("Package refused should keep its dependencies, got %v", deps)
+	}
+	if removed, _ := store.RemovePackage("base"); (removed) {
+		t.Error("Package should not be removed while other packages depend on it")
+	}
+	if added, _ = store.AddPackage("base", nil); (!added) {
+		t.Error("Package should be indexed again")
+	}
+	if parents, _ := store.GetParents("base"); (len(parents) != 1 || parents[0] != "app") {
+		t.Errorf("Package indexed again should keep the packages depending on it, got %v", parents)
+	}
+}
+
+// Tests that a sharded store saves in the same form as our in memory store, so either loads it.
+func TestShardedSaveLoad(t *testing.T) {
+	store := newTestShardedStore(4)
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+	store.AddPackage("package", []string{"dep1", "dep2"})
+	store.ForceRemovePackage("dep2")
+
+	var saved bytes.Buffer
+	if err := store.Save(&saved); (err != nil) {
+		t.Fatalf("Error encountered saving store : %s", err.Error())
+	}
+	maps := newTestMapsStore()
+	if err := maps.Load(bytes.NewReader(saved.Bytes())); (err != nil) {
+		t.Fatalf("Error encountered loading store : %s", err.Error())
+	}
+	if parents, _ := maps.GetParents("dep1"); (len(parents) != 1 || parents[0] != "package") {
+		t.Errorf("Parents should be loaded, got %v", parents)
+	}
+
+	saved.Reset()
+	maps.Save(&saved)
+	loaded := newTestShardedStore(2)
+	if err := loaded.Load(&saved); (err != nil) {
+		t.Fatalf("Error encountered loading store : %s", err.Error())
+	}
+	loaded.AddPackage("dep2", nil)
+	if parents, _ := loaded.GetParents("dep2"); (len(parents) != 1 || parents[0] != "package") {
+		t.Errorf("Dangling dependents should be loaded, got %v", parents)
+	}
+	if versions, _ := loaded.GetVersions("dep1"); (len(versions) != 1) {
+		t.Errorf("Versions should be loaded, got %v", versions)
+	}
+}
+
+// Tests that the number of shards is configured by storeOptions.
+func TestShardedBackend(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store, err := NewBackendStore(ShardedBackend, "default", "", logger)
+	if (err != nil || len(store.(*ShardedIndexStore).shards) != DefaultShards) {
+		t.Errorf("Store should have default shards, got %v", err)
+	}
+	store, _ = NewBackendStore(ShardedBackend, "default", "64", logger)
+	if (len(store.(*ShardedIndexStore).shards) != 64) {
+		t.Error("Store should have shards configured")
+	}
+	if _, err = NewBackendStore(ShardedBackend, "default", "none", logger); (err == nil) {
+		t.Error("Invalid number of shards should be rejected")
+	}
+}
+
+// benchmarkClients runs b.N operations split across 100 concurrent clients, each indexing, querying and
+// removing its own packages, which depend on packages shared by every client.  Each operation holds lock as our
+// service does around the operations of a namespace, exclusively unless shared.
+func benchmarkClients(b *testing.B, store IndexStore, lock IndexLock, shared bool) {
+	const clients = 100
+	const packages = 1000
+	for i := 0; i < packages; i++ {
+		store.AddPackage(fmt.Sprintf("base%d", i), nil)
+	}
+	operation := func(client int, i int) {
+		name := fmt.Sprintf("client%d-%d", client, i % 100)
+		dep := fmt.Sprintf("base%d", (client * 31 + i) % packages)
+		if shared {
+			lock.RLock()
+			defer lock.RUnlock()
+		} else {
+			lock.Lock()
+			defer lock.Unlock()
+		}
+		switch i % 4 {
+		case 0:
+			store.AddPackage(name, []string{dep})
+		case 1:
+			store.HasPackage(name)
+		case 2:
+			store.GetParents(dep)
+		case 3:
+			store.RemovePackage(name)
+		}
+	}
+	b.ResetTimer()
+	var wait sync.WaitGroup
+	for client := 0; client < clients; client++ {
+		wait.Add(1)
+		go func(client int) {
+			defer wait.Done()
+			for i := client; i < b.N; i += clients {
+				operation(client, i / clients)
+			}
+		}(client)
+	}
+	wait.Wait()
+}
+
+// Benchmarks our in memory store at 100 clients, holding the lock of its namespace exclusively.
+func BenchmarkMapsStore100Clients(b *testing.B) {
+	benchmarkClients(b, newTestMapsStore(), NewLock(), false)
+}
+
+// Benchmarks our sharded store at 100 clients, sharing the lock of its namespace.
+func BenchmarkShardedStore100Clients(b *testing.B) {
+	benchmarkClients(b, newTestShardedStore(DefaultShards), NewLock(), true)
+}
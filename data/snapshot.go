@@ -0,0 +1,189 @@
+package data
+
+import (
+	"io"
+	"time"
+	"github.com/kristenfelch/pkgindexer/err"
+)
+
+// Snapshotter is an IndexStore whose state can be captured as it is at a moment, so that long queries and
+// exports can read a consistent version of our Index while it continues to change.
+type Snapshotter interface {
+	// Returns an immutable IndexStore holding our Index as it is now, which later changes do not affect.
+	// Every change to a snapshot fails.
+	Snapshot() (snapshot IndexStore, error error)
+}
+
+// snapshotStore is an immutable snapshot of a MapsIndexStore.  It shares the maps of the store it was taken
+// from until that store next changes, and shares every Package until that Package is next changed.
+type snapshotStore struct {
+	*MapsIndexStore
+}
+
+// Snapshot captures our Index copy on write, so that taking a snapshot copies nothing.  The first change
+// after a snapshot copies our maps of packages, but not the packages themselves, each of which is only
+// copied when it is first changed.  Marking packages queried is not a change, so a snapshot taken after only
+// that shares our maps too, copying just when they were queried.  Snapshots cannot be taken within a
+// transaction, which may be rolled back.
+func (m *MapsIndexStore) Snapshot() (snapshot IndexStore, error error) {
+	if m.journal != nil {
+		return nil, err.NewIndexError("Unable to snapshot within a transaction")
+	}
+	if m.snapshot == nil {
+		m.snapshot = &snapshotStore{&MapsIndexStore{store: m.store, dangling: m.dangling, versions: m.versions, history: m.history.share(), logger: m.logger}}
+		m.owned = make(map[string]bool)
+	} else if len(m.queried) > 0 {
+		// a snapshot taken since we last changed is reused, unless packages have since been queried.
+		queried := make(map[string]time.Time, len(m.queried))
+		for name, at := range m.queried {
+			queried[name] = at
+		}
+		m.snapshot = &snapshotStore{&MapsIndexStore{store: m.store, dangling: m.dangling, versions: m.versions, queried: queried, history: m.snapshot.history, logger: m.logger}}
+	}
+	return m.snapshot, nil
+}
+
+// CopySnapshot captures any IndexStore as it is now, as Snapshot does, for stores that are not Snapshotters.  Every
+// Package is copied using only the methods of IndexStore, so neither history nor the dependents left dangling by
+// packages forcibly removed are kept, as IndexStore does not list them.  The store must not change while copied.
+func CopySnapshot(store IndexStore) (snapshot IndexStore, error error) {
+	names, error := store.ListPackages()
+	if error != nil {
+		return nil, error
+	}
+	copied := &MapsIndexStore{store: make(map[string]*Package, len(names)), dangling: make(map[string]map[string]bool), versions: make(map[string]map[string]bool)}
+	for _, name := range names {
+		kinds, kindsErr := store.GetDependencyKinds(name)
+		if kindsErr != nil {
+			return nil, kindsErr
+		}
+		parents, parentsErr := store.GetParents(name)
+		if parentsErr != nil {
+			return nil, parentsErr
+		}
+		info, infoErr := store.GetInfo(name)
+		if infoErr != nil {
+			return nil, infoErr
+		}
+		lib := &Package{make(map[string]bool, len(kinds)), make(map[string]DependencyKind), make(map[string]bool, len(parents)), *info}
+		for dep, kind := range kinds {
+			lib.Dependencies[dep] = true
+			if kind != KindRuntime {
+				lib.Kinds[dep] = kind
+			}
+		}
+		for _, parent := range parents {
+			lib.Parents[parent] = true
+		}
+		copied.store[name] = lib
+		copied.addVersion(name)
+	}
+	return &snapshotStore{copied}, nil
+}
+
+// write prepares our maps to be changed, copying them, and those of our history, if our latest snapshot shares them.
+// Dangling dependents are changed in place, and few, so are copied along with their maps.  Packages queried since
+// are copied too, now that they can be changed.
+func (m *MapsIndexStore) write() {
+	if m.snapshot == nil {
+		return
+	}
+	m.snapshot = nil
+	store := make(map[string]*Package, len(m.store))
+	for name, lib := range m.store {
+		store[name] = lib
+	}
+	dangling := make(map[string]map[string]bool, len(m.dangling))
+	for key, dependents := range m.dangling {
+		dangling[key] = copySet(dependents)
+	}
+	versions := make(map[string]map[string]bool, len(m.versions))
+	for key, ids := range m.versions {
+		versions[key] = ids
+	}
+	m.store, m.dangling, m.versions = store, dangling, versions
+	m.history.unshare()
+	for name, queried := range m.queried {
+		lib := m.store[name].copy()
+		lib.Info.Queried = queried
+		m.store[name] = lib
+		m.own(name)
+	}
+	m.queried = nil
+}
+
+// own records that no snapshot shares a Package, so it can be changed in place.
+func (m *MapsIndexStore) own(name string) {
+	if m.owned != nil {
+		m.owned[name] = true
+	}
+}
+
+// change prepares a Package to be changed in place, recording it for our transaction, if any, and copying
+// it if a snapshot may share it.  It returns the Package to change, which is nil if it is not indexed.
+func (m *MapsIndexStore) change(name string) (lib *Package) {
+	m.write()
+	m.record(name)
+	lib = m.store[name]
+	if lib == nil || m.owned == nil || m.owned[name] {
+		return lib
+	}
+	lib = lib.copy()
+	m.store[name] = lib
+	m.own(name)
+	return lib
+}
+
+// failure describes a change attempted on a snapshot.
+func (s *snapshotStore) failure() error {
+	return err.NewIndexError("Unable to change a snapshot")
+}
+
+// Snapshot returns ourselves, as we never change.
+func (s *snapshotStore) Snapshot() (snapshot IndexStore, error error) {
+	return s, nil
+}
+
+func (s *snapshotStore) AddPackage(name string, deps []string) (added bool, error error) {
+	return false, s.failure()
+}
+
+func (s *snapshotStore) AddTypedPackage(name string, deps []string, kinds map[string]DependencyKind) (added bool, error error) {
+	return false, s.failure()
+}
+
+func (s *snapshotStore) UpdatePackage(name string, deps []string, kinds map[string]DependencyKind) (updated bool, error error) {
+	return false, s.failure()
+}
+
+func (s *snapshotStore) RemovePackage(name string) (removed bool, error error) {
+	return false, s.failure()
+}
+
+func (s *snapshotStore) ForceRemovePackage(name string) (dependents []string, error error) {
+	return []string{}, s.failure()
+}
+
+func (s *snapshotStore) SetInfo(name string, info PackageInfo) (error error) {
+	return s.failure()
+}
+
+func (s *snapshotStore) MarkQueried(name string) (error error) {
+	return s.failure()
+}
+
+func (s *snapshotStore) Begin() (error error) {
+	return s.failure()
+}
+
+func (s *snapshotStore) Commit() (error error) {
+	return s.failure()
+}
+
+func (s *snapshotStore) Rollback() (error error) {
+	return s.failure()
+}
+
+func (s *snapshotStore) Load(r io.Reader) (error error) {
+	return s.failure()
+}
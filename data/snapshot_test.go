@@ -0,0 +1,200 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Tests that a snapshot keeps the Index as it was when taken, whatever changes follow.
+func TestSnapshotUnchanged(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	snapshot, snapshotErr := store.Snapshot()
+	if (snapshotErr != nil) {
+		t.Fatalf("Error encountered taking snapshot : %s", snapshotErr.Error())
+	}
+
+	store.AddPackage("app", []string{"lib"})
+	store.UpdatePackage("lib", nil, nil)
+	store.ForceRemovePackage("base")
+	store.SetInfo("lib", PackageInfo{Client: "changed"})
+
+	if exists, _ := snapshot.HasPackage("app"); (exists) {
+		t.Error("Snapshot should not hold packages indexed since")
+	}
+	if exists, _ := snapshot.HasPackage("base"); (!exists) {
+		t.Error("Snapshot should hold packages removed since")
+	}
+	if deps, _ := snapshot.GetDependencies("lib"); (len(deps) != 1) {
+		t.Errorf("Snapshot should hold dependencies as they were, got %v", deps)
+	}
+	if parents, _ := snapshot.GetParents("lib"); (len(parents) != 0) {
+		t.Errorf("Snapshot should hold parents as they were, got %v", parents)
+	}
+	if info, _ := snapshot.GetInfo("lib"); (info.Client != "") {
+		t.Errorf("Snapshot should hold info as it was, got %v", info)
+	}
+	if versions, _ := snapshot.GetVersions("base"); (len(versions) != 1) {
+		t.Errorf("Snapshot should hold versions as they were, got %v", versions)
+	}
+	if problems, _ := snapshot.(Checkable).Check(); (len(problems) != 0) {
+		t.Errorf("Snapshot should be consistent, got %v", problems)
+	}
+	if parents, _ := snapshot.GetParents("base"); (len(parents) != 1) {
+		t.Errorf("Snapshot should hold parents of removed packages, got %v", parents)
+	}
+	if deps, _ := store.GetDependencies("lib"); (len(deps) != 0) {
+		t.Errorf("Store should have changed, got dependencies %v", deps)
+	}
+}
+
+// Tests that every change to a snapshot fails.
+func TestSnapshotImmutable(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	snapshot, _ := store.Snapshot()
+	if _, addErr := snapshot.AddPackage("lib", []string{"base"}); (addErr == nil) {
+		t.Error("Indexing in a snapshot should fail")
+	}
+	if _, removeErr := snapshot.RemovePackage("base"); (removeErr == nil) {
+		t.Error("Removing from a snapshot should fail")
+	}
+	if beginErr := snapshot.Begin(); (beginErr == nil) {
+		t.Error("Transactions on a snapshot should fail")
+	}
+	if exists, _ := store.HasPackage("lib"); (exists) {
+		t.Error("Store should not be changed through its snapshot")
+	}
+}
+
+// Tests that a snapshot is reused until our store changes, and that packages are only copied once changed.
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	store.AddPackage("other", nil)
+	first, _ := store.Snapshot()
+	if second, _ := store.Snapshot(); (first != second) {
+		t.Error("Snapshot should be reused until our store changes")
+	}
+	shared := store.store["other"]
+
+	store.AddPackage("app", []string{"lib"})
+	if second, _ := store.Snapshot(); (first == second) {
+		t.Error("Snapshot should be taken again once our store changes")
+	}
+	if (store.store["other"] != shared) {
+		t.Error("Packages left unchanged should be shared with snapshots")
+	}
+	changed := store.store["lib"]
+	store.AddPackage("tool", []string{"lib"})
+	store.AddPackage("cli", []string{"lib"})
+	if (store.store["lib"] == changed) {
+		t.Error("Packages changed should be copied once after each snapshot")
+	}
+	changed = store.store["lib"]
+	store.AddPackage("web", []string{"lib"})
+	if (store.store["lib"] != changed) {
+		t.Error("Packages already copied since our snapshot should be changed in place")
+	}
+}
+
+// Tests that marking packages queried after a snapshot does not copy our maps, yet is seen by later snapshots.
+func TestSnapshotQueried(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("lib", nil)
+	store.AddPackage("other", nil)
+	first, _ := store.Snapshot()
+	packages := store.store
+	store.MarkQueried("lib")
+	if (fmt.Sprintf("%p", store.store) != fmt.Sprintf("%p", packages)) {
+		t.Error("Marking a package queried should not copy the maps our snapshot shares")
+	}
+	if info, _ := first.GetInfo("lib"); (!info.Queried.IsZero()) {
+		t.Errorf("Snapshot should not see packages queried since, got %v", info)
+	}
+	second, _ := store.Snapshot()
+	if info, _ := second.GetInfo("lib"); (first == second || info.Queried.IsZero()) {
+		t.Errorf("Snapshot taken after a query should see it, got %v", info)
+	}
+	if info, _ := store.GetInfo("lib"); (info.Queried.IsZero()) {
+		t.Errorf("Packages queried should be answered as such, got %v", info)
+	}
+	var saved bytes.Buffer
+	second.(PersistentStore).Save(&saved)
+	loaded := newTestMapsStore()
+	loaded.Load(&saved)
+	if info, _ := loaded.GetInfo("lib"); (info.Queried.IsZero()) {
+		t.Errorf("Packages queried should be saved as such, got %v", info)
+	}
+
+	store.AddPackage("app", []string{"other"})
+	if info, _ := store.GetInfo("lib"); (len(store.queried) != 0 || info.Queried.IsZero()) {
+		t.Errorf("Packages queried should be changed once we next change, got %v", info)
+	}
+	if info, _ := second.GetInfo("lib"); (info.Queried.IsZero()) {
+		t.Errorf("Snapshot should keep packages queried before it was taken, got %v", info)
+	}
+}
+
+// Tests that snapshots cannot be taken within a transaction, which may be rolled back.
+func TestSnapshotTransaction(t *testing.T) {
+	store := newTestMapsStore()
+	store.AddPackage("base", nil)
+	snapshot, _ := store.Snapshot()
+	store.Begin()
+	store.AddPackage("lib", []string{"base"})
+	if _, snapshotErr := store.Snapshot(); (snapshotErr == nil) {
+		t.Error("Snapshot within a transaction should fail")
+	}
+	store.Rollback()
+	if parents, _ := snapshot.GetParents("base"); (len(parents) != 0) {
+		t.Errorf("Snapshot should be unchanged by a transaction, got %v", parents)
+	}
+	if problems, _ := store.Check(); (len(problems) != 0) {
+		t.Errorf("Store should be consistent once rolled back, got %v", problems)
+	}
+}
+
+// Tests that snapshots are saved and queried while our store continues to change.
+func TestSnapshotConcurrent(t *testing.T) {
+	store := newTestMapsStore()
+	buildGraph(store, 200, 4)
+	lock := NewLock()
+	var wait sync.WaitGroup
+	for reader := 0; reader < 4; reader++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 20; i++ {
+				lock.Lock()
+				snapshot, _ := store.Snapshot()
+				lock.Unlock()
+				var saved bytes.Buffer
+				if saveErr := snapshot.(PersistentStore).Save(&saved); (saveErr != nil) {
+					t.Errorf("Error encountered saving snapshot : %s", saveErr.Error())
+				}
+				if problems, _ := snapshot.(Checkable).Check(); (len(problems) != 0) {
+					t.Errorf("Snapshot should be consistent, got %v", problems)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 500; i++ {
+		id := 1 + i % 199
+		name := fmt.Sprintf("package%d", id)
+		lock.Lock()
+		if hasParents, _ := store.HasParents(name); (i % 3 == 0 && !hasParents) {
+			store.RemovePackage(name)
+		} else if exists, _ := store.HasPackage(name); (exists) {
+			store.UpdatePackage(name, []string{fmt.Sprintf("package%d", i % id)}, nil)
+		} else {
+			store.AddPackage(name, []string{"package0"})
+		}
+		lock.Unlock()
+	}
+	wait.Wait()
+}
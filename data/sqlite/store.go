@@ -0,0 +1,439 @@
+//go:build sqlite
+
+// Package sqlite is a storage backend keeping our Index in a SQLite database, using a pure Go driver so
+// that no C compiler is needed.  It is only built with the sqlite build tag, as in go build -tags sqlite,
+// so that installations which keep their Index in memory need not fetch the driver.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+	_ "modernc.org/sqlite"
+)
+
+// Backend is the name our storage backend is selected by.
+const Backend = "sqlite"
+
+// schema creates our tables.  Every namespace shares them, keyed by the name of the namespace.
+// Each row of dependencies is an edge from a package to one of its dependencies, which need not be indexed.
+// Parents are not stored, but found from these edges, so they always mirror our dependencies - and the
+// parents of a forcibly removed package are restored when it is indexed again, as its edges remain.
+const schema = `
+CREATE TABLE IF NOT EXISTS packages (
+	namespace TEXT NOT NULL,
+	name      TEXT NOT NULL,
+	base      TEXT NOT NULL,
+	revision  INTEGER NOT NULL,
+	indexed   INTEGER NOT NULL,
+	updated   INTEGER NOT NULL,
+	client    TEXT NOT NULL,
+	queried   INTEGER NOT NULL,
+	required  INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (namespace, name)
+);
+CREATE INDEX IF NOT EXISTS packages_base ON packages (namespace, base);
+CREATE TABLE IF NOT EXISTS dependencies (
+	namespace  TEXT NOT NULL,
+	package    TEXT NOT NULL,
+	dependency TEXT NOT NULL,
+	kind       TEXT NOT NULL,
+	PRIMARY KEY (namespace, package, dependency)
+);
+CREATE INDEX IF NOT EXISTS dependencies_parents ON dependencies (namespace, dependency);
+`
+
+// columns lists the columns added to our tables since they were first created, along with their definitions,
+// which are added to databases created before them.
+var columns = [][]string{
+	{"packages", "required", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// queryer runs statements against our database, either directly or within a transaction.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// SQLiteIndexStore is an IndexStore keeping the packages of a single namespace in a SQLite database.
+// Each change is made in its own transaction, unless a transaction has begun, in which case every change
+// is made within it until it is committed or rolled back.
+type SQLiteIndexStore struct {
+	db        *sql.DB
+	tx        *sql.Tx
+	namespace string
+	logger    logging.Logger
+}
+
+// query returns the transaction in progress, if any, or otherwise our database.
+func (s *SQLiteIndexStore) query() queryer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// write makes a change within the transaction in progress, or otherwise within a transaction of its own,
+// so that a change is never left partially made.
+func (s *SQLiteIndexStore) write(change func(q queryer) error) (error error) {
+	if s.tx != nil {
+		return wrap(change(s.tx))
+	}
+	tx, beginErr := s.db.Begin()
+	if beginErr != nil {
+		return wrap(beginErr)
+	}
+	if changeErr := change(tx); changeErr != nil {
+		tx.Rollback()
+		return wrap(changeErr)
+	}
+	return wrap(tx.Commit())
+}
+
+// wrap describes an error from our database as an IndexError.
+func wrap(failure error) error {
+	if failure == nil {
+		return nil
+	}
+	if _, ok := failure.(*err.IndexError); ok {
+		return failure
+	}
+	return err.NewIndexError("SQLite store failed : " + failure.Error())
+}
+
+// unix converts a time to the nanoseconds since the unix epoch that we store, with 0 for the zero time.
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnix converts a stored time back, with 0 as the zero time.
+func fromUnix(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func (s *SQLiteIndexStore) AddPackage(name string, deps []string) (added bool, error error) {
+	return s.AddTypedPackage(name, deps, nil)
+}
+
+func (s *SQLiteIndexStore) AddTypedPackage(name string, deps []string, kinds map[string]data.DependencyKind) (bool, error) {
+	writeErr := s.write(func(q queryer) error {
+		now := unix(time.Now())
+		base, _ := data.SplitVersion(name)
+		if _, execErr := q.Exec(`INSERT OR REPLACE INTO packages (namespace, name, base, revision, indexed, updated, client, queried, required)
+			VALUES (?, ?, ?, 1, ?, ?, '', 0, 0)`, s.namespace, name, base, now, now); execErr != nil {
+			return execErr
+		}
+		return s.replaceDependencies(q, name, deps, kinds)
+	})
+	if writeErr != nil {
+		return false, writeErr
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s added to Index", name))
+	return true, nil
+}
+
+// replaceDependencies replaces the edges from a Package to its dependencies.
+func (s *SQLiteIndexStore) replaceDependencies(q queryer, name string, deps []string, kinds map[string]data.DependencyKind) (error error) {
+	if _, execErr := q.Exec(`DELETE FROM dependencies WHERE namespace = ? AND package = ?`, s.namespace, name); execErr != nil {
+		return execErr
+	}
+	for _, dep := range deps {
+		kind, ok := kinds[dep]
+		if !ok {
+			kind = data.KindRuntime
+		}
+		if _, execErr := q.Exec(`INSERT OR REPLACE INTO dependencies VALUES (?, ?, ?, ?)`, s.namespace, name, dep, string(kind)); execErr != nil {
+			return execErr
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteIndexStore) UpdatePackage(name string, deps []string, kinds map[string]data.DependencyKind) (bool, error) {
+	writeErr := s.write(func(q queryer) error {
+		result, execErr := q.Exec(`UPDATE packages SET revision = revision + 1, updated = ? WHERE namespace = ? AND name = ?`, unix(time.Now()), s.namespace, name)
+		if execErr != nil {
+			return execErr
+		}
+		if changed, _ := result.RowsAffected(); changed == 0 {
+			return err.NewIndexError("Unable to update Unindexed package")
+		}
+		// parents are found from our edges, so only the edges that changed affect them.
+		return s.replaceDependencies(q, name, deps, kinds)
+	})
+	if writeErr != nil {
+		return false, writeErr
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s updated in Index", name))
+	return true, nil
+}
+
+func (s *SQLiteIndexStore) RemovePackage(name string) (bool, error) {
+	writeErr := s.write(func(q queryer) error {
+		if _, execErr := q.Exec(`DELETE FROM packages WHERE namespace = ? AND name = ?`, s.namespace, name); execErr != nil {
+			return execErr
+		}
+		_, execErr := q.Exec(`DELETE FROM dependencies WHERE namespace = ? AND package = ?`, s.namespace, name)
+		return execErr
+	})
+	if writeErr != nil {
+		return false, writeErr
+	}
+	s.logger.Trace(fmt.Sprintf("Package %s removed from Index", name))
+	return true, nil
+}
+
+func (s *SQLiteIndexStore) ForceRemovePackage(name string) (dependents []string, error error) {
+	exists, error := s.HasPackage(name)
+	if error != nil || !exists {
+		return []string{}, error
+	}
+	// dependents keep their edges to this package, so are left with a dangling dependency.
+	dependents, error = s.GetParents(name)
+	if error != nil {
+		return []string{}, error
+	}
+	if _, error = s.RemovePackage(name); error != nil {
+		return []string{}, error
+	}
+	return dependents, nil
+}
+
+func (s *SQLiteIndexStore) HasPackage(name string) (exists bool, error error) {
+	var count int
+	if scanErr := s.query().QueryRow(`SELECT COUNT(*) FROM packages WHERE namespace = ? AND name = ?`, s.namespace, name).Scan(&count); scanErr != nil {
+		return false, wrap(scanErr)
+	}
+	return count > 0, nil
+}
+
+// mustExist fails unless a Package is indexed, describing what could not be done.
+func (s *SQLiteIndexStore) mustExist(name string, failure string) (error error) {
+	exists, error := s.HasPackage(name)
+	if error == nil && !exists {
+		error = err.NewIndexError(failure)
+	}
+	return error
+}
+
+func (s *SQLiteIndexStore) HasParents(name string) (hasParents bool, error error) {
+	if error = s.mustExist(name, "Unable to determined if Unindexed package has parents"); error != nil {
+		return false, error
+	}
+	parents, error := s.GetParents(name)
+	return len(parents) > 0, error
+}
+
+// list returns the first column of every row a query returns.
+func (s *SQLiteIndexStore) list(query string, args ...interface{}) (values []string, error error) {
+	values = make([]string, 0)
+	rows, queryErr := s.query().Query(query, args...)
+	if queryErr != nil {
+		return values, wrap(queryErr)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value string
+		if scanErr := rows.Scan(&value); scanErr != nil {
+			return values, wrap(scanErr)
+		}
+		values = append(values, value)
+	}
+	return values, wrap(rows.Err())
+}
+
+func (s *SQLiteIndexStore) GetDependencies(name string) (deps []string, error error) {
+	if error = s.mustExist(name, "Unable to determine dependencies of Unindexed package"); error != nil {
+		return nil, error
+	}
+	return s.list(`SELECT dependency FROM dependencies WHERE namespace = ? AND package = ? ORDER BY dependency`, s.namespace, name)
+}
+
+func (s *SQLiteIndexStore) GetDependencyKinds(name string) (kinds map[string]data.DependencyKind, error error) {
+	if error = s.mustExist(name, "Unable to determine dependencies of Unindexed package"); error != nil {
+		return nil, error
+	}
+	rows, queryErr := s.query().Query(`SELECT dependency, kind FROM dependencies WHERE namespace = ? AND package = ?`, s.namespace, name)
+	if queryErr != nil {
+		return nil, wrap(queryErr)
+	}
+	defer rows.Close()
+	kinds = make(map[string]data.DependencyKind)
+	for rows.Next() {
+		var dep, kind string
+		if scanErr := rows.Scan(&dep, &kind); scanErr != nil {
+			return nil, wrap(scanErr)
+		}
+		kinds[dep] = data.DependencyKind(kind)
+	}
+	return kinds, wrap(rows.Err())
+}
+
+func (s *SQLiteIndexStore) GetParents(name string) (parents []string, error error) {
+	if error = s.mustExist(name, "Unable to determine parents of Unindexed package"); error != nil {
+		return nil, error
+	}
+	// optional dependencies never make a package a parent.
+	return s.list(`SELECT package FROM dependencies WHERE namespace = ? AND dependency = ? AND kind != ? ORDER BY package`,
+		s.namespace, name, string(data.KindOptional))
+}
+
+func (s *SQLiteIndexStore) ListPackages() (names []string, error error) {
+	return s.list(`SELECT name FROM packages WHERE namespace = ? ORDER BY name`, s.namespace)
+}
+
+func (s *SQLiteIndexStore) GetVersions(name string) (versions []string, error error) {
+	return s.list(`SELECT name FROM packages WHERE namespace = ? AND base = ? ORDER BY name`, s.namespace, name)
+}
+
+func (s *SQLiteIndexStore) GetInfo(name string) (info *data.PackageInfo, error error) {
+	var indexed, updated, queried int64
+	info = &data.PackageInfo{}
+	scanErr := s.query().QueryRow(`SELECT revision, indexed, updated, client, queried, required FROM packages WHERE namespace = ? AND name = ?`,
+		s.namespace, name).Scan(&info.Revision, &indexed, &updated, &info.Client, &queried, &info.Required)
+	if scanErr == sql.ErrNoRows {
+		return nil, err.NewIndexError("Unable to determine info of Unindexed package")
+	}
+	if scanErr != nil {
+		return nil, wrap(scanErr)
+	}
+	info.Indexed = fromUnix(indexed)
+	info.Updated = fromUnix(updated)
+	info.Queried = fromUnix(queried)
+	return info, nil
+}
+
+// update changes a single indexed Package, describing what could not be done if it is not indexed.
+func (s *SQLiteIndexStore) update(name string, failure string, query string, args ...interface{}) error {
+	return s.write(func(q queryer) error {
+		result, execErr := q.Exec(query, append(args, s.namespace, name)...)
+		if execErr != nil {
+			return execErr
+		}
+		if changed, _ := result.RowsAffected(); changed == 0 {
+			return err.NewIndexError(failure)
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteIndexStore) SetInfo(name string, info data.PackageInfo) (error error) {
+	return s.update(name, "Unable to set info of Unindexed package",
+		`UPDATE packages SET revision = ?, indexed = ?, updated = ?, client = ?, queried = ?, required = ? WHERE namespace = ? AND name = ?`,
+		info.Revision, unix(info.Indexed), unix(info.Updated), info.Client, unix(info.Queried), info.Required)
+}
+
+func (s *SQLiteIndexStore) MarkQueried(name string) (error error) {
+	return s.update(name, "Unable to mark Unindexed package as queried",
+		`UPDATE packages SET queried = ? WHERE namespace = ? AND name = ?`, unix(time.Now()))
+}
+
+func (s *SQLiteIndexStore) Begin() (error error) {
+	if s.tx != nil {
+		return err.NewIndexError("Unable to begin a transaction within another transaction")
+	}
+	tx, beginErr := s.db.Begin()
+	if beginErr != nil {
+		return wrap(beginErr)
+	}
+	s.tx = tx
+	return nil
+}
+
+func (s *SQLiteIndexStore) Commit() (error error) {
+	if s.tx == nil {
+		return err.NewIndexError("Unable to commit outside of a transaction")
+	}
+	tx := s.tx
+	s.tx = nil
+	return wrap(tx.Commit())
+}
+
+func (s *SQLiteIndexStore) Rollback() (error error) {
+	if s.tx == nil {
+		return err.NewIndexError("Unable to roll back outside of a transaction")
+	}
+	tx := s.tx
+	s.tx = nil
+	s.logger.Trace("Transaction rolled back")
+	return wrap(tx.Rollback())
+}
+
+// Drop deletes every package of our namespace from the database, along with their dependencies, and closes our
+// connection to it, rolling back any transaction in progress.
+func (s *SQLiteIndexStore) Drop() error {
+	if s.tx != nil {
+		s.Rollback()
+	}
+	dropErr := s.write(func(q queryer) error {
+		if _, execErr := q.Exec(`DELETE FROM dependencies WHERE namespace = ?`, s.namespace); execErr != nil {
+			return execErr
+		}
+		_, execErr := q.Exec(`DELETE FROM packages WHERE namespace = ?`, s.namespace)
+		return execErr
+	})
+	if closeErr := s.db.Close(); dropErr == nil {
+		dropErr = wrap(closeErr)
+	}
+	return dropErr
+}
+
+// Check verifies that no packages depend on one another in a cycle.  Parents are found from our
+// dependencies, so always mirror them.
+func (s *SQLiteIndexStore) Check() (problems []data.Problem, error error) {
+	return data.CheckGraph(s)
+}
+
+// New creates an IndexStore for a namespace in the SQLite database at path, creating the database if needed.
+// A path of ':memory:' keeps the database in memory, for testing.
+func New(namespace string, path string, logger logging.Logger) (store data.IndexStore, error error) {
+	if len(path) == 0 {
+		return nil, err.NewCodedIndexError(err.CodeInvalidArgument, "SQLite store needs the path of its database in storeOptions")
+	}
+	db, openErr := sql.Open("sqlite", path)
+	if openErr != nil {
+		return nil, wrap(openErr)
+	}
+	// a single connection serializes our changes, and keeps an in memory database alive.
+	db.SetMaxOpenConns(1)
+	if _, execErr := db.Exec(`PRAGMA busy_timeout = 5000; PRAGMA journal_mode = WAL;` + schema); execErr != nil {
+		db.Close()
+		return nil, wrap(execErr)
+	}
+	if migrateErr := migrate(db); migrateErr != nil {
+		db.Close()
+		return nil, wrap(migrateErr)
+	}
+	return &SQLiteIndexStore{db, nil, namespace, logger}, nil
+}
+
+// migrate adds any of our columns missing from a database created before them.
+func migrate(db *sql.DB) (error error) {
+	for _, column := range columns {
+		var count int
+		if scanErr := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, column[0], column[1]).Scan(&count); scanErr != nil {
+			return scanErr
+		}
+		if count > 0 {
+			continue
+		}
+		if _, execErr := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, column[0], column[1], column[2])); execErr != nil {
+			return execErr
+		}
+	}
+	return nil
+}
+
+func init() {
+	data.RegisterBackend(Backend, New)
+}
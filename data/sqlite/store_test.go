@@ -0,0 +1,110 @@
+//go:build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/data/storetest"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+func newTestStore(t *testing.T, namespace string, path string) data.IndexStore {
+	logLevel := "FATAL"
+	store, err := New(namespace, path, logging.NewIndexLogger(&logLevel))
+	if (err != nil) {
+		t.Fatalf("Error encountered opening store : %s", err.Error())
+	}
+	return store
+}
+
+// Tests that our SQLite store conforms to IndexStore.
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func() data.IndexStore {
+		return newTestStore(t, "default", ":memory:")
+	})
+}
+
+// Tests that packages are kept on disk, and that namespaces sharing a database are kept apart.
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	store := newTestStore(t, "default", path)
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	other := newTestStore(t, "other", path)
+	other.AddPackage("zlib", nil)
+
+	reopened := newTestStore(t, "default", path)
+	if names, _ := reopened.ListPackages(); (len(names) != 2 || names[0] != "base" || names[1] != "lib") {
+		t.Errorf("Packages should be kept on disk, got %v", names)
+	}
+	if parents, _ := reopened.GetParents("base"); (len(parents) != 1 || parents[0] != "lib") {
+		t.Errorf("Parents should be kept on disk, got %v", parents)
+	}
+	if exists, _ := reopened.HasPackage("zlib"); (exists) {
+		t.Error("Packages of other namespaces should not be seen")
+	}
+}
+
+// Tests that dropping a namespace deletes its packages, so a namespace created again with its name is empty,
+// leaving other namespaces sharing the database untouched.
+func TestDrop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	store := newTestStore(t, "default", path)
+	store.AddPackage("base", nil)
+	other := newTestStore(t, "other", path)
+	other.AddPackage("zlib", nil)
+	other.AddPackage("lib", []string{"zlib"})
+
+	if err := other.(data.Droppable).Drop(); (err != nil) {
+		t.Fatalf("Error encountered dropping store : %s", err.Error())
+	}
+	if _, err := other.HasPackage("zlib"); (err == nil) {
+		t.Error("Store should be closed once dropped")
+	}
+	recreated := newTestStore(t, "other", path)
+	if names, _ := recreated.ListPackages(); (len(names) != 0) {
+		t.Errorf("Packages of dropped namespace should be deleted, got %v", names)
+	}
+	var edges int
+	recreated.(*SQLiteIndexStore).db.QueryRow(`SELECT COUNT(*) FROM dependencies WHERE namespace = 'other'`).Scan(&edges)
+	if (edges != 0) {
+		t.Errorf("Dependencies of dropped namespace should be deleted, got %d", edges)
+	}
+	if exists, _ := store.HasPackage("base"); (!exists) {
+		t.Error("Packages of other namespaces should be kept")
+	}
+}
+
+// Tests that databases created before a column was added are migrated.
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	db, _ := sql.Open("sqlite", path)
+	_, execErr := db.Exec(`CREATE TABLE packages (namespace TEXT NOT NULL, name TEXT NOT NULL, base TEXT NOT NULL,
+		revision INTEGER NOT NULL, indexed INTEGER NOT NULL, updated INTEGER NOT NULL, client TEXT NOT NULL,
+		queried INTEGER NOT NULL, PRIMARY KEY (namespace, name));
+		INSERT INTO packages VALUES ('default', 'base', 'base', 3, 0, 0, 'alice', 0)`)
+	db.Close()
+	if (execErr != nil) {
+		t.Fatalf("Error encountered creating database : %s", execErr.Error())
+	}
+
+	store := newTestStore(t, "default", path)
+	if info, err := store.GetInfo("base"); (err != nil || info.Revision != 3 || info.Required) {
+		t.Errorf("Packages should be kept once migrated, got %v %v", info, err)
+	}
+	store.AddPackage("lib", []string{"base"})
+	if info, err := store.GetInfo("lib"); (err != nil || info.Revision != 1) {
+		t.Errorf("Packages should be added once migrated, got %v %v", info, err)
+	}
+}
+
+// Tests that a database is required.
+func TestNewWithoutPath(t *testing.T) {
+	logLevel := "FATAL"
+	if _, err := New("default", "", logging.NewIndexLogger(&logLevel)); (err == nil) {
+		t.Error("Store should not be created without the path of its database")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"github.com/kristenfelch/pkgindexer/err"
 	"github.com/kristenfelch/pkgindexer/logging"
 	"fmt"
+	"sort"
+	"time"
 )
 
 // IndexStore is responsible for storing the current state of our index.
@@ -11,9 +13,17 @@ import (
 // packages, and abstracts the data storage choice for our index.
 type IndexStore interface {
 
-	// Adds a Package to our Index.
+	// Adds a Package to our Index, with only runtime dependencies.
 	AddPackage(name string, deps []string) (added bool, error error)
 
+	// Adds a Package to our Index, where kinds gives the kind of each dependency other than runtime dependencies.
+	// Optional dependencies do not make the Package a parent of the dependency, so never prevent its removal.
+	AddTypedPackage(name string, deps []string, kinds map[string]DependencyKind) (added bool, error error)
+
+	// Replaces the dependencies of an indexed Package, adjusting only the parents of dependencies that changed.
+	// The Package keeps its own parents and history, and its revision increases.
+	UpdatePackage(name string, deps []string, kinds map[string]DependencyKind) (updated bool, error error)
+
 	// Removes a Package from our Index.
 	RemovePackage(name string) (removed bool, error error)
 
@@ -22,11 +32,90 @@ type IndexStore interface {
 
 	// Determines if a Package has Parents - other packages that depend on it.
 	HasParents(name string) (hasParents bool, error error)
+
+	// Returns the direct Dependencies of an indexed Package, sorted by name.
+	GetDependencies(name string) (deps []string, error error)
+
+	// Returns the kind of each direct Dependency of an indexed Package.
+	GetDependencyKinds(name string) (kinds map[string]DependencyKind, error error)
+
+	// Removes a Package from our Index even though other packages depend on it, returning
+	// those dependents, which are left with a dangling dependency until the Package is indexed again.
+	ForceRemovePackage(name string) (dependents []string, error error)
+
+	// Returns the Parents of an indexed Package - other packages that depend on it, sorted by name.
+	GetParents(name string) (parents []string, error error)
+
+	// Returns the names of every indexed Package, sorted by name.
+	ListPackages() (names []string, error error)
+
+	// Returns every indexed version of a Package, including the Package indexed without a version,
+	// identified as in foo@1.2.0 and sorted.
+	GetVersions(name string) (versions []string, error error)
+
+	// Returns the revision of an indexed Package, when it was indexed, last changed and last queried,
+	// and the client that last changed it.
+	GetInfo(name string) (info *PackageInfo, error error)
+
+	// Replaces the info of an indexed Package.
+	SetInfo(name string, info PackageInfo) (error error)
+
+	// Records that an indexed Package has been queried by a client.
+	MarkQueried(name string) (error error)
+
+	// Begins a transaction, so that every change until Commit or Rollback can be undone.
+	Begin() (error error)
+
+	// Commits a transaction, keeping every change made since Begin.
+	Commit() (error error)
+
+	// Rolls back a transaction, undoing every change made since Begin.
+	Rollback() (error error)
+}
+
+// PackageInfo describes the history of an indexed Package.
+// Revision starts at 1 when a Package is indexed, and increases each time it is updated.
+// Indexed is when the Package was first indexed, and Updated when it last changed.
+// Client identifies the client that last changed the Package, if known.
+// Queried is the zero time if the Package has never been queried.
+// Required records whether another Package has ever required this one, so it was indexed as a dependency.
+type PackageInfo struct {
+	Revision int
+	Indexed  time.Time
+	Updated  time.Time
+	Client   string
+	Queried  time.Time
+	Required bool
 }
 
 type MapsIndexStore struct {
 	store map[string]*Package
-	logger logging.Logger
+	// dangling records, for each forcibly removed package, the dependents that still depend on it.
+	dangling map[string]map[string]bool
+	// versions records the indexed versions of each package name, so they can be found without iteration.
+	versions map[string]map[string]bool
+	// journal records how to undo changes made during a transaction, and is nil outside of one.
+	journal *journal
+	// snapshot is our latest snapshot, which shares our maps until we next change.
+	snapshot *snapshotStore
+	// owned records the packages replaced since our latest snapshot, which no snapshot shares so can be
+	// changed in place, and is nil if no snapshot was ever taken.
+	owned map[string]bool
+	// queried records when each package was queried since our latest snapshot, while that snapshot still shares
+	// our maps, so that marking a package queried does not copy them.  It is applied once we next change.
+	queried map[string]time.Time
+	// history records the changes made to each package, and is nil if none are kept.
+	history *history
+	logger  logging.Logger
+}
+
+// journal records the state of each package before it was first changed during a transaction,
+// with nil recording a package that was not indexed, along with our dangling dependencies.
+type journal struct {
+	packages map[string]*Package
+	dangling map[string]map[string]bool
+	// changes counts the changes in our history when the transaction began.
+	changes int
 }
 
 // Package is a type of struct used to store our packages that have been indexed.
@@ -36,52 +125,200 @@ type MapsIndexStore struct {
 // We store dependencies (packages that this package depends on) also for efficient removal,
 // so that when a package is removed we can remove it also from the Parents list of its dependencies.
 // We are using map[string]bool for Dependencies and Parents for faster lookup time than a []string would provide.
+// Most dependencies are runtime dependencies, so Kinds only records the kind of those that are not.
 type Package struct {
 	Dependencies map[string]bool
+	Kinds        map[string]DependencyKind
 	Parents      map[string]bool
+	Info         PackageInfo
 }
 
 func (l *Package) HasParents() bool {
 	return len(l.Parents) > 0
 }
 
+// copy creates a deep copy of a Package, so that later changes to either do not affect the other.
+func (l *Package) copy() *Package {
+	kinds := make(map[string]DependencyKind, len(l.Kinds))
+	for dep, kind := range l.Kinds {
+		kinds[dep] = kind
+	}
+	return &Package{
+		copySet(l.Dependencies),
+		kinds,
+		copySet(l.Parents),
+		l.Info,
+	}
+}
+
+// kind returns the kind of one of our Dependencies.
+func (l *Package) kind(dep string) DependencyKind {
+	if kind, ok := l.Kinds[dep]; ok {
+		return kind
+	}
+	return KindRuntime
+}
+
+// requires determines if we depend on a Package such that it cannot be removed while we are indexed,
+// which is true of every kind of Dependency other than optional.
+func (l *Package) requires(dep string) bool {
+	return l.Dependencies[dep] && l.kind(dep) != KindOptional
+}
+
+func copySet(set map[string]bool) map[string]bool {
+	copied := make(map[string]bool, len(set))
+	for key := range set {
+		copied[key] = true
+	}
+	return copied
+}
+
 func (m *MapsIndexStore) AddPackage(name string, deps []string) (added bool, error error) {
+	return m.AddTypedPackage(name, deps, nil)
+}
+
+func (m *MapsIndexStore) AddTypedPackage(name string, deps []string, kinds map[string]DependencyKind) (added bool, error error) {
+	m.write()
 	dependencies := make(map[string]bool, len(deps))
+	dependencyKinds := make(map[string]DependencyKind)
 	for v := range deps {
 		dependencies[deps[v]] = true
-		if depPackage, _ := m.getPackage(deps[v]); depPackage != nil {
+		if kind, ok := kinds[deps[v]]; ok && kind != KindRuntime {
+			dependencyKinds[deps[v]] = kind
+		}
+		if dependencyKinds[deps[v]] == KindOptional {
+			// optional dependencies can be removed regardless of this package.
+			continue
+		}
+		if depPackage := m.change(deps[v]); depPackage != nil {
 			// add this package to each dependency's parents, so that we know we
 			// cannot remove the dependency.
 			m.logger.Trace(fmt.Sprintf("Package %s added to dependencies of %s", name, deps[v]))
 			depPackage.Parents[name] = true
 		}
 	}
+	m.record(name)
+	m.addVersion(name)
+	now := time.Now()
 	m.store[name] = &Package{
 		dependencies,
+		dependencyKinds,
 		// No packages can depend on this one until after this one has been created
 		// thus initialize with an empty list.
 		make(map[string]bool),
+		PackageInfo{Revision: 1, Indexed: now, Updated: now},
+	}
+	m.own(name)
+	// Unless this package was forcibly removed, in which case the dependents left dangling
+	// depend on it once again.
+	if dependents, ok := m.dangling[name]; ok {
+		for dependent := range dependents {
+			m.logger.Trace(fmt.Sprintf("Package %s restored as parent of %s", dependent, name))
+			m.store[name].Parents[dependent] = true
+		}
+		delete(m.dangling, name)
 	}
+	m.remember(name)
 	m.logger.Trace(fmt.Sprintf("Package %s added to Index", name))
 	return true, nil
 }
 
+func (m *MapsIndexStore) UpdatePackage(name string, deps []string, kinds map[string]DependencyKind) (updated bool, error error) {
+	lib, _ := m.getPackage(name)
+	if lib == nil {
+		return false, err.NewIndexError("Unable to update Unindexed package")
+	}
+	lib = m.change(name)
+	replaced := &Package{make(map[string]bool, len(deps)), make(map[string]DependencyKind), lib.Parents, lib.Info}
+	for _, dep := range deps {
+		replaced.Dependencies[dep] = true
+		if kind, ok := kinds[dep]; ok && kind != KindRuntime {
+			replaced.Kinds[dep] = kind
+		}
+	}
+
+	// drop this package from the parents of dependencies it no longer requires.
+	for dep := range lib.Dependencies {
+		if !lib.requires(dep) || replaced.requires(dep) {
+			continue
+		}
+		if depPackage := m.change(dep); depPackage != nil {
+			m.logger.Trace(fmt.Sprintf("Package %s removed as parent of %s", name, dep))
+			delete(depPackage.Parents, name)
+		} else if dependents, ok := m.dangling[dep]; ok {
+			// this package no longer dangles from a forcibly removed dependency.
+			delete(dependents, name)
+			if len(dependents) == 0 {
+				delete(m.dangling, dep)
+			}
+		}
+	}
+	// and add it to the parents of dependencies it newly requires.
+	for dep := range replaced.Dependencies {
+		if !replaced.requires(dep) || lib.requires(dep) {
+			continue
+		}
+		if depPackage := m.change(dep); depPackage != nil {
+			m.logger.Trace(fmt.Sprintf("Package %s added to dependencies of %s", name, dep))
+			depPackage.Parents[name] = true
+		}
+	}
+
+	replaced.Info.Revision++
+	replaced.Info.Updated = time.Now()
+	m.store[name] = replaced
+	m.own(name)
+	m.remember(name)
+	m.logger.Trace(fmt.Sprintf("Package %s updated in Index", name))
+	return true, nil
+}
+
 func (m *MapsIndexStore) RemovePackage(name string) (removed bool, error error) {
 	if lib, _ := m.getPackage(name); lib != nil {
+		m.write()
+		m.record(name)
 		delete(m.store, name)
+		m.removeVersion(name)
 		for key := range lib.Dependencies {
-			if dependentPackage, _ := m.getPackage(key); dependentPackage != nil {
+			if dependentPackage := m.change(key); dependentPackage != nil {
 				// remove this package from each dependency's parents, so that we know
 				// we can remove the dependency if no others depend on it.
 				m.logger.Trace(fmt.Sprintf("Package %s removed as parent of %s", name, key))
 				delete(dependentPackage.Parents, name)
+			} else if dependents, ok := m.dangling[key]; ok {
+				// this package no longer dangles from a forcibly removed dependency.
+				delete(dependents, name)
+				if len(dependents) == 0 {
+					delete(m.dangling, key)
+				}
 			}
 		}
+		m.remember(name)
 	}
 	m.logger.Trace(fmt.Sprintf("Package %s removed from Index", name))
 	return true, nil
 }
 
+func (m *MapsIndexStore) ForceRemovePackage(name string) (dependents []string, error error) {
+	lib, _ := m.getPackage(name)
+	if lib == nil {
+		return []string{}, nil
+	}
+	m.write()
+	dependents = sortedKeys(lib.Parents)
+	if len(dependents) > 0 {
+		if _, ok := m.dangling[name]; !ok {
+			m.dangling[name] = make(map[string]bool, len(dependents))
+		}
+		for _, dependent := range dependents {
+			m.logger.Trace(fmt.Sprintf("Package %s left with dangling dependency %s", dependent, name))
+			m.dangling[name][dependent] = true
+		}
+	}
+	m.RemovePackage(name)
+	return dependents, nil
+}
+
 func (m *MapsIndexStore) getPackage(name string) (lib *Package, error error) {
 	if lib, ok := m.store[name]; ok {
 		return lib, nil
@@ -106,9 +343,202 @@ func (m *MapsIndexStore) HasParents(name string) (hasParents bool, error error)
 	}
 }
 
+func (m *MapsIndexStore) GetDependencies(name string) (deps []string, error error) {
+	if lib, _ := m.getPackage(name); lib != nil {
+		return sortedKeys(lib.Dependencies), nil
+	} else {
+		return nil, err.NewIndexError("Unable to determine dependencies of Unindexed package")
+	}
+}
+
+func (m *MapsIndexStore) GetDependencyKinds(name string) (kinds map[string]DependencyKind, error error) {
+	if lib, _ := m.getPackage(name); lib != nil {
+		kinds = make(map[string]DependencyKind, len(lib.Dependencies))
+		for dep := range lib.Dependencies {
+			kinds[dep] = lib.kind(dep)
+		}
+		return kinds, nil
+	} else {
+		return nil, err.NewIndexError("Unable to determine dependencies of Unindexed package")
+	}
+}
+
+func (m *MapsIndexStore) GetParents(name string) (parents []string, error error) {
+	if lib, _ := m.getPackage(name); lib != nil {
+		return sortedKeys(lib.Parents), nil
+	} else {
+		return nil, err.NewIndexError("Unable to determine parents of Unindexed package")
+	}
+}
+
+func (m *MapsIndexStore) ListPackages() (names []string, error error) {
+	names = make([]string, 0, len(m.store))
+	for name := range m.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *MapsIndexStore) GetVersions(name string) (versions []string, error error) {
+	return sortedKeys(m.versions[name]), nil
+}
+
+// addVersion records that a Package is indexed under its name.  The versions of a name are replaced rather
+// than changed, as a snapshot may share them.
+func (m *MapsIndexStore) addVersion(id string) {
+	name, _ := SplitVersion(id)
+	versions := copySet(m.versions[name])
+	versions[id] = true
+	m.versions[name] = versions
+}
+
+// removeVersion records that a Package is no longer indexed under its name.
+func (m *MapsIndexStore) removeVersion(id string) {
+	name, _ := SplitVersion(id)
+	if versions, ok := m.versions[name]; ok && versions[id] {
+		versions = copySet(versions)
+		delete(versions, id)
+		if len(versions) == 0 {
+			delete(m.versions, name)
+		} else {
+			m.versions[name] = versions
+		}
+	}
+}
+
+func (m *MapsIndexStore) GetInfo(name string) (info *PackageInfo, error error) {
+	if lib, _ := m.getPackage(name); lib != nil {
+		info := lib.Info
+		if queried, ok := m.queried[name]; ok {
+			info.Queried = queried
+		}
+		return &info, nil
+	} else {
+		return nil, err.NewIndexError("Unable to determine info of Unindexed package")
+	}
+}
+
+func (m *MapsIndexStore) SetInfo(name string, info PackageInfo) (error error) {
+	if lib := m.change(name); lib != nil {
+		before := lib.Info
+		lib.Info = info
+		m.rememberInfo(name, before)
+		return nil
+	} else {
+		return err.NewIndexError("Unable to set info of Unindexed package")
+	}
+}
+
+// MarkQueried does not change a Package our latest snapshot shares outside of a transaction, but records when it
+// was queried until we next change, as that is all that changed.
+func (m *MapsIndexStore) MarkQueried(name string) (error error) {
+	if _, ok := m.store[name]; ok && m.snapshot != nil && m.journal == nil {
+		if m.queried == nil {
+			m.queried = make(map[string]time.Time)
+		}
+		m.queried[name] = time.Now()
+		return nil
+	}
+	if lib := m.change(name); lib != nil {
+		lib.Info.Queried = time.Now()
+		return nil
+	} else {
+		return err.NewIndexError("Unable to mark Unindexed package as queried")
+	}
+}
+
+func (m *MapsIndexStore) Begin() (error error) {
+	if m.journal != nil {
+		return err.NewIndexError("Unable to begin a transaction within another transaction")
+	}
+	dangling := make(map[string]map[string]bool, len(m.dangling))
+	for key, dependents := range m.dangling {
+		dangling[key] = copySet(dependents)
+	}
+	changes := 0
+	if m.history != nil {
+		changes = len(m.history.changes)
+		m.history.seal()
+	}
+	m.journal = &journal{
+		make(map[string]*Package),
+		dangling,
+		changes,
+	}
+	return nil
+}
+
+func (m *MapsIndexStore) Commit() (error error) {
+	if m.journal == nil {
+		return err.NewIndexError("Unable to commit outside of a transaction")
+	}
+	m.journal = nil
+	m.prune()
+	return nil
+}
+
+func (m *MapsIndexStore) Rollback() (error error) {
+	if m.journal == nil {
+		return err.NewIndexError("Unable to roll back outside of a transaction")
+	}
+	m.write()
+	for name, lib := range m.journal.packages {
+		if lib == nil {
+			delete(m.store, name)
+			m.removeVersion(name)
+		} else {
+			// our journal holds copies, which no snapshot shares.
+			m.store[name] = lib
+			m.own(name)
+			m.addVersion(name)
+		}
+	}
+	m.dangling = m.journal.dangling
+	if m.history != nil {
+		m.history.truncate(m.journal.changes)
+	}
+	m.journal = nil
+	m.logger.Trace("Transaction rolled back")
+	return nil
+}
+
+// record saves the state of a Package before it is first changed during a transaction.
+func (m *MapsIndexStore) record(name string) {
+	if m.journal == nil {
+		return
+	}
+	if _, ok := m.journal.packages[name]; ok {
+		return
+	}
+	if lib, _ := m.getPackage(name); lib != nil {
+		m.journal.packages[name] = lib.copy()
+	} else {
+		m.journal.packages[name] = nil
+	}
+}
+
+// sortedKeys returns the keys of a set of package names in sorted order, so that
+// results are consistent regardless of map iteration order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func NewIndexStore(logger logging.Logger) IndexStore {
 	return &MapsIndexStore{
 		make(map[string]*Package),
+		make(map[string]map[string]bool),
+		make(map[string]map[string]bool),
+		nil,
+		nil,
+		nil,
+		nil,
+		newHistory(DefaultRetention),
 		logger,
 	}
 }
@@ -84,3 +84,206 @@ func TestHasPackage(t *testing.T) {
 	}
 
 }
+
+// Tests that forcibly removing a package returns its dependents, and that indexing the package
+// again restores it as their dependency.
+func TestForceRemoveAndReindex(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("dep1", nil)
+	store.AddPackage("package", []string{"dep1"})
+
+	dependents, err := store.ForceRemovePackage("dep1")
+	if (err != nil || len(dependents) != 1 || dependents[0] != "package") {
+		t.Errorf("Forced removal should return dependents : %v", dependents)
+	}
+	exists, err := store.HasPackage("dep1")
+	if (err != nil || exists) {
+		t.Error("Package should be removed even though others depend on it")
+	}
+
+	store.AddPackage("dep1", nil)
+	hasParents, err := store.HasParents("dep1")
+	if (err != nil || !hasParents) {
+		t.Error("Dependents left dangling should depend on package once it is indexed again")
+	}
+}
+
+// Tests that a dependent removed while dangling is not restored as a parent.
+func TestForceRemoveDependentRemoved(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("dep1", nil)
+	store.AddPackage("package", []string{"dep1"})
+	store.ForceRemovePackage("dep1")
+	store.RemovePackage("package")
+
+	store.AddPackage("dep1", nil)
+	hasParents, err := store.HasParents("dep1")
+	if (err != nil || hasParents) {
+		t.Error("Removed dependents should not depend on package once it is indexed again")
+	}
+}
+
+// Tests that updating a package keeps its own parents, and adjusts only the parents of dependencies that changed.
+func TestUpdatePackage(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+	store.AddPackage("package", []string{"dep1", "dep2"})
+	store.AddPackage("parent", []string{"package"})
+
+	updated, err := store.UpdatePackage("package", []string{"dep2", "dep3"}, nil)
+	if (err != nil || !updated) {
+		t.Error("Indexed package should be updated")
+	}
+	if parents, _ := store.GetParents("package"); (len(parents) != 1 || parents[0] != "parent") {
+		t.Errorf("Updated package should keep its parents, got %v", parents)
+	}
+	if hasParents, _ := store.HasParents("dep1"); (hasParents) {
+		t.Error("Package should be removed from parents of dependencies it no longer has")
+	}
+	if parents, _ := store.GetParents("dep2"); (len(parents) != 1 || parents[0] != "package") {
+		t.Errorf("Package should remain a parent of dependencies it keeps, got %v", parents)
+	}
+	if deps, _ := store.GetDependencies("package"); (len(deps) != 2 || deps[0] != "dep2" || deps[1] != "dep3") {
+		t.Errorf("Dependencies should be replaced, got %v", deps)
+	}
+	if info, _ := store.GetInfo("package"); (info.Revision != 2) {
+		t.Errorf("Revision should increase when updated, got %d", info.Revision)
+	}
+
+	store.UpdatePackage("package", []string{"dep2"}, map[string]DependencyKind{"dep2": KindOptional})
+	if hasParents, _ := store.HasParents("dep2"); (hasParents) {
+		t.Error("Package should not be a parent of its optional dependencies")
+	}
+	if problems, _ := store.(*MapsIndexStore).Check(); (len(problems) != 0) {
+		t.Errorf("Updated store should be consistent, got %v", problems)
+	}
+	if _, err = store.UpdatePackage("missing", nil, nil); (err == nil) {
+		t.Error("Package that is not indexed should not be updated")
+	}
+}
+
+// Tests that updating a package within a transaction is undone by rolling back.
+func TestUpdatePackageRollback(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+	store.AddPackage("package", []string{"dep1"})
+	store.Begin()
+	store.UpdatePackage("package", []string{"dep2"}, nil)
+	store.Rollback()
+	if parents, _ := store.GetParents("dep1"); (len(parents) != 1 || parents[0] != "package") {
+		t.Errorf("Parents should be restored after rollback, got %v", parents)
+	}
+	if hasParents, _ := store.HasParents("dep2"); (hasParents) {
+		t.Error("Parents added within transaction should be removed after rollback")
+	}
+}
+
+// Tests that rolling back a transaction restores every package changed within it.
+func TestRollback(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("dep1", nil)
+	store.AddPackage("package", []string{"dep1"})
+
+	if err := store.Begin(); (err != nil) {
+		t.Errorf("Error encountered beginning transaction : %s", err.Error())
+	}
+	store.AddPackage("dep2", nil)
+	store.AddPackage("other", []string{"dep1", "dep2"})
+	store.RemovePackage("package")
+	store.ForceRemovePackage("dep1")
+	if err := store.Rollback(); (err != nil) {
+		t.Errorf("Error encountered rolling back transaction : %s", err.Error())
+	}
+
+	for _, name := range []string{"dep2", "other"} {
+		if exists, _ := store.HasPackage(name); (exists) {
+			t.Errorf("Package %s indexed within transaction should not be indexed after rollback", name)
+		}
+	}
+	if exists, _ := store.HasPackage("package"); (!exists) {
+		t.Error("Package removed within transaction should be indexed after rollback")
+	}
+	parents, _ := store.GetParents("dep1")
+	if (len(parents) != 1 || parents[0] != "package") {
+		t.Errorf("Parents should be restored after rollback, got %v", parents)
+	}
+}
+
+// Tests that committing a transaction keeps its changes, and that transactions cannot be nested.
+func TestCommit(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.Begin()
+	if err := store.Begin(); (err == nil) {
+		t.Error("Beginning a transaction within another should fail")
+	}
+	store.AddPackage("dep1", nil)
+	if err := store.Commit(); (err != nil) {
+		t.Errorf("Error encountered committing transaction : %s", err.Error())
+	}
+	if exists, _ := store.HasPackage("dep1"); (!exists) {
+		t.Error("Package indexed within committed transaction should be indexed")
+	}
+	if err := store.Rollback(); (err == nil) {
+		t.Error("Rolling back outside of a transaction should fail")
+	}
+}
+
+// Tests that every version of a package is found by its name.
+func TestGetVersions(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("foo@2.0.1", nil)
+	store.AddPackage("foo@1.2.0", nil)
+	store.AddPackage("foo", nil)
+	store.AddPackage("foobar@1.0", nil)
+	store.RemovePackage("foo")
+
+	versions, err := store.GetVersions("foo")
+	if (err != nil || len(versions) != 2 || versions[0] != "foo@1.2.0" || versions[1] != "foo@2.0.1") {
+		t.Errorf("Every indexed version should be found, got %v", versions)
+	}
+	store.Begin()
+	store.RemovePackage("foo@1.2.0")
+	store.AddPackage("foo@3.0", nil)
+	store.Rollback()
+	versions, _ = store.GetVersions("foo")
+	if (len(versions) != 2 || versions[0] != "foo@1.2.0") {
+		t.Errorf("Versions should be restored after rollback, got %v", versions)
+	}
+}
+
+// Tests that optional dependencies are recorded with their kind, without making the package their parent.
+func TestAddTypedPackage(t *testing.T) {
+	logLevel := "FATAL"
+	store := NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("gcc", nil)
+	store.AddPackage("docs", nil)
+	store.AddTypedPackage("package", []string{"gcc", "docs", "missing"}, map[string]DependencyKind{
+		"gcc":     KindBuild,
+		"docs":    KindOptional,
+		"missing": KindOptional,
+	})
+
+	kinds, err := store.GetDependencyKinds("package")
+	if (err != nil || len(kinds) != 3 || kinds["gcc"] != KindBuild || kinds["docs"] != KindOptional) {
+		t.Errorf("Dependency kinds should be recorded, got %v", kinds)
+	}
+	if hasParents, _ := store.HasParents("gcc"); (!hasParents) {
+		t.Error("Build dependency should have package as a parent")
+	}
+	if hasParents, _ := store.HasParents("docs"); (hasParents) {
+		t.Error("Optional dependency should not have package as a parent")
+	}
+	store.RemovePackage("package")
+	if hasParents, _ := store.HasParents("gcc"); (hasParents) {
+		t.Error("Removed package should no longer be a parent")
+	}
+}
@@ -0,0 +1,388 @@
+// Package storetest is a conformance test suite for implementations of data.IndexStore, so that every
+// storage backend can be verified to behave exactly as our service expects.
+//
+// A backend runs the suite from its own tests, giving a function that creates a new, empty store:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func() data.IndexStore {
+//			return NewMyStore()
+//		})
+//	}
+package storetest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+)
+
+// Run runs every conformance test against stores created by newStore, each test with a new store.
+func Run(t *testing.T, newStore func() data.IndexStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store data.IndexStore)
+	}{
+		{"AddPackage", testAddPackage},
+		{"Parents", testParents},
+		{"RemovePackage", testRemovePackage},
+		{"Unindexed", testUnindexed},
+		{"DependencyKinds", testDependencyKinds},
+		{"ForceRemovePackage", testForceRemovePackage},
+		{"ForceRemoveDependentRemoved", testForceRemoveDependentRemoved},
+		{"UpdatePackage", testUpdatePackage},
+		{"ListPackages", testListPackages},
+		{"GetVersions", testGetVersions},
+		{"Info", testInfo},
+		{"Rollback", testRollback},
+		{"Commit", testCommit},
+		{"Snapshot", testSnapshot},
+		{"History", testHistory},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			store := newStore()
+			test.test(t, store)
+			checkConsistent(t, store)
+		})
+	}
+}
+
+// checkConsistent fails our test if a store that can be checked has any problems.
+func checkConsistent(t *testing.T, store data.IndexStore) {
+	checkable, ok := store.(data.Checkable)
+	if !ok {
+		return
+	}
+	problems, err := checkable.Check()
+	if (err != nil || len(problems) != 0) {
+		t.Errorf("Store should be consistent, got %v %v", problems, err)
+	}
+}
+
+// expect fails our test unless got is equal to want.
+func expect(t *testing.T, what string, got interface{}, want interface{}) {
+	if (!reflect.DeepEqual(got, want)) {
+		t.Errorf("%s should be %v, got %v", what, want, got)
+	}
+}
+
+// mustAdd adds a Package, failing our test if it is not added.
+func mustAdd(t *testing.T, store data.IndexStore, name string, deps ...string) {
+	if added, err := store.AddPackage(name, deps); (err != nil || !added) {
+		t.Fatalf("Package %s should be added, got %v %v", name, added, err)
+	}
+}
+
+func testAddPackage(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "base")
+	mustAdd(t, store, "lib", "base")
+	exists, err := store.HasPackage("lib")
+	expect(t, "HasPackage of added package", exists, true)
+	expect(t, "HasPackage error", err, nil)
+	exists, _ = store.HasPackage("missing")
+	expect(t, "HasPackage of package never added", exists, false)
+	deps, _ := store.GetDependencies("lib")
+	expect(t, "Dependencies", deps, []string{"base"})
+	deps, _ = store.GetDependencies("base")
+	expect(t, "Dependencies of package without any", deps, []string{})
+}
+
+func testParents(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "base")
+	hasParents, _ := store.HasParents("base")
+	expect(t, "HasParents before anything depends on package", hasParents, false)
+	mustAdd(t, store, "zlib", "base")
+	mustAdd(t, store, "app", "base", "zlib")
+	hasParents, _ = store.HasParents("base")
+	expect(t, "HasParents once others depend on package", hasParents, true)
+	parents, _ := store.GetParents("base")
+	expect(t, "Parents, sorted", parents, []string{"app", "zlib"})
+	deps, _ := store.GetDependencies("app")
+	expect(t, "Dependencies, sorted", deps, []string{"base", "zlib"})
+}
+
+func testRemovePackage(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "base")
+	mustAdd(t, store, "app", "base")
+	removed, err := store.RemovePackage("app")
+	if (err != nil || !removed) {
+		t.Errorf("Package should be removed, got %v %v", removed, err)
+	}
+	exists, _ := store.HasPackage("app")
+	expect(t, "HasPackage of removed package", exists, false)
+	hasParents, _ := store.HasParents("base")
+	expect(t, "HasParents once dependent is removed", hasParents, false)
+	removed, err = store.RemovePackage("missing")
+	if (err != nil || !removed) {
+		t.Errorf("Removing package that is not indexed should succeed, got %v %v", removed, err)
+	}
+}
+
+func testUnindexed(t *testing.T, store data.IndexStore) {
+	if _, err := store.HasParents("missing"); (err == nil) {
+		t.Error("HasParents of package that is not indexed should fail")
+	}
+	if _, err := store.GetDependencies("missing"); (err == nil) {
+		t.Error("GetDependencies of package that is not indexed should fail")
+	}
+	if _, err := store.GetDependencyKinds("missing"); (err == nil) {
+		t.Error("GetDependencyKinds of package that is not indexed should fail")
+	}
+	if _, err := store.GetParents("missing"); (err == nil) {
+		t.Error("GetParents of package that is not indexed should fail")
+	}
+	if _, err := store.GetInfo("missing"); (err == nil) {
+		t.Error("GetInfo of package that is not indexed should fail")
+	}
+	if err := store.SetInfo("missing", data.PackageInfo{}); (err == nil) {
+		t.Error("SetInfo of package that is not indexed should fail")
+	}
+	if err := store.MarkQueried("missing"); (err == nil) {
+		t.Error("MarkQueried of package that is not indexed should fail")
+	}
+	if _, err := store.UpdatePackage("missing", nil, nil); (err == nil) {
+		t.Error("UpdatePackage of package that is not indexed should fail")
+	}
+	dependents, err := store.ForceRemovePackage("missing")
+	if (err != nil || len(dependents) != 0) {
+		t.Errorf("ForceRemovePackage of package that is not indexed should do nothing, got %v %v", dependents, err)
+	}
+}
+
+func testDependencyKinds(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "base")
+	mustAdd(t, store, "gcc")
+	added, err := store.AddTypedPackage("app", []string{"base", "gcc", "docs"},
+		map[string]data.DependencyKind{"gcc": data.KindBuild, "docs": data.KindOptional})
+	if (err != nil || !added) {
+		t.Fatalf("Typed package should be added, got %v %v", added, err)
+	}
+	kinds, _ := store.GetDependencyKinds("app")
+	expect(t, "Dependency kinds", kinds, map[string]data.DependencyKind{
+		"base": data.KindRuntime,
+		"gcc":  data.KindBuild,
+		"docs": data.KindOptional,
+	})
+	hasParents, _ := store.HasParents("gcc")
+	expect(t, "HasParents of build dependency", hasParents, true)
+	mustAdd(t, store, "docs")
+	hasParents, _ = store.HasParents("docs")
+	expect(t, "HasParents of optional dependency", hasParents, false)
+}
+
+func testForceRemovePackage(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "base")
+	mustAdd(t, store, "lib", "base")
+	mustAdd(t, store, "app", "base")
+	dependents, err := store.ForceRemovePackage("base")
+	expect(t, "Dependents of forcibly removed package", dependents, []string{"app", "lib"})
+	expect(t, "ForceRemovePackage error", err, nil)
+	exists, _ := store.HasPackage("base")
+	expect(t, "HasPackage of forcibly removed package", exists, false)
+	deps, _ := store.GetDependencies("lib")
+	expect(t, "Dependencies of broken package", deps, []string{"base"})
+	mustAdd(t, store, "base")
+	parents, _ := store.GetParents("base")
+	expect(t, "Parents of forcibly removed package once indexed again", parents, []string{"app", "lib"})
+}
+
+func testForceRemoveDependentRemoved(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "base")
+	mustAdd(t, store, "lib", "base")
+	store.ForceRemovePackage("base")
+	store.RemovePackage("lib")
+	mustAdd(t, store, "base")
+	hasParents, _ := store.HasParents("base")
+	expect(t, "HasParents once broken dependent is removed", hasParents, false)
+}
+
+func testUpdatePackage(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "dep1")
+	mustAdd(t, store, "dep2")
+	mustAdd(t, store, "dep3")
+	mustAdd(t, store, "lib", "dep1", "dep2")
+	mustAdd(t, store, "app", "lib")
+	before, _ := store.GetInfo("lib")
+
+	updated, err := store.UpdatePackage("lib", []string{"dep2", "dep3"}, nil)
+	if (err != nil || !updated) {
+		t.Fatalf("Package should be updated, got %v %v", updated, err)
+	}
+	parents, _ := store.GetParents("lib")
+	expect(t, "Parents of updated package", parents, []string{"app"})
+	deps, _ := store.GetDependencies("lib")
+	expect(t, "Dependencies of updated package", deps, []string{"dep2", "dep3"})
+	hasParents, _ := store.HasParents("dep1")
+	expect(t, "HasParents of dropped dependency", hasParents, false)
+	parents, _ = store.GetParents("dep2")
+	expect(t, "Parents of kept dependency", parents, []string{"lib"})
+	parents, _ = store.GetParents("dep3")
+	expect(t, "Parents of added dependency", parents, []string{"lib"})
+	after, _ := store.GetInfo("lib")
+	expect(t, "Revision of updated package", after.Revision, before.Revision + 1)
+	expect(t, "Indexed time of updated package", after.Indexed.Unix(), before.Indexed.Unix())
+
+	store.UpdatePackage("lib", []string{"dep2"}, map[string]data.DependencyKind{"dep2": data.KindOptional})
+	hasParents, _ = store.HasParents("dep2")
+	expect(t, "HasParents of dependency made optional", hasParents, false)
+	hasParents, _ = store.HasParents("dep3")
+	expect(t, "HasParents of dropped dependency", hasParents, false)
+}
+
+func testListPackages(t *testing.T, store data.IndexStore) {
+	names, _ := store.ListPackages()
+	expect(t, "Packages of empty store", names, []string{})
+	mustAdd(t, store, "zlib")
+	mustAdd(t, store, "base")
+	mustAdd(t, store, "app", "base")
+	names, _ = store.ListPackages()
+	expect(t, "Packages, sorted", names, []string{"app", "base", "zlib"})
+}
+
+func testGetVersions(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "foo@2.0.0")
+	mustAdd(t, store, "foo")
+	mustAdd(t, store, "foo@1.0.0")
+	mustAdd(t, store, "foobar@1.0.0")
+	versions, _ := store.GetVersions("foo")
+	expect(t, "Versions", versions, []string{"foo", "foo@1.0.0", "foo@2.0.0"})
+	store.RemovePackage("foo@2.0.0")
+	versions, _ = store.GetVersions("foo")
+	expect(t, "Versions once one is removed", versions, []string{"foo", "foo@1.0.0"})
+	versions, _ = store.GetVersions("missing")
+	expect(t, "Versions of package never added", versions, []string{})
+}
+
+func testInfo(t *testing.T, store data.IndexStore) {
+	start := time.Now().Add(-time.Second)
+	mustAdd(t, store, "lib")
+	info, err := store.GetInfo("lib")
+	if (err != nil) {
+		t.Fatalf("Info should be returned, got %v", err)
+	}
+	expect(t, "Revision of new package", info.Revision, 1)
+	expect(t, "Queried time of new package", info.Queried.IsZero(), true)
+	if (info.Indexed.Before(start) || info.Updated.Unix() != info.Indexed.Unix()) {
+		t.Errorf("Indexed and updated times should be when package was added, got %v", info)
+	}
+
+	info.Revision = 7
+	info.Client = "bot"
+	info.Required = true
+	if err = store.SetInfo("lib", *info); (err != nil) {
+		t.Errorf("Info should be set, got %v", err)
+	}
+	stored, _ := store.GetInfo("lib")
+	expect(t, "Revision once set", stored.Revision, 7)
+	expect(t, "Client once set", stored.Client, "bot")
+	expect(t, "Required once set", stored.Required, true)
+
+	if err = store.MarkQueried("lib"); (err != nil) {
+		t.Errorf("Package should be marked queried, got %v", err)
+	}
+	stored, _ = store.GetInfo("lib")
+	if (stored.Queried.Before(start)) {
+		t.Errorf("Queried time should be when package was queried, got %v", stored.Queried)
+	}
+}
+
+func testRollback(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "dep1")
+	mustAdd(t, store, "dep2")
+	mustAdd(t, store, "lib", "dep1")
+	mustAdd(t, store, "app", "lib")
+	if err := store.Begin(); (err != nil) {
+		t.Fatalf("Transaction should begin, got %v", err)
+	}
+	if err := store.Begin(); (err == nil) {
+		t.Error("Transaction should not begin within another")
+	}
+	mustAdd(t, store, "new", "dep2")
+	store.UpdatePackage("lib", []string{"dep2"}, nil)
+	store.RemovePackage("app")
+	store.ForceRemovePackage("dep1")
+	if err := store.Rollback(); (err != nil) {
+		t.Fatalf("Transaction should roll back, got %v", err)
+	}
+
+	names, _ := store.ListPackages()
+	expect(t, "Packages after rollback", names, []string{"app", "dep1", "dep2", "lib"})
+	parents, _ := store.GetParents("dep1")
+	expect(t, "Parents after rollback", parents, []string{"lib"})
+	parents, _ = store.GetParents("lib")
+	expect(t, "Parents of updated package after rollback", parents, []string{"app"})
+	hasParents, _ := store.HasParents("dep2")
+	expect(t, "HasParents of dependency added within transaction", hasParents, false)
+	versions, _ := store.GetVersions("new")
+	expect(t, "Versions of package added within transaction", versions, []string{})
+	if err := store.Rollback(); (err == nil) {
+		t.Error("Rollback outside of a transaction should fail")
+	}
+}
+
+func testCommit(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "base")
+	if err := store.Commit(); (err == nil) {
+		t.Error("Commit outside of a transaction should fail")
+	}
+	store.Begin()
+	mustAdd(t, store, "lib", "base")
+	if err := store.Commit(); (err != nil) {
+		t.Fatalf("Transaction should commit, got %v", err)
+	}
+	if err := store.Rollback(); (err == nil) {
+		t.Error("Rollback after commit should fail")
+	}
+	parents, _ := store.GetParents("base")
+	expect(t, "Parents after commit", parents, []string{"lib"})
+}
+
+// testSnapshot checks that every store can be captured as it is now, which DIFF compares, whether it is a
+// Snapshotter or must be copied, and that later changes do not affect what was captured.
+func testSnapshot(t *testing.T, store data.IndexStore) {
+	mustAdd(t, store, "base")
+	mustAdd(t, store, "lib", "base")
+	var snapshot data.IndexStore
+	var err error
+	if snapshotter, ok := store.(data.Snapshotter); ok {
+		snapshot, err = snapshotter.Snapshot()
+	} else {
+		snapshot, err = data.CopySnapshot(store)
+	}
+	if (err != nil) {
+		t.Fatalf("Store should be captured, got %v", err)
+	}
+	diff, err := data.Diff(snapshot, store)
+	if (err != nil || !diff.Empty()) {
+		t.Errorf("Captured store should not differ from the store, got %v %v", diff, err)
+	}
+	store.RemovePackage("lib")
+	parents, _ := snapshot.GetParents("base")
+	expect(t, "Parents once captured", parents, []string{"lib"})
+	if _, err = snapshot.AddPackage("app", nil); (err == nil) {
+		t.Error("Captured store should not change")
+	}
+}
+
+// testHistory checks that a store keeping history knows each Package as it was.  A store keeping none never
+// reaches back to any moment, so QUERYAT, DEPSAT and DIFF at a time answer FAIL|NO_HISTORY for it.
+func testHistory(t *testing.T, store data.IndexStore) {
+	historical, ok := store.(data.HistoricalStore)
+	if !ok {
+		t.Skip("Store keeps no history")
+	}
+	mustAdd(t, store, "base")
+	mustAdd(t, store, "lib", "base")
+	store.UpdatePackage("lib", []string{}, nil)
+	lib, known, err := historical.PackageAt("lib", data.Moment{Revision: 1})
+	if (err != nil || !known || lib == nil) {
+		t.Fatalf("First revision should be known, got %v %v", known, err)
+	}
+	expect(t, "Dependencies at first revision", lib.Dependencies, map[string]bool{"base": true})
+	lib, known, _ = historical.PackageAt("missing", data.Moment{Time: time.Now()})
+	if (!known || lib != nil) {
+		t.Error("Package never indexed should be known not to have been indexed")
+	}
+}
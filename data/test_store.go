@@ -17,6 +17,14 @@ func (t *TestStore) AddPackage(name string, deps []string) (added bool, err erro
 	return t.canAdd, t.errAdd
 }
 
+func (t *TestStore) AddTypedPackage(name string, deps []string, kinds map[string]DependencyKind) (added bool, err error) {
+	return t.canAdd, t.errAdd
+}
+
+func (t *TestStore) UpdatePackage(name string, deps []string, kinds map[string]DependencyKind) (updated bool, err error) {
+	return t.canAdd, t.errAdd
+}
+
 func (t *TestStore) RemovePackage(name string) (removed bool, err error) {
 	return t.canRemove, t.errRemove
 }
@@ -29,6 +37,54 @@ func (t *TestStore) HasParents(name string) (hasParents bool, err error) {
 	return t.canParents, t.errParents
 }
 
+func (t *TestStore) GetDependencies(name string) (deps []string, err error) {
+	return []string{}, t.errHas
+}
+
+func (t *TestStore) GetDependencyKinds(name string) (kinds map[string]DependencyKind, err error) {
+	return map[string]DependencyKind{}, t.errHas
+}
+
+func (t *TestStore) ForceRemovePackage(name string) (dependents []string, err error) {
+	return []string{}, t.errRemove
+}
+
+func (t *TestStore) GetParents(name string) (parents []string, err error) {
+	return []string{}, t.errParents
+}
+
+func (t *TestStore) ListPackages() (names []string, err error) {
+	return []string{}, t.errHas
+}
+
+func (t *TestStore) GetVersions(name string) (versions []string, err error) {
+	return []string{}, t.errHas
+}
+
+func (t *TestStore) GetInfo(name string) (info *PackageInfo, err error) {
+	return &PackageInfo{}, t.errHas
+}
+
+func (t *TestStore) MarkQueried(name string) (err error) {
+	return t.errHas
+}
+
+func (t *TestStore) SetInfo(name string, info PackageInfo) (err error) {
+	return t.errAdd
+}
+
+func (t *TestStore) Begin() (err error) {
+	return nil
+}
+
+func (t *TestStore) Commit() (err error) {
+	return nil
+}
+
+func (t *TestStore) Rollback() (err error) {
+	return nil
+}
+
 // Creates a new IndexStore to be used for testing.
 func NewTestStore(canAdd bool, errAdd error, canRemove bool, errRemove error, canHas bool, errHas error, canParents bool, errParents error) (IndexStore) {
 	return &TestStore{
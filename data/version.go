@@ -0,0 +1,29 @@
+package data
+
+import (
+	"strings"
+)
+
+// VersionSeparator separates the name of a Package from its version, as in foo@1.2.0.
+// Packages indexed without a version are identified by their name alone.
+const VersionSeparator = "@"
+
+// AnyVersion may be given in place of a version to refer to every version of a Package, as in foo@*.
+const AnyVersion = "*"
+
+// SplitVersion splits a Package identifier into its name and version, which is empty if the
+// Package is not versioned.
+func SplitVersion(id string) (name string, version string) {
+	if i := strings.Index(id, VersionSeparator); i != -1 {
+		return id[:i], id[i+len(VersionSeparator):]
+	}
+	return id, ""
+}
+
+// JoinVersion identifies a version of a Package.
+func JoinVersion(name string, version string) string {
+	if len(version) == 0 {
+		return name
+	}
+	return name + VersionSeparator + version
+}
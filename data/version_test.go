@@ -0,0 +1,27 @@
+package data
+
+import (
+	"testing"
+)
+
+// Tests splitting package identifiers into their name and version.
+func TestSplitVersion(t *testing.T) {
+	cases := []struct {
+		id      string
+		name    string
+		version string
+	}{
+		{"foo@1.2.0", "foo", "1.2.0"},
+		{"foo", "foo", ""},
+		{"foo@*", "foo", AnyVersion},
+	}
+	for _, c := range cases {
+		name, version := SplitVersion(c.id)
+		if (name != c.name || version != c.version) {
+			t.Errorf("Incorrect split of %s : %s %s", c.id, name, version)
+		}
+		if (c.version != AnyVersion && JoinVersion(name, version) != c.id) {
+			t.Errorf("Incorrect join of %s", c.id)
+		}
+	}
+}
@@ -3,13 +3,33 @@ package err
 import (
 	"time"
 	"fmt"
+	"strings"
+)
+
+// Codes classifying errors, reported to clients alongside ERROR in extended response mode.
+const (
+	// message does not have the required number of arguments.
+	CodeInvalidFormat = "INVALID_FORMAT"
+	// message request type is not supported.
+	CodeUnknownVerb = "UNKNOWN_VERB"
+	// message package name is missing or incorrect.
+	CodeInvalidPackage = "INVALID_PACKAGE"
+	// message dependencies are incorrectly formatted.
+	CodeInvalidDependencies = "INVALID_DEPENDENCIES"
+	// message arguments are well formatted, but not understood by its request type.
+	CodeInvalidArgument = "INVALID_ARGUMENT"
+	// request type requires the connection to authenticate first.
+	CodeUnauthorized = "UNAUTHORIZED"
+	// anything else that went wrong while processing a message.
+	CodeInternal = "INTERNAL"
 )
 
 // IndexError is a custom Error type used throughout application,
-// which includes a timestamp on all error messages.
+// which includes a timestamp on all error messages, and optionally a code classifying the error.
 type IndexError struct {
 	msg string
 	time time.Time
+	code string
 }
 
 func (e *IndexError) Error() string {
@@ -21,5 +41,27 @@ func NewIndexError(text string) error {
 	return &IndexError{
 		text,
 		time.Now(),
+		CodeInternal,
+	}
+}
+
+// NewCodedIndexError creates a new IndexError including the current timestamp and a code classifying it.
+func NewCodedIndexError(code string, text string) error {
+	return &IndexError{
+		text,
+		time.Now(),
+		code,
+	}
+}
+
+// Describe formats an error for clients as its code followed by its message, separated by '|'.
+// Errors other than IndexErrors are classified as internal.  Line breaks and '|' are removed from
+// the message so that it cannot be mistaken for further fields or responses.
+func Describe(e error) string {
+	code, msg := CodeInternal, e.Error()
+	if indexError, ok := e.(*IndexError); ok {
+		code, msg = indexError.code, indexError.msg
 	}
+	msg = strings.Replace(strings.Replace(msg, "\n", "", -1), "|", " ", -1)
+	return code + "|" + strings.TrimSpace(msg)
 }
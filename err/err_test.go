@@ -12,3 +12,15 @@ func TestErrCreation(t *testing.T) {
 		t.Error("Index Error should include error text")
 	}
 }
+
+// Tests that errors are described by their code and message.
+func TestErrDescribe(t *testing.T) {
+	err := NewCodedIndexError(CodeInvalidFormat, "Input does not have 3 arguments : QUERY|lib\n")
+	if (Describe(err) != "INVALID_FORMAT|Input does not have 3 arguments : QUERY lib") {
+		t.Errorf("Incorrect error description : %s", Describe(err))
+	}
+	err = NewIndexError("error test")
+	if (Describe(err) != "INTERNAL|error test") {
+		t.Errorf("Errors without code should be described as internal : %s", Describe(err))
+	}
+}
@@ -0,0 +1,69 @@
+package input
+
+import (
+	"fmt"
+	"strconv"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/operation"
+)
+
+// MaxBatchSize is the most messages a connection may queue in a single batch.
+const MaxBatchSize = 10000
+
+// begin starts a named batch on a connection.  Until the batch is committed or aborted, INDEX and
+// REMOVE messages are queued rather than processed, and are then applied together all or nothing.
+// Messages queued are not answered, so that a client can send a whole batch without waiting for
+// any response other than that to COMMIT.
+func (s *SimpleMessageGateway) begin(session *session, name string) (result string) {
+	session.batchName = name
+	session.batch = make([]*InputMessage, 0)
+	session.batchFailure = ""
+	return "ok"
+}
+
+// failBatch records why a message sent within our batch cannot be queued, unless an earlier message could not
+// be, so that COMMIT fails the whole batch with the position and reason of the first, applying none of it.
+// The message is not answered, as no message within a batch is, so that clients pipelining a batch can still
+// tell which response answers which message.
+func (s *SimpleMessageGateway) failBatch(session *session, failure error) (resp []byte) {
+	s.logger.Debug(failure.Error())
+	if len(session.batchFailure) == 0 {
+		session.batchFailure = strconv.Itoa(len(session.batch) + 1) + "|" + err.Describe(failure)
+	}
+	return nil
+}
+
+// batchMessage handles a message sent while a connection has a batch in progress, returning no response
+// for messages queued, or that cannot be.  COMMIT and ABORT must name the batch in progress, and COMMIT passes
+// the whole batch on to be applied, unless one of its messages could not be queued.
+func (s *SimpleMessageGateway) batchMessage(session *session, validated *InputMessage, c chan<- *ValidatedMessage) (resp []byte) {
+	switch validated.Verb {
+	case "INDEX", "REMOVE":
+		if len(session.batchFailure) > 0 {
+			// the batch will fail regardless, so the rest of it need not be kept.
+			return nil
+		}
+		if len(session.batch) >= MaxBatchSize {
+			return s.failBatch(session, err.NewCodedIndexError(err.CodeInvalidArgument, "Batch is limited to " + strconv.Itoa(MaxBatchSize) + " messages"))
+		}
+		session.batch = append(session.batch, validated)
+		return nil
+	case "COMMIT", "ABORT":
+		if validated.Package != session.batchName {
+			return s.formatSessionResponse(session, validated.Verb, "error|" + err.CodeInvalidArgument + "|Batch in progress is " + session.batchName)
+		}
+		batch, failure := session.batch, session.batchFailure
+		session.batchName = ""
+		session.batch = nil
+		session.batchFailure = ""
+		if validated.Verb == "ABORT" {
+			s.logger.Debug(fmt.Sprintf("Aborted batch of %d messages", len(batch)))
+			return s.formatResponse("ok")
+		}
+		if len(failure) > 0 {
+			return s.formatSessionResponse(session, validated.Verb, "fail|" + operation.ReasonBatchFailed + "|" + failure)
+		}
+		return s.forwardMessage(session, validated, batch, c)
+	}
+	return s.failBatch(session, err.NewCodedIndexError(err.CodeInvalidArgument, "Request type cannot be batched : " + validated.Verb))
+}
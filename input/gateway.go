@@ -2,7 +2,11 @@ package input
 
 import (
 	"bufio"
+	"fmt"
 	"net"
+	"strings"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
 	"github.com/kristenfelch/pkgindexer/logging"
 )
 
@@ -18,18 +22,25 @@ type MessageGateway interface {
 	Close() (closed bool, err error)
 }
 
-// SimpleMessageGateway is a MessageGateway that has an optional rate limit.
+// SimpleMessageGateway is a MessageGateway that has an optional rate limit, and an optional
+// token that clients must present with AUTH before using privileged operations.
 type SimpleMessageGateway struct {
 	validator Validator
 	rate *int
+	adminToken string
 	logger logging.Logger
 }
 
 // ValidatedMessage contains an input message as well as a channel created to receive the
-// result of processing this message.
+// result of processing this message.  A COMMIT message also carries the batch of messages to apply.
+// Client identifies the client that sent the message, and Kinds restricts the kinds of dependency
+// that graph queries follow.
 type ValidatedMessage struct {
 	*InputMessage
 	ResponseChannel chan<- string
+	Batch []*InputMessage
+	Client string
+	Kinds data.KindFilter
 }
 
 // Open starts listening on a Port and accepting connections.
@@ -51,48 +62,144 @@ func (s *SimpleMessageGateway) Open(c chan<- *ValidatedMessage) (opened bool, er
 // handleConnection reads messages through the TCP connection, rate limiting if desired.
 func (s *SimpleMessageGateway) handleConnection(conn net.Conn, c chan<- *ValidatedMessage) {
 	throttler := NewThrottler(s.rate)
+	session := &session{}
+	if addr := conn.RemoteAddr(); addr != nil {
+		session.client = addr.String()
+	}
+	// a single reader for the connection keeps every message buffered beyond the one read, so that clients
+	// can pipeline several messages in a single write.
+	reader := bufio.NewReader(conn)
 	for {
 		throttler.Next()
-		message, msgError := bufio.NewReader(conn).ReadString('\n')
+		message, msgError := reader.ReadString('\n')
 		if (msgError != nil) {
 			s.logger.Debug("No more messages available from connection")
 			conn.Close()
 			throttler.Stop()
 			break;
 		}
-		s.handleMessage(conn, message, c)
+		s.handleMessage(conn, session, message, c)
 	}
 }
 
 // handleMessage validates our input message.  If it is valid, it is returned to the ValidatedMessage
 // channel with it's own length-1 channel to contain the final result of processing the message.
-func (s *SimpleMessageGateway) handleMessage(conn net.Conn, message string, c chan<- *ValidatedMessage) {
-	validated, validatedError := s.validator.ValidateInput(message)
+// Connections that have negotiated request IDs prefix each message with an ID, which is echoed
+// back at the start of its response.  Messages queued in a batch are not answered.
+func (s *SimpleMessageGateway) handleMessage(conn net.Conn, session *session, message string, c chan<- *ValidatedMessage) {
+	id := ""
+	if session.ids {
+		id, message = splitRequestId(message)
+	}
+	response := s.processMessage(session, message, c)
+	if len(response) == 0 {
+		return
+	}
+	if len(id) > 0 {
+		response = append([]byte(id + "|"), response...)
+	}
+	conn.Write(response)
+}
+
+// processMessage returns the response to a single message, if any.  The handshake, authentication and
+// response mode are handled by the gateway itself, as they only concern the connection.
+func (s *SimpleMessageGateway) processMessage(session *session, message string, c chan<- *ValidatedMessage) (resp []byte) {
+	// only connections that have negotiated version 2 may version their packages or constrain their dependencies.
+	validate := s.validator.ValidateLegacyInput
+	if session.version >= 2 {
+		validate = s.validator.ValidateInput
+	}
+	validated, validatedError := validate(message)
+	if validatedError == nil && !session.supports(validated.Verb) {
+		validatedError = err.NewCodedIndexError(err.CodeUnknownVerb, fmt.Sprintf("Input method is not supported : %s", validated.Verb))
+	}
+	if validatedError == nil && len(validated.Namespace) > 0 && session.version < 2 {
+		validatedError = err.NewCodedIndexError(err.CodeUnknownVerb, fmt.Sprintf("Input method is not supported : %s@%s", validated.Verb, validated.Namespace))
+	}
+	if validatedError != nil && len(session.batchName) > 0 {
+		return s.failBatch(session, validatedError)
+	}
 	if validatedError != nil {
 		s.logger.Debug(validatedError.Error())
-		conn.Write(s.formatResponse("error"))
-	} else {
-		ch := make(chan string, 1)
-		validMessage := &ValidatedMessage{
-			validated,
-			ch,
-		}
-		c <- validMessage
-		returned := <-ch
-		close(ch)
-		conn.Write(s.formatResponse(returned))
+		return s.formatSessionResponse(session, "", "error|" + err.Describe(validatedError))
+	}
+
+	if len(session.batchName) > 0 {
+		return s.batchMessage(session, validated, c)
 	}
+
+	switch validated.Verb {
+	case "HELLO":
+		return s.formatResponse(s.hello(session, validated.Package, validated.Dependencies))
+	case "AUTH":
+		return s.formatResponse(s.authenticate(session, validated.Package))
+	case "MODE":
+		return s.formatResponse(s.setMode(session, validated.Package))
+	case "FILTER":
+		return s.formatSessionResponse(session, validated.Verb, s.setFilter(session, validated.Package, validated.Dependencies))
+	case "CLIENT":
+		session.client = validated.Package
+		return s.formatResponse("ok")
+	case "BEGIN":
+		return s.formatResponse(s.begin(session, validated.Package))
+	case "COMMIT", "ABORT":
+		return s.formatSessionResponse(session, validated.Verb, "error|" + err.CodeInvalidArgument + "|No batch has begun")
+	}
+	if requiresAdmin(validated) && !session.admin {
+		s.logger.Debug(fmt.Sprintf("Unauthenticated connection attempted %s", validated.Verb))
+		return s.formatSessionResponse(session, validated.Verb, "error|" + err.CodeUnauthorized)
+	}
+	if validated.Verb == "USE" {
+		return s.use(session, validated, c)
+	}
+	return s.forwardMessage(session, validated, nil, c)
+}
+
+// use switches the namespace a connection uses, once our service has confirmed that it exists.
+func (s *SimpleMessageGateway) use(session *session, validated *InputMessage, c chan<- *ValidatedMessage) (resp []byte) {
+	resp = s.forwardMessage(session, validated, nil, c)
+	if strings.HasPrefix(string(resp), "OK") {
+		session.namespace = validated.Package
+	}
+	return resp
+}
+
+// forwardMessage passes a message back through the ValidatedMessage channel for processing,
+// and formats the result.  Messages that do not name a namespace apply to the one their connection uses.
+func (s *SimpleMessageGateway) forwardMessage(session *session, validated *InputMessage, batch []*InputMessage, c chan<- *ValidatedMessage) (resp []byte) {
+	if len(validated.Namespace) == 0 {
+		validated.Namespace = session.namespace
+	}
+	ch := make(chan string, 1)
+	validMessage := &ValidatedMessage{
+		validated,
+		ch,
+		batch,
+		session.client,
+		session.kinds,
+	}
+	c <- validMessage
+	returned := <-ch
+	close(ch)
+	return s.formatSessionResponse(session, validated.Verb, returned)
 }
 
-// formatResponse formats our generic 'ok', 'fail', and 'error' into format that clients receive.
+// formatResponse formats our generic 'ok', 'fail', 'conflict' and 'error' into format that clients receive.
+// Any payload following the first '|', such as the chain returned for WHY, is passed through as is.
 func (s *SimpleMessageGateway) formatResponse(str string) (resp []byte) {
-	switch str {
+	status, payload := str, ""
+	if i := strings.Index(str, "|"); i != -1 {
+		status, payload = str[:i], str[i:]
+	}
+	switch status {
 	case "ok":
-		return []byte("OK\n")
+		return []byte("OK" + payload + "\n")
 	case "fail":
-		return []byte("FAIL\n")
+		return []byte("FAIL" + payload + "\n")
+	case "conflict":
+		return []byte("CONFLICT" + payload + "\n")
 	case "error":
-		return []byte("ERROR\n")
+		return []byte("ERROR" + payload + "\n")
 	}
 	return []byte("ERROR\n")
 }
@@ -101,11 +208,13 @@ func (s *SimpleMessageGateway) Close() (closed bool, err error) {
 	return true, nil
 }
 
-// NewMessageGateway create an instance of MessageGateway including validator and throttler.
-func NewMessageGateway(throttle *int, logger logging.Logger) MessageGateway {
+// NewMessageGateway create an instance of MessageGateway including validator and throttler,
+// and the token required to authenticate for privileged operations.
+func NewMessageGateway(throttle *int, adminToken string, logger logging.Logger) MessageGateway {
 	return &SimpleMessageGateway{
 		NewValidator(),
 		throttle,
+		adminToken,
 		logger,
 	}
 }
@@ -2,7 +2,11 @@ package input
 
 import (
 	"testing"
+	"bufio"
 	"bytes"
+	"net"
+	"strconv"
+	"time"
 	"github.com/kristenfelch/pkgindexer/logging"
 )
 
@@ -16,7 +20,7 @@ type TestingGateway struct {
 
 func (s *TestingGateway) Open(c chan<- *ValidatedMessage) (opened bool, err error) {
 	conn := NewTestConnection()
-	s.handleMessage(conn, "QUERY|lib|dep\n", c)
+	s.handleMessage(conn, &session{}, "QUERY|lib|dep\n", c)
 	return true, nil
 }
 
@@ -27,6 +31,7 @@ func NewTestingGateway() MessageGateway {
 		SimpleMessageGateway{
 			NewValidator(),
 			&throttle,
+			"secret",
 			logging.NewIndexLogger(&logLevel),
 		},
 	}
@@ -52,6 +57,7 @@ func TestGatewayFormatResponse(t *testing.T) {
 	gateway := &SimpleMessageGateway{
 		NewValidator(),
 		&throttle,
+		"",
 		logging.NewIndexLogger(&logLevel),
 	}
 	formatted := gateway.formatResponse("ok")
@@ -66,8 +72,362 @@ func TestGatewayFormatResponse(t *testing.T) {
 	if (!bytes.Equal(formatted, []byte("FAIL\n"))) {
 		t.Error("Incorrect FAIL response formatting")
 	}
+	formatted = gateway.formatResponse("ok|lib,dep")
+	if (!bytes.Equal(formatted, []byte("OK|lib,dep\n"))) {
+		t.Error("Incorrect OK response formatting with payload")
+	}
+	formatted = gateway.formatResponse("garbage|lib")
+	if (!bytes.Equal(formatted, []byte("ERROR\n"))) {
+		t.Error("Incorrect ERROR response formatting with payload")
+	}
 	formatted = gateway.formatResponse("garbage")
 	if (!bytes.Equal(formatted, []byte("ERROR\n"))) {
 		t.Error("Incorrect ERROR response formatting")
 	}
 }
+
+// Tests that privileged operations are rejected until the connection has authenticated,
+// and that authentication requires the configured token.
+func TestGatewayPrivilegedOperations(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	conn := &TestConnection{}
+	session := &session{version: 2}
+	msgChannel := make(chan *ValidatedMessage, 1)
+
+	gateway.handleMessage(conn, session, "FORCE|lib|\n", msgChannel)
+	if (conn.Written.String() != "ERROR\n" || len(msgChannel) != 0) {
+		t.Error("Privileged operation should be rejected before authentication")
+	}
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "AUTH|wrong|\n", msgChannel)
+	if (conn.Written.String() != "FAIL\n" || session.admin) {
+		t.Error("Authentication should fail with incorrect token")
+	}
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "AUTH|secret|\n", msgChannel)
+	if (conn.Written.String() != "OK\n" || !session.admin) {
+		t.Error("Authentication should succeed with correct token")
+	}
+
+	go func() {
+		val := <-msgChannel
+		val.ResponseChannel <- "ok"
+	}()
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "FORCE|lib|\n", msgChannel)
+	if (conn.Written.String() != "OK\n") {
+		t.Error("Privileged operation should be processed once authenticated")
+	}
+}
+
+// Tests that authentication always fails when no admin token is configured.
+func TestGatewayAuthenticationDisabled(t *testing.T) {
+	throttle := 0
+	logLevel := "FATAL"
+	gateway := &SimpleMessageGateway{
+		NewValidator(),
+		&throttle,
+		"",
+		logging.NewIndexLogger(&logLevel),
+	}
+	session := &session{}
+	if (gateway.authenticate(session, "") != "fail" || session.admin) {
+		t.Error("Authentication should fail when no admin token is configured")
+	}
+}
+
+// Tests that reasons are only included in responses to connections in extended mode,
+// and that original request types always receive plain responses otherwise.
+func TestGatewayFormatSessionResponse(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	plain := &session{}
+	extended := &session{extended: true}
+
+	cases := []struct {
+		session  *session
+		verb     string
+		returned string
+		expected string
+	}{
+		{plain, "INDEX", "fail|MISSING_DEPENDENCIES|dep", "FAIL\n"},
+		{extended, "INDEX", "fail|MISSING_DEPENDENCIES|dep", "FAIL|MISSING_DEPENDENCIES|dep\n"},
+		{plain, "QUERY", "ok|BROKEN|dep", "OK\n"},
+		{extended, "QUERY", "ok|BROKEN|dep", "OK|BROKEN|dep\n"},
+		{plain, "", "error|INVALID_FORMAT|detail", "ERROR\n"},
+		{extended, "", "error|INVALID_FORMAT|detail", "ERROR|INVALID_FORMAT|detail\n"},
+		{plain, "WHY", "ok|lib,dep", "OK|lib,dep\n"},
+		{plain, "WHY", "fail|NOT_A_DEPENDENCY", "FAIL\n"},
+		{plain, "DRYINDEX", "fail|MISSING_DEPENDENCIES|dep", "FAIL|MISSING_DEPENDENCIES|dep\n"},
+		{plain, "CASINDEX", "conflict|dep", "CONFLICT|dep\n"},
+		{plain, "CASINDEX", "conflict|", "CONFLICT|\n"},
+	}
+	for _, c := range cases {
+		formatted := gateway.formatSessionResponse(c.session, c.verb, c.returned)
+		if (!bytes.Equal(formatted, []byte(c.expected))) {
+			t.Errorf("Incorrect response formatting of %s for %s : %s", c.returned, c.verb, formatted)
+		}
+	}
+}
+
+// Tests switching response modes.
+func TestGatewaySetMode(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	conn := &TestConnection{}
+	session := &session{version: 2}
+	gateway.handleMessage(conn, session, "MODE|extended|\n", nil)
+	if (conn.Written.String() != "OK\n" || !session.extended) {
+		t.Error("Connection should switch to extended mode")
+	}
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "MODE|verbose|\n", nil)
+	if (conn.Written.String() != "ERROR|INVALID_ARGUMENT|Unknown response mode : verbose\n" || !session.extended) {
+		t.Errorf("Unknown mode should be rejected : %s", conn.Written.String())
+	}
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "MODE|plain|\n", nil)
+	if (conn.Written.String() != "OK\n" || session.extended) {
+		t.Error("Connection should switch back to plain mode")
+	}
+}
+
+// Tests that connections which never negotiate a version only support the original request types.
+func TestGatewayOriginalProtocol(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	conn := &TestConnection{}
+	msgChannel := make(chan *ValidatedMessage, 1)
+	gateway.handleMessage(conn, &session{}, "WHY|lib|dep\n", msgChannel)
+	if (conn.Written.String() != "ERROR\n" || len(msgChannel) != 0) {
+		t.Error("Newer request types should be rejected until a version is negotiated")
+	}
+	conn.Written.Reset()
+	gateway.handleMessage(conn, &session{}, "MODE|extended|\n", msgChannel)
+	if (conn.Written.String() != "ERROR\n") {
+		t.Error("Response mode should not be available until a version is negotiated")
+	}
+}
+
+// Tests that packages are only versioned, and dependencies constrained, once a version is negotiated.
+func TestGatewayVersionedGrammar(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	msgChannel := make(chan *ValidatedMessage, 1)
+	defer close(msgChannel)
+	go func() {
+		for val := range msgChannel {
+			val.ResponseChannel <- "ok"
+		}
+	}()
+	for _, message := range []string{"INDEX|foo@1.0|\n", "INDEX|app|foo@1.0\n", "INDEX|a|b>=1\n"} {
+		conn := &TestConnection{}
+		gateway.handleMessage(conn, &session{}, message, msgChannel)
+		if (conn.Written.String() != "ERROR\n") {
+			t.Errorf("Message should be rejected until a version is negotiated : %s", message)
+		}
+		conn.Written.Reset()
+		gateway.handleMessage(conn, &session{version: 2}, message, msgChannel)
+		if (conn.Written.String() != "OK\n") {
+			t.Errorf("Message should be accepted once a version is negotiated : %s", message)
+		}
+	}
+}
+
+// Tests negotiation of protocol version and features.
+func TestGatewayHello(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	session := &session{admin: true}
+	if (gateway.hello(session, "2", "ids,compression,extended") != "ok|2|ids,extended") {
+		t.Error("Known features should be accepted, and unknown features ignored")
+	}
+	if (session.version != 2 || !session.ids || !session.extended || !session.admin) {
+		t.Error("Session should speak negotiated version and features")
+	}
+	if (gateway.hello(session, "7", "") != "ok|2|" || session.version != 2 || session.ids || session.extended) {
+		t.Error("Newer versions should be negotiated down to ours, without features")
+	}
+	if (gateway.hello(session, "1", "extended") != "ok|1|" || session.version != 1 || session.extended) {
+		t.Error("Original version should not support features")
+	}
+	if (gateway.hello(session, "latest", "") != "error|INVALID_ARGUMENT|Unknown protocol version : latest") {
+		t.Error("Unknown version should be rejected")
+	}
+}
+
+// Tests that request IDs are echoed back at the start of responses once negotiated.
+func TestGatewayRequestIds(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	conn := &TestConnection{}
+	session := &session{}
+	gateway.handleMessage(conn, session, "HELLO|2|ids\n", nil)
+	if (conn.Written.String() != "OK|2|ids\n") {
+		t.Errorf("Request IDs should be negotiated : %s", conn.Written.String())
+	}
+
+	msgChannel := make(chan *ValidatedMessage, 1)
+	go func() {
+		val := <-msgChannel
+		val.ResponseChannel <- "fail"
+	}()
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "req-1|QUERY|lib|\n", msgChannel)
+	if (conn.Written.String() != "req-1|FAIL\n") {
+		t.Errorf("Request ID should be echoed back : %s", conn.Written.String())
+	}
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "#1|QUERY|lib|\n", msgChannel)
+	if (conn.Written.String() != "ERROR\n") {
+		t.Errorf("Message without valid request ID should be rejected : %s", conn.Written.String())
+	}
+}
+
+// Tests that several messages sent in a single write are each answered, in order.
+func TestGatewayPipelining(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	server, client := net.Pipe()
+	defer client.Close()
+	go gateway.handleConnection(server, nil)
+	go client.Write([]byte("HELLO|2|ids\na1|CLIENT|alice|\na2|CLIENT|bob|\na3|MODE|extended|\n"))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+
+	reader := bufio.NewReader(client)
+	for _, expected := range []string{"OK|2|ids\n", "a1|OK\n", "a2|OK\n", "a3|OK\n"} {
+		if resp, err := reader.ReadString('\n'); (err != nil || resp != expected) {
+			t.Errorf("Pipelined message should be answered with %s, got : %s", expected, resp)
+		}
+	}
+}
+
+// Tests that messages within a batch are queued without being answered, and passed on together when committed.
+func TestGatewayBatch(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	conn := &TestConnection{}
+	session := &session{version: 2}
+	msgChannel := make(chan *ValidatedMessage, 1)
+	gateway.handleMessage(conn, session, "BEGIN|import|\n", msgChannel)
+	gateway.handleMessage(conn, session, "INDEX|base|\n", msgChannel)
+	gateway.handleMessage(conn, session, "INDEX|lib|base\n", msgChannel)
+	gateway.handleMessage(conn, session, "COMMIT|other|\n", msgChannel)
+	if (conn.Written.String() != "OK\nERROR\n" || len(msgChannel) != 0) {
+		t.Errorf("Messages should be queued until batch is committed : %s", conn.Written.String())
+	}
+
+	go func() {
+		val := <-msgChannel
+		if (val.Verb != "COMMIT" || len(val.Batch) != 2 || val.Batch[1].Package != "lib") {
+			t.Error("Batch should be passed on when committed")
+		}
+		val.ResponseChannel <- "fail|BATCH_FAILED|2|MISSING_DEPENDENCIES|base"
+	}()
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "COMMIT|import|\n", msgChannel)
+	if (conn.Written.String() != "FAIL|BATCH_FAILED|2|MISSING_DEPENDENCIES|base\n" || len(session.batchName) > 0) {
+		t.Errorf("Batch failure should be reported : %s", conn.Written.String())
+	}
+}
+
+// Tests that messages within a batch which cannot be queued are not answered, and fail the whole batch once
+// committed with the position and reason of the first, without passing any of it on.
+func TestGatewayBatchInvalid(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	conn := &TestConnection{}
+	session := &session{version: 2}
+	msgChannel := make(chan *ValidatedMessage, 1)
+	for _, message := range []string{"BEGIN|b|", "INDEX|x1|", "INDEX|&bad|", "QUERY|x1|", "INDEX|x2|x1", "COMMIT|b|"} {
+		gateway.handleMessage(conn, session, message + "\n", msgChannel)
+	}
+	if (conn.Written.String() != "OK\nFAIL|BATCH_FAILED|2|INVALID_PACKAGE|Package name missing or incorrect : &bad\n" || len(msgChannel) != 0) {
+		t.Errorf("Batch with an invalid message should fail without being applied : %s", conn.Written.String())
+	}
+
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "BEGIN|b|\n", msgChannel)
+	gateway.handleMessage(conn, session, "QUERY|x1|\n", msgChannel)
+	gateway.handleMessage(conn, session, "ABORT|b|\n", msgChannel)
+	gateway.handleMessage(conn, session, "BEGIN|b|\n", msgChannel)
+	gateway.handleMessage(conn, session, "INDEX|x1|\n", msgChannel)
+	go func() {
+		val := <-msgChannel
+		val.ResponseChannel <- "ok|" + strconv.Itoa(len(val.Batch))
+	}()
+	gateway.handleMessage(conn, session, "COMMIT|b|\n", msgChannel)
+	if (conn.Written.String() != "OK\nOK\nOK\nOK|1\n") {
+		t.Errorf("Failure of an aborted batch should not fail the next : %s", conn.Written.String())
+	}
+}
+
+// Tests that aborted batches are discarded, and that batches must begin before they end.
+func TestGatewayBatchAbort(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	conn := &TestConnection{}
+	session := &session{version: 2, extended: true}
+	msgChannel := make(chan *ValidatedMessage, 1)
+	gateway.handleMessage(conn, session, "BEGIN|import|\n", msgChannel)
+	gateway.handleMessage(conn, session, "INDEX|base|\n", msgChannel)
+	gateway.handleMessage(conn, session, "ABORT|import|\n", msgChannel)
+	gateway.handleMessage(conn, session, "COMMIT|import|\n", msgChannel)
+	if (conn.Written.String() != "OK\nOK\nERROR|INVALID_ARGUMENT|No batch has begun\n" || len(msgChannel) != 0) {
+		t.Errorf("Aborted batch should be discarded : %s", conn.Written.String())
+	}
+}
+
+// Tests restricting the kinds of dependency that graph queries follow.
+func TestGatewayFilter(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	conn := &TestConnection{}
+	session := &session{version: 2, extended: true}
+	gateway.handleMessage(conn, session, "FILTER|kinds|runtime,build\n", nil)
+	if (conn.Written.String() != "OK\n" || !session.kinds.Allows("build") || session.kinds.Allows("optional")) {
+		t.Errorf("Kinds should be filtered : %s", conn.Written.String())
+	}
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "FILTER|kinds|test\n", nil)
+	if (conn.Written.String() != "ERROR|INVALID_DEPENDENCIES|Unknown dependency kind : test\n" || session.kinds.Allows("optional")) {
+		t.Errorf("Unknown kind should be rejected : %s", conn.Written.String())
+	}
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "FILTER|kinds|\n", nil)
+	if (conn.Written.String() != "OK\n" || !session.kinds.Allows("optional")) {
+		t.Errorf("Empty filter should allow every kind : %s", conn.Written.String())
+	}
+}
+
+// Tests selecting namespaces per connection and per request.
+func TestGatewayNamespaces(t *testing.T) {
+	gateway := NewTestingGateway().(*TestingGateway)
+	conn := &TestConnection{}
+	session := &session{version: 2}
+	msgChannel := make(chan *ValidatedMessage, 1)
+
+	go func() {
+		val := <-msgChannel
+		val.ResponseChannel <- "fail|NO_SUCH_NAMESPACE"
+		val = <-msgChannel
+		val.ResponseChannel <- "ok"
+		val = <-msgChannel
+		if (val.Namespace != "staging") {
+			t.Errorf("Request should apply to namespace in use : %s", val.Namespace)
+		}
+		val.ResponseChannel <- "ok"
+		val = <-msgChannel
+		if (val.Namespace != "prod") {
+			t.Errorf("Request should apply to namespace it names : %s", val.Namespace)
+		}
+		val.ResponseChannel <- "ok"
+	}()
+	gateway.handleMessage(conn, session, "USE|missing|\n", msgChannel)
+	if (conn.Written.String() != "FAIL\n" || len(session.namespace) > 0) {
+		t.Errorf("Missing namespace should not be used : %s", conn.Written.String())
+	}
+	gateway.handleMessage(conn, session, "USE|staging|\n", msgChannel)
+	gateway.handleMessage(conn, session, "QUERY|lib|\n", msgChannel)
+	gateway.handleMessage(conn, session, "QUERY@prod|lib|\n", msgChannel)
+	if (conn.Written.String() != "FAIL\nOK\nOK\nOK\n" || session.namespace != "staging") {
+		t.Errorf("Namespace should be used : %s", conn.Written.String())
+	}
+
+	conn.Written.Reset()
+	gateway.handleMessage(conn, session, "NAMESPACE|create|prod\n", msgChannel)
+	session.version = 1
+	gateway.handleMessage(conn, session, "QUERY@prod|lib|\n", msgChannel)
+	if (conn.Written.String() != "ERROR\nERROR\n" || len(msgChannel) != 0) {
+		t.Errorf("Creating namespaces should be privileged, and namespaces need version 2 : %s", conn.Written.String())
+	}
+}
@@ -0,0 +1,177 @@
+package input
+
+import (
+	"crypto/subtle"
+	"regexp"
+	"strconv"
+	"strings"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+)
+
+// ProtocolVersion is the newest version of our protocol that clients can negotiate with HELLO.
+// Version 1 is the original protocol of INDEX, REMOVE and QUERY, which connections speak until
+// they negotiate otherwise, so that original clients see exactly the behaviour they expect.
+// Version 2 adds every other request type.
+const ProtocolVersion = 2
+
+// session holds the state of a single client connection.
+// Connections in extended response mode receive the reason for every FAIL and ERROR.
+// Version 2 connections can negotiate optional features with HELLO - 'extended' starts the connection
+// in extended response mode, and 'ids' prefixes every message and its response with a request ID,
+// so that clients can match responses to requests.
+// Connections are identified by their remote address, unless they name themselves with CLIENT.
+// Connections can FILTER the kinds of dependency that graph queries follow, which are otherwise all followed.
+// Connections USE a namespace for every request that does not name its own, initially the default namespace.
+// Connections that BEGIN a batch queue their INDEX and REMOVE messages in it until they COMMIT or ABORT.
+// The first message of a batch that cannot be queued is recorded in batchFailure, as its position and reason,
+// failing the batch once committed.
+type session struct {
+	version   int
+	admin     bool
+	extended  bool
+	ids       bool
+	client    string
+	kinds     data.KindFilter
+	namespace string
+	batchName string
+	batch     []*InputMessage
+	batchFailure string
+}
+
+// legacy lists the original request types, which always receive plain responses
+// unless a connection has opted into extended response mode.
+var legacy = map[string]bool{
+	"INDEX":  true,
+	"REMOVE": true,
+	"QUERY":  true,
+}
+
+// reasoned lists the request types whose reason for failing is their result, so it is
+// reported regardless of response mode.
+var reasoned = map[string]bool{
+	"DRYINDEX":  true,
+	"DRYREMOVE": true,
+	"COMMIT":    true,
+	"FSCK":      true,
+}
+
+// privileged lists the request types that may only be sent once a connection has authenticated.
+var privileged = map[string]bool{
+	"FORCE": true,
+	"GC":    true,
+}
+
+// privilegedNamespace lists the NAMESPACE subcommands that may only be sent once a connection has authenticated.
+var privilegedNamespace = map[string]bool{
+	"create": true,
+	"drop":   true,
+}
+
+// requiresAdmin determines if a request may only be sent once a connection has authenticated.
+func requiresAdmin(validated *InputMessage) bool {
+	return privileged[validated.Verb] || (validated.Verb == "NAMESPACE" && privilegedNamespace[validated.Package])
+}
+
+// supports determines if a request type is part of the protocol version the connection speaks.
+// HELLO is always supported, as it is how connections negotiate a version.
+func (s *session) supports(verb string) bool {
+	return s.version >= 2 || legacy[verb] || verb == "HELLO"
+}
+
+// hello negotiates the protocol version and features of a connection.  Clients asking for a newer
+// version than ours are given our newest, and features we do not know are ignored, so the response
+// lists the version and features actually in use.  Authentication is kept across handshakes.
+func (s *SimpleMessageGateway) hello(session *session, version string, requested string) (result string) {
+	v, parseErr := strconv.Atoi(version)
+	if parseErr != nil || v < 1 {
+		return "error|" + err.CodeInvalidArgument + "|Unknown protocol version : " + version
+	}
+	if v > ProtocolVersion {
+		v = ProtocolVersion
+	}
+	session.version = v
+	session.extended = false
+	session.ids = false
+	accepted := make([]string, 0)
+	if v >= 2 {
+		for _, feature := range strings.Split(requested, ",") {
+			switch feature {
+			case "extended":
+				session.extended = true
+			case "ids":
+				session.ids = true
+			default:
+				continue
+			}
+			accepted = append(accepted, feature)
+		}
+	}
+	return "ok|" + strconv.Itoa(v) + "|" + strings.Join(accepted, ",")
+}
+
+// setMode switches a connection between 'plain' responses, and 'extended' responses that
+// include the reason for every FAIL and ERROR.
+func (s *SimpleMessageGateway) setMode(session *session, mode string) (result string) {
+	switch mode {
+	case "extended":
+		session.extended = true
+		return "ok"
+	case "plain":
+		session.extended = false
+		return "ok"
+	}
+	return "error|" + err.CodeInvalidArgument + "|Unknown response mode : " + mode
+}
+
+// setFilter restricts graph queries on a connection to the given kinds of dependency.
+// An empty list of kinds removes the filter.
+func (s *SimpleMessageGateway) setFilter(session *session, filter string, kinds string) (result string) {
+	if filter != "kinds" {
+		return "error|" + err.CodeInvalidArgument + "|Unknown filter : " + filter
+	}
+	parsed, parseErr := data.ParseKindFilter(kinds)
+	if parseErr != nil {
+		return "error|" + err.Describe(parseErr)
+	}
+	session.kinds = parsed
+	return "ok"
+}
+
+// authenticate grants a connection access to privileged operations if it presents our admin token.
+// Privileged operations are disabled entirely when no admin token has been configured.
+func (s *SimpleMessageGateway) authenticate(session *session, token string) (result string) {
+	if len(s.adminToken) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1 {
+		session.admin = true
+		return "ok"
+	}
+	s.logger.Info("Connection failed to authenticate")
+	return "fail"
+}
+
+// splitRequestId splits the request ID from the start of a message.  Messages without a valid ID
+// are returned whole, so that they are rejected by validation.
+func splitRequestId(message string) (id string, rest string) {
+	pieces := strings.SplitN(message, "|", 2)
+	if len(pieces) != 2 {
+		return "", message
+	}
+	if match, _ := regexp.MatchString(`^[a-zA-Z0-9_\-]+$`, pieces[0]); !match {
+		return "", message
+	}
+	return pieces[0], pieces[1]
+}
+
+// formatSessionResponse formats a response for a connection, according to its response mode.
+// Unless a connection is in extended mode, reasons are removed from FAIL and ERROR responses,
+// and responses to the original request types are kept plain, exactly as clients of our
+// original protocol expect.
+func (s *SimpleMessageGateway) formatSessionResponse(session *session, verb string, str string) (resp []byte) {
+	if !session.extended {
+		status := strings.SplitN(str, "|", 2)[0]
+		if legacy[verb] || status == "error" || (status == "fail" && !reasoned[verb]) {
+			str = status
+		}
+	}
+	return s.formatResponse(str)
+}
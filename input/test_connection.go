@@ -1,19 +1,23 @@
 package input
 
 import (
+	"bytes"
 	"time"
 	"net"
 )
 
 // TestConnection implements net.Conn, to be used for testing purposes.
-type TestConnection struct {}
+// Everything written to the connection is recorded, so that responses can be verified.
+type TestConnection struct {
+	Written bytes.Buffer
+}
 
 func (t *TestConnection) Read(b []byte) (n int, err error) {
 	return 100, nil
 }
 
 func (t *TestConnection) Write(b []byte) (n int, err error) {
-	return 100, nil
+	return t.Written.Write(b)
 }
 
 func (t *TestConnection) Close() error {
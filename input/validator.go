@@ -10,48 +10,217 @@ import (
 // Validator is responsible for validating input format of received messages.
 type Validator interface {
 	ValidateInput(input string) (validMessage *InputMessage, err error)
+
+	// validates a message sent by a connection speaking the original protocol, whose packages and dependencies
+	// are never versioned, nor dependencies constrained.
+	ValidateLegacyInput(input string) (validMessage *InputMessage, err error)
 }
 
 type SimpleValidator struct{}
 
+// verbs maps each request type we support to whether its third argument names a single
+// target package (true) rather than a comma delimited list of dependencies (false).
+var verbs = map[string]bool{
+	"REMOVE":    false,
+	"INDEX":     false,
+	"QUERY":     false,
+	"WHY":       true,
+	"WHYALL":    true,
+	"CASCADE":   false,
+	"FORCE":     false,
+	"DEPS":      false,
+	"AUTH":      false,
+	"ORPHANS":   false,
+	"GC":        false,
+	"DRYINDEX":  false,
+	"DRYREMOVE": false,
+	"MODE":      false,
+	"HELLO":     false,
+	"BEGIN":     false,
+	"COMMIT":    false,
+	"ABORT":     false,
+	"CASINDEX":  false,
+	"CLIENT":    false,
+	"INFO":      false,
+	"FILTER":    false,
+	"USE":       false,
+	"NAMESPACE": false,
+	"FSCK":      false,
+	"QUERYAT":   false,
+	"DEPSAT":    false,
+	"DIFF":      true,
+}
+
+// expecting lists the request types whose messages carry a fourth argument, the state of the
+// package that the client expects - either a comma delimited list of dependencies, or '!' if
+// the package should not be indexed.
+var expecting = map[string]bool{
+	"CASINDEX": true,
+}
+
+// wildcards lists the request types that may refer to every version of a package, as in foo@*.
+var wildcards = map[string]bool{
+	"QUERY":     true,
+	"REMOVE":    true,
+	"DRYREMOVE": true,
+}
+
+// whole lists the request types that concern the whole index, so need not name a package.
+var whole = map[string]bool{
+	"FSCK": true,
+}
+
+// namePattern matches the name of a package.
+const namePattern = `[a-zA-Z0-9_\-\+]+`
+
+// packagePattern matches a package - a name, optionally followed by a version as in foo@1.2.0.
+const packagePattern = namePattern + `(@[a-zA-Z0-9_\.\-\+]+)?`
+
+// constraintPattern matches a constraint on the version of a dependency, as in >=1.2 or ^2.0.
+const constraintPattern = `(>=|<=|!=|=|>|<|~|\^)[a-zA-Z0-9_\.\-\+]+`
+
+// dependencyPattern matches a dependency - a package, or the name of a package constrained to a range
+// of versions, optionally preceded by its kind as in build:gcc.  Constraints may be comma delimited,
+// as in bar>=1.2,<2, so may also stand alone.
+const dependencyPattern = `(([a-z]+:)?(` + packagePattern + `|` + namePattern + constraintPattern + `)|` + constraintPattern + `)`
+
+// dependenciesPattern matches a comma delimited list of dependencies.
+const dependenciesPattern = dependencyPattern + `?(,` + dependencyPattern + `?)*`
+
+// legacyDependencyPattern matches a dependency sent over the original protocol, which is never versioned
+// or constrained.
+const legacyDependencyPattern = `(([a-z]+:)?` + namePattern + `)`
+
+// legacyDependenciesPattern matches a comma delimited list of dependencies sent over the original protocol.
+const legacyDependenciesPattern = legacyDependencyPattern + `?(,` + legacyDependencyPattern + `?)*`
+
+// grammar holds the patterns that the packages and dependencies of a message must match, which depend on
+// the protocol version its connection speaks, and whether it may refer to every version of a package.
+type grammar struct {
+	packages     string
+	dependencies string
+	wildcards    bool
+}
+
+// versionedGrammar is spoken by connections that have negotiated version 2 or later with HELLO.
+var versionedGrammar = grammar{packagePattern, dependenciesPattern, true}
+
+// legacyGrammar is spoken by connections using the original protocol.
+var legacyGrammar = grammar{namePattern, legacyDependenciesPattern, false}
+
+// namespacePattern matches the name of a namespace.
+const namespacePattern = `[a-z0-9_\-]+`
+
+// InputMessage is a single request.  Requests may name the namespace they apply to after their
+// request type, as in QUERY@staging, and otherwise apply to the namespace their connection uses.
 type InputMessage struct {
 	Verb         string
 	Package      string
 	Dependencies string
+	Expected     string
+	Namespace    string
 }
 
 func (s *SimpleValidator) ValidateInput(input string) (validMessage *InputMessage, error error) {
+	return s.validate(input, versionedGrammar)
+}
+
+func (s *SimpleValidator) ValidateLegacyInput(input string) (validMessage *InputMessage, error error) {
+	return s.validate(input, legacyGrammar)
+}
+
+// validate validates a message whose packages and dependencies are spoken in our grammar.
+func (s *SimpleValidator) validate(input string, spoken grammar) (validMessage *InputMessage, error error) {
 	pieces := strings.Split(input, "|")
 
-	// First ensure that we have the 3 required parts to our input.
-	if len(pieces) != 3 {
-		return nil, err.NewIndexError(fmt.Sprintf("Input does not have 3 arguments : %s", input))
+	//Split off the namespace the request applies to, if it names one.
+	namespace := ""
+	if i := strings.Index(pieces[0], "@"); i != -1 {
+		pieces[0], namespace = pieces[0][:i], pieces[0][i+1:]
+		match, _ := regexp.MatchString(`^` + namespacePattern + `$`, namespace)
+		if !match {
+			return nil, err.NewCodedIndexError(err.CodeInvalidArgument, fmt.Sprintf("Namespace name missing or incorrect : %s", namespace))
+		}
 	}
 
-	//Ensure that our request type is REMOVE/INDEX/QUERY
+	// First ensure that we have the 3 required parts to our input, or 4 if the request type expects them.
+	arguments := 3
+	if expecting[pieces[0]] {
+		arguments = 4
+	}
+	if len(pieces) != arguments {
+		return nil, err.NewCodedIndexError(err.CodeInvalidFormat, fmt.Sprintf("Input does not have %d arguments : %s", arguments, input))
+	}
+
+	//Ensure that our request type is one we support
 	method := pieces[0]
-	if method != "REMOVE" && method != "INDEX" && method != "QUERY" {
-		return nil, err.NewIndexError(fmt.Sprintf("Input method should be REMOVE/INDEX/QUERY, not : %s", method))
+	targeted, supported := verbs[method]
+	if !supported {
+		return nil, err.NewCodedIndexError(err.CodeUnknownVerb, fmt.Sprintf("Input method is not supported : %s", method))
 	}
 
-	//Make sure our lib name is >1 alphanumeric character
+	//Make sure our lib name is >1 alphanumeric character, optionally followed by a version.
 	lib := pieces[1]
-	match, _ := regexp.MatchString(`^[a-zA-Z0-9_\-\+]+$`, lib)
+	match, _ := regexp.MatchString(`^` + spoken.packages + `$`, lib)
+	if (!match && whole[method]) {
+		match = len(lib) == 0
+	}
+	if (!match && wildcards[method] && spoken.wildcards) {
+		match, _ = regexp.MatchString(`^[a-zA-Z0-9_\-\+]+@\*$`, lib)
+	}
 	if (!match) {
-		return nil, err.NewIndexError(fmt.Sprintf("Package name missing or incorrect : %s", lib))
+		return nil, err.NewCodedIndexError(err.CodeInvalidPackage, fmt.Sprintf("Package name missing or incorrect : %s", lib))
 	}
 
-	//Make sure that our dependencies list is a comma delimited list of alphanumeric words.
-	dependencies := pieces[2][:len(pieces[2])-1]
-	match, _ = regexp.MatchString(`^[a-zA-Z0-9_,\-\+]*$`, dependencies)
+	//Make sure that our dependencies list is a comma delimited list of alphanumeric words, optionally versioned.
+	dependencies := pieces[2]
+	expected := ""
+	if arguments == 4 {
+		expected = pieces[3][:len(pieces[3])-1]
+	} else {
+		dependencies = dependencies[:len(dependencies)-1]
+	}
+	match, _ = regexp.MatchString(`^` + spoken.dependencies + `$`, dependencies)
 	if !match {
-		return nil, err.NewIndexError(fmt.Sprintf("Dependencies are incorrectly formatted : %s", dependencies))
+		return nil, err.NewCodedIndexError(err.CodeInvalidDependencies, fmt.Sprintf("Dependencies are incorrectly formatted : %s", dependencies))
+	}
+
+	//Make sure that requests about a target package name exactly one.
+	if targeted {
+		match, _ = regexp.MatchString(`^` + spoken.packages + `$`, dependencies)
+		if !match {
+			return nil, err.NewCodedIndexError(err.CodeInvalidArgument, fmt.Sprintf("Target package missing or incorrect : %s", dependencies))
+		}
+	}
+
+	//Make sure that the expected state is a comma delimited list of dependencies, or '!'.
+	if arguments == 4 {
+		match, _ = regexp.MatchString(`^(!|` + spoken.dependencies + `)$`, expected)
+		if !match {
+			return nil, err.NewCodedIndexError(err.CodeInvalidArgument, fmt.Sprintf("Expected dependencies are incorrectly formatted : %s", expected))
+		}
+	}
+
+	//Make sure that requests about a namespace name one correctly.
+	named := ""
+	if method == "USE" {
+		named = lib
+	} else if method == "NAMESPACE" {
+		named = dependencies
+	}
+	if len(named) > 0 {
+		match, _ = regexp.MatchString(`^` + namespacePattern + `$`, named)
+		if !match {
+			return nil, err.NewCodedIndexError(err.CodeInvalidArgument, fmt.Sprintf("Namespace name missing or incorrect : %s", named))
+		}
 	}
 
 	return &InputMessage{
 		method,
 		lib,
 		dependencies,
+		expected,
+		namespace,
 	}, nil
 }
 
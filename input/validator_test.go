@@ -30,6 +30,29 @@ func TestCorrectRemove(t *testing.T) {
 	validateMessage(t, result, err, "REMOVE", "lib", "dep1,dep2")
 }
 
+// Tests correct Why message.
+func TestCorrectWhy(t *testing.T) {
+	validator := NewValidator()
+	validQuery := "WHY|lib|dep\n"
+	result, err := validator.ValidateInput(validQuery)
+	validateMessage(t, result, err, "WHY", "lib", "dep")
+}
+
+// Tests that Why requires exactly one target package.
+func TestBadWhyTarget(t *testing.T) {
+	validator := NewValidator()
+	badQuery := "WHYALL|lib|dep1,dep2\n"
+	_, err := validator.ValidateInput(badQuery)
+	if (strings.Index(err.Error(), "Target package missing or incorrect : dep1,dep2") == -1) {
+		t.Errorf("Incorrect error message : %s", err.Error())
+	}
+	badQuery = "WHY|lib|\n"
+	_, err = validator.ValidateInput(badQuery)
+	if (strings.Index(err.Error(), "Target package missing or incorrect : ") == -1) {
+		t.Errorf("Incorrect error message : %s", err.Error())
+	}
+}
+
 // Tests incorrect piping in input.
 func TestBadFormat(t *testing.T) {
 	validator := NewValidator()
@@ -45,7 +68,7 @@ func TestBadVerb(t *testing.T) {
 	validator := NewValidator()
 	badQuery := "FAKE|lib|\n"
 	_, err := validator.ValidateInput(badQuery)
-	if (strings.Index(err.Error(), "Input method should be REMOVE/INDEX/QUERY, not : FAKE") == -1) {
+	if (strings.Index(err.Error(), "Input method is not supported : FAKE") == -1) {
 		t.Errorf("Incorrect error message : %s", err.Error())
 	}
 }
@@ -84,3 +107,127 @@ func validateMessage(t *testing.T, result *InputMessage, err error, expectedVerb
 		t.Errorf("Incorrect message dependencies parsed : %s", result.Dependencies)
 	}
 }
+
+// Tests that conditional requests carry the state the client expects as a fourth argument.
+func TestExpectedState(t *testing.T) {
+	validator := NewValidator()
+	msg, err := validator.ValidateInput("CASINDEX|lib|dep1,dep2|dep1\n")
+	if (err != nil || msg.Dependencies != "dep1,dep2" || msg.Expected != "dep1") {
+		t.Error("Expected dependencies should be validated")
+	}
+	msg, err = validator.ValidateInput("CASINDEX|lib||!\n")
+	if (err != nil || msg.Dependencies != "" || msg.Expected != "!") {
+		t.Error("Expecting package not to be indexed should be validated")
+	}
+	_, err = validator.ValidateInput("CASINDEX|lib|dep1\n")
+	if (err == nil || strings.Index(err.Error(), "Input does not have 4 arguments") == -1) {
+		t.Error("Conditional request without expected state should be rejected")
+	}
+	_, err = validator.ValidateInput("CASINDEX|lib|dep1|dep1!\n")
+	if (err == nil || strings.Index(err.Error(), "Expected dependencies are incorrectly formatted : dep1!") == -1) {
+		t.Error("Incorrectly formatted expected state should be rejected")
+	}
+}
+
+// Tests that packages and dependencies may be versioned, and that only some requests refer to every version.
+func TestVersionedPackages(t *testing.T) {
+	validator := NewValidator()
+	msg, err := validator.ValidateInput("INDEX|foo@1.2.0|bar@2.0.1-rc1,baz\n")
+	if (err != nil || msg.Package != "foo@1.2.0" || msg.Dependencies != "bar@2.0.1-rc1,baz") {
+		t.Error("Versioned packages and dependencies should be validated")
+	}
+	msg, err = validator.ValidateInput("REMOVE|foo@*|\n")
+	if (err != nil || msg.Package != "foo@*") {
+		t.Error("Removal of every version should be validated")
+	}
+	cases := []string{"INDEX|foo@*|\n", "INDEX|foo@|\n", "INDEX|foo@1@2|\n", "INDEX|foo|bar@\n", "INDEX|foo|@1.0\n", "CASCADE|foo@*|\n"}
+	for _, c := range cases {
+		if _, err = validator.ValidateInput(c); (err == nil) {
+			t.Errorf("Incorrectly versioned message should be rejected : %s", c)
+		}
+	}
+}
+
+// Tests that packages and dependencies are never versioned by connections speaking the original protocol.
+func TestLegacyUnversionedPackages(t *testing.T) {
+	validator := NewValidator()
+	msg, err := validator.ValidateLegacyInput("INDEX|foo|bar,build:baz\n")
+	if (err != nil || msg.Package != "foo" || msg.Dependencies != "bar,build:baz") {
+		t.Error("Unversioned packages and dependencies should be validated")
+	}
+	cases := []string{"INDEX|foo@1.0|\n", "INDEX|foo|bar@2.0\n", "QUERY|foo@1.0|\n", "REMOVE|foo@*|\n", "QUERY|foo@*|\n"}
+	for _, c := range cases {
+		if _, err = validator.ValidateLegacyInput(c); (err == nil) {
+			t.Errorf("Versioned message should be rejected over the original protocol : %s", c)
+		}
+	}
+}
+
+// Tests that dependencies may be constrained to a range of versions.
+func TestConstrainedDependencies(t *testing.T) {
+	validator := NewValidator()
+	msg, err := validator.ValidateInput("INDEX|foo|bar>=1.2,<2,baz^0.1,qux~1.2.3,quux=1.0\n")
+	if (err != nil || msg.Dependencies != "bar>=1.2,<2,baz^0.1,qux~1.2.3,quux=1.0") {
+		t.Error("Constrained dependencies should be validated")
+	}
+	cases := []string{"INDEX|foo|bar>=\n", "INDEX|foo|bar>=1.2<2\n", "INDEX|foo|bar@1.0>=1\n", "INDEX|foo>=1|\n"}
+	for _, c := range cases {
+		if _, err = validator.ValidateInput(c); (err == nil) {
+			t.Errorf("Incorrectly constrained dependencies should be rejected : %s", c)
+		}
+	}
+	cases = []string{"INDEX|a|b>=1\n", "INDEX|foo|bar>=1.2,<2\n", "INDEX|foo|build:baz^0.1\n"}
+	for _, c := range cases {
+		if _, err = validator.ValidateLegacyInput(c); (err == nil) {
+			t.Errorf("Constrained dependencies should be rejected over the original protocol : %s", c)
+		}
+	}
+	if _, err = validator.ValidateLegacyInput("INDEX|foo|\n"); (err != nil) {
+		t.Error("Packages without dependencies should be validated over the original protocol")
+	}
+}
+
+// Tests that dependencies may be preceded by their kind.
+func TestTypedDependencies(t *testing.T) {
+	validator := NewValidator()
+	msg, err := validator.ValidateInput("INDEX|foo|lib,build:gcc@9,optional:docs>=1,<2\n")
+	if (err != nil || msg.Dependencies != "lib,build:gcc@9,optional:docs>=1,<2") {
+		t.Error("Typed dependencies should be validated")
+	}
+	cases := []string{"INDEX|foo|build:\n", "INDEX|foo|:gcc\n", "INDEX|foo|Build:gcc\n", "INDEX|foo|build:<2\n"}
+	for _, c := range cases {
+		if _, err = validator.ValidateInput(c); (err == nil) {
+			t.Errorf("Incorrectly typed dependencies should be rejected : %s", c)
+		}
+	}
+}
+
+// Tests that requests may name the namespace they apply to.
+func TestNamespaces(t *testing.T) {
+	validator := NewValidator()
+	msg, err := validator.ValidateInput("QUERY@staging|lib|\n")
+	if (err != nil || msg.Verb != "QUERY" || msg.Namespace != "staging" || msg.Package != "lib") {
+		t.Error("Namespace should be split from request type")
+	}
+	msg, err = validator.ValidateInput("NAMESPACE|create|staging\n")
+	if (err != nil || msg.Package != "create" || msg.Dependencies != "staging") {
+		t.Error("Namespace commands should be validated")
+	}
+	cases := []string{"QUERY@|lib|\n", "QUERY@Staging|lib|\n", "USE|a+b|\n", "NAMESPACE|create|a,b\n", "CASINDEX@x|lib|dep\n"}
+	for _, c := range cases {
+		if _, err = validator.ValidateInput(c); (err == nil) {
+			t.Errorf("Incorrect namespace should be rejected : %s", c)
+		}
+	}
+}
+
+// Tests that requests concerning the whole index need not name a package.
+func TestWholeIndex(t *testing.T) {
+	validator := NewValidator()
+	if _, err := validator.ValidateInput("FSCK||\n"); (err != nil) {
+		t.Error("FSCK should not need a package")
+	}
+	if _, err := validator.ValidateInput("QUERY||\n"); (err == nil) {
+		t.Error("QUERY should need a package")
+	}
+}
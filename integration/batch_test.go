@@ -0,0 +1,67 @@
+package integration
+
+import (
+	"strings"
+	"testing"
+)
+
+// BEGIN|<name>| starts a batch, queueing INDEX and REMOVE messages without a response until
+// COMMIT|<name>| applies them all or nothing, returning `OK|<count>\n` or
+// `FAIL|BATCH_FAILED|<position>|<reason>|<packages>\n`.  ABORT|<name>| discards the batch.
+
+//Tests that a batch may depend on packages indexed earlier in the same batch.
+func TestBatchCommit(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+
+	resps, err := client.Pipeline([]string{"BEGIN|import|", "INDEX|testpackage1|", "INDEX|testpackage2|testpackage1", "COMMIT|import|"}, 2)
+	if (err != nil || resps[0] != "OK" || resps[1] != "OK|2") {
+		t.Errorf("Batch should be applied, answering only BEGIN and COMMIT, got : %v", resps)
+	}
+	respCode, err := client.Send("QUERY|testpackage2|")
+	if (err != nil || respCode != OK) {
+		t.Error("Package should be indexed by batch")
+	}
+	teardownTest()
+}
+
+//Tests that a failing batch applies none of its operations.
+func TestBatchRollback(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+
+	resps, err := client.Pipeline([]string{"BEGIN|import|", "INDEX|testpackage1|", "INDEX|testpackage3|testpackage1,testpackage2", "COMMIT|import|"}, 2)
+	if (err != nil || resps[1] != "FAIL|BATCH_FAILED|2|MISSING_DEPENDENCIES|testpackage2") {
+		t.Errorf("Batch failure should be reported, got : %v", resps)
+	}
+	respCode, err := client.Send("QUERY|testpackage1|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Failed batch should be rolled back")
+	}
+
+	resps, err = client.Pipeline([]string{"BEGIN|import|", "INDEX|testpackage1|", "INDEX|&bad|", "INDEX|testpackage2|testpackage1", "COMMIT|import|"}, 2)
+	if (err != nil || !strings.HasPrefix(resps[1], "FAIL|BATCH_FAILED|2|INVALID_PACKAGE|")) {
+		t.Errorf("Malformed message should fail the batch when committed, got : %v", resps)
+	}
+	respCode, err = client.Send("QUERY|testpackage1|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Batch with a malformed message should not be applied")
+	}
+
+	client.Pipeline([]string{"BEGIN|import|", "INDEX|testpackage1|", "ABORT|import|"}, 2)
+	respCode, err = client.Send("QUERY|testpackage1|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Aborted batch should not be applied")
+	}
+	teardownTest()
+}
@@ -0,0 +1,51 @@
+package integration
+
+import (
+	"strings"
+	"testing"
+)
+
+// ORPHANS|<before>|<options> returns `OK|<orphans>\n` listing packages that were only ever indexed as
+// dependencies and that nothing depends on any longer, optionally only those indexed before a unix timestamp,
+// or never queried with option 'unqueried'.
+// GC|<before>|<options> returns every package garbage collection would remove, in order,
+// removing them only with option 'apply'.  GC is a privileged operation.
+
+//Tests that orphans are listed, that packages indexed for their own sake are not, and that garbage
+//collection dry runs remove nothing.
+func TestOrphansAndDryRun(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+	defer teardownTest()
+
+	resp, err := client.Request("ORPHANS|0|unqueried")
+	if (err != nil || strings.Contains(resp, "testpackage2") || strings.Contains(resp, "testpackage1")) {
+		t.Errorf("Orphans should not list packages indexed for their own sake, got : %s", resp)
+	}
+	client.Send("REMOVE|testpackage2|")
+	resp, err = client.Request("ORPHANS|0|unqueried")
+	if (err != nil || !strings.Contains(resp, "testpackage1")) {
+		t.Errorf("Orphans should list dependencies nothing depends on, got : %s", resp)
+	}
+	respCode, err := client.Send("ORPHANS|yesterday|")
+	if (err != nil || respCode != ERROR) {
+		t.Error("ERROR should be returned for incorrect timestamp")
+	}
+
+	authenticate(t, client)
+	resp, err = client.Request("GC|0|unqueried")
+	if (err != nil || !strings.Contains(resp, "testpackage1")) {
+		t.Errorf("Garbage collection dry run should list orphans, got : %s", resp)
+	}
+	respCode, err = client.Send("QUERY|testpackage1|")
+	if (err != nil || respCode != OK) {
+		t.Error("Garbage collection dry run should not remove anything")
+	}
+}
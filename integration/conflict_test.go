@@ -0,0 +1,40 @@
+package integration
+
+import (
+	"testing"
+)
+
+// CASINDEX|<package>|<dependencies>|<expected> indexes a package only if it currently has the expected
+// dependencies, or '!' if it should not be indexed yet.  Otherwise it returns `CONFLICT|<current>\n`
+// with the package's current dependencies, or '!' if it is not indexed.
+
+//Tests that conditional indexing reports conflicting updates.
+func TestCompareAndIndex(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|")
+
+	resp, err := client.Request("CASINDEX|testpackage3|testpackage1|!")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Package expected not to be indexed should be indexed, got : %s", resp)
+	}
+	resp, err = client.Request("CASINDEX|testpackage3|testpackage2|!")
+	if (err != nil || resp != "CONFLICT|testpackage1") {
+		t.Errorf("Conflict should report current dependencies, got : %s", resp)
+	}
+	resp, err = client.Request("CASINDEX|testpackage3|testpackage1,testpackage2|testpackage1")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Package with expected dependencies should be updated, got : %s", resp)
+	}
+	resp, err = client.Request("CASINDEX|testpackage2||!")
+	if (err != nil || resp != "CONFLICT|") {
+		t.Errorf("Conflict should report package without dependencies, got : %s", resp)
+	}
+	teardownTest()
+}
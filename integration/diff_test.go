@@ -0,0 +1,46 @@
+package integration
+
+import (
+	"testing"
+)
+
+// DIFF|<index>|<index> compares two indexes, each a namespace as it is now, or as it was at a unix timestamp
+// as in default@1500000000.  It returns `OK|+<added>|-<removed>|~<changed>,+<dependency>,-<dependency>\n`
+// listing each package that differs, or OK if none do.
+
+//Tests that packages added, removed and changed between namespaces are listed.
+func TestDiff(t *testing.T) {
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "extended")
+	authenticate(t, client)
+	client.Request("NAMESPACE|create|diffbefore")
+	client.Request("NAMESPACE|create|diffafter")
+	client.Send("INDEX@diffbefore|testpackage1|")
+	client.Send("INDEX@diffbefore|testpackage2|testpackage1")
+	client.Send("INDEX@diffafter|testpackage1|")
+	client.Send("INDEX@diffafter|testpackage2|build:testpackage1")
+	client.Send("INDEX@diffafter|testpackage3|testpackage2")
+
+	resp, err := client.Request("DIFF|diffbefore|diffafter")
+	if (err != nil || resp != "OK|+testpackage3|~testpackage2,+build:testpackage1,-testpackage1") {
+		t.Errorf("Differences between namespaces should be listed, got : %s", resp)
+	}
+	resp, err = client.Request("DIFF|diffafter|diffafter")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Namespace should not differ from itself, got : %s", resp)
+	}
+	resp, err = client.Request("DIFF|diffafter@0|diffafter")
+	if (err != nil || resp != "FAIL|NO_HISTORY") {
+		t.Errorf("History should not reach back to 1970, got : %s", resp)
+	}
+	resp, err = client.Request("DIFF|diffafter|missing")
+	if (err != nil || resp != "FAIL|NO_SUCH_NAMESPACE") {
+		t.Errorf("Missing namespace should not be compared, got : %s", resp)
+	}
+	client.Request("NAMESPACE|drop|diffbefore")
+	client.Request("NAMESPACE|drop|diffafter")
+}
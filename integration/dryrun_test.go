@@ -0,0 +1,62 @@
+package integration
+
+import (
+	"testing"
+)
+
+// DRYINDEX and DRYREMOVE report whether INDEX and REMOVE would succeed, without changing the index.
+// They return `OK|<reason>\n` or `FAIL|<reason>|<packages>\n`, such as the missing dependencies
+// of a package or the parents blocking its removal.
+
+//Tests that a dry run index reports missing dependencies, and indexes nothing.
+func TestDryRunIndex(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+
+	resp, err := client.Request("DRYINDEX|testpackage3|testpackage1,testpackage2")
+	if (err != nil || resp != "FAIL|MISSING_DEPENDENCIES|testpackage2") {
+		t.Errorf("Dry run should report missing dependencies, got : %s", resp)
+	}
+	resp, err = client.Request("DRYINDEX|testpackage2|testpackage1")
+	if (err != nil || resp != "OK|INDEXED") {
+		t.Errorf("Dry run should report package would be indexed, got : %s", resp)
+	}
+	respCode, err := client.Send("QUERY|testpackage2|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Dry run should not index package")
+	}
+	teardownTest()
+}
+
+//Tests that a dry run remove reports blocking parents, and removes nothing.
+func TestDryRunRemove(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+
+	resp, err := client.Request("DRYREMOVE|testpackage1|")
+	if (err != nil || resp != "FAIL|HAS_PARENTS|testpackage2") {
+		t.Errorf("Dry run should report blocking parents, got : %s", resp)
+	}
+	resp, err = client.Request("DRYREMOVE|testpackage2|")
+	if (err != nil || resp != "OK|REMOVED") {
+		t.Errorf("Dry run should report package would be removed, got : %s", resp)
+	}
+	respCode, err := client.Send("QUERY|testpackage2|")
+	if (err != nil || respCode != OK) {
+		t.Error("Dry run should not remove package")
+	}
+	teardownTest()
+}
@@ -0,0 +1,55 @@
+package integration
+
+import (
+	"testing"
+)
+
+// MODE|extended|, once a connection has negotiated version 2 with HELLO, or negotiating feature
+// 'extended' in HELLO itself, switches a connection into extended response mode, where FAIL and ERROR are
+// followed by a reason code and detail, such as `FAIL|MISSING_DEPENDENCIES|<dependencies>\n`.
+// MODE|plain| switches back.  Connections start in plain mode, so original clients are unaffected.
+
+//Tests that reasons are only given for failures and errors in extended mode.
+func TestExtendedResponses(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+
+	respCode, err := client.Send("INDEX|testpackage3|testpackage4")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Plain FAIL should be returned before switching mode")
+	}
+	respCode, err = client.Send("MODE|extended|")
+	if (err != nil || respCode != OK) {
+		t.Error("Switching to extended mode should succeed")
+	}
+
+	expected := map[string]string{
+		"INDEX|testpackage3|testpackage4,testpackage1": "FAIL|MISSING_DEPENDENCIES|testpackage4",
+		"REMOVE|testpackage1|":                         "FAIL|HAS_PARENTS|testpackage2",
+		"QUERY|testpackage3|":                          "FAIL|NOT_INDEXED",
+		"QUERY|testpackage1|":                          "OK",
+		"BAD|testpackage1|":                            "ERROR|UNKNOWN_VERB|Input method is not supported : BAD",
+		"QUERY|testpackage1":                           "ERROR|INVALID_FORMAT|Input does not have 3 arguments : QUERY testpackage1",
+		"FORCE|testpackage1|":                          "ERROR|UNAUTHORIZED",
+	}
+	for msg, expectedResp := range expected {
+		resp, err := client.Request(msg)
+		if (err != nil || resp != expectedResp) {
+			t.Errorf("Incorrect extended response to %s : %s", msg, resp)
+		}
+	}
+
+	client.Send("MODE|plain|")
+	respCode, err = client.Send("REMOVE|testpackage1|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Plain FAIL should be returned after switching back")
+	}
+	teardownTest()
+}
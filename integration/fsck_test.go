@@ -0,0 +1,36 @@
+package integration
+
+import (
+	"testing"
+)
+
+// FSCK|| returns OK if the index is consistent, or `FAIL|<problem>|<problem>...\n` otherwise, where each
+// problem is CYCLE, ASYMMETRIC or DANGLING followed by the packages involved, as in CYCLE:a,b.
+
+//Tests that the index remains consistent through indexing, forced removal and removal.
+func TestFsck(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	authenticate(t, client)
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+	client.Send("INDEX|testpackage3|testpackage2,optional:testpackage4")
+	client.Request("FORCE|testpackage1|")
+
+	resp, err := client.Request("FSCK||")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Index should be consistent, got : %s", resp)
+	}
+	client.Send("REMOVE|testpackage3|")
+	client.Send("REMOVE|testpackage2|")
+	resp, err = client.Request("FSCK||")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Index should remain consistent, got : %s", resp)
+	}
+	teardownTest()
+}
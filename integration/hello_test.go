@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"testing"
+)
+
+// HELLO|<version>|<features> negotiates the protocol version a connection speaks, returning
+// `OK|<version>|<accepted features>\n`.  Connections that never send it speak the original protocol,
+// so every request type other than INDEX, REMOVE and QUERY returns `ERROR\n`.
+
+//Tests that newer request types are only available once a version is negotiated.
+func TestHelloEnablesRequestTypes(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	client.Send("INDEX|testpackage1|")
+
+	respCode, err := client.Send("DEPS|testpackage1|")
+	if (err != nil || respCode != ERROR) {
+		t.Error("Newer request types should return ERROR before negotiating a version")
+	}
+	resp, err := client.Request("HELLO|3|extended,compression")
+	if (err != nil || resp != "OK|2|extended") {
+		t.Errorf("Version and known features should be negotiated, got : %s", resp)
+	}
+	resp, err = client.Request("DEPS|testpackage3|")
+	if (err != nil || resp != "FAIL|NOT_INDEXED") {
+		t.Errorf("Newer request types should be available once negotiated, got : %s", resp)
+	}
+	resp, err = client.Request("HELLO|1|")
+	if (err != nil || resp != "OK|1|") {
+		t.Errorf("Original version should be negotiable, got : %s", resp)
+	}
+	respCode, err = client.Send("DEPS|testpackage1|")
+	if (err != nil || respCode != ERROR) {
+		t.Error("Newer request types should return ERROR after negotiating original version")
+	}
+	teardownTest()
+}
+
+//Tests that request IDs are echoed back once negotiated.
+func TestHelloRequestIds(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "ids")
+
+	resp, err := client.Request("a1|INDEX|testpackage1|")
+	if (err != nil || resp != "a1|OK") {
+		t.Errorf("Request ID should be echoed back, got : %s", resp)
+	}
+	resp, err = client.Request("a2|QUERY|testpackage2|")
+	if (err != nil || resp != "a2|FAIL") {
+		t.Errorf("Request ID should be echoed back, got : %s", resp)
+	}
+	teardownTest()
+}
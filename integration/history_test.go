@@ -0,0 +1,54 @@
+package integration
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// QUERYAT|<package>|<moment> and DEPSAT|<package>|<moment> answer QUERY and DEPS as they would have at a
+// unix timestamp, or at a revision of the package as in rev:2, failing with NO_HISTORY if the history of
+// the index does not reach back that far.
+
+//Tests that earlier revisions of a package are answered from history.
+func TestHistory(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "extended")
+	client.Request("CLIENT|first|")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+	client.Request("CLIENT|second|")
+	client.Send("INDEX|testpackage2|")
+
+	resp, err := client.Request("DEPSAT|testpackage2|rev:1")
+	if (err != nil || resp != "OK|testpackage1") {
+		t.Errorf("Dependencies of first revision should be listed, got : %s", resp)
+	}
+	resp, err = client.Request("DEPSAT|testpackage2|rev:2")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Current revision should have no dependencies, got : %s", resp)
+	}
+	resp, err = client.Request("QUERYAT|testpackage2|" + strconv.FormatInt(time.Now().Unix() + 60, 10))
+	if (err != nil || !strings.HasPrefix(resp, "OK|2|") || strings.Split(resp, "|")[4] != "second") {
+		t.Errorf("Package should be indexed at current revision by its client, got : %s", resp)
+	}
+	resp, err = client.Request("QUERYAT|testpackage2|rev:1")
+	if (err != nil || !strings.HasPrefix(resp, "OK|1|") || strings.Split(resp, "|")[4] != "first") {
+		t.Errorf("First revision should be answered with the client that indexed it, got : %s", resp)
+	}
+	resp, err = client.Request("QUERYAT|testpackage2|0")
+	if (err != nil || resp != "FAIL|NO_HISTORY") {
+		t.Errorf("History should not reach back to 1970, got : %s", resp)
+	}
+	resp, err = client.Request("QUERYAT|neverindexed|rev:1")
+	if (err != nil || resp != "FAIL|NOT_INDEXED") {
+		t.Errorf("Package never indexed should not be indexed, got : %s", resp)
+	}
+	teardownTest()
+}
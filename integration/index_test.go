@@ -157,3 +157,40 @@ func BenchmarkIndexMessage(b *testing.B) {
 
 	teardownTest()
 }
+
+// Tests that re-indexing a package keeps the packages that depend on it - we test this by
+// attempting to remove the re-indexed package, which should still fail.
+func TestReindexPreservesParents(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+	client.Send("INDEX|testpackage3|testpackage2")
+
+	//re-index testpackage2 with the same dependencies, and then with none.
+	respCode, err := client.Send("INDEX|testpackage2|testpackage1")
+	if (err != nil || respCode != OK) {
+		t.Error("Package update should succeed")
+	}
+	respCode, err = client.Send("INDEX|testpackage2|")
+	if (err != nil || respCode != OK) {
+		t.Error("Package update should succeed")
+	}
+
+	//removal of testpackage2 should fail, as testpackage3 still depends on it.
+	respCode, err = client.Send("REMOVE|testpackage2|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Removal of re-indexed package should fail while others depend on it")
+	}
+	//while removal of testpackage1 should succeed, as testpackage2 no longer depends on it.
+	respCode, err = client.Send("REMOVE|testpackage1|")
+	if (err != nil || respCode != OK) {
+		t.Error("Dependency removal should succeed now since nothing depends on it")
+	}
+	teardownTest()
+}
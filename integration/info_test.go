@@ -0,0 +1,40 @@
+package integration
+
+import (
+	"strings"
+	"testing"
+)
+
+// INFO|<package>| returns `OK|<revision>|<indexed>|<updated>|<client>|<queried>\n` with unix times,
+// where queried is 0 if the package has never been queried.  CLIENT|<name>| names the connection,
+// which is otherwise identified by its remote address.
+
+//Tests that updating a package increases its revision, recording the client that updated it.
+func TestInfo(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|")
+
+	resp, err := client.Request("INFO|testpackage2|")
+	if (err != nil || !strings.HasPrefix(resp, "OK|1|") || !strings.HasSuffix(resp, "|0")) {
+		t.Errorf("Newly indexed package should be at revision 1, got : %s", resp)
+	}
+	client.Request("CLIENT|integration|")
+	client.Send("INDEX|testpackage2|testpackage1")
+	client.Send("QUERY|testpackage2|")
+	resp, err = client.Request("INFO|testpackage2|")
+	if (err != nil || !strings.HasPrefix(resp, "OK|2|") || !strings.Contains(resp, "|integration|") || strings.HasSuffix(resp, "|0")) {
+		t.Errorf("Updated package should be at revision 2, got : %s", resp)
+	}
+	resp, err = client.Request("INFO|testpackage3|")
+	if (err != nil || resp != "FAIL") {
+		t.Errorf("Package that is not indexed should have no info, got : %s", resp)
+	}
+	teardownTest()
+}
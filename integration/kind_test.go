@@ -0,0 +1,49 @@
+package integration
+
+import (
+	"testing"
+)
+
+// Dependencies may be preceded by their kind - runtime (the default), build or optional, as in
+// INDEX|app|lib,build:gcc,optional:docs.  FILTER|kinds|<kinds> restricts DEPS, WHY and WHYALL to
+// dependencies of the given kinds, or every kind if none are given.
+
+//Tests that optional dependencies need not be indexed, and do not prevent removal.
+func TestOptionalDependencies(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|")
+
+	respCode, err := client.Send("INDEX|testpackage3|build:testpackage1,optional:testpackage2,optional:testpackage4")
+	if (err != nil || respCode != OK) {
+		t.Error("Package should be indexed without its optional dependencies")
+	}
+	resp, err := client.Request("DEPS|testpackage3|")
+	if (err != nil || resp != "OK|build:testpackage1,optional:testpackage2,optional:testpackage4") {
+		t.Errorf("Dependencies should be listed with their kinds, got : %s", resp)
+	}
+	client.Request("FILTER|kinds|optional")
+	resp, err = client.Request("DEPS|testpackage3|")
+	if (err != nil || resp != "OK|optional:testpackage2,optional:testpackage4") {
+		t.Errorf("Dependencies should be filtered by kind, got : %s", resp)
+	}
+	resp, err = client.Request("WHY|testpackage3|testpackage1")
+	if (err != nil || resp != "FAIL") {
+		t.Errorf("Chains should only follow dependencies of the kinds allowed, got : %s", resp)
+	}
+	respCode, err = client.Send("REMOVE|testpackage2|")
+	if (err != nil || respCode != OK) {
+		t.Error("Optional dependency should be removed regardless of packages using it")
+	}
+	respCode, err = client.Send("REMOVE|testpackage1|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Build dependency should not be removed while packages depend on it")
+	}
+	teardownTest()
+}
@@ -0,0 +1,75 @@
+package integration
+
+import (
+	"strings"
+	"testing"
+)
+
+// NAMESPACE|create|<name> and NAMESPACE|drop|<name> require AUTH.  NAMESPACE|list| lists every namespace,
+// and NAMESPACE|stats|<name> returns `OK|<packages>|<requests>|<failures>|<errors>|<conflicts>\n`.
+// USE|<name>| selects the namespace a connection uses, and VERB@<name> applies a single request to one.
+
+//Tests that packages indexed in one namespace are independent of those in another.
+func TestNamespaces(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	authenticate(t, client)
+
+	resp, err := client.Request("NAMESPACE|create|integration")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Namespace should be created, got : %s", resp)
+	}
+	// the namespace is dropped even if the test fails, so that later runs can create it again.
+	defer client.Request("NAMESPACE|drop|integration")
+	resp, err = client.Request("NAMESPACE|list|")
+	if (err != nil || !listed(strings.TrimPrefix(resp, "OK|"), "default") || !listed(strings.TrimPrefix(resp, "OK|"), "integration")) {
+		t.Errorf("Namespaces should be listed, got : %s", resp)
+	}
+	client.Request("USE|integration|")
+	client.Send("INDEX|testpackage1|")
+	resp, err = client.Request("QUERY|testpackage1|")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Package should be indexed in namespace in use, got : %s", resp)
+	}
+	resp, err = client.Request("QUERY@default|testpackage1|")
+	if (err != nil || resp != "FAIL") {
+		t.Errorf("Package should not be indexed in default namespace, got : %s", resp)
+	}
+	resp, err = client.Request("NAMESPACE|stats|integration")
+	if (err != nil || resp != "OK|1|2|0|0|0") {
+		t.Errorf("Namespace statistics should be reported, got : %s", resp)
+	}
+	resp, err = client.Request("USE|missing|")
+	if (err != nil || resp != "FAIL") {
+		t.Errorf("Missing namespace should not be used, got : %s", resp)
+	}
+	resp, err = client.Request("NAMESPACE|drop|default")
+	if (err != nil || resp != "FAIL") {
+		t.Errorf("Default namespace should not be dropped, got : %s", resp)
+	}
+	resp, err = client.Request("NAMESPACE|drop|integration")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Namespace should be dropped, got : %s", resp)
+	}
+	resp, err = client.Request("QUERY|testpackage1|")
+	if (err != nil || resp != "FAIL") {
+		t.Errorf("Dropped namespace should no longer be used, got : %s", resp)
+	}
+	client.Request("USE|default|")
+	teardownTest()
+}
+
+// listed determines if a name is in a comma delimited list.
+func listed(list string, name string) bool {
+	for _, listedName := range strings.Split(list, ",") {
+		if listedName == name {
+			return true
+		}
+	}
+	return false
+}
@@ -82,3 +82,85 @@ func BenchmarkRemoveNonIndexedMessage(b *testing.B) {
 
 	teardownTest()
 }
+
+// CASCADE|A| removes A like REMOVE, and then every dependency left without parents, returning
+// `OK|<removed packages>\n`.
+
+//Tests cascading removal removes orphaned dependencies, but not those still depended on.
+func TestCascadeRemoval(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+	client.Send("INDEX|testpackage3|testpackage2")
+
+	respCode, err := client.Send("CASCADE|testpackage2|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Cascading remove should fail as another package depends on it")
+	}
+	resp, err := client.Request("CASCADE|testpackage3|")
+	if (err != nil || resp != "OK|testpackage3,testpackage2,testpackage1") {
+		t.Errorf("Package and orphaned dependencies should be removed, got : %s", resp)
+	}
+	respCode, err = client.Send("QUERY|testpackage1|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Orphaned dependency should no longer be indexed")
+	}
+	teardownTest()
+}
+
+// FORCE|A| removes A even if other packages depend on it, returning `OK|<broken dependents>\n`.
+// QUERY and DEPS of those dependents then report `BROKEN|<missing dependencies>`, although QUERY
+// only does so in extended response mode.
+
+//Tests forced removal of a package others depend on, and that they are reported broken.
+func TestForceRemoval(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+	defer teardownTest()
+
+	respCode, err := client.Send("FORCE|testpackage1|")
+	if (err != nil || respCode != ERROR) {
+		t.Error("Forced removal should be rejected before authenticating")
+	}
+	authenticate(t, client)
+	resp, err := client.Request("FORCE|testpackage1|")
+	if (err != nil || resp != "OK|testpackage2") {
+		t.Errorf("Forced removal should report broken dependents, got : %s", resp)
+	}
+	resp, err = client.Request("QUERY|testpackage2|")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Query should respond plainly outside extended mode, got : %s", resp)
+	}
+	client.Send("MODE|extended|")
+	resp, err = client.Request("QUERY|testpackage2|")
+	if (err != nil || resp != "OK|BROKEN|testpackage1") {
+		t.Errorf("Query should report broken package, got : %s", resp)
+	}
+	resp, err = client.Request("DEPS|testpackage2|")
+	if (err != nil || resp != "OK|testpackage1|BROKEN|testpackage1") {
+		t.Errorf("Deps should report broken package, got : %s", resp)
+	}
+
+	client.Send("INDEX|testpackage1|")
+	resp, err = client.Request("QUERY|testpackage2|")
+	if (err != nil || resp != "OK") {
+		t.Errorf("Package should no longer be broken once dependency is indexed, got : %s", resp)
+	}
+	resp, err = client.Request("REMOVE|testpackage1|")
+	if (err != nil || resp != "FAIL|HAS_PARENTS|testpackage2") {
+		t.Errorf("Package indexed again should not be removable while others depend on it, got : %s", resp)
+	}
+}
@@ -1,5 +1,9 @@
 package integration
 
+import (
+	"testing"
+)
+
 // setupTest removes test packages 1-4 to ensure that our testing environment is clean.
 func setupTest() {
 	client, err := MakeTCPPackageIndexClient(8080)
@@ -23,3 +27,25 @@ func teardownTest() {
 	client.Send("REMOVE|testpackage1|")
 	client.Close()
 }
+
+// adminToken is the token that the server must be started with, using -adminToken, for tests of
+// privileged operations to run.  Otherwise these tests will be skipped.
+const adminToken = "integration"
+
+// authenticate authenticates our client for privileged operations, skipping the test if the
+// server was not started with our admin token.
+func authenticate(t *testing.T, client PackageIndexerClient) {
+	respCode, err := client.Send("AUTH|" + adminToken + "|")
+	if (err != nil || respCode != OK) {
+		t.Skip("Server not started with integration admin token")
+	}
+}
+
+// hello negotiates version 2 of our protocol, with the given features, which every request type
+// other than INDEX, REMOVE and QUERY requires.
+func hello(t *testing.T, client PackageIndexerClient, features string) {
+	resp, err := client.Request("HELLO|2|" + features)
+	if (err != nil || resp != "OK|2|" + features) {
+		t.Fatalf("Protocol version should be negotiated, got : %s", resp)
+	}
+}
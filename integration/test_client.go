@@ -22,6 +22,8 @@ const (
 type PackageIndexerClient interface {
 	Close() error
 	Send(msg string) (ResponseCode, error)
+	Request(msg string) (string, error)
+	Pipeline(msgs []string, responses int) ([]string, error)
 }
 
 // TCPPackageIndexerClient connects to the running server via TCP
@@ -64,6 +66,42 @@ func (client *TCPPackageIndexerClient) Send(msg string) (ResponseCode, error) {
 	return UNKNOWN, fmt.Errorf("Error parsing message from server [%s]: %v", responseMsg, err)
 }
 
+//Request sends a message to the server and returns the full response line, including any payload.
+func (client *TCPPackageIndexerClient) Request(msg string) (string, error) {
+	_, err := fmt.Fprintln(client.conn, msg)
+
+	if err != nil {
+		return "", fmt.Errorf("Error sending message to server: %v", err)
+	}
+
+	responseMsg, err := bufio.NewReader(client.conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("Error reading response from server: %v", err)
+	}
+
+	return strings.TrimRight(responseMsg, "\n"), nil
+}
+
+//Pipeline sends several messages to the server in a single write, then reads the given number of response lines.
+func (client *TCPPackageIndexerClient) Pipeline(msgs []string, responses int) ([]string, error) {
+	_, err := fmt.Fprint(client.conn, strings.Join(msgs, "\n") + "\n")
+
+	if err != nil {
+		return nil, fmt.Errorf("Error sending messages to server: %v", err)
+	}
+
+	reader := bufio.NewReader(client.conn)
+	lines := make([]string, 0, responses)
+	for len(lines) < responses {
+		responseMsg, err := reader.ReadString('\n')
+		if err != nil {
+			return lines, fmt.Errorf("Error reading response from server: %v", err)
+		}
+		lines = append(lines, strings.TrimRight(responseMsg, "\n"))
+	}
+	return lines, nil
+}
+
 // MakeTCPPackageIndexClient returns a new instance of the client
 func MakeTCPPackageIndexClient(port int) (PackageIndexerClient, error) {
 	host := fmt.Sprintf("localhost:%d", port)
@@ -0,0 +1,85 @@
+package integration
+
+import (
+	"testing"
+)
+
+// Packages may be versioned as in foo@1.2.0, and dependencies may name a specific version.
+// QUERY|foo@*| and REMOVE|foo@*| refer to every version of a package.
+
+//Tests that versions of a package are indexed side by side, and removed together.
+func TestVersions(t *testing.T) {
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("REMOVE|testapp|")
+	client.Send("REMOVE|testversioned@*|")
+
+	respCode, err := client.Send("INDEX|testversioned@1.2.0|")
+	if (err != nil || respCode != OK) {
+		t.Error("Versioned package should be indexed")
+	}
+	client.Send("INDEX|testversioned@2.0.1|")
+	respCode, err = client.Send("INDEX|testapp|testversioned@2.0.1")
+	if (err != nil || respCode != OK) {
+		t.Error("Package depending on a specific version should be indexed")
+	}
+	respCode, err = client.Send("QUERY|testversioned|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Package without a version should not match versioned packages")
+	}
+	respCode, err = client.Send("QUERY|testversioned@*|")
+	if (err != nil || respCode != OK) {
+		t.Error("Package should be found if any version is indexed")
+	}
+	respCode, err = client.Send("REMOVE|testversioned@*|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Versions should not be removed while other packages depend on them")
+	}
+	respCode, err = client.Send("REMOVE|testversioned@1.2.0|")
+	if (err != nil || respCode != OK) {
+		t.Error("Single version should be removed")
+	}
+	client.Send("REMOVE|testapp|")
+	respCode, err = client.Send("REMOVE|testversioned@*|")
+	if (err != nil || respCode != OK) {
+		t.Error("Every version should be removed")
+	}
+	respCode, err = client.Send("QUERY|testversioned@2.0.1|")
+	if (err != nil || respCode != FAIL) {
+		t.Error("No versions should remain indexed")
+	}
+}
+
+//Tests that constrained dependencies resolve to the highest satisfying version.
+func TestConstrainedDependencies(t *testing.T) {
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("REMOVE|testapp|")
+	client.Send("REMOVE|testversioned@*|")
+	client.Send("INDEX|testversioned@1.2.0|")
+	client.Send("INDEX|testversioned@1.9.0|")
+	client.Send("INDEX|testversioned@2.0.1|")
+
+	respCode, err := client.Send("INDEX|testapp|testversioned>=1.2,<2")
+	if (err != nil || respCode != OK) {
+		t.Error("Package should be indexed when constraint is satisfied")
+	}
+	resp, err := client.Request("DEPS|testapp|")
+	if (err != nil || resp != "OK|testversioned@1.9.0") {
+		t.Errorf("Resolved version should be recorded, got : %s", resp)
+	}
+	respCode, err = client.Send("INDEX|testapp|testversioned>=3")
+	if (err != nil || respCode != FAIL) {
+		t.Error("Package should not be indexed when constraint is unsatisfied")
+	}
+	client.Send("REMOVE|testapp|")
+	client.Send("REMOVE|testversioned@*|")
+}
@@ -0,0 +1,52 @@
+package integration
+
+import (
+	"testing"
+)
+
+// WHY|A|B returns `OK|<chain>\n` with one shortest chain of dependencies leading from A to B,
+// or `FAIL\n` if B is not a dependency of A.  WHYALL|A|B returns every chain, up to a limit,
+// each separated by '|'.
+
+//Tests that a transitive dependency is explained by the chain leading to it.
+func TestWhyTransitive(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+	client.Send("INDEX|testpackage3|testpackage1,testpackage2")
+
+	resp, err := client.Request("WHY|testpackage3|testpackage1")
+	if (err != nil || resp != "OK|testpackage3,testpackage1") {
+		t.Errorf("Shortest chain should be returned, got : %s", resp)
+	}
+	resp, err = client.Request("WHYALL|testpackage3|testpackage1")
+	if (err != nil || resp != "OK|testpackage3,testpackage1|testpackage3,testpackage2,testpackage1") {
+		t.Errorf("All chains should be returned, got : %s", resp)
+	}
+	teardownTest()
+}
+
+//Tests that WHY fails when the target is not a dependency.
+func TestWhyUnreachable(t *testing.T) {
+	setupTest()
+	client, err := MakeTCPPackageIndexClient(8080)
+	if (err != nil) {
+		t.SkipNow()
+	}
+	defer client.Close()
+	hello(t, client, "")
+	client.Send("INDEX|testpackage1|")
+	client.Send("INDEX|testpackage2|testpackage1")
+
+	respCode, err := client.Send("WHY|testpackage1|testpackage2")
+	if (err != nil || respCode != FAIL) {
+		t.Error("WHY should fail when target is not a dependency")
+	}
+	teardownTest()
+}
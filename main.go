@@ -2,9 +2,16 @@ package main
 
 import (
 	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
 	"github.com/kristenfelch/pkgindexer/input"
 	"github.com/kristenfelch/pkgindexer/operation"
+	"github.com/kristenfelch/pkgindexer/semver"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 	"flag"
 	"github.com/kristenfelch/pkgindexer/logging"
 )
@@ -18,11 +25,8 @@ type IndexService interface {
 }
 
 type SimpleIndexService struct {
-	remover operation.Remover
-	indexer operation.Indexer
-	querier operation.Querier
-	lock    data.IndexLock
-	gateway input.MessageGateway
+	namespaces *Namespaces
+	gateway    input.MessageGateway
 }
 
 func (s *SimpleIndexService) StartIndexing() (started bool, err error) {
@@ -37,46 +41,553 @@ func (s *SimpleIndexService) StartIndexing() (started bool, err error) {
 
 }
 
+// ProcessMessage processes a message within the namespace it applies to, holding the lock of that namespace.
+// Messages managing namespaces themselves are processed separately.  Messages reading a ConcurrentStore, or
+// making a single change to it, are processed alongside one another, sharing the lock, so only wait for batches
+// and other messages making several changes that must not interleave.
 func (s *SimpleIndexService) ProcessMessage(input *input.ValidatedMessage) {
-	s.lock.Lock()
 	respChan := input.ResponseChannel
+	switch input.Verb {
+	case "NAMESPACE":
+		respChan <- s.manageNamespace(input.Package, input.Dependencies)
+		return
+	case "DIFF":
+		s.diff(input.Package, input.Dependencies, respChan)
+		return
+	case "USE":
+		if _, exists := s.namespaces.Get(input.Package); !exists {
+			respChan <- "fail|" + operation.ReasonNoSuchNamespace
+			return
+		}
+		respChan <- "ok"
+		return
+	}
+
+	name := input.Namespace
+	if len(name) == 0 {
+		name = DefaultNamespace
+	}
+	namespace, exists := s.namespaces.Get(name)
+	if !exists {
+		respChan <- "fail|" + operation.ReasonNoSuchNamespace
+		return
+	}
+	if shared(namespace, input.InputMessage) {
+		go func() {
+			namespace.lock.RLock()
+			response := namespace.process(input)
+			namespace.lock.RUnlock()
+			namespace.stats.record(response)
+			respChan <- response
+		}()
+		return
+	}
+	namespace.lock.Lock()
+	if snapshot := s.snapshot(namespace, input.Verb); snapshot != nil {
+		namespace.lock.Unlock()
+		go func() {
+			response := snapshot.process(input)
+			namespace.stats.record(response)
+			respChan <- response
+		}()
+		return
+	}
+	response := namespace.process(input)
+	namespace.stats.record(response)
+	namespace.lock.Unlock()
+	respChan <- response
+}
+
+// snapshotVerbs are the long graph queries that only read our Index, so run against a snapshot without
+// holding the lock of their namespace.
+var snapshotVerbs = map[string]bool{"WHY": true, "WHYALL": true, "ORPHANS": true, "FSCK": true}
+
+// readVerbs are the messages that only read our Index, other than marking packages queried.
+var readVerbs = map[string]bool{
+	"QUERY": true, "INFO": true, "DEPS": true, "QUERYAT": true, "DEPSAT": true, "WHY": true, "WHYALL": true,
+	"ORPHANS": true, "FSCK": true, "DRYINDEX": true, "DRYREMOVE": true,
+}
+
+// concurrentVerbs are the messages making a single change to our Index, which a ConcurrentStore checks again is
+// allowed as it makes it.  REMOVE of every version of a Package makes several, so is not among them.
+var concurrentVerbs = map[string]bool{"INDEX": true, "REMOVE": true}
+
+// shared determines if a message can be processed while sharing the lock of namespace, as it only reads, or
+// makes a single change to, a store that is safe for concurrent use.
+func shared(namespace *Namespace, message *input.InputMessage) bool {
+	concurrent, ok := namespace.store.(data.ConcurrentStore)
+	if !ok || !concurrent.Concurrent() {
+		return false
+	}
+	if concurrentVerbs[message.Verb] {
+		return !strings.HasSuffix(message.Package, "@*")
+	}
+	return readVerbs[message.Verb]
+}
+
+// snapshot returns a Namespace reading a snapshot of the store of namespace, or nil if the message must be
+// processed by namespace itself, holding its lock.
+func (s *SimpleIndexService) snapshot(namespace *Namespace, verb string) *Namespace {
+	snapshotter, ok := namespace.store.(data.Snapshotter)
+	if !ok || !snapshotVerbs[verb] {
+		return nil
+	}
+	store, snapshotErr := snapshotter.Snapshot()
+	if snapshotErr != nil {
+		return nil
+	}
+	return NewNamespace(store, s.namespaces.pathLimit, s.namespaces.logger)
+}
+
+// process returns the response to a single message within a Namespace.
+func (n *Namespace) process(input *input.ValidatedMessage) string {
 	var response bool
+	var conflict bool
+	var payload string
 	var err error
 
 	switch input.Verb {
 	case "REMOVE":
-		response, err = s.remover.Remove(input.Package)
+		response, err = n.remover.Remove(input.Package)
+		if !response && err == nil {
+			payload, err = n.removeFailure(input.Package)
+		}
+
+	case "CASCADE":
+		var removed []string
+		response, removed, err = n.remover.RemoveCascade(input.Package)
+		payload = strings.Join(removed, ",")
+		if !response && err == nil {
+			payload, err = n.removeFailure(input.Package)
+		}
+
+	case "FORCE":
+		var dependents []string
+		dependents, err = n.remover.ForceRemove(input.Package)
+		response = true
+		payload = strings.Join(dependents, ",")
 
 	case "INDEX":
-		var splitDeps []string
-		if len(input.Dependencies) > 0 {
-			splitDeps = strings.Split(input.Dependencies, ",")
-		} else {
-			splitDeps = make([]string, 0)
+		deps := splitDependencies(input.Dependencies)
+		response, err = n.indexer.Index(input.Package, deps, input.Client)
+		if !response && err == nil {
+			var reason string
+			var missing []string
+			_, reason, missing, err = n.indexer.CheckIndex(input.Package, deps)
+			payload = reasonPayload(reason, missing)
+		}
+
+	case "CASINDEX":
+		deps := splitDependencies(input.Dependencies)
+		var current operation.PackageState
+		response, conflict, current, err = n.indexer.CompareAndIndex(input.Package, deps, operation.ParsePackageState(input.Expected), input.Client)
+		if conflict {
+			payload = current.String()
+		} else if !response && err == nil {
+			var reason string
+			var missing []string
+			_, reason, missing, err = n.indexer.CheckIndex(input.Package, deps)
+			payload = reasonPayload(reason, missing)
+		}
+
+	case "DRYINDEX":
+		var reason string
+		var missing []string
+		response, reason, missing, err = n.indexer.CheckIndex(input.Package, splitDependencies(input.Dependencies))
+		payload = reasonPayload(reason, missing)
+
+	case "DRYREMOVE":
+		var reason string
+		var parents []string
+		response, reason, parents, err = n.remover.CheckRemove(input.Package)
+		payload = reasonPayload(reason, parents)
+
+	case "ORPHANS":
+		var filter operation.OrphanFilter
+		var orphans []string
+		filter, _, err = operation.ParseOrphanFilter(input.Package, input.Dependencies)
+		if err == nil {
+			orphans, err = n.collector.Orphans(filter)
+		}
+		response = true
+		payload = strings.Join(orphans, ",")
+
+	case "GC":
+		var filter operation.OrphanFilter
+		var apply bool
+		var collected []string
+		filter, apply, err = operation.ParseOrphanFilter(input.Package, input.Dependencies)
+		if err == nil {
+			collected, err = n.collector.Collect(filter, apply)
+		}
+		response = true
+		payload = strings.Join(collected, ",")
+
+	case "FSCK":
+		var problems []data.Problem
+		problems, err = n.check()
+		response = len(problems) == 0
+		formatted := make([]string, len(problems))
+		for i, problem := range problems {
+			formatted[i] = problem.String()
+		}
+		payload = strings.Join(formatted, "|")
+
+	case "COMMIT":
+		var failed int
+		var reason string
+		var packages []string
+		response, failed, reason, packages, err = n.batcher.Apply(batchOperations(input.Batch, input.Client))
+		payload = strconv.Itoa(len(input.Batch))
+		if !response {
+			payload = operation.ReasonBatchFailed + "|" + strconv.Itoa(failed) + "|" + reasonPayload(reason, packages)
 		}
-		response, err = s.indexer.Index(input.Package, splitDeps)
 
 	case "QUERY":
-		response, err = s.querier.Query(input.Package)
+		response, err = n.querier.Query(input.Package)
+		if response && err == nil {
+			payload, err = n.brokenPayload(input.Package)
+		} else {
+			payload = operation.ReasonNotIndexed
+		}
+
+	case "QUERYAT":
+		var moment data.Moment
+		var info *data.PackageInfo
+		var reason string
+		moment, err = operation.ParseMoment(input.Dependencies)
+		if err == nil {
+			response, info, reason, err = n.historian.QueryAt(input.Package, moment)
+		}
+		payload = reason
+		if response {
+			payload = infoPayload(info)
+		}
+
+	case "DEPSAT":
+		var moment data.Moment
+		var deps []string
+		var reason string
+		moment, err = operation.ParseMoment(input.Dependencies)
+		if err == nil {
+			response, deps, reason, err = n.historian.DependenciesAt(input.Package, moment, input.Kinds)
+		}
+		payload = reason
+		if response {
+			payload = strings.Join(deps, ",")
+		}
+
+	case "INFO":
+		var info *data.PackageInfo
+		response, info, err = n.querier.Info(input.Package)
+		if response && err == nil {
+			payload = infoPayload(info)
+		} else {
+			payload = operation.ReasonNotIndexed
+		}
+
+	case "DEPS":
+		var deps []string
+		response, deps, err = n.querier.Dependencies(input.Package, input.Kinds)
+		if response && err == nil {
+			var broken string
+			broken, err = n.brokenPayload(input.Package)
+			payload = strings.Join(deps, ",")
+			if len(broken) > 0 {
+				payload += "|" + broken
+			}
+		} else {
+			payload = operation.ReasonNotIndexed
+		}
+
+	case "WHY":
+		var chain []string
+		chain, err = n.explainer.Explain(input.Package, input.Dependencies, input.Kinds)
+		response = len(chain) > 0
+		payload = strings.Join(chain, ",")
+		if !response {
+			payload = operation.ReasonNotADependency
+		}
+
+	case "WHYALL":
+		var chains [][]string
+		chains, err = n.explainer.ExplainAll(input.Package, input.Dependencies, input.Kinds)
+		response = len(chains) > 0
+		joined := make([]string, len(chains))
+		for i, chain := range chains {
+			joined[i] = strings.Join(chain, ",")
+		}
+		payload = strings.Join(joined, "|")
+		if !response {
+			payload = operation.ReasonNotADependency
+		}
 	}
 
 	if err != nil {
-		respChan <- "error"
-	} else {
-		if response {
-			respChan <- "ok"
+		return errorResponse(err)
+	} else if conflict {
+		return "conflict|" + payload
+	} else if response {
+		if len(payload) > 0 {
+			return "ok|" + payload
+		}
+		return "ok"
+	}
+	if len(payload) > 0 {
+		return "fail|" + payload
+	}
+	return "fail"
+}
+
+// manageNamespace creates, drops, lists or reports the statistics of namespaces.
+// Statistics are reported as the number of packages indexed, then the number of requests, failures,
+// errors and conflicts.
+func (s *SimpleIndexService) manageNamespace(command string, name string) string {
+	if command != "list" && len(name) == 0 {
+		return "error|" + err.CodeInvalidArgument + "|Namespace name missing : " + command
+	}
+	switch command {
+	case "create":
+		created, createErr := s.namespaces.Create(name)
+		if createErr != nil {
+			return errorResponse(createErr)
+		}
+		if !created {
+			return "fail|" + operation.ReasonNamespaceExists
+		}
+		return "ok"
+	case "drop":
+		if name == DefaultNamespace {
+			return "fail|" + operation.ReasonDefaultNamespace
+		}
+		dropped, dropErr := s.namespaces.Drop(name)
+		if dropErr != nil {
+			return errorResponse(dropErr)
+		}
+		if !dropped {
+			return "fail|" + operation.ReasonNoSuchNamespace
+		}
+		return "ok"
+	case "list":
+		return "ok|" + strings.Join(s.namespaces.List(), ",")
+	case "stats":
+		namespace, exists := s.namespaces.Get(name)
+		if !exists {
+			return "fail|" + operation.ReasonNoSuchNamespace
+		}
+		namespace.lock.Lock()
+		defer namespace.lock.Unlock()
+		packages, listErr := namespace.store.ListPackages()
+		if listErr != nil {
+			return errorResponse(listErr)
+		}
+		return "ok|" + strings.Join(append([]string{strconv.Itoa(len(packages))}, namespace.stats.counts()...), "|")
+	}
+	return "error|" + err.CodeInvalidArgument + "|Unknown namespace command : " + command
+}
+
+// diff compares two indexes, each named as a namespace as it is now, or as it was at a unix timestamp as in
+// staging@1500000000.  Both are snapshotted before the next message is processed, so are consistent with one
+// another, and compared without holding the lock of either namespace.
+func (s *SimpleIndexService) diff(from string, to string, respChan chan<- string) {
+	before, response := s.indexState(from)
+	if before == nil {
+		respChan <- response
+		return
+	}
+	after, response := s.indexState(to)
+	if after == nil {
+		respChan <- response
+		return
+	}
+	go func() {
+		diff, diffErr := data.Diff(before, after)
+		if diffErr != nil {
+			respChan <- errorResponse(diffErr)
+			return
+		}
+		respChan <- diffPayload(diff)
+	}()
+}
+
+// indexState snapshots the index named, or returns nil along with the response explaining why it cannot.
+func (s *SimpleIndexService) indexState(named string) (state data.IndexStore, response string) {
+	name, when := named, ""
+	if i := strings.Index(named, "@"); i != -1 {
+		name, when = named[:i], named[i+1:]
+	}
+	namespace, exists := s.namespaces.Get(name)
+	if !exists {
+		return nil, "fail|" + operation.ReasonNoSuchNamespace
+	}
+	var at time.Time
+	if len(when) > 0 {
+		seconds, parseErr := strconv.ParseInt(when, 10, 64)
+		if parseErr != nil || seconds < 0 {
+			return nil, "error|" + err.CodeInvalidArgument + "|Timestamp is incorrectly formatted : " + when
+		}
+		at = time.Unix(seconds, 0)
+	}
+	state, known, stateErr := namespace.snapshot(at)
+	if stateErr != nil {
+		return nil, errorResponse(stateErr)
+	}
+	if !known {
+		return nil, "fail|" + operation.ReasonNoHistory
+	}
+	return state, ""
+}
+
+// diffPayload reports each package that differs between two indexes, delimited by '|', as +A if added, -A if
+// removed, or ~A,+B,-C if the dependencies of A changed, followed by each dependency added or removed.
+func diffPayload(diff *data.IndexDiff) string {
+	if diff.Empty() {
+		return "ok"
+	}
+	entries := make([]string, 0, len(diff.Added) + len(diff.Removed) + len(diff.Changed))
+	for _, name := range diff.Added {
+		entries = append(entries, "+" + name)
+	}
+	for _, name := range diff.Removed {
+		entries = append(entries, "-" + name)
+	}
+	for _, change := range diff.Changed {
+		entry := "~" + change.Name
+		for _, dep := range change.Added {
+			entry += ",+" + dep
+		}
+		for _, dep := range change.Removed {
+			entry += ",-" + dep
+		}
+		entries = append(entries, entry)
+	}
+	return "ok|" + strings.Join(entries, "|")
+}
+
+// removeFailure explains why a Package could not be removed, listing the parents that depend on it.
+func (n *Namespace) removeFailure(name string) (payload string, err error) {
+	_, reason, parents, err := n.remover.CheckRemove(name)
+	return reasonPayload(reason, parents), err
+}
+
+// errorResponse describes an error that occurred processing a message.
+func errorResponse(failure error) string {
+	return "error|" + err.Describe(failure)
+}
+
+// splitDependencies splits a comma delimited list of dependencies.  Constraints on a dependency's
+// version may themselves be comma delimited, as in bar>=1.2,<2, so a part beginning with a constraint
+// continues the dependency before it.
+func splitDependencies(dependencies string) []string {
+	split := make([]string, 0)
+	if len(dependencies) == 0 {
+		return split
+	}
+	for _, part := range strings.Split(dependencies, ",") {
+		if len(split) > 0 && semver.IsConstraint(part) {
+			split[len(split)-1] += "," + part
 		} else {
-			respChan <- "fail"
+			split = append(split, part)
 		}
 	}
+	return split
+}
 
-	s.lock.Unlock()
+// batchOperations converts a batch of messages into the operations to apply on behalf of client.
+func batchOperations(batch []*input.InputMessage, client string) []operation.BatchOperation {
+	operations := make([]operation.BatchOperation, len(batch))
+	for i, message := range batch {
+		operations[i] = operation.BatchOperation{
+			Verb:         message.Verb,
+			Package:      message.Package,
+			Dependencies: splitDependencies(message.Dependencies),
+			Client:       client,
+		}
+	}
+	return operations
+}
+
+// reasonPayload reports the reason for the outcome of an operation, followed by the packages involved.
+func reasonPayload(reason string, packages []string) string {
+	if len(packages) > 0 {
+		return reason + "|" + strings.Join(packages, ",")
+	}
+	return reason
+}
+
+// infoPayload reports the revision of a Package, the unix times it was indexed and last updated,
+// the client that last updated it, and the unix time it was last queried, or 0 if never.
+func infoPayload(info *data.PackageInfo) string {
+	queried := int64(0)
+	if !info.Queried.IsZero() {
+		queried = info.Queried.Unix()
+	}
+	return strings.Join([]string{
+		strconv.Itoa(info.Revision),
+		strconv.FormatInt(info.Indexed.Unix(), 10),
+		strconv.FormatInt(info.Updated.Unix(), 10),
+		info.Client,
+		strconv.FormatInt(queried, 10),
+	}, "|")
+}
+
+// brokenPayload reports the dependencies of a Package that were forcibly removed, if any.
+func (n *Namespace) brokenPayload(name string) (payload string, err error) {
+	broken, err := n.querier.Broken(name)
+	if err != nil || len(broken) == 0 {
+		return "", err
+	}
+	return "BROKEN|" + strings.Join(broken, ","), nil
+}
+
+// persist saves our namespaces to a file every interval, if any, and when our service is stopped,
+// so that they can be loaded again when our service restarts.
+func (s *SimpleIndexService) persist(path string, interval time.Duration, logger logging.Logger) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+	for {
+		select {
+		case <-tick:
+			s.save(path, logger)
+		case <-stop:
+			s.save(path, logger)
+			os.Exit(0)
+		}
+	}
+}
+
+// save saves our namespaces to a file, each holding its lock so that it is saved in a consistent state.
+func (s *SimpleIndexService) save(path string, logger logging.Logger) {
+	if saveErr := data.SaveFile(s.namespaces, path); saveErr != nil {
+		logger.Error(saveErr.Error())
+		return
+	}
+	logger.Debug("Index saved to " + path)
+}
+
+// isPersistent determines if a store can be saved to a file.
+func isPersistent(store data.IndexStore) bool {
+	_, ok := store.(data.PersistentStore)
+	return ok
 }
 
 // Main method reads input parameters throttle/logLevel, and starts up our service.
 func main() {
 	throttle := flag.Int("throttle", 0, "limit on max messages/second from each given")
 	logLevel := flag.String("logLevel", "INFO", "log level")
+	adminToken := flag.String("adminToken", "", "token clients send with AUTH to use privileged operations, which are disabled if empty")
+	pathLimit := flag.Int("pathLimit", 10, "limit on dependency chains returned by WHYALL, 0 for the most allowed")
+	dataFile := flag.String("dataFile", "", "file the index is loaded from at startup and saved to, which is not persisted if empty")
+	saveInterval := flag.Int("saveInterval", 60, "seconds between saves of the index to dataFile, 0 to only save when stopped")
+	storeName := flag.String("store", data.DefaultBackend, "storage backend for the index, one of " + strings.Join(data.BackendNames(), ", "))
+	storeOptions := flag.String("storeOptions", "", "options for the storage backend, such as the location of its database")
+	namespaceNames := flag.String("namespaces", "", "comma delimited namespaces to create at startup, in addition to the default namespace")
+	historyAge := flag.Int("historyAge", int(data.DefaultRetention.Age / time.Second), "seconds changes to packages are kept in the history of the index, 0 for no limit")
+	historyChanges := flag.Int("historyChanges", data.DefaultRetention.Changes, "limit on changes to packages kept in the history of each namespace, 0 for no limit")
 	flag.Parse()
 	logger := logging.NewIndexLogger(logLevel)
 
@@ -86,13 +597,48 @@ func main() {
 		throttle = &maxThrottle
 	}
 
-	var store = data.NewIndexStore(logger)
+	namespaces, storeErr := NewNamespaces(func(namespace string) (data.IndexStore, error) {
+		store, backendErr := data.NewBackendStore(*storeName, namespace, *storeOptions, logger)
+		if historical, ok := store.(data.HistoricalStore); ok {
+			historical.Retain(data.Retention{Age: time.Duration(*historyAge) * time.Second, Changes: *historyChanges})
+		}
+		return store, backendErr
+	}, *pathLimit, logger)
+	if storeErr != nil {
+		logger.Error(storeErr.Error())
+		os.Exit(1)
+	}
 	service := &SimpleIndexService{
-		operation.NewRemover(store, logger),
-		operation.NewIndexer(store, logger),
-		operation.NewQuerier(store),
-		data.NewLock(),
-		input.NewMessageGateway(throttle, logger),
+		namespaces,
+		input.NewMessageGateway(throttle, *adminToken, logger),
+	}
+	if len(*dataFile) > 0 {
+		if defaults, _ := namespaces.Get(DefaultNamespace); !isPersistent(defaults.store) {
+			logger.Error("Storage backend " + *storeName + " cannot be saved to dataFile")
+			os.Exit(1)
+		}
+		loaded, loadErr := data.LoadFile(namespaces, *dataFile)
+		if loadErr != nil {
+			logger.Error(loadErr.Error())
+			os.Exit(1)
+		}
+		if loaded {
+			logger.Info("Index loaded from " + *dataFile)
+			// persisted data may have been edited, or saved by an older version, so make sure it is consistent.
+			if !namespaces.Check() {
+				logger.Error("Index loaded from " + *dataFile + " is inconsistent, see FSCK")
+			}
+		}
+		go service.persist(*dataFile, time.Duration(*saveInterval) * time.Second, logger)
+	}
+	for _, name := range strings.Split(*namespaceNames, ",") {
+		if len(name) == 0 {
+			continue
+		}
+		if _, createErr := namespaces.Create(name); createErr != nil {
+			logger.Error(createErr.Error())
+			os.Exit(1)
+		}
 	}
 	logger.Info("Indexing service starting on port 8080...")
 
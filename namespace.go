@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+	"github.com/kristenfelch/pkgindexer/operation"
+)
+
+// DefaultNamespace is the Namespace that requests use unless they select another, and which always exists.
+const DefaultNamespace = "default"
+
+// Namespace is an independent index of packages, with its own store, lock and statistics, so that
+// a single service can index several distributions.
+type Namespace struct {
+	store     data.IndexStore
+	remover   operation.Remover
+	indexer   operation.Indexer
+	querier   operation.Querier
+	explainer operation.Explainer
+	collector operation.Collector
+	historian operation.Historian
+	batcher   operation.Batcher
+	lock      data.IndexLock
+	stats     *NamespaceStats
+}
+
+// NamespaceStats counts the requests processed by a Namespace, by their outcome.
+type NamespaceStats struct {
+	Requests  int
+	Failures  int
+	Errors    int
+	Conflicts int
+	// lock is held while requests are counted, as requests processed alongside one another are counted at once.
+	lock sync.Mutex
+}
+
+// counts returns the number of requests, failures, errors and conflicts, formatted as the stats command reports them.
+func (n *NamespaceStats) counts() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return []string{strconv.Itoa(n.Requests), strconv.Itoa(n.Failures), strconv.Itoa(n.Errors), strconv.Itoa(n.Conflicts)}
+}
+
+// record counts a request by the status of its response.
+func (n *NamespaceStats) record(response string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.Requests++
+	switch strings.SplitN(response, "|", 2)[0] {
+	case "fail":
+		n.Failures++
+	case "error":
+		n.Errors++
+	case "conflict":
+		n.Conflicts++
+	}
+}
+
+// NewNamespace creates a new Namespace over a store, with the operations our service performs on it.
+func NewNamespace(store data.IndexStore, pathLimit int, logger logging.Logger) *Namespace {
+	remover := operation.NewRemover(store, logger)
+	indexer := operation.NewIndexer(store, logger)
+	return &Namespace{
+		store,
+		remover,
+		indexer,
+		operation.NewQuerier(store),
+		operation.NewExplainer(store, pathLimit, logger),
+		operation.NewCollector(store, logger),
+		operation.NewHistorian(store, logger),
+		operation.NewBatcher(store, indexer, remover, logger),
+		data.NewLock(),
+		&NamespaceStats{},
+	}
+}
+
+// check checks the consistency of our store, returning every Problem found.
+func (n *Namespace) check() (problems []data.Problem, error error) {
+	checkable, ok := n.store.(data.Checkable)
+	if !ok {
+		return nil, err.NewIndexError("Index store cannot be checked")
+	}
+	return checkable.Check()
+}
+
+// save writes our store to w, holding our lock while it is saved.  A store that can be snapshotted holds our
+// lock only while the snapshot is taken, so writes continue while the snapshot is saved.
+func (n *Namespace) save(persistent data.PersistentStore, w io.Writer) (error error) {
+	n.lock.Lock()
+	if snapshotter, ok := persistent.(data.Snapshotter); ok {
+		if snapshot, snapshotErr := snapshotter.Snapshot(); snapshotErr == nil {
+			n.lock.Unlock()
+			return snapshot.(data.PersistentStore).Save(w)
+		}
+	}
+	defer n.lock.Unlock()
+	return persistent.Save(w)
+}
+
+// snapshot returns an immutable snapshot of our store, as it is now if at is zero, and otherwise as it was at
+// that time, indicating if our store knows it, which a store keeping no history never does.  Our lock is only
+// held while the snapshot is taken, or for a store that cannot be snapshotted, while it is copied.
+func (n *Namespace) snapshot(at time.Time) (snapshot data.IndexStore, known bool, error error) {
+	snapshotter, ok := n.store.(data.Snapshotter)
+	if !ok {
+		if !at.IsZero() {
+			return nil, false, nil
+		}
+		n.lock.Lock()
+		snapshot, error = data.CopySnapshot(n.store)
+		n.lock.Unlock()
+		return snapshot, true, error
+	}
+	n.lock.Lock()
+	snapshot, error = snapshotter.Snapshot()
+	n.lock.Unlock()
+	if error != nil || at.IsZero() {
+		return snapshot, true, error
+	}
+	historical, ok := snapshot.(data.HistoricalStore)
+	if !ok {
+		return nil, false, nil
+	}
+	return historical.IndexAt(at)
+}
+
+// Namespaces holds every Namespace our service indexes, which always includes DefaultNamespace.
+// Each Namespace is created with its own store.
+type Namespaces struct {
+	namespaces map[string]*Namespace
+	newStore   func(namespace string) (data.IndexStore, error)
+	pathLimit  int
+	logger     logging.Logger
+	lock       *sync.RWMutex
+}
+
+// Get finds a Namespace by name, indicating if it exists.
+func (n *Namespaces) Get(name string) (namespace *Namespace, exists bool) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	namespace, exists = n.namespaces[name]
+	return namespace, exists
+}
+
+// Create creates a new Namespace, indicating if it did not already exist.
+func (n *Namespaces) Create(name string) (created bool, error error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if _, exists := n.namespaces[name]; exists {
+		return false, nil
+	}
+	store, storeErr := n.newStore(name)
+	if storeErr != nil {
+		return false, storeErr
+	}
+	n.namespaces[name] = NewNamespace(store, n.pathLimit, n.logger)
+	n.logger.Info(fmt.Sprintf("Namespace %s created", name))
+	return true, nil
+}
+
+// Drop discards a Namespace along with every package indexed in it, indicating if it existed.
+// DefaultNamespace cannot be dropped.  A store keeping its packages outside our process discards them,
+// once requests already holding the lock of the Namespace are done, and the Namespace is kept if it cannot.
+func (n *Namespaces) Drop(name string) (dropped bool, error error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	namespace, exists := n.namespaces[name]
+	if !exists || name == DefaultNamespace {
+		return false, nil
+	}
+	if droppable, ok := namespace.store.(data.Droppable); ok {
+		namespace.lock.Lock()
+		dropErr := droppable.Drop()
+		namespace.lock.Unlock()
+		if dropErr != nil {
+			return false, dropErr
+		}
+	}
+	delete(n.namespaces, name)
+	n.logger.Info(fmt.Sprintf("Namespace %s dropped", name))
+	return true, nil
+}
+
+// List returns the name of every Namespace, sorted.
+func (n *Namespaces) List() (names []string) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	names = make([]string, 0, len(n.namespaces))
+	for name := range n.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save writes the store of every Namespace to w, holding the lock of each Namespace while it is saved, or
+// while it is snapshotted.
+func (n *Namespaces) Save(w io.Writer) (error error) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	saved := make(map[string]json.RawMessage, len(n.namespaces))
+	for name, namespace := range n.namespaces {
+		persistent, ok := namespace.store.(data.PersistentStore)
+		if !ok {
+			return err.NewIndexError("Index store cannot be persisted")
+		}
+		var buffer bytes.Buffer
+		if saveErr := namespace.save(persistent, &buffer); saveErr != nil {
+			return saveErr
+		}
+		saved[name] = buffer.Bytes()
+	}
+	return json.NewEncoder(w).Encode(saved)
+}
+
+// Load replaces every Namespace with those previously saved to r.  An index saved before namespaces
+// existed is loaded into DefaultNamespace.
+func (n *Namespaces) Load(r io.Reader) (error error) {
+	var saved map[string]json.RawMessage
+	if decodeErr := json.NewDecoder(r).Decode(&saved); decodeErr != nil {
+		return err.NewIndexError("Unable to load saved namespaces : " + decodeErr.Error())
+	}
+	if _, legacy := saved["Packages"]; legacy {
+		contents, _ := json.Marshal(saved)
+		saved = map[string]json.RawMessage{DefaultNamespace: contents}
+	}
+	namespaces := make(map[string]*Namespace, len(saved))
+	for name, contents := range saved {
+		store, storeErr := n.newStore(name)
+		if storeErr != nil {
+			return storeErr
+		}
+		persistent, ok := store.(data.PersistentStore)
+		if !ok {
+			return err.NewIndexError("Index store cannot be persisted")
+		}
+		if loadErr := persistent.Load(bytes.NewReader(contents)); loadErr != nil {
+			return loadErr
+		}
+		namespaces[name] = NewNamespace(store, n.pathLimit, n.logger)
+	}
+	if _, exists := namespaces[DefaultNamespace]; !exists {
+		store, storeErr := n.newStore(DefaultNamespace)
+		if storeErr != nil {
+			return storeErr
+		}
+		namespaces[DefaultNamespace] = NewNamespace(store, n.pathLimit, n.logger)
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.namespaces = namespaces
+	return nil
+}
+
+// Check checks the consistency of every Namespace, logging each Problem found, and indicating if
+// every Namespace is consistent.
+func (n *Namespaces) Check() (consistent bool) {
+	consistent = true
+	for _, name := range n.List() {
+		namespace, exists := n.Get(name)
+		if !exists {
+			continue
+		}
+		namespace.lock.Lock()
+		problems, checkErr := namespace.check()
+		namespace.lock.Unlock()
+		if checkErr != nil {
+			n.logger.Error(checkErr.Error())
+			return false
+		}
+		for _, problem := range problems {
+			n.logger.Error(fmt.Sprintf("Namespace %s is inconsistent : %s", name, problem.String()))
+			consistent = false
+		}
+	}
+	return consistent
+}
+
+// NewNamespaces creates our Namespaces, initially only DefaultNamespace, creating the store for
+// each Namespace with newStore.
+func NewNamespaces(newStore func(namespace string) (data.IndexStore, error), pathLimit int, logger logging.Logger) (namespaces *Namespaces, error error) {
+	namespaces = &Namespaces{
+		make(map[string]*Namespace),
+		newStore,
+		pathLimit,
+		logger,
+		&sync.RWMutex{},
+	}
+	if _, createErr := namespaces.Create(DefaultNamespace); createErr != nil {
+		return nil, createErr
+	}
+	return namespaces, nil
+}
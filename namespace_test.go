@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/input"
+	"github.com/kristenfelch/pkgindexer/logging"
+	"github.com/kristenfelch/pkgindexer/operation"
+)
+
+func newTestNamespaces() *Namespaces {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	namespaces, _ := NewNamespaces(func(namespace string) (data.IndexStore, error) {
+		return data.NewIndexStore(logger), nil
+	}, 10, logger)
+	return namespaces
+}
+
+// newTestBackendNamespaces creates namespaces whose stores are created by a storage backend.
+func newTestBackendNamespaces(backend string) *Namespaces {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	namespaces, _ := NewNamespaces(func(namespace string) (data.IndexStore, error) {
+		return data.NewBackendStore(backend, namespace, "", logger)
+	}, 10, logger)
+	return namespaces
+}
+
+// Tests creating, listing and dropping namespaces.
+func TestNamespacesCreateDrop(t *testing.T) {
+	namespaces := newTestNamespaces()
+	created, err := namespaces.Create("staging")
+	if (err != nil || !created) {
+		t.Error("Namespace should be created")
+	}
+	if created, _ = namespaces.Create("staging"); (created) {
+		t.Error("Namespace should only be created once")
+	}
+	if list := namespaces.List(); (len(list) != 2 || list[0] != "default" || list[1] != "staging") {
+		t.Errorf("Namespaces should be listed : %v", list)
+	}
+	if dropped, _ := namespaces.Drop(DefaultNamespace); (dropped) {
+		t.Error("Default namespace should not be dropped")
+	}
+	if dropped, _ := namespaces.Drop("staging"); (!dropped) {
+		t.Error("Existing namespace should be dropped")
+	}
+	if dropped, _ := namespaces.Drop("staging"); (dropped) {
+		t.Error("Namespace should only be dropped once")
+	}
+	if _, exists := namespaces.Get("staging"); exists {
+		t.Error("Dropped namespace should not exist")
+	}
+}
+
+// droppableStore is an IndexStore recording whether it was dropped, failing to be dropped if given an error.
+type droppableStore struct {
+	data.IndexStore
+	dropped bool
+	dropErr error
+}
+
+func (d *droppableStore) Drop() (error error) {
+	d.dropped = d.dropErr == nil
+	return d.dropErr
+}
+
+// Tests that stores keeping packages outside our process discard them when their namespace is dropped, and
+// that a namespace whose store cannot be dropped is kept.
+func TestNamespacesDropStore(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	stores := make(map[string]*droppableStore)
+	namespaces, _ := NewNamespaces(func(namespace string) (data.IndexStore, error) {
+		stores[namespace] = &droppableStore{data.NewIndexStore(logger), false, nil}
+		return stores[namespace], nil
+	}, 10, logger)
+	namespaces.Create("staging")
+	namespaces.Create("testing")
+	stores["testing"].dropErr = err.NewIndexError("Database unreachable")
+
+	if dropped, dropErr := namespaces.Drop("staging"); (!dropped || dropErr != nil || !stores["staging"].dropped) {
+		t.Error("Store of dropped namespace should be dropped")
+	}
+	if dropped, dropErr := namespaces.Drop("testing"); (dropped || dropErr == nil) {
+		t.Error("Failure to drop store should be reported")
+	}
+	if _, exists := namespaces.Get("testing"); (!exists) {
+		t.Error("Namespace whose store was not dropped should be kept")
+	}
+}
+
+// Tests that namespaces index packages independently.
+func TestNamespacesIndependent(t *testing.T) {
+	namespaces := newTestNamespaces()
+	namespaces.Create("staging")
+	staging, _ := namespaces.Get("staging")
+	staging.store.AddPackage("lib", []string{})
+	defaults, _ := namespaces.Get(DefaultNamespace)
+	if has, _ := defaults.store.HasPackage("lib"); has {
+		t.Error("Package should only be indexed in its namespace")
+	}
+}
+
+// Tests that every namespace is saved and loaded, along with indexes saved before namespaces existed.
+func TestNamespacesSaveLoad(t *testing.T) {
+	namespaces := newTestNamespaces()
+	namespaces.Create("staging")
+	staging, _ := namespaces.Get("staging")
+	staging.store.AddPackage("lib", []string{})
+	var saved bytes.Buffer
+	if err := namespaces.Save(&saved); (err != nil) {
+		t.Fatal(err)
+	}
+	loaded := newTestNamespaces()
+	if err := loaded.Load(&saved); (err != nil) {
+		t.Fatal(err)
+	}
+	staging, exists := loaded.Get("staging")
+	if has, _ := staging.store.HasPackage("lib"); (!exists || !has) {
+		t.Error("Namespace should be loaded with its packages")
+	}
+
+	logLevel := "FATAL"
+	store := data.NewIndexStore(logging.NewIndexLogger(&logLevel)).(data.PersistentStore)
+	store.AddPackage("base", []string{})
+	saved.Reset()
+	store.Save(&saved)
+	loaded = newTestNamespaces()
+	if err := loaded.Load(&saved); (err != nil) {
+		t.Fatal(err)
+	}
+	defaults, _ := loaded.Get(DefaultNamespace)
+	if has, _ := defaults.store.HasPackage("base"); (!has || len(loaded.List()) != 1) {
+		t.Error("Index saved before namespaces should be loaded into default namespace")
+	}
+}
+
+// Tests that requests are counted by outcome.
+func TestNamespaceStats(t *testing.T) {
+	stats := &NamespaceStats{}
+	for _, response := range []string{"ok", "ok|lib", "fail", "fail|NOT_INDEXED", "error|INTERNAL|oops", "conflict|lib"} {
+		stats.record(response)
+	}
+	if (stats.Requests != 6 || stats.Failures != 2 || stats.Errors != 1 || stats.Conflicts != 1) {
+		t.Errorf("Requests should be counted by outcome : %v", stats.counts())
+	}
+}
+
+// process sends a message to service, returning its response.
+func process(service *SimpleIndexService, verb string, name string, deps string) string {
+	responses := make(chan string, 1)
+	message := &input.InputMessage{Verb: verb, Package: name, Dependencies: deps}
+	service.ProcessMessage(&input.ValidatedMessage{InputMessage: message, ResponseChannel: responses})
+	return <-responses
+}
+
+// Tests that graph queries read a snapshot, seeing every change made before them and counted like any request.
+func TestProcessSnapshot(t *testing.T) {
+	service := &SimpleIndexService{newTestNamespaces(), nil}
+	process(service, "INDEX", "base", "")
+	process(service, "INDEX", "lib", "base")
+	process(service, "INDEX", "app", "lib")
+	if response := process(service, "WHY", "app", "base"); (response != "ok|app,lib,base") {
+		t.Errorf("Graph queries should see every change before them, got %s", response)
+	}
+	process(service, "REMOVE", "app", "")
+	if response := process(service, "ORPHANS", "0", ""); (response != "ok|lib") {
+		t.Errorf("Graph queries should see changes after earlier snapshots, got %s", response)
+	}
+	namespace, _ := service.namespaces.Get(DefaultNamespace)
+	if (namespace.stats.Requests != 6) {
+		t.Errorf("Graph queries should be counted, got %v", namespace.stats.counts())
+	}
+}
+
+// Tests that reads and single changes of a sharded namespace share its lock, so are answered while another
+// request holds it, while requests making several changes wait for every other request to finish.
+func TestProcessShared(t *testing.T) {
+	service := &SimpleIndexService{newTestBackendNamespaces(data.ShardedBackend), nil}
+	process(service, "INDEX", "base", "")
+	namespace, _ := service.namespaces.Get(DefaultNamespace)
+	namespace.lock.RLock()
+
+	responses := make(chan string, 2)
+	for _, message := range []*input.InputMessage{{Verb: "QUERY", Package: "base"}, {Verb: "INDEX", Package: "lib", Dependencies: "base"}} {
+		service.ProcessMessage(&input.ValidatedMessage{InputMessage: message, ResponseChannel: responses})
+		select {
+		case response := <-responses:
+			if (response != "ok") {
+				t.Errorf("%s should succeed, got %s", message.Verb, response)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s should share the lock of its namespace", message.Verb)
+		}
+	}
+	changed := make(chan string, 1)
+	go func() {
+		changed <- process(service, "CASCADE", "lib", "")
+	}()
+	select {
+	case <-changed:
+		t.Error("Several changes should wait for other requests to finish")
+	case <-time.After(50 * time.Millisecond):
+	}
+	namespace.lock.RUnlock()
+	if response := <-changed; (response != "ok|lib,base") {
+		t.Errorf("Packages should be removed once other requests finish, got %s", response)
+	}
+	if (namespace.stats.Requests != 4) {
+		t.Errorf("Shared requests should be counted, got %d requests", namespace.stats.Requests)
+	}
+}
+
+// Tests that clients indexing and removing packages of a sharded namespace at once, sharing its lock, leave
+// it consistent, as each change is checked again as it is made.
+func TestProcessSharedChanges(t *testing.T) {
+	service := &SimpleIndexService{newTestBackendNamespaces(data.ShardedBackend), nil}
+	for i := 0; i < 5; i++ {
+		process(service, "INDEX", fmt.Sprintf("base%d", i), "")
+	}
+	var wait sync.WaitGroup
+	for client := 0; client < 20; client++ {
+		wait.Add(1)
+		go func(client int) {
+			defer wait.Done()
+			for i := 0; i < 50; i++ {
+				switch (client + i) % 3 {
+				case 0:
+					process(service, "INDEX", fmt.Sprintf("lib%d", i % 5), fmt.Sprintf("base%d", client % 5))
+				case 1:
+					process(service, "REMOVE", fmt.Sprintf("base%d", i % 5), "")
+				case 2:
+					process(service, "INDEX", fmt.Sprintf("base%d", i % 5), "")
+				}
+			}
+		}(client)
+	}
+	wait.Wait()
+	if response := process(service, "FSCK", "", ""); (response != "ok") {
+		t.Errorf("Namespace should be consistent, got %s", response)
+	}
+}
+
+// Tests that namespaces are compared as they are, or as they were, and that unknown namespaces are reported.
+func TestProcessDiff(t *testing.T) {
+	service := &SimpleIndexService{newTestNamespaces(), nil}
+	service.namespaces.Create("testing")
+	process(service, "INDEX", "base", "")
+	process(service, "INDEX", "lib", "base")
+	message := &input.InputMessage{Verb: "INDEX", Package: "base", Namespace: "testing"}
+	responses := make(chan string, 1)
+	service.ProcessMessage(&input.ValidatedMessage{InputMessage: message, ResponseChannel: responses})
+	<-responses
+
+	if response := process(service, "DIFF", "testing", "default"); (response != "ok|+lib") {
+		t.Errorf("Namespaces should be compared, got %s", response)
+	}
+	if response := process(service, "DIFF", "default", "default"); (response != "ok") {
+		t.Errorf("Namespace should not differ from itself, got %s", response)
+	}
+	if response := process(service, "DIFF", "default@0", "default"); (response != "fail|" + operation.ReasonNoHistory) {
+		t.Errorf("History should not reach back before the namespace was created, got %s", response)
+	}
+	if response := process(service, "DIFF", "default", "missing"); (response != "fail|" + operation.ReasonNoSuchNamespace) {
+		t.Errorf("Unknown namespaces should be reported, got %s", response)
+	}
+}
+
+// Tests that a namespace whose store keeps no history is compared as it is now, and reported as never reaching
+// back to an earlier time or revision.
+func TestProcessNoHistory(t *testing.T) {
+	service := &SimpleIndexService{newTestBackendNamespaces(data.ShardedBackend), nil}
+	process(service, "INDEX", "base", "")
+	process(service, "NAMESPACE", "create", "staging")
+	if response := process(service, "DIFF", "default", "staging"); (response != "ok|-base") {
+		t.Errorf("Namespaces should be compared as they are now, got %s", response)
+	}
+	if response := process(service, "DIFF", fmt.Sprintf("default@%d", time.Now().Unix()), "default"); (response != "fail|" + operation.ReasonNoHistory) {
+		t.Errorf("Earlier indexes should not be known, got %s", response)
+	}
+	for _, verb := range []string{"QUERYAT", "DEPSAT"} {
+		if response := process(service, verb, "base", "rev:1"); (response != "fail|" + operation.ReasonNoHistory) {
+			t.Errorf("%s should report no history, got %s", verb, response)
+		}
+	}
+}
+
+// benchmarkService runs b.N requests through our service split across 100 concurrent clients, each indexing,
+// querying and removing its own packages, which depend on packages shared by every client.  Requests are
+// dispatched one at a time as StartIndexing does, so every backend is locked as our service locks it.
+func benchmarkService(b *testing.B, backend string) {
+	const clients = 100
+	const shared = 1000
+	service := &SimpleIndexService{newTestBackendNamespaces(backend), nil}
+	for i := 0; i < shared; i++ {
+		process(service, "INDEX", fmt.Sprintf("base%d", i), "")
+	}
+	messages := make(chan *input.ValidatedMessage)
+	defer close(messages)
+	go func() {
+		for message := range messages {
+			service.ProcessMessage(message)
+		}
+	}()
+	request := func(client int, i int) {
+		message := &input.InputMessage{Package: fmt.Sprintf("client%d-%d", client, i % 100)}
+		switch i % 4 {
+		case 0:
+			message.Verb, message.Dependencies = "INDEX", fmt.Sprintf("base%d", (client * 31 + i) % shared)
+		case 1:
+			message.Verb = "QUERY"
+		case 2:
+			message.Verb = "DEPS"
+		case 3:
+			message.Verb = "REMOVE"
+		}
+		responses := make(chan string, 1)
+		messages <- &input.ValidatedMessage{InputMessage: message, ResponseChannel: responses}
+		<-responses
+	}
+	b.ResetTimer()
+	var wait sync.WaitGroup
+	for client := 0; client < clients; client++ {
+		wait.Add(1)
+		go func(client int) {
+			defer wait.Done()
+			for i := client; i < b.N; i += clients {
+				request(client, i / clients)
+			}
+		}(client)
+	}
+	wait.Wait()
+}
+
+// Benchmarks our service over our in memory store at 100 clients.
+func BenchmarkMapsIndexStore100Clients(b *testing.B) {
+	benchmarkService(b, data.DefaultBackend)
+}
+
+// Benchmarks our service over our sharded store at 100 clients.
+func BenchmarkShardedIndexStore100Clients(b *testing.B) {
+	benchmarkService(b, data.ShardedBackend)
+}
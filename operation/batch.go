@@ -0,0 +1,88 @@
+package operation
+
+import (
+	"fmt"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// Batcher is responsible for applying a batch of INDEX and REMOVE operations all or nothing.
+// Operations are applied in order, so a Package may depend on another indexed earlier in the same
+// batch.  If any operation fails, every operation already applied is rolled back.
+type Batcher interface {
+	// indicates if every operation was applied.  Otherwise failed is the position of the first
+	// operation that failed, counting from 1, along with the reason why and the packages involved.
+	Apply(operations []BatchOperation) (applied bool, failed int, reason string, packages []string, err error)
+}
+
+// BatchOperation is a single INDEX or REMOVE operation within a batch, on behalf of a client.
+type BatchOperation struct {
+	Verb         string
+	Package      string
+	Dependencies []string
+	Client       string
+}
+
+type SimpleBatcher struct {
+	store   data.IndexStore
+	indexer Indexer
+	remover Remover
+	logger  logging.Logger
+}
+
+func (s *SimpleBatcher) Apply(operations []BatchOperation) (applied bool, failed int, reason string, packages []string, err error) {
+	packages = make([]string, 0)
+	if beginErr := s.store.Begin(); beginErr != nil {
+		s.logger.Error(beginErr.Error())
+		return false, 0, "", packages, beginErr
+	}
+	for i, operation := range operations {
+		applied, reason, packages, err = s.apply(operation)
+		if err != nil || !applied {
+			if rollbackErr := s.store.Rollback(); rollbackErr != nil {
+				s.logger.Error(rollbackErr.Error())
+				return false, i + 1, reason, packages, rollbackErr
+			}
+			return false, i + 1, reason, packages, err
+		}
+	}
+	if commitErr := s.store.Commit(); commitErr != nil {
+		s.logger.Error(commitErr.Error())
+		return false, 0, "", packages, commitErr
+	}
+	s.logger.Debug(fmt.Sprintf("Applied batch of %d operations", len(operations)))
+	return true, 0, "", make([]string, 0), nil
+}
+
+// apply applies a single operation, explaining why it failed if it could not be applied.
+func (s *SimpleBatcher) apply(operation BatchOperation) (applied bool, reason string, packages []string, error error) {
+	switch operation.Verb {
+	case "INDEX":
+		applied, error = s.indexer.Index(operation.Package, operation.Dependencies, operation.Client)
+		if applied || error != nil {
+			return applied, "", make([]string, 0), error
+		}
+		_, reason, packages, error = s.indexer.CheckIndex(operation.Package, operation.Dependencies)
+		return false, reason, packages, error
+	case "REMOVE":
+		applied, error = s.remover.Remove(operation.Package)
+		if applied || error != nil {
+			return applied, "", make([]string, 0), error
+		}
+		_, reason, packages, error = s.remover.CheckRemove(operation.Package)
+		return false, reason, packages, error
+	}
+	return false, "", make([]string, 0), err.NewCodedIndexError(err.CodeUnknownVerb, fmt.Sprintf("Batch operation is not supported : %s", operation.Verb))
+}
+
+// NewBatcher creates a new Batcher referencing our Index data store, and the Indexer and Remover
+// that apply each operation.
+func NewBatcher(store data.IndexStore, indexer Indexer, remover Remover, logger logging.Logger) Batcher {
+	return &SimpleBatcher{
+		store,
+		indexer,
+		remover,
+		logger,
+	}
+}
@@ -0,0 +1,72 @@
+package operation
+
+import (
+	"testing"
+	"strings"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// newBatcher creates a Batcher over a store where lib depends on base.
+func newBatcher() (data.IndexStore, Batcher) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("base", nil)
+	store.AddPackage("lib", []string{"base"})
+	return store, NewBatcher(store, NewIndexer(store, logger), NewRemover(store, logger), logger)
+}
+
+// Tests that a batch may depend on packages indexed earlier in the same batch.
+func TestApplyBatch(t *testing.T) {
+	store, batcher := newBatcher()
+	applied, _, _, _, err := batcher.Apply([]BatchOperation{
+		{"INDEX", "util", []string{"base"}, "bot"},
+		{"INDEX", "app", []string{"lib", "util"}, "bot"},
+		{"REMOVE", "missing", nil, "bot"},
+	})
+	if (err != nil || !applied) {
+		t.Error("Batch should be applied")
+	}
+	for _, name := range []string{"util", "app"} {
+		if exists, _ := store.HasPackage(name); (!exists) {
+			t.Errorf("Package %s should be indexed after batch", name)
+		}
+	}
+}
+
+// Tests that a failing batch is rolled back, reporting the operation that failed and why.
+func TestApplyBatchRollback(t *testing.T) {
+	store, batcher := newBatcher()
+	applied, failed, reason, packages, err := batcher.Apply([]BatchOperation{
+		{"INDEX", "util", []string{"base"}, "bot"},
+		{"REMOVE", "lib", nil, "bot"},
+		{"INDEX", "app", []string{"lib", "other"}, "bot"},
+	})
+	if (err != nil || applied) {
+		t.Error("Batch should not be applied")
+	}
+	if (failed != 3 || reason != ReasonMissingDependencies || strings.Join(packages, ",") != "lib,other") {
+		t.Errorf("Incorrect failure reported : %d %s %v", failed, reason, packages)
+	}
+	if exists, _ := store.HasPackage("util"); (exists) {
+		t.Error("Package indexed by failed batch should not be indexed")
+	}
+	if exists, _ := store.HasPackage("lib"); (!exists) {
+		t.Error("Package removed by failed batch should still be indexed")
+	}
+	if parents, _ := store.GetParents("base"); (strings.Join(parents, ",") != "lib") {
+		t.Errorf("Parents should be restored after failed batch, got %v", parents)
+	}
+}
+
+// Tests that a failing removal reports the parents preventing it.
+func TestApplyBatchHasParents(t *testing.T) {
+	_, batcher := newBatcher()
+	applied, failed, reason, packages, err := batcher.Apply([]BatchOperation{
+		{"REMOVE", "base", nil, "bot"},
+	})
+	if (err != nil || applied || failed != 1 || reason != ReasonHasParents || strings.Join(packages, ",") != "lib") {
+		t.Errorf("Incorrect failure reported : %d %s %v", failed, reason, packages)
+	}
+}
@@ -0,0 +1,187 @@
+package operation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// Collector is responsible for finding orphans - indexed packages that were only ever indexed as dependencies
+// of other packages, none of which depends on them any longer - and garbage collecting them.  Packages that
+// no other package has ever required were indexed for their own sake, so are never orphans.  Removing an
+// orphan may in turn orphan its own dependencies, so collection removes packages in a safe order, always
+// removing dependents before their dependencies.
+type Collector interface {
+	// lists orphans matching filter, sorted by name.
+	Orphans(filter OrphanFilter) (orphans []string, err error)
+
+	// lists every package that garbage collection would remove, in the order it would remove them,
+	// actually removing them only if apply is set.
+	Collect(filter OrphanFilter, apply bool) (collected []string, err error)
+}
+
+// OrphanFilter restricts which orphans are found or collected.
+// Before, if set, excludes packages indexed at or after that time.
+// Unqueried excludes packages that have ever been queried.
+type OrphanFilter struct {
+	Before    time.Time
+	Unqueried bool
+}
+
+type SimpleCollector struct {
+	store  data.IndexStore
+	logger logging.Logger
+}
+
+func (s *SimpleCollector) Orphans(filter OrphanFilter) (orphans []string, err error) {
+	orphans = make([]string, 0)
+	names, err := s.store.ListPackages()
+	if err != nil {
+		s.logger.Error(err.Error())
+		return orphans, err
+	}
+	for _, name := range names {
+		hasParents, hasParentsErr := s.store.HasParents(name)
+		if hasParentsErr != nil {
+			s.logger.Error(hasParentsErr.Error())
+			return orphans, hasParentsErr
+		}
+		if hasParents {
+			continue
+		}
+		matches, matchesErr := s.matches(name, filter)
+		if matchesErr != nil {
+			s.logger.Error(matchesErr.Error())
+			return orphans, matchesErr
+		}
+		if matches {
+			orphans = append(orphans, name)
+		}
+	}
+	return orphans, nil
+}
+
+func (s *SimpleCollector) Collect(filter OrphanFilter, apply bool) (collected []string, err error) {
+	collected, err = s.Orphans(filter)
+	if err != nil {
+		return collected, err
+	}
+
+	// Work out which packages would be orphaned as collection proceeds, without touching our store,
+	// so that dry runs and real collection agree on exactly what is removed and in which order.
+	removed := make(map[string]bool, len(collected))
+	for _, name := range collected {
+		removed[name] = true
+	}
+	for i := 0; i < len(collected); i++ {
+		deps, depsErr := s.store.GetDependencies(collected[i])
+		if depsErr != nil {
+			s.logger.Error(depsErr.Error())
+			return collected, depsErr
+		}
+		for _, dep := range deps {
+			orphaned, orphanedErr := s.orphanedBy(dep, removed, filter)
+			if orphanedErr != nil {
+				s.logger.Error(orphanedErr.Error())
+				return collected, orphanedErr
+			}
+			if orphaned {
+				removed[dep] = true
+				collected = append(collected, dep)
+			}
+		}
+	}
+
+	if apply {
+		for _, name := range collected {
+			if _, removedErr := s.store.RemovePackage(name); removedErr != nil {
+				s.logger.Error(removedErr.Error())
+				return collected, removedErr
+			}
+		}
+		if len(collected) > 0 {
+			s.logger.Info(fmt.Sprintf("Garbage collected %s", strings.Join(collected, ",")))
+		}
+	}
+	return collected, nil
+}
+
+// orphanedBy determines if an indexed Package matching filter would be orphaned once every package
+// already in removed has been removed.
+func (s *SimpleCollector) orphanedBy(name string, removed map[string]bool, filter OrphanFilter) (orphaned bool, err error) {
+	if removed[name] {
+		return false, nil
+	}
+	exists, err := s.store.HasPackage(name)
+	if err != nil || !exists {
+		return false, err
+	}
+	parents, err := s.store.GetParents(name)
+	if err != nil {
+		return false, err
+	}
+	for _, parent := range parents {
+		if !removed[parent] {
+			return false, nil
+		}
+	}
+	return s.matches(name, filter)
+}
+
+// matches determines if an indexed Package was indexed as a dependency, and satisfies our filter.
+func (s *SimpleCollector) matches(name string, filter OrphanFilter) (matches bool, err error) {
+	info, err := s.store.GetInfo(name)
+	if err != nil {
+		return false, err
+	}
+	if !info.Required {
+		return false, nil
+	}
+	if !filter.Before.IsZero() && !info.Indexed.Before(filter.Before) {
+		return false, nil
+	}
+	if filter.Unqueried && !info.Queried.IsZero() {
+		return false, nil
+	}
+	return true, nil
+}
+
+// ParseOrphanFilter reads the arguments of orphan and garbage collection requests - a unix timestamp
+// that orphans must have been indexed before, or 0 for any time, and a comma delimited list of options.
+// Option 'unqueried' only includes orphans that have never been queried, and 'apply' indicates that
+// garbage should actually be collected rather than only reported.
+func ParseOrphanFilter(before string, options string) (filter OrphanFilter, apply bool, error error) {
+	seconds, parseErr := strconv.ParseInt(before, 10, 64)
+	if parseErr != nil || seconds < 0 {
+		return filter, false, err.NewCodedIndexError(err.CodeInvalidArgument, fmt.Sprintf("Timestamp is incorrectly formatted : %s", before))
+	}
+	if seconds > 0 {
+		filter.Before = time.Unix(seconds, 0)
+	}
+	if len(options) == 0 {
+		return filter, false, nil
+	}
+	for _, option := range strings.Split(options, ",") {
+		switch option {
+		case "unqueried":
+			filter.Unqueried = true
+		case "apply":
+			apply = true
+		default:
+			return filter, false, err.NewCodedIndexError(err.CodeInvalidArgument, fmt.Sprintf("Unknown option : %s", option))
+		}
+	}
+	return filter, apply, nil
+}
+
+// NewCollector creates a new Collector referencing our Index data store and logger.
+func NewCollector(store data.IndexStore, logger logging.Logger) Collector {
+	return &SimpleCollector{
+		store,
+		logger,
+	}
+}
@@ -0,0 +1,151 @@
+package operation
+
+import (
+	"testing"
+	"strings"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// newCollectorStore creates a store where lib depends on base and shared, tool also depends on shared, and
+// util is indexed alone.  app, which depended on lib, and cli, which depended on util, have been removed.
+func newCollectorStore() data.IndexStore {
+	logLevel := "FATAL"
+	store := data.NewIndexStore(logging.NewIndexLogger(&logLevel))
+	indexer := NewIndexer(store, logging.NewIndexLogger(&logLevel))
+	indexer.Index("base", nil, "test")
+	indexer.Index("shared", nil, "test")
+	indexer.Index("lib", []string{"base", "shared"}, "test")
+	indexer.Index("app", []string{"lib"}, "test")
+	indexer.Index("tool", []string{"shared"}, "test")
+	indexer.Index("util", nil, "test")
+	indexer.Index("cli", []string{"util"}, "test")
+	store.RemovePackage("app")
+	store.RemovePackage("cli")
+	return store
+}
+
+// Tests that packages indexed as dependencies which no others depend on any longer are found as orphans.
+func TestOrphans(t *testing.T) {
+	logLevel := "FATAL"
+	collector := &SimpleCollector{newCollectorStore(), logging.NewIndexLogger(&logLevel)}
+
+	orphans, err := collector.Orphans(OrphanFilter{})
+	if (err != nil || strings.Join(orphans, ",") != "lib,util") {
+		t.Errorf("Incorrect orphans found : %v", orphans)
+	}
+}
+
+// Tests that orphans can be restricted to those indexed before a time, or never queried.
+func TestOrphansFiltered(t *testing.T) {
+	logLevel := "FATAL"
+	store := newCollectorStore()
+	collector := &SimpleCollector{store, logging.NewIndexLogger(&logLevel)}
+
+	orphans, err := collector.Orphans(OrphanFilter{Before: time.Now().Add(-time.Hour)})
+	if (err != nil || len(orphans) != 0) {
+		t.Errorf("No orphans should be found indexed before an hour ago : %v", orphans)
+	}
+	store.MarkQueried("lib")
+	orphans, err = collector.Orphans(OrphanFilter{Before: time.Now().Add(time.Hour), Unqueried: true})
+	if (err != nil || strings.Join(orphans, ",") != "util") {
+		t.Errorf("Queried orphans should be excluded : %v", orphans)
+	}
+}
+
+// Tests that a dry run reports everything collection would remove, dependents first, without removing it.
+func TestCollectDryRun(t *testing.T) {
+	logLevel := "FATAL"
+	store := newCollectorStore()
+	collector := &SimpleCollector{store, logging.NewIndexLogger(&logLevel)}
+
+	collected, err := collector.Collect(OrphanFilter{}, false)
+	if (err != nil || strings.Join(collected, ",") != "lib,util,base") {
+		t.Errorf("Incorrect packages collected : %v", collected)
+	}
+	if exists, _ := store.HasPackage("lib"); !exists {
+		t.Error("Dry run should not remove any packages")
+	}
+}
+
+// Tests that collection removes orphans, and dependencies it orphans that also match the filter.
+func TestCollectApply(t *testing.T) {
+	logLevel := "FATAL"
+	store := newCollectorStore()
+	collector := &SimpleCollector{store, logging.NewIndexLogger(&logLevel)}
+	store.MarkQueried("util")
+	store.MarkQueried("base")
+
+	collected, err := collector.Collect(OrphanFilter{Unqueried: true}, true)
+	if (err != nil || strings.Join(collected, ",") != "lib") {
+		t.Errorf("Incorrect packages collected : %v", collected)
+	}
+	for _, name := range []string{"tool", "base", "shared", "util"} {
+		if exists, _ := store.HasPackage(name); !exists {
+			t.Errorf("Package %s should not be collected", name)
+		}
+	}
+	if exists, _ := store.HasPackage("lib"); exists {
+		t.Error("Orphaned dependency should be collected")
+	}
+}
+
+// Tests that packages indexed for their own sake survive collection, along with everything they depend on,
+// and that only the dependencies they no longer need are collected once they are removed.
+func TestCollectKeepsIndexedRoots(t *testing.T) {
+	logLevel := "FATAL"
+	store := data.NewIndexStore(logging.NewIndexLogger(&logLevel))
+	indexer := NewIndexer(store, logging.NewIndexLogger(&logLevel))
+	collector := &SimpleCollector{store, logging.NewIndexLogger(&logLevel)}
+	indexer.Index("libc", nil, "test")
+	indexer.Index("zlib", []string{"libc"}, "test")
+	indexer.Index("app", []string{"zlib"}, "test")
+	indexer.Index("tool", []string{"libc"}, "test")
+
+	collected, err := collector.Collect(OrphanFilter{}, true)
+	if (err != nil || len(collected) != 0) {
+		t.Errorf("Packages indexed for their own sake should not be collected : %v", collected)
+	}
+	if names, _ := store.ListPackages(); (len(names) != 4) {
+		t.Errorf("Every package should survive collection : %v", names)
+	}
+	store.RemovePackage("app")
+	collected, err = collector.Collect(OrphanFilter{}, true)
+	if (err != nil || strings.Join(collected, ",") != "zlib") {
+		t.Errorf("Only dependencies no longer needed should be collected : %v", collected)
+	}
+}
+
+// Tests that an error listing packages is propagated.
+func TestOrphansError(t *testing.T) {
+	store := data.NewTestStore(true, nil, true, nil, true, err.NewIndexError("Error listing packages"), true, nil)
+	logLevel := "FATAL"
+	collector := &SimpleCollector{store, logging.NewIndexLogger(&logLevel)}
+
+	_, err := collector.Collect(OrphanFilter{}, true)
+	if (err == nil || strings.Index(err.Error(), "Error listing packages") == -1) {
+		t.Error("Error listing packages should be propagated")
+	}
+}
+
+// Tests parsing of orphan filter arguments.
+func TestParseOrphanFilter(t *testing.T) {
+	filter, apply, err := ParseOrphanFilter("0", "")
+	if (err != nil || apply || !filter.Before.IsZero() || filter.Unqueried) {
+		t.Error("Empty filter should match every orphan")
+	}
+	filter, apply, err = ParseOrphanFilter("1500000000", "unqueried,apply")
+	if (err != nil || !apply || filter.Before.Unix() != 1500000000 || !filter.Unqueried) {
+		t.Error("Filter options should be parsed")
+	}
+	_, _, err = ParseOrphanFilter("yesterday", "")
+	if (err == nil) {
+		t.Error("Incorrect timestamp should not be parsed")
+	}
+	_, _, err = ParseOrphanFilter("0", "everything")
+	if (err == nil) {
+		t.Error("Unknown option should not be parsed")
+	}
+}
@@ -0,0 +1,51 @@
+package operation
+
+import (
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// Explainer is responsible for explaining why one Package depends on another.
+// It answers with chains of dependencies leading from the Package to its (possibly transitive)
+// dependency, leaving traversal of the dependency graph itself to the data package.
+type Explainer interface {
+	// returns one shortest chain from name to target, empty if target is not a dependency of name,
+	// following only dependencies of the kinds allowed.
+	Explain(name string, target string, kinds data.KindFilter) (chain []string, err error)
+
+	// returns every chain from name to target up to our limit, shortest chains first,
+	// following only dependencies of the kinds allowed.
+	ExplainAll(name string, target string, kinds data.KindFilter) (chains [][]string, err error)
+}
+
+type SimpleExplainer struct {
+	store  data.IndexStore
+	limit  int
+	logger logging.Logger
+}
+
+func (s *SimpleExplainer) Explain(name string, target string, kinds data.KindFilter) (chain []string, err error) {
+	chain, err = data.ShortestPath(s.store, name, target, kinds)
+	if err != nil {
+		s.logger.Error(err.Error())
+	}
+	return chain, err
+}
+
+func (s *SimpleExplainer) ExplainAll(name string, target string, kinds data.KindFilter) (chains [][]string, err error) {
+	chains, err = data.Paths(s.store, name, target, s.limit, kinds)
+	if err != nil {
+		s.logger.Error(err.Error())
+	}
+	return chains, err
+}
+
+// NewExplainer creates a new Explainer referencing our Index data store, the maximum number of
+// chains returned by ExplainAll, and a logger.
+func NewExplainer(store data.IndexStore, limit int, logger logging.Logger) Explainer {
+	return &SimpleExplainer{
+		store,
+		limit,
+		logger,
+	}
+}
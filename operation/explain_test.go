@@ -0,0 +1,54 @@
+package operation
+
+import (
+	"testing"
+	"strings"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// Tests that the shortest chain is returned when target is a transitive dependency.
+func TestExplainTransitive(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("dep", nil)
+	store.AddPackage("mid", []string{"dep"})
+	store.AddPackage("lib", []string{"mid"})
+	explainer := &SimpleExplainer{store, 10, logger}
+
+	chain, err := explainer.Explain("lib", "dep", nil)
+	if (err != nil || strings.Join(chain, ",") != "lib,mid,dep") {
+		t.Errorf("Incorrect chain explaining transitive dependency : %v", chain)
+	}
+}
+
+// Tests that ExplainAll returns no more chains than its limit.
+func TestExplainAllLimited(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("dep", nil)
+	store.AddPackage("mid1", []string{"dep"})
+	store.AddPackage("mid2", []string{"dep"})
+	store.AddPackage("lib", []string{"mid1", "mid2", "dep"})
+	explainer := &SimpleExplainer{store, 2, logger}
+
+	chains, err := explainer.ExplainAll("lib", "dep", nil)
+	if (err != nil || len(chains) != 2 || strings.Join(chains[0], ",") != "lib,dep") {
+		t.Errorf("Incorrect chains explaining dependency : %v", chains)
+	}
+}
+
+// Tests that an error looking up packages is propagated.
+func TestExplainError(t *testing.T) {
+	store := data.NewTestStore(true, nil, true, nil, true, err.NewIndexError("Error looking up package"), true, nil)
+	logLevel := "FATAL"
+	explainer := &SimpleExplainer{store, 10, logging.NewIndexLogger(&logLevel)}
+
+	_, err := explainer.Explain("lib", "dep", nil)
+	if (err == nil || strings.Index(err.Error(), "Error looking up package") == -1) {
+		t.Error("Error looking up packages should be propagated")
+	}
+}
@@ -0,0 +1,110 @@
+package operation
+
+import (
+	"fmt"
+	"strconv"
+	"sort"
+	"strings"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/err"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// RevisionPrefix precedes a revision of a Package where a Moment is expected, as in rev:3.
+const RevisionPrefix = "rev:"
+
+// Historian is responsible for answering queries from the history of our Index.
+type Historian interface {
+	// indicates if element was indexed at moment, with the info it then had.  Reason explains why it
+	// could not be determined, which is when our history does not reach back to moment.
+	QueryAt(name string, moment data.Moment) (indexed bool, info *data.PackageInfo, reason string, err error)
+
+	// lists the direct dependencies of an element at moment of the kinds allowed, as Dependencies does.
+	DependenciesAt(name string, moment data.Moment, kinds data.KindFilter) (indexed bool, deps []string, reason string, err error)
+}
+
+type SimpleHistorian struct {
+	store  data.IndexStore
+	logger logging.Logger
+}
+
+// ParseMoment reads a Moment, either a unix timestamp or a revision, as in rev:3.
+func ParseMoment(moment string) (parsed data.Moment, error error) {
+	if strings.HasPrefix(moment, RevisionPrefix) {
+		revision, parseErr := strconv.Atoi(moment[len(RevisionPrefix):])
+		if parseErr != nil || revision < 1 {
+			return parsed, err.NewCodedIndexError(err.CodeInvalidArgument, fmt.Sprintf("Revision is incorrectly formatted : %s", moment))
+		}
+		parsed.Revision = revision
+		return parsed, nil
+	}
+	seconds, parseErr := strconv.ParseInt(moment, 10, 64)
+	if parseErr != nil || seconds < 0 {
+		return parsed, err.NewCodedIndexError(err.CodeInvalidArgument, fmt.Sprintf("Timestamp is incorrectly formatted : %s", moment))
+	}
+	parsed.Time = time.Unix(seconds, 0)
+	return parsed, nil
+}
+
+func (s *SimpleHistorian) QueryAt(name string, moment data.Moment) (indexed bool, info *data.PackageInfo, reason string, err error) {
+	lib, reason, err := s.packageAt(name, moment)
+	if lib == nil {
+		return false, nil, reason, err
+	}
+	return true, &lib.Info, "", nil
+}
+
+func (s *SimpleHistorian) DependenciesAt(name string, moment data.Moment, kinds data.KindFilter) (indexed bool, deps []string, reason string, err error) {
+	deps = make([]string, 0)
+	lib, reason, err := s.packageAt(name, moment)
+	if lib == nil {
+		return false, deps, reason, err
+	}
+	for _, dep := range sortedDependencies(lib) {
+		kind, ok := lib.Kinds[dep]
+		if !ok {
+			kind = data.KindRuntime
+		}
+		if kinds.Allows(kind) {
+			deps = append(deps, data.JoinDependencyKind(kind, dep))
+		}
+	}
+	return true, deps, "", nil
+}
+
+// packageAt finds the state of a Package at moment, or the reason it is not known to have been indexed.
+func (s *SimpleHistorian) packageAt(name string, moment data.Moment) (lib *data.Package, reason string, error error) {
+	historical, ok := s.store.(data.HistoricalStore)
+	if !ok {
+		// a store keeping no history never reaches back to any moment.
+		return nil, ReasonNoHistory, nil
+	}
+	lib, known, error := historical.PackageAt(name, moment)
+	if error != nil {
+		return nil, "", error
+	}
+	if !known {
+		s.logger.Debug(fmt.Sprintf("History of %s does not reach back far enough", name))
+		return nil, ReasonNoHistory, nil
+	}
+	if lib == nil {
+		return nil, ReasonNotIndexed, nil
+	}
+	return lib, "", nil
+}
+
+// sortedDependencies returns the dependencies of a Package sorted by name.
+func sortedDependencies(lib *data.Package) []string {
+	deps := make([]string, 0, len(lib.Dependencies))
+	for dep := range lib.Dependencies {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// NewHistorian creates a new Historian referencing our Index data store and logger.
+func NewHistorian(store data.IndexStore, logger logging.Logger) Historian {
+	return &SimpleHistorian{store, logger}
+}
@@ -0,0 +1,97 @@
+package operation
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/logging"
+)
+
+// Tests that moments are read as unix timestamps or revisions.
+func TestParseMoment(t *testing.T) {
+	if moment, err := ParseMoment("1500000000"); (err != nil || moment.Time.Unix() != 1500000000 || moment.Revision != 0) {
+		t.Errorf("Timestamp should be parsed, got %v", moment)
+	}
+	if moment, err := ParseMoment("rev:3"); (err != nil || moment.Revision != 3) {
+		t.Errorf("Revision should be parsed, got %v", moment)
+	}
+	for _, invalid := range []string{"", "yesterday", "-1", "rev:", "rev:0", "rev:x"} {
+		if _, err := ParseMoment(invalid); (err == nil) {
+			t.Errorf("Moment should be rejected : %s", invalid)
+		}
+	}
+}
+
+// Tests that earlier revisions of a package are answered from history, along with their dependencies.
+func TestHistorianRevisions(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+	store.AddTypedPackage("lib", []string{"dep2", "dep1"}, map[string]data.DependencyKind{"dep2": data.KindBuild})
+	store.UpdatePackage("lib", []string{"dep1"}, nil)
+	historian := NewHistorian(store, logger)
+
+	indexed, deps, reason, err := historian.DependenciesAt("lib", data.Moment{Revision: 1}, nil)
+	if (err != nil || !indexed || strings.Join(deps, ",") != "dep1,build:dep2") {
+		t.Errorf("Dependencies of earlier revision should be listed : %v %s", deps, reason)
+	}
+	indexed, deps, _, _ = historian.DependenciesAt("lib", data.Moment{Revision: 1}, data.KindFilter{data.KindRuntime: true})
+	if (!indexed || strings.Join(deps, ",") != "dep1") {
+		t.Errorf("Dependencies should be filtered by kind : %v", deps)
+	}
+	indexed, info, reason, err := historian.QueryAt("lib", data.Moment{Revision: 3})
+	if (err != nil || indexed || info != nil || reason != ReasonNotIndexed) {
+		t.Errorf("Revisions not made should not be indexed : %s", reason)
+	}
+	indexed, info, _, _ = historian.QueryAt("lib", data.Moment{Revision: 2})
+	if (!indexed || info.Revision != 2) {
+		t.Error("Current revision should be indexed")
+	}
+}
+
+// Tests that the client that indexed each revision of a package is answered from history, along with the
+// client that indexed it before the latest snapshot or transaction.
+func TestHistorianClients(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	indexer := NewIndexer(store, logger)
+	historian := NewHistorian(store, logger)
+	indexer.Index("base", nil, "alice")
+	indexer.Index("lib", nil, "alice")
+	store.(data.Snapshotter).Snapshot()
+	indexer.Index("lib", []string{"base"}, "bob")
+	store.Begin()
+	indexer.Index("app", []string{"lib"}, "carol")
+	store.Rollback()
+
+	if _, info, _, _ := historian.QueryAt("lib", data.Moment{Revision: 1}); (info == nil || info.Client != "alice") {
+		t.Errorf("Client of first revision should be answered, got %v", info)
+	}
+	if _, info, _, _ := historian.QueryAt("lib", data.Moment{Revision: 2}); (info == nil || info.Client != "bob") {
+		t.Errorf("Client of second revision should be answered, got %v", info)
+	}
+	if _, info, _, _ := historian.QueryAt("base", data.Moment{Time: time.Now()}); (info == nil || info.Client != "alice") {
+		t.Errorf("Package once required should be answered with the client that indexed it, got %v", info)
+	}
+	if indexed, _, _, _ := historian.QueryAt("app", data.Moment{Revision: 1}); (indexed) {
+		t.Error("Revisions rolled back should not be answered")
+	}
+}
+
+// Tests that queries before our history began are reported as such, as are queries of stores without history.
+func TestHistorianNoHistory(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	historian := NewHistorian(data.NewIndexStore(logger), logger)
+	if indexed, _, reason, err := historian.QueryAt("lib", data.Moment{}); (err != nil || indexed || reason != ReasonNoHistory) {
+		t.Errorf("History should not reach back before it began : %s", reason)
+	}
+	historian = NewHistorian(data.NewTestStore(true, nil, true, nil, true, nil, true, nil), logger)
+	if indexed, _, reason, err := historian.QueryAt("lib", data.Moment{Revision: 1}); (err != nil || indexed || reason != ReasonNoHistory) {
+		t.Errorf("Store without history should never reach back : %s", reason)
+	}
+}
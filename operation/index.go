@@ -1,16 +1,71 @@
 package operation
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"github.com/kristenfelch/pkgindexer/data"
 	"github.com/kristenfelch/pkgindexer/logging"
+	"github.com/kristenfelch/pkgindexer/semver"
 )
 
 // Indexer is responsible for adding a Package to our Index.
 // It encapsulates business logic for knowing when a Package can be added,
 // leaving actual storage of index to IndexStore.
 type Indexer interface {
-	// indicates if element was Indexed, err if we tried and failed.
-	Index(name string, dependencies []string) (Indexed bool, err error)
+	// indicates if element was Indexed on behalf of client, err if we tried and failed.
+	// Dependencies constrained to a range of versions, as in bar>=1.2,<2, are resolved to the highest
+	// indexed version satisfying the constraint, which is recorded as the dependency.
+	Index(name string, dependencies []string, client string) (Indexed bool, err error)
+
+	// indicates if element could be Indexed, without indexing it, along with the reason why
+	// and any dependencies that are missing.
+	CheckIndex(name string, dependencies []string) (indexable bool, reason string, missing []string, err error)
+
+	// indexes element only if its current state matches expected, so that concurrent updates are not
+	// silently lost.  Otherwise conflict is set, and current holds the state the client should expect instead.
+	CompareAndIndex(name string, dependencies []string, expected PackageState, client string) (Indexed bool, conflict bool, current PackageState, err error)
+}
+
+// PackageState is the state of a Package that a client expects when conditionally indexing it -
+// whether it is indexed, and if so its dependencies.
+type PackageState struct {
+	Indexed      bool
+	Dependencies []string
+}
+
+// Matches determines if two states are the same, regardless of the order of their dependencies.
+func (p PackageState) Matches(other PackageState) bool {
+	return p.String() == other.String()
+}
+
+// String formats a state as clients send it - '!' if the Package is not indexed, and otherwise
+// a sorted comma delimited list of its dependencies.
+func (p PackageState) String() string {
+	if !p.Indexed {
+		return "!"
+	}
+	deps := make([]string, 0, len(p.Dependencies))
+	seen := make(map[string]bool, len(p.Dependencies))
+	for _, dep := range p.Dependencies {
+		if !seen[dep] {
+			seen[dep] = true
+			deps = append(deps, dep)
+		}
+	}
+	sort.Strings(deps)
+	return strings.Join(deps, ",")
+}
+
+// ParsePackageState reads the state of a Package as clients send it.
+func ParsePackageState(state string) PackageState {
+	if state == "!" {
+		return PackageState{false, []string{}}
+	}
+	if len(state) == 0 {
+		return PackageState{true, []string{}}
+	}
+	return PackageState{true, strings.Split(state, ",")}
 }
 
 type SimpleIndexer struct {
@@ -18,35 +73,190 @@ type SimpleIndexer struct {
 	logger logging.Logger
 }
 
-func (s *SimpleIndexer) Index(name string, dependencies []string) (Indexed bool, err error) {
+func (s *SimpleIndexer) Index(name string, dependencies []string, client string) (Indexed bool, err error) {
+	indexable, reason, resolved, _, err := s.check(name, dependencies)
+	if err != nil || !indexable {
+		return false, err
+	}
+	deps, kinds, err := splitKinds(resolved)
+	if err != nil {
+		return false, err
+	}
+	if reason == ReasonUpdated {
+		// update in place, so that the packages depending on this one are kept.
+		Indexed, err = s.store.UpdatePackage(name, deps, kinds)
+	} else {
+		Indexed, err = s.store.AddTypedPackage(name, deps, kinds)
+	}
+	if err != nil || !Indexed {
+		return Indexed, err
+	}
+	// a package indexed again after being forcibly removed is required by the dependents it was restored to.
+	hasParents, err := s.store.HasParents(name)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return false, err
+	}
+	err = updateInfo(s.store, name, func(info *data.PackageInfo) {
+		info.Client = client
+		info.Required = info.Required || hasParents
+	})
+	if err != nil {
+		s.logger.Error(err.Error())
+		return false, err
+	}
+	if err = s.require(deps, kinds); err != nil {
+		s.logger.Error(err.Error())
+		return false, err
+	}
+	return true, nil
+}
+
+// require records that each dependency other than optional dependencies has been required by another
+// Package, so was indexed as a dependency, and may be garbage collected once nothing depends on it.
+func (s *SimpleIndexer) require(deps []string, kinds map[string]data.DependencyKind) (err error) {
+	for _, dep := range deps {
+		if kinds[dep] == data.KindOptional {
+			continue
+		}
+		exists, err := s.store.HasPackage(dep)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		info, err := s.store.GetInfo(dep)
+		if err != nil {
+			return err
+		}
+		if info.Required {
+			continue
+		}
+		err = updateInfo(s.store, dep, func(info *data.PackageInfo) {
+			info.Required = true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateInfo changes the info of an indexed Package, in place if our store is safe for concurrent use, so that
+// changes other clients make to it at the same time are not lost.
+func updateInfo(store data.IndexStore, name string, change func(info *data.PackageInfo)) (err error) {
+	if concurrent, ok := store.(data.ConcurrentStore); ok && concurrent.Concurrent() {
+		return concurrent.UpdateInfo(name, change)
+	}
+	info, err := store.GetInfo(name)
+	if err != nil {
+		return err
+	}
+	change(info)
+	return store.SetInfo(name, *info)
+}
+
+func (s *SimpleIndexer) CompareAndIndex(name string, dependencies []string, expected PackageState, client string) (Indexed bool, conflict bool, current PackageState, err error) {
+	current, err = s.state(name)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return false, false, current, err
+	}
+	if !current.Matches(expected) {
+		return false, true, current, nil
+	}
+	Indexed, err = s.Index(name, dependencies, client)
+	return Indexed, false, current, err
+}
+
+// state looks up the current state of a Package.
+func (s *SimpleIndexer) state(name string) (state PackageState, err error) {
+	exists, err := s.store.HasPackage(name)
+	if err != nil || !exists {
+		return PackageState{false, []string{}}, err
+	}
+	deps, err := typedDependencies(s.store, name, nil)
+	return PackageState{true, deps}, err
+}
+
+func (s *SimpleIndexer) CheckIndex(name string, dependencies []string) (indexable bool, reason string, missing []string, err error) {
+	indexable, reason, _, missing, err = s.check(name, dependencies)
+	return indexable, reason, missing, err
+}
+
+// check determines if a Package could be indexed, resolving each of its dependencies to the
+// Package it would depend on, along with its kind.  Optional dependencies need not be indexed.
+func (s *SimpleIndexer) check(name string, dependencies []string) (indexable bool, reason string, resolved []string, missing []string, err error) {
+	resolved = make([]string, 0, len(dependencies))
+	missing = make([]string, 0)
 	//// Check to see if all dependencies are present
 	for _, dep := range dependencies {
-		lib, libError := s.store.HasPackage(dep)
+		kind, spec, kindError := data.SplitDependencyKind(dep)
+		if kindError != nil {
+			return false, "", resolved, missing, kindError
+		}
+		id, lib, libError := s.resolve(spec)
 		if libError != nil {
 			//error determining if dependency is there, for indexing
 			s.logger.Error(libError.Error())
-			return false, libError
+			return false, "", resolved, missing, libError
 		}
-		if !lib {
+		if lib {
+			resolved = append(resolved, data.JoinDependencyKind(kind, id))
+		} else if kind != data.KindOptional {
 			//dependency is missing, not indexed
-			return false, nil
+			missing = append(missing, dep)
+		} else if _, constraint := splitConstraint(spec); len(constraint) == 0 {
+			//optional dependencies need not be indexed, though constraints can only be recorded once resolved.
+			resolved = append(resolved, dep)
 		}
 	}
+	if len(missing) > 0 {
+		return false, ReasonMissingDependencies, resolved, missing, nil
+	}
 
 	exists, existsErr := s.store.HasPackage(name)
 	if existsErr != nil {
 		// error looking up existing indexed package
 		s.logger.Error(existsErr.Error())
-		return false, existsErr
+		return false, "", resolved, missing, existsErr
 	}
 	if exists {
-		//remove package with old dependencies
-		s.store.RemovePackage(name)
-
+		return true, ReasonUpdated, resolved, missing, nil
 	}
+	return true, ReasonIndexed, resolved, missing, nil
+}
 
-	return s.store.AddPackage(name, dependencies)
-
+// resolve finds the indexed Package that a dependency refers to.  A dependency constrained to a range
+// of versions, as in bar>=1.2,<2, resolves to the highest indexed version satisfying the constraint.
+func (s *SimpleIndexer) resolve(dependency string) (id string, indexed bool, err error) {
+	name, constraint := splitConstraint(dependency)
+	if len(constraint) == 0 {
+		indexed, err = s.store.HasPackage(dependency)
+		return dependency, indexed, err
+	}
+	parsed, err := semver.ParseConstraint(constraint)
+	if err != nil {
+		return "", false, err
+	}
+	ids, err := s.store.GetVersions(name)
+	if err != nil {
+		return "", false, err
+	}
+	versions := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, version := data.SplitVersion(id); len(version) > 0 {
+			versions = append(versions, version)
+		}
+	}
+	version, found := parsed.Best(versions)
+	if !found {
+		return "", false, nil
+	}
+	id = data.JoinVersion(name, version)
+	s.logger.Trace(fmt.Sprintf("Dependency %s resolved to %s", dependency, id))
+	return id, true, nil
 }
 
 // NewIndexer creates a new Indexer referencing our Index data store and a logger.
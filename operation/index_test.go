@@ -14,7 +14,7 @@ func TestIndexSuccessful(t *testing.T) {
 	logLevel := "FATAL"
 	indexer := &SimpleIndexer{store, logging.NewIndexLogger(&logLevel)}
 
-	indexed, err := indexer.Index("lib", []string{"dep1", "dep2"})
+	indexed, err := indexer.Index("lib", []string{"dep1", "dep2"}, "")
 	if (err != nil || !indexed) {
 		t.Error("When all dependencies are present, package should be successfully indexed")
 	}
@@ -26,7 +26,7 @@ func TestDependencyMissing(t *testing.T) {
 	logLevel := "FATAL"
 	indexer := &SimpleIndexer{store, logging.NewIndexLogger(&logLevel)}
 
-	indexed, err := indexer.Index("lib", []string{"dep1", "dep2"})
+	indexed, err := indexer.Index("lib", []string{"dep1", "dep2"}, "")
 	if (err != nil || indexed) {
 		t.Error("When dependency is missing, should not be able to index")
 	}
@@ -38,8 +38,197 @@ func TestErrorCheckingDependencies(t *testing.T) {
 	logLevel := "FATAL"
 	indexer := &SimpleIndexer{store, logging.NewIndexLogger(&logLevel)}
 
-	indexed, err := indexer.Index("lib", []string{"dep1", "dep2"})
+	indexed, err := indexer.Index("lib", []string{"dep1", "dep2"}, "")
 	if (strings.Index(err.Error(), "Error checking for dependencies") == -1 || indexed) {
 		t.Error("Error checking for dependencies should be propagated, and indexing should not take place")
 	}
 }
+
+// Tests that checking an index reports every missing dependency, without indexing the package.
+func TestCheckIndexMissing(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("dep2", nil)
+	indexer := &SimpleIndexer{store, logger}
+
+	indexable, reason, missing, err := indexer.CheckIndex("lib", []string{"dep1", "dep2", "dep3"})
+	if (err != nil || indexable || reason != ReasonMissingDependencies || strings.Join(missing, ",") != "dep1,dep3") {
+		t.Errorf("Every missing dependency should be reported : %s %v", reason, missing)
+	}
+}
+
+// Tests that checking an index reports whether the package would be newly indexed or updated,
+// leaving the store untouched.
+func TestCheckIndexNewAndUpdated(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("dep", nil)
+	indexer := &SimpleIndexer{store, logger}
+
+	indexable, reason, _, err := indexer.CheckIndex("lib", []string{"dep"})
+	if (err != nil || !indexable || reason != ReasonIndexed) {
+		t.Errorf("Package should be reported as newly indexed : %s", reason)
+	}
+	if exists, _ := store.HasPackage("lib"); exists {
+		t.Error("Checking an index should not index the package")
+	}
+	if hasParents, _ := store.HasParents("dep"); hasParents {
+		t.Error("Checking an index should not change dependencies")
+	}
+
+	store.AddPackage("lib", nil)
+	indexable, reason, _, err = indexer.CheckIndex("lib", []string{"dep"})
+	if (err != nil || !indexable || reason != ReasonUpdated) {
+		t.Errorf("Package should be reported as updated : %s", reason)
+	}
+}
+
+// Tests that conditional indexing only succeeds when the package is in the state the client expects.
+func TestCompareAndIndex(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	indexer := &SimpleIndexer{store, logger}
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+
+	indexed, conflict, _, err := indexer.CompareAndIndex("lib", []string{"dep1"}, ParsePackageState("!"), "bot")
+	if (err != nil || !indexed || conflict) {
+		t.Error("Package should be indexed when expected not to be indexed yet")
+	}
+	indexed, conflict, current, err := indexer.CompareAndIndex("lib", []string{"dep2"}, ParsePackageState("!"), "bot")
+	if (err != nil || indexed || !conflict || current.String() != "dep1") {
+		t.Errorf("Conflict should be reported with current dependencies, got %s", current.String())
+	}
+	indexed, conflict, _, err = indexer.CompareAndIndex("lib", []string{"dep2"}, ParsePackageState("dep1,dep1"), "bot")
+	if (err != nil || !indexed || conflict) {
+		t.Error("Package should be updated when it has the expected dependencies")
+	}
+	indexed, conflict, _, err = indexer.CompareAndIndex("lib", []string{"dep3"}, ParsePackageState("dep2"), "bot")
+	if (err != nil || indexed || conflict) {
+		t.Error("Package should not be updated when dependencies are missing, without conflict")
+	}
+}
+
+// Tests formatting of package states.
+func TestPackageState(t *testing.T) {
+	if (ParsePackageState("!").String() != "!" || ParsePackageState("").String() != "") {
+		t.Error("Package states should be formatted as clients send them")
+	}
+	if (!ParsePackageState("b,a").Matches(PackageState{true, []string{"a", "b", "a"}})) {
+		t.Error("States should match regardless of the order of dependencies")
+	}
+	if (ParsePackageState("").Matches(ParsePackageState("!"))) {
+		t.Error("Package without dependencies should not match a package that is not indexed")
+	}
+}
+
+// Tests that updating a package increases its revision, keeping when it was first indexed.
+func TestIndexRevision(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	indexer := &SimpleIndexer{store, logger}
+	store.AddPackage("dep1", nil)
+
+	indexer.Index("lib", nil, "alice")
+	first, _ := store.GetInfo("lib")
+	if (first.Revision != 1 || first.Client != "alice") {
+		t.Errorf("Newly indexed package should be at revision 1, got %v", first)
+	}
+	indexer.Index("lib", []string{"dep1"}, "bob")
+	second, _ := store.GetInfo("lib")
+	if (second.Revision != 2 || second.Client != "bob" || !second.Indexed.Equal(first.Indexed) || second.Updated.Before(first.Updated)) {
+		t.Errorf("Updated package should be at revision 2, indexed at the same time, got %v", second)
+	}
+	indexer.Index("lib", []string{"missing"}, "carol")
+	third, _ := store.GetInfo("lib")
+	if (third.Revision != 2 || third.Client != "bob") {
+		t.Errorf("Failed update should not change revision, got %v", third)
+	}
+}
+
+// Tests that dependencies are recorded as required, other than optional dependencies, along with packages
+// indexed again for the dependents left dangling when they were forcibly removed.
+func TestIndexRequired(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	indexer := &SimpleIndexer{store, logger}
+	indexer.Index("base", nil, "alice")
+	indexer.Index("extra", nil, "alice")
+	indexer.Index("lib", []string{"base", "optional:extra"}, "alice")
+	if info, _ := store.GetInfo("base"); (!info.Required || info.Client != "alice") {
+		t.Errorf("Dependency should be required, keeping its client, got %v", info)
+	}
+	for _, name := range []string{"extra", "lib"} {
+		if info, _ := store.GetInfo(name); (info.Required) {
+			t.Errorf("Package %s should not be required", name)
+		}
+	}
+	store.ForceRemovePackage("base")
+	indexer.Index("base", nil, "bob")
+	if info, _ := store.GetInfo("base"); (!info.Required) {
+		t.Error("Package restored to its dependents should be required")
+	}
+}
+
+// Tests that constrained dependencies are resolved to the highest indexed version satisfying them.
+func TestIndexConstrainedDependencies(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	indexer := &SimpleIndexer{store, logger}
+	store.AddPackage("bar@1.0.0", nil)
+	store.AddPackage("bar@1.5.0", nil)
+	store.AddPackage("bar@2.0.0", nil)
+	store.AddPackage("bar", nil)
+	store.AddPackage("baz@0.1.0", nil)
+
+	indexed, err := indexer.Index("app", []string{"bar>=1.2,<2", "baz^0.1"}, "")
+	if (err != nil || !indexed) {
+		t.Error("Package should be indexed when constraints are satisfied")
+	}
+	if deps, _ := store.GetDependencies("app"); (strings.Join(deps, ",") != "bar@1.5.0,baz@0.1.0") {
+		t.Errorf("Resolved versions should be recorded as dependencies : %v", deps)
+	}
+	if parents, _ := store.GetParents("bar@1.5.0"); (strings.Join(parents, ",") != "app") {
+		t.Errorf("Resolved version should have package as a parent : %v", parents)
+	}
+
+	indexable, reason, missing, err := indexer.CheckIndex("tool", []string{"bar>=3", "bar"})
+	if (err != nil || indexable || reason != ReasonMissingDependencies || strings.Join(missing, ",") != "bar>=3") {
+		t.Errorf("Unsatisfied constraints should be missing : %s %v", reason, missing)
+	}
+	_, _, _, err = indexer.CheckIndex("tool", []string{"bar>=x"})
+	if (err == nil) {
+		t.Error("Malformed constraint should be an error")
+	}
+}
+
+// Tests that optional dependencies need not be indexed, and that kinds are recorded.
+func TestIndexTypedDependencies(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	indexer := &SimpleIndexer{store, logger}
+	store.AddPackage("lib", nil)
+	store.AddPackage("gcc@9.1.0", nil)
+
+	indexed, err := indexer.Index("app", []string{"lib", "build:gcc^9", "optional:docs", "optional:extras>=1"}, "")
+	if (err != nil || !indexed) {
+		t.Error("Package should be indexed without its optional dependencies")
+	}
+	if deps, _ := typedDependencies(store, "app", nil); (strings.Join(deps, ",") != "optional:docs,build:gcc@9.1.0,lib") {
+		t.Errorf("Dependencies should be recorded with their kinds : %v", deps)
+	}
+	indexable, _, missing, err := indexer.CheckIndex("tool", []string{"build:missing", "optional:docs"})
+	if (err != nil || indexable || strings.Join(missing, ",") != "build:missing") {
+		t.Errorf("Missing build dependency should prevent indexing : %v", missing)
+	}
+	if _, _, _, err = indexer.CheckIndex("tool", []string{"test:mock"}); (err == nil) {
+		t.Error("Unknown dependency kind should be an error")
+	}
+}
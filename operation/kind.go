@@ -0,0 +1,47 @@
+package operation
+
+import (
+	"github.com/kristenfelch/pkgindexer/data"
+)
+
+// typedDependencies lists the dependencies of an indexed Package of the kinds allowed by our filter,
+// sorted by name, with the kind of each that is not a runtime dependency, as in build:gcc.
+func typedDependencies(store data.IndexStore, name string, kinds data.KindFilter) (deps []string, err error) {
+	deps = make([]string, 0)
+	names, err := store.GetDependencies(name)
+	if err != nil {
+		return deps, err
+	}
+	depKinds, err := store.GetDependencyKinds(name)
+	if err != nil {
+		return deps, err
+	}
+	for _, dep := range names {
+		kind, ok := depKinds[dep]
+		if !ok {
+			kind = data.KindRuntime
+		}
+		if kinds.Allows(kind) {
+			deps = append(deps, data.JoinDependencyKind(kind, dep))
+		}
+	}
+	return deps, nil
+}
+
+// splitKinds splits a list of dependencies, as in build:gcc, into the dependencies themselves and
+// the kind of each that is not a runtime dependency.
+func splitKinds(typed []string) (deps []string, kinds map[string]data.DependencyKind, err error) {
+	deps = make([]string, 0, len(typed))
+	kinds = make(map[string]data.DependencyKind)
+	for _, dep := range typed {
+		kind, rest, kindErr := data.SplitDependencyKind(dep)
+		if kindErr != nil {
+			return deps, kinds, kindErr
+		}
+		deps = append(deps, rest)
+		if kind != data.KindRuntime {
+			kinds[rest] = kind
+		}
+	}
+	return deps, kinds, nil
+}
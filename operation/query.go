@@ -6,8 +6,19 @@ import (
 
 // Querier is responsible for Querying if a Package is indexed.
 type Querier interface {
-	// indicates if element is currently indexed.
+	// indicates if element is currently indexed, or for foo@* if any version of it is.
 	Query(name string) (indexed bool, err error)
+
+	// lists the direct dependencies of an element of the kinds allowed, indicating if it is currently indexed.
+	// Dependencies other than runtime dependencies are listed with their kind, as in build:gcc.
+	Dependencies(name string, kinds data.KindFilter) (indexed bool, deps []string, err error)
+
+	// lists dependencies of an indexed element that are no longer indexed because they
+	// were forcibly removed, leaving the element broken.  Optional dependencies never break an element.
+	Broken(name string) (broken []string, err error)
+
+	// describes the revision and history of an element, indicating if it is currently indexed.
+	Info(name string) (indexed bool, info *data.PackageInfo, err error)
 }
 
 type SimpleQuerier struct {
@@ -15,7 +26,82 @@ type SimpleQuerier struct {
 }
 
 func (s *SimpleQuerier) Query(name string) (indexed bool, err error) {
-	return s.store.HasPackage(name)
+	if isEveryVersion(name) {
+		return s.queryVersions(name)
+	}
+	indexed, err = s.store.HasPackage(name)
+	if err != nil || !indexed {
+		return indexed, err
+	}
+	// keep track of queries, so that packages which are never queried can be garbage collected.
+	return true, s.store.MarkQueried(name)
+}
+
+// queryVersions indicates if any version of a Package is currently indexed.
+func (s *SimpleQuerier) queryVersions(name string) (indexed bool, err error) {
+	ids, err := versionsOf(s.store, name)
+	if err != nil || len(ids) == 0 {
+		return false, err
+	}
+	for _, id := range ids {
+		if err = s.store.MarkQueried(id); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (s *SimpleQuerier) Info(name string) (indexed bool, info *data.PackageInfo, err error) {
+	indexed, err = s.store.HasPackage(name)
+	if err != nil || !indexed {
+		return false, nil, err
+	}
+	info, err = s.store.GetInfo(name)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, info, nil
+}
+
+func (s *SimpleQuerier) Dependencies(name string, kinds data.KindFilter) (indexed bool, deps []string, err error) {
+	indexed, err = s.store.HasPackage(name)
+	if err != nil || !indexed {
+		return false, []string{}, err
+	}
+	deps, err = typedDependencies(s.store, name, kinds)
+	if err != nil {
+		return false, []string{}, err
+	}
+	return true, deps, nil
+}
+
+func (s *SimpleQuerier) Broken(name string) (broken []string, err error) {
+	broken = make([]string, 0)
+	indexed, err := s.store.HasPackage(name)
+	if err != nil || !indexed {
+		return broken, err
+	}
+	kinds, err := s.store.GetDependencyKinds(name)
+	if err != nil {
+		return broken, err
+	}
+	deps, err := s.store.GetDependencies(name)
+	if err != nil {
+		return broken, err
+	}
+	for _, dep := range deps {
+		if kinds[dep] == data.KindOptional {
+			continue
+		}
+		exists, existsErr := s.store.HasPackage(dep)
+		if existsErr != nil {
+			return broken, existsErr
+		}
+		if !exists {
+			broken = append(broken, dep)
+		}
+	}
+	return broken, nil
 }
 
 // NewQuerier creates a new Querier referencing our Index data store.
@@ -2,7 +2,9 @@ package operation
 
 import (
 	"testing"
+	"strings"
 	"github.com/kristenfelch/pkgindexer/data"
+	"github.com/kristenfelch/pkgindexer/logging"
 )
 
 // Tests case where query returns true
@@ -26,3 +28,105 @@ func TestQueryFalse(t *testing.T) {
 		t.Error("When package is not present in index, false should be returned with no error")
 	}
 }
+
+// Tests that dependencies of an indexed package are listed.
+func TestQueryDependencies(t *testing.T) {
+	logLevel := "FATAL"
+	store := data.NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+	store.AddPackage("lib", []string{"dep2", "dep1"})
+	querier := &SimpleQuerier{store}
+
+	indexed, deps, err := querier.Dependencies("lib", nil)
+	if (err != nil || !indexed || strings.Join(deps, ",") != "dep1,dep2") {
+		t.Errorf("Dependencies of indexed package should be listed : %v", deps)
+	}
+	indexed, deps, err = querier.Dependencies("missing", nil)
+	if (err != nil || indexed || len(deps) != 0) {
+		t.Error("Package that is not indexed should have no dependencies")
+	}
+}
+
+// Tests that a package is reported broken once a dependency is forcibly removed.
+func TestQueryBroken(t *testing.T) {
+	logLevel := "FATAL"
+	store := data.NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("dep1", nil)
+	store.AddPackage("dep2", nil)
+	store.AddPackage("lib", []string{"dep1", "dep2"})
+	querier := &SimpleQuerier{store}
+
+	broken, err := querier.Broken("lib")
+	if (err != nil || len(broken) != 0) {
+		t.Error("Package should not be broken while all dependencies are indexed")
+	}
+	store.ForceRemovePackage("dep2")
+	broken, err = querier.Broken("lib")
+	if (err != nil || strings.Join(broken, ",") != "dep2") {
+		t.Errorf("Package should be broken once dependency is forcibly removed : %v", broken)
+	}
+}
+
+// Tests that the info of indexed packages is described.
+func TestQueryInfo(t *testing.T) {
+	logLevel := "FATAL"
+	store := data.NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("lib", nil)
+	querier := &SimpleQuerier{store}
+
+	indexed, info, err := querier.Info("lib")
+	if (err != nil || !indexed || info.Revision != 1) {
+		t.Error("Info of indexed package should be described")
+	}
+	indexed, info, err = querier.Info("missing")
+	if (err != nil || indexed || info != nil) {
+		t.Error("Package that is not indexed should have no info")
+	}
+}
+
+// Tests that a package is found if any version of it is indexed.
+func TestQueryEveryVersion(t *testing.T) {
+	logLevel := "FATAL"
+	store := data.NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("foo@1.0", nil)
+	querier := &SimpleQuerier{store}
+
+	if indexed, err := querier.Query("foo@*"); (err != nil || !indexed) {
+		t.Error("Package should be found if any version is indexed")
+	}
+	if indexed, err := querier.Query("foo"); (err != nil || indexed) {
+		t.Error("Package without a version should not match versioned packages")
+	}
+	if info, _ := store.GetInfo("foo@1.0"); (info.Queried.IsZero()) {
+		t.Error("Every version found should be marked as queried")
+	}
+}
+
+// Tests that dependencies are listed with their kinds, filtered by kind, and that missing optional
+// dependencies do not break a package.
+func TestQueryTypedDependencies(t *testing.T) {
+	logLevel := "FATAL"
+	store := data.NewIndexStore(logging.NewIndexLogger(&logLevel))
+	store.AddPackage("lib", nil)
+	store.AddPackage("gcc", nil)
+	store.AddTypedPackage("app", []string{"lib", "gcc", "docs"}, map[string]data.DependencyKind{
+		"gcc":  data.KindBuild,
+		"docs": data.KindOptional,
+	})
+	querier := &SimpleQuerier{store}
+
+	_, deps, err := querier.Dependencies("app", nil)
+	if (err != nil || strings.Join(deps, ",") != "optional:docs,build:gcc,lib") {
+		t.Errorf("Dependencies should be listed with their kinds : %v", deps)
+	}
+	runtime, _ := data.ParseKindFilter("runtime")
+	_, deps, err = querier.Dependencies("app", runtime)
+	if (err != nil || strings.Join(deps, ",") != "lib") {
+		t.Errorf("Dependencies should be filtered by kind : %v", deps)
+	}
+	broken, err := querier.Broken("app")
+	if (err != nil || len(broken) != 0) {
+		t.Errorf("Missing optional dependency should not break package : %v", broken)
+	}
+}
@@ -0,0 +1,29 @@
+package operation
+
+// Reasons explaining the outcome of an operation, reported to clients alongside its result.
+const (
+	// package is not yet indexed, and would be.
+	ReasonIndexed = "INDEXED"
+	// package is already indexed, and its dependencies would be updated.
+	ReasonUpdated = "UPDATED"
+	// package cannot be indexed until its missing dependencies are.
+	ReasonMissingDependencies = "MISSING_DEPENDENCIES"
+	// package is indexed, and would be removed.
+	ReasonRemoved = "REMOVED"
+	// package is not indexed, so there is nothing to remove.
+	ReasonNotIndexed = "NOT_INDEXED"
+	// package cannot be removed while its parents depend on it.
+	ReasonHasParents = "HAS_PARENTS"
+	// package does not depend on the target, even transitively.
+	ReasonNotADependency = "NOT_A_DEPENDENCY"
+	// batch was not applied, as one of its operations failed.
+	ReasonBatchFailed = "BATCH_FAILED"
+	// namespace named does not exist.
+	ReasonNoSuchNamespace = "NO_SUCH_NAMESPACE"
+	// namespace cannot be created, as it already exists.
+	ReasonNamespaceExists = "NAMESPACE_EXISTS"
+	// the default namespace cannot be dropped.
+	ReasonDefaultNamespace = "DEFAULT_NAMESPACE"
+	// history of the index does not reach back far enough to know the state of a package.
+	ReasonNoHistory = "NO_HISTORY"
+)
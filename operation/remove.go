@@ -1,6 +1,9 @@
 package operation
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"github.com/kristenfelch/pkgindexer/data"
 	"github.com/kristenfelch/pkgindexer/logging"
 )
@@ -9,8 +12,20 @@ import (
 // It encapsulates the business logic behind removal and conditions required for removal,
 // leaving removal itself to IndexStore.
 type Remover interface {
-	//removed indicates if element was removed, err if we tried and failed.
+	//removed indicates if element was removed, or for foo@* every version of it, err if we tried and failed.
 	Remove(name string) (removed bool, err error)
+
+	//removed indicates if element was removed, in which case packages lists it and every
+	//dependency that was removed along with it because nothing else depends on it any longer.
+	RemoveCascade(name string) (removed bool, packages []string, err error)
+
+	//removes element even if other packages depend on it, returning those dependents which
+	//are now broken, err if we tried and failed.
+	ForceRemove(name string) (dependents []string, err error)
+
+	//indicates if element could be removed, without removing it, along with the reason why
+	//and any parents that depend on it.
+	CheckRemove(name string) (removable bool, reason string, parents []string, err error)
 }
 
 type SimpleRemover struct {
@@ -19,30 +34,212 @@ type SimpleRemover struct {
 }
 
 func (s *SimpleRemover) Remove(name string) (removed bool, err error) {
+	removable, reason, _, err := s.CheckRemove(name)
+	if err != nil || !removable {
+		return false, err
+	}
+	if reason == ReasonNotIndexed {
+		return true, nil
+	}
+	ids, idsErr := versionsOf(s.store, name)
+	if idsErr != nil {
+		s.logger.Error(idsErr.Error())
+		return false, idsErr
+	}
+	// versions may depend on one another, and stores may refuse to remove a Package while others depend on it,
+	// so each pass removes the versions no other version still depends on.
+	for len(ids) > 0 {
+		remaining := make([]string, 0, len(ids))
+		for _, id := range ids {
+			removed, removedErr := s.store.RemovePackage(id)
+			if removedErr != nil {
+				s.logger.Error(removedErr.Error())
+				return false, removedErr
+			}
+			if !removed {
+				remaining = append(remaining, id)
+			}
+		}
+		if len(remaining) == len(ids) {
+			return false, nil
+		}
+		ids = remaining
+	}
+	return true, nil
+}
+
+func (s *SimpleRemover) CheckRemove(name string) (removable bool, reason string, parents []string, err error) {
+	if isEveryVersion(name) {
+		return s.checkRemoveVersions(name)
+	}
+	parents = make([]string, 0)
 	lib, libError := s.store.HasPackage(name)
 	if libError != nil {
 		s.logger.Error(libError.Error())
-		return false, libError
+		return false, "", parents, libError
+	}
+	if !lib {
+		return true, ReasonNotIndexed, parents, nil
+	}
+	hasParents, hasParentsError := s.store.HasParents(name)
+	if hasParentsError != nil {
+		s.logger.Error(hasParentsError.Error())
+		return false, "", parents, hasParentsError
+	}
+	if !hasParents {
+		return true, ReasonRemoved, parents, nil
+	}
+	parents, parentsError := s.store.GetParents(name)
+	if parentsError != nil {
+		s.logger.Error(parentsError.Error())
+		return false, "", []string{}, parentsError
+	}
+	return false, ReasonHasParents, parents, nil
+}
+
+// checkRemoveVersions determines if every version of a Package could be removed together.  Versions
+// may depend on one another, so only parents that are not themselves being removed prevent removal.
+func (s *SimpleRemover) checkRemoveVersions(name string) (removable bool, reason string, parents []string, err error) {
+	parents = make([]string, 0)
+	ids, idsErr := versionsOf(s.store, name)
+	if idsErr != nil {
+		s.logger.Error(idsErr.Error())
+		return false, "", parents, idsErr
 	}
-	if lib {
-		hasParents, hasParentsError := s.store.HasParents(name)
-		if hasParentsError != nil {
-			s.logger.Error(hasParentsError.Error())
-			return false, hasParentsError
+	if len(ids) == 0 {
+		return true, ReasonNotIndexed, parents, nil
+	}
+	for _, id := range ids {
+		idParents, parentsError := s.store.GetParents(id)
+		if parentsError != nil {
+			s.logger.Error(parentsError.Error())
+			return false, "", []string{}, parentsError
 		}
-		if hasParents {
-			return false, nil
-		} else {
-			removed, removedErr := s.store.RemovePackage(name)
-			if removedErr != nil {
-				s.logger.Error(removedErr.Error())
-				return false, removedErr
+		for _, parent := range idParents {
+			if !contains(ids, parent) && !contains(parents, parent) {
+				parents = append(parents, parent)
 			}
-			return removed, nil
 		}
-	} else {
-		return true, nil
 	}
+	if len(parents) > 0 {
+		sort.Strings(parents)
+		return false, ReasonHasParents, parents, nil
+	}
+	return true, ReasonRemoved, parents, nil
+}
+
+// RemoveCascade removes within a transaction, as the batcher does, so that failing partway removes nothing.
+func (s *SimpleRemover) RemoveCascade(name string) (removed bool, packages []string, err error) {
+	if beginErr := s.store.Begin(); beginErr != nil {
+		s.logger.Error(beginErr.Error())
+		return false, make([]string, 0), beginErr
+	}
+	removed, packages, err = s.cascade(name)
+	if err != nil || !removed {
+		if rollbackErr := s.store.Rollback(); rollbackErr != nil {
+			s.logger.Error(rollbackErr.Error())
+			return false, make([]string, 0), rollbackErr
+		}
+		return false, make([]string, 0), err
+	}
+	if commitErr := s.store.Commit(); commitErr != nil {
+		s.logger.Error(commitErr.Error())
+		return false, make([]string, 0), commitErr
+	}
+	return true, packages, nil
+}
+
+// cascade removes our package, and each dependency it orphans, listing every package removed.
+func (s *SimpleRemover) cascade(name string) (removed bool, packages []string, err error) {
+	packages = make([]string, 0)
+	removable, reason, _, err := s.CheckRemove(name)
+	if err != nil || !removable {
+		return false, packages, err
+	}
+	if reason == ReasonNotIndexed {
+		return true, packages, nil
+	}
+
+	// Remove our package, then each dependency left without parents by a removal, until
+	// no more packages have been orphaned.
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		deps, depsErr := s.store.GetDependencies(current)
+		if depsErr != nil {
+			s.logger.Error(depsErr.Error())
+			return false, packages, depsErr
+		}
+		kinds, kindsErr := s.store.GetDependencyKinds(current)
+		if kindsErr != nil {
+			s.logger.Error(kindsErr.Error())
+			return false, packages, kindsErr
+		}
+		removed, removedErr := s.store.RemovePackage(current)
+		if removedErr != nil {
+			s.logger.Error(removedErr.Error())
+			return false, packages, removedErr
+		}
+		if !removed && current == name {
+			return false, packages, nil
+		}
+		if !removed {
+			// another client began depending on this package since it was orphaned, so it and its dependencies stay.
+			continue
+		}
+		packages = append(packages, current)
+
+		for _, dep := range deps {
+			if kinds[dep] == data.KindOptional {
+				// optional dependencies were never required by this package, so are not orphaned by its removal.
+				continue
+			}
+			orphaned, orphanedErr := s.isOrphaned(dep)
+			if orphanedErr != nil {
+				s.logger.Error(orphanedErr.Error())
+				return false, packages, orphanedErr
+			}
+			if orphaned && !contains(queue, dep) {
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return true, packages, nil
+}
+
+func (s *SimpleRemover) ForceRemove(name string) (dependents []string, err error) {
+	dependents, err = s.store.ForceRemovePackage(name)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return []string{}, err
+	}
+	if len(dependents) > 0 {
+		s.logger.Info(fmt.Sprintf("Package %s forcibly removed, breaking %s", name, strings.Join(dependents, ",")))
+	}
+	return dependents, nil
+}
+
+// isOrphaned determines if a Package is indexed, but no other package depends on it.
+func (s *SimpleRemover) isOrphaned(name string) (orphaned bool, err error) {
+	exists, existsErr := s.store.HasPackage(name)
+	if existsErr != nil || !exists {
+		return false, existsErr
+	}
+	hasParents, hasParentsErr := s.store.HasParents(name)
+	if hasParentsErr != nil {
+		return false, hasParentsErr
+	}
+	return !hasParents, nil
+}
+
+func contains(list []string, name string) bool {
+	for _, v := range list {
+		if v == name {
+			return true
+		}
+	}
+	return false
 }
 
 // NewRemover creates a new Remover referencing our Index data store and logger.
@@ -79,3 +79,210 @@ func TestRemoveError(t *testing.T) {
 		t.Error("Error removing should be thrown")
 	}
 }
+
+// Tests case where cascading remove also removes dependencies that are no longer depended on,
+// but keeps those that other packages still depend on.
+func TestRemoveCascade(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("shared", nil)
+	store.AddPackage("leaf", nil)
+	store.AddPackage("dep", []string{"leaf", "shared"})
+	store.AddPackage("other", []string{"shared"})
+	store.AddPackage("lib", []string{"dep"})
+	remover := &SimpleRemover{store, logger}
+
+	removed, packages, err := remover.RemoveCascade("lib")
+	if (err != nil || !removed || strings.Join(packages, ",") != "lib,dep,leaf") {
+		t.Errorf("Package and its orphaned dependencies should be removed : %v", packages)
+	}
+	if exists, _ := store.HasPackage("shared"); !exists {
+		t.Error("Dependency that others still depend on should not be removed")
+	}
+}
+
+// failingStore fails to remove one Package, as a store may partway through a cascade.
+type failingStore struct {
+	data.IndexStore
+	failing string
+}
+
+func (f *failingStore) RemovePackage(name string) (removed bool, error error) {
+	if name == f.failing {
+		return false, err.NewIndexError("Error removing")
+	}
+	return f.IndexStore.RemovePackage(name)
+}
+
+// Tests that a cascading remove failing partway removes nothing.
+func TestRemoveCascadeError(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("leaf", nil)
+	store.AddPackage("dep", []string{"leaf"})
+	store.AddPackage("lib", []string{"dep"})
+	remover := &SimpleRemover{&failingStore{store, "leaf"}, logger}
+
+	if removed, packages, err := remover.RemoveCascade("lib"); (err == nil || removed || len(packages) != 0) {
+		t.Errorf("Cascading remove failing partway should fail, got %v %v", removed, packages)
+	}
+	for _, name := range []string{"lib", "dep", "leaf"} {
+		if exists, _ := store.HasPackage(name); (!exists) {
+			t.Errorf("Packages removed before a cascading remove failed should be restored, %s was not", name)
+		}
+	}
+}
+
+// Tests case where cascading remove fails because other packages depend on the one being removed.
+func TestRemoveCascadeParentsPresent(t *testing.T) {
+	store := data.NewTestStore(true, nil, true, nil, true, nil, true, nil)
+	logLevel := "FATAL"
+	remover := &SimpleRemover{store, logging.NewIndexLogger(&logLevel)}
+
+	removed, packages, err := remover.RemoveCascade("lib")
+	if (err != nil || removed || len(packages) != 0) {
+		t.Error("When package has others that depend on it, it cannot be removed")
+	}
+}
+
+// Tests case where cascading remove of a package that is not indexed succeeds, removing nothing.
+func TestRemoveCascadeNotIndexed(t *testing.T) {
+	store := data.NewTestStore(true, nil, true, nil, false, nil, true, nil)
+	logLevel := "FATAL"
+	remover := &SimpleRemover{store, logging.NewIndexLogger(&logLevel)}
+
+	removed, packages, err := remover.RemoveCascade("lib")
+	if (err != nil || !removed || len(packages) != 0) {
+		t.Error("When package has already been removed, cascade should succeed without removing anything")
+	}
+}
+
+// Tests case where forced remove succeeds even though others depend on the package, reporting them.
+func TestForceRemove(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("dep", nil)
+	store.AddPackage("lib1", []string{"dep"})
+	store.AddPackage("lib2", []string{"dep"})
+	remover := &SimpleRemover{store, logger}
+
+	dependents, err := remover.ForceRemove("dep")
+	if (err != nil || strings.Join(dependents, ",") != "lib1,lib2") {
+		t.Errorf("Forced removal should report broken dependents : %v", dependents)
+	}
+	if exists, _ := store.HasPackage("dep"); exists {
+		t.Error("Package should be removed even though others depend on it")
+	}
+}
+
+// Tests case where forced removal causes an error.
+func TestForceRemoveError(t *testing.T) {
+	store := data.NewTestStore(true, nil, false, err.NewIndexError("Error removing"), true, nil, true, nil)
+	logLevel := "FATAL"
+	remover := &SimpleRemover{store, logging.NewIndexLogger(&logLevel)}
+
+	_, err := remover.ForceRemove("lib")
+	if (err == nil || strings.Index(err.Error(), "Error removing") == -1) {
+		t.Error("Error removing should be thrown")
+	}
+}
+
+// Tests that checking a remove reports the parents blocking it, without removing the package.
+func TestCheckRemoveHasParents(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("dep", nil)
+	store.AddPackage("lib2", []string{"dep"})
+	store.AddPackage("lib1", []string{"dep"})
+	remover := &SimpleRemover{store, logger}
+
+	removable, reason, parents, err := remover.CheckRemove("dep")
+	if (err != nil || removable || reason != ReasonHasParents || strings.Join(parents, ",") != "lib1,lib2") {
+		t.Errorf("Parents blocking removal should be reported : %s %v", reason, parents)
+	}
+}
+
+// Tests that checking a remove reports whether the package would be removed, leaving the store untouched.
+func TestCheckRemoveRemovable(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("lib", nil)
+	remover := &SimpleRemover{store, logger}
+
+	removable, reason, _, err := remover.CheckRemove("lib")
+	if (err != nil || !removable || reason != ReasonRemoved) {
+		t.Errorf("Package should be reported as removed : %s", reason)
+	}
+	if exists, _ := store.HasPackage("lib"); !exists {
+		t.Error("Checking a remove should not remove the package")
+	}
+	removable, reason, _, err = remover.CheckRemove("missing")
+	if (err != nil || !removable || reason != ReasonNotIndexed) {
+		t.Errorf("Package should be reported as not indexed : %s", reason)
+	}
+}
+
+// Tests that every version of a package is removed together, unless other packages depend on one.
+func TestRemoveEveryVersion(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("foo@1.0", nil)
+	store.AddPackage("foo@2.0", []string{"foo@1.0"})
+	store.AddPackage("app", []string{"foo@2.0"})
+	remover := &SimpleRemover{store, logger}
+
+	removed, err := remover.Remove("foo@*")
+	if (err != nil || removed) {
+		t.Error("Versions should not be removed while other packages depend on them")
+	}
+	_, reason, parents, _ := remover.CheckRemove("foo@*")
+	if (reason != ReasonHasParents || strings.Join(parents, ",") != "app") {
+		t.Errorf("Only parents outside the package should block removal : %s %v", reason, parents)
+	}
+	store.RemovePackage("app")
+	removed, err = remover.Remove("foo@*")
+	if (err != nil || !removed) {
+		t.Error("Every version should be removed")
+	}
+	if versions, _ := store.GetVersions("foo"); (len(versions) != 0) {
+		t.Errorf("No versions should remain indexed : %v", versions)
+	}
+	removable, reason, _, err := remover.CheckRemove("foo@*")
+	if (err != nil || !removable || reason != ReasonNotIndexed) {
+		t.Errorf("Package without versions should be reported as not indexed : %s", reason)
+	}
+}
+
+// Tests that optional dependencies do not prevent removal, and are not removed in a cascade.
+func TestRemoveOptionalDependency(t *testing.T) {
+	logLevel := "FATAL"
+	logger := logging.NewIndexLogger(&logLevel)
+	store := data.NewIndexStore(logger)
+	store.AddPackage("docs", nil)
+	store.AddPackage("lib", nil)
+	store.AddTypedPackage("app", []string{"docs", "lib"}, map[string]data.DependencyKind{"docs": data.KindOptional})
+	remover := &SimpleRemover{store, logger}
+
+	removed, _, err := remover.RemoveCascade("app")
+	if (err != nil || !removed) {
+		t.Error("Package should be removed")
+	}
+	if exists, _ := store.HasPackage("docs"); (!exists) {
+		t.Error("Optional dependency should not be removed in a cascade")
+	}
+	if exists, _ := store.HasPackage("lib"); (exists) {
+		t.Error("Orphaned runtime dependency should be removed in a cascade")
+	}
+
+	store.AddTypedPackage("app", []string{"docs"}, map[string]data.DependencyKind{"docs": data.KindOptional})
+	removed, err = remover.Remove("docs")
+	if (err != nil || !removed) {
+		t.Error("Optional dependency should be removed regardless of packages using it")
+	}
+}
@@ -0,0 +1,31 @@
+package operation
+
+import (
+	"strings"
+	"github.com/kristenfelch/pkgindexer/data"
+)
+
+// isEveryVersion determines if a request refers to every version of a Package, as in foo@*.
+func isEveryVersion(id string) bool {
+	_, version := data.SplitVersion(id)
+	return version == data.AnyVersion
+}
+
+// versionsOf lists the indexed packages that a request refers to - every indexed version of a Package
+// for foo@*, and otherwise the Package itself, whether or not it is indexed.
+func versionsOf(store data.IndexStore, id string) (ids []string, err error) {
+	if !isEveryVersion(id) {
+		return []string{id}, nil
+	}
+	name, _ := data.SplitVersion(id)
+	return store.GetVersions(name)
+}
+
+// splitConstraint splits a dependency into the name of a Package and the constraint on its version,
+// as in bar>=1.2,<2, which is empty if the dependency is not constrained.
+func splitConstraint(dependency string) (name string, constraint string) {
+	if i := strings.IndexAny(dependency, "<>=!~^"); i != -1 {
+		return dependency[:i], dependency[i:]
+	}
+	return dependency, ""
+}
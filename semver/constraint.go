@@ -0,0 +1,127 @@
+package semver
+
+import (
+	"fmt"
+	"strings"
+	"github.com/kristenfelch/pkgindexer/err"
+)
+
+// Operators that a constraint may begin with, longest first so that '>=' is not read as '>'.
+// A constraint without an operator requires exactly that version.
+var operators = []string{">=", "<=", "!=", "=", ">", "<", "~", "^"}
+
+// Constraint is a comma delimited list of comparisons that a version must satisfy all of, as in >=1.2,<2.
+// Comparisons are made with =, !=, >, >=, < and <=, along with ~1.2.3 which allows patch updates
+// (>=1.2.3,<1.3.0) and ^1.2.3 which allows updates that do not change the first non-zero version
+// (>=1.2.3,<2.0.0).  Omitted minor and patch versions are 0, except that ~1 allows any 1.x version.
+type Constraint struct {
+	comparisons []comparison
+}
+
+// comparison is a single comparison against a version, such as >=1.2.
+type comparison struct {
+	operator string
+	version  Version
+}
+
+// ParseConstraint reads a constraint, as in >=1.2,<2.
+func ParseConstraint(constraint string) (parsed Constraint, error error) {
+	for _, part := range strings.Split(constraint, ",") {
+		operator := ""
+		for _, candidate := range operators {
+			if strings.HasPrefix(part, candidate) {
+				operator = candidate
+				break
+			}
+		}
+		version, parts, parseErr := parse(strings.TrimPrefix(part, operator))
+		if parseErr != nil {
+			return parsed, err.NewCodedIndexError(err.CodeInvalidDependencies, fmt.Sprintf("Constraint is incorrectly formatted : %s", constraint))
+		}
+		switch operator {
+		case "~":
+			parsed.comparisons = append(parsed.comparisons, comparison{">=", version}, comparison{"<", tildeLimit(version, parts)})
+		case "^":
+			parsed.comparisons = append(parsed.comparisons, comparison{">=", version}, comparison{"<", caretLimit(version, parts)})
+		case "":
+			parsed.comparisons = append(parsed.comparisons, comparison{"=", version})
+		default:
+			parsed.comparisons = append(parsed.comparisons, comparison{operator, version})
+		}
+	}
+	return parsed, nil
+}
+
+// tildeLimit is the first version that ~version does not allow.
+func tildeLimit(version Version, parts int) Version {
+	if parts == 1 {
+		return Version{Major: version.Major + 1}
+	}
+	return Version{Major: version.Major, Minor: version.Minor + 1}
+}
+
+// caretLimit is the first version that ^version does not allow.
+func caretLimit(version Version, parts int) Version {
+	switch {
+	case version.Major > 0 || parts == 1:
+		return Version{Major: version.Major + 1}
+	case version.Minor > 0 || parts == 2:
+		return Version{Minor: version.Minor + 1}
+	}
+	return Version{Patch: version.Patch + 1}
+}
+
+// Matches determines if a version satisfies every comparison of our constraint.
+func (c Constraint) Matches(version Version) bool {
+	for _, comparison := range c.comparisons {
+		if !comparison.matches(version) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c comparison) matches(version Version) bool {
+	compared := version.Compare(c.version)
+	switch c.operator {
+	case "=":
+		return compared == 0
+	case "!=":
+		return compared != 0
+	case ">":
+		return compared > 0
+	case ">=":
+		return compared >= 0
+	case "<":
+		return compared < 0
+	case "<=":
+		return compared <= 0
+	}
+	return false
+}
+
+// Best finds the highest of a list of versions that satisfies our constraint, ignoring any that
+// are not semantic versions, indicating if there was one.
+func (c Constraint) Best(versions []string) (best string, found bool) {
+	var highest Version
+	for _, candidate := range versions {
+		version, parseErr := Parse(candidate)
+		if parseErr != nil || !c.Matches(version) {
+			continue
+		}
+		if !found || version.Compare(highest) > 0 {
+			best, highest, found = candidate, version, true
+		}
+	}
+	return best, found
+}
+
+// IsConstraint determines if text begins with a constraint operator, rather than naming a package.
+func IsConstraint(text string) bool {
+	for _, operator := range operators {
+		if strings.HasPrefix(text, operator) {
+			return true
+		}
+	}
+	return false
+}
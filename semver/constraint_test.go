@@ -0,0 +1,100 @@
+package semver
+
+import (
+	"testing"
+)
+
+// Tests which versions satisfy each kind of constraint.
+func TestConstraintMatches(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", "1.2.4", false},
+		{"=1.2", "1.2.0", true},
+		{"!=1.2.3", "1.2.3", false},
+		{"!=1.2.3", "1.2.4", true},
+		{">1.2", "1.2.0", false},
+		{">1.2", "1.2.1", true},
+		{">=1.2", "1.2.0", true},
+		{">=1.2", "1.1.9", false},
+		{"<2", "1.99.0", true},
+		{"<2", "2.0.0", false},
+		{"<2", "2.0.0-rc.1", true},
+		{"<=2", "2.0.0", true},
+		{">=1.2,<2", "1.5.0", true},
+		{">=1.2,<2", "2.1.0", false},
+		{">=1.2,<2", "1.1.0", false},
+		{">=1.2,<2,!=1.5.0", "1.5.0", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1.2.3", "1.2.2", false},
+		{"~1.2", "1.2.0", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"~1", "2.0.0", false},
+		{"^1.2.3", "1.9.9", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^1.2.3", "1.2.2", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^0", "0.9.0", true},
+		{"^0", "1.0.0", false},
+		{"^0.0", "0.0.9", true},
+		{"^0.0", "0.1.0", false},
+	}
+	for _, c := range cases {
+		constraint, err := ParseConstraint(c.constraint)
+		if (err != nil) {
+			t.Errorf("Constraint %s should be parsed : %s", c.constraint, err.Error())
+			continue
+		}
+		version, _ := Parse(c.version)
+		if (constraint.Matches(version) != c.expected) {
+			t.Errorf("Constraint %s matching %s should be %t", c.constraint, c.version, c.expected)
+		}
+	}
+}
+
+// Tests that malformed constraints are rejected.
+func TestParseConstraintInvalid(t *testing.T) {
+	cases := []string{"", ">=", ">=1.2,", ">=x", "=>1.2", "~>1.2", ">=1.2,<2,"}
+	for _, c := range cases {
+		if _, err := ParseConstraint(c); (err == nil) {
+			t.Errorf("Constraint %s should be rejected", c)
+		}
+	}
+}
+
+// Tests that the highest version satisfying a constraint is chosen.
+func TestConstraintBest(t *testing.T) {
+	cases := []struct {
+		constraint string
+		versions   []string
+		expected   string
+		found      bool
+	}{
+		{">=1.2,<2", []string{"1.0.0", "1.2.0", "1.10.1", "1.9.0", "2.0.0"}, "1.10.1", true},
+		{"^1.0", []string{"latest", "1.0.0", "1.1.0-rc.1", "1.0.5"}, "1.1.0-rc.1", true},
+		{">=3", []string{"1.0.0", "2.0.0"}, "", false},
+		{">=1", []string{}, "", false},
+	}
+	for _, c := range cases {
+		constraint, _ := ParseConstraint(c.constraint)
+		best, found := constraint.Best(c.versions)
+		if (best != c.expected || found != c.found) {
+			t.Errorf("Best version for %s should be %s, got %s", c.constraint, c.expected, best)
+		}
+	}
+}
+
+// Tests recognition of text beginning with a constraint.
+func TestIsConstraint(t *testing.T) {
+	if (!IsConstraint(">=1.2") || !IsConstraint("<2") || !IsConstraint("^1") || IsConstraint("bar") || IsConstraint("1.2")) {
+		t.Error("Constraints should be recognised by their operator")
+	}
+}
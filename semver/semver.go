@@ -0,0 +1,133 @@
+// Package semver parses semantic versions, such as 1.2.0 or 2.0.1-rc.1, and the range constraints that
+// dependencies place on them, such as >=1.2,<2.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"github.com/kristenfelch/pkgindexer/err"
+)
+
+// Version is a semantic version.  Minor and Patch versions may be omitted, as in 1 or 1.2, in
+// which case they are 0.  Build metadata is kept, but plays no part in comparing versions.
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+}
+
+// Parse reads a semantic version, optionally prefixed with 'v'.
+func Parse(version string) (parsed Version, error error) {
+	parsed, _, error = parse(version)
+	return parsed, error
+}
+
+// parse reads a semantic version, along with how many of its major, minor and patch versions were given.
+func parse(version string) (parsed Version, parts int, error error) {
+	rest := strings.TrimPrefix(version, "v")
+	if i := strings.Index(rest, "+"); i != -1 {
+		parsed.Build = rest[i+1:]
+		rest = rest[:i]
+		if len(parsed.Build) == 0 {
+			return parsed, 0, invalid(version)
+		}
+	}
+	if i := strings.Index(rest, "-"); i != -1 {
+		parsed.Prerelease = rest[i+1:]
+		rest = rest[:i]
+		if len(parsed.Prerelease) == 0 {
+			return parsed, 0, invalid(version)
+		}
+	}
+	numbers := strings.Split(rest, ".")
+	if len(numbers) > 3 {
+		return parsed, 0, invalid(version)
+	}
+	fields := []*int{&parsed.Major, &parsed.Minor, &parsed.Patch}
+	for i, number := range numbers {
+		value, parseErr := strconv.Atoi(number)
+		if parseErr != nil || value < 0 || strings.HasPrefix(number, "+") {
+			return parsed, 0, invalid(version)
+		}
+		*fields[i] = value
+	}
+	return parsed, len(numbers), nil
+}
+
+func invalid(version string) error {
+	return err.NewCodedIndexError(err.CodeInvalidDependencies, fmt.Sprintf("Version is incorrectly formatted : %s", version))
+}
+
+// Compare returns -1 if v precedes other, 1 if it follows other, and 0 if they are equal.
+// A prerelease precedes the version it is a prerelease of, as in 1.0.0-rc.1 < 1.0.0.
+func (v Version) Compare(other Version) int {
+	if c := compareInts(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareInts(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareInts(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+	return comparePrereleases(v.Prerelease, other.Prerelease)
+}
+
+// comparePrereleases compares dot separated prerelease identifiers in turn, numerically if both are
+// numbers, and otherwise lexically, with numbers preceding words and shorter lists preceding longer.
+func comparePrereleases(a string, b string) int {
+	if a == b {
+		return 0
+	}
+	if len(a) == 0 {
+		return 1
+	}
+	if len(b) == 0 {
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareInts(an, bn); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInts(len(as), len(bs))
+}
+
+func compareInts(a int, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// String formats a version in full, as in 1.2.0-rc.1+build.5.
+func (v Version) String() string {
+	formatted := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		formatted += "-" + v.Prerelease
+	}
+	if len(v.Build) > 0 {
+		formatted += "+" + v.Build
+	}
+	return formatted
+}
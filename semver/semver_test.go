@@ -0,0 +1,68 @@
+package semver
+
+import (
+	"testing"
+)
+
+// Tests parsing of well formed and malformed versions.
+func TestParse(t *testing.T) {
+	cases := []struct {
+		version  string
+		expected string
+		valid    bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"v1.2.3", "1.2.3", true},
+		{"1.2", "1.2.0", true},
+		{"1", "1.0.0", true},
+		{"0.0.0", "0.0.0", true},
+		{"1.2.3-rc.1", "1.2.3-rc.1", true},
+		{"1.2.3+build.5", "1.2.3+build.5", true},
+		{"1.2.3-beta+exp.sha.5114f85", "1.2.3-beta+exp.sha.5114f85", true},
+		{"", "", false},
+		{"1.2.3.4", "", false},
+		{"1..3", "", false},
+		{"a.b.c", "", false},
+		{"1.2.3-", "", false},
+		{"1.2.3+", "", false},
+		{"1.+2", "", false},
+	}
+	for _, c := range cases {
+		parsed, err := Parse(c.version)
+		if (c.valid && (err != nil || parsed.String() != c.expected)) {
+			t.Errorf("Version %s should be parsed as %s, got %s", c.version, c.expected, parsed.String())
+		}
+		if (!c.valid && err == nil) {
+			t.Errorf("Version %s should be rejected", c.version)
+		}
+	}
+}
+
+// Tests ordering of versions, including prereleases.
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.2.3+build.1", "1.2.3+build.2", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.3.0", "1.2.9", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta", "1.0.0-alpha", 1},
+		{"1.0.0-rc.1", "0.9.9", 1},
+	}
+	for _, c := range cases {
+		a, _ := Parse(c.a)
+		b, _ := Parse(c.b)
+		if (a.Compare(b) != c.expected || b.Compare(a) != -c.expected) {
+			t.Errorf("Comparison of %s with %s should be %d", c.a, c.b, c.expected)
+		}
+	}
+}